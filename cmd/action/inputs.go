@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// expectedNbArgs is the number of positional arguments, if the inputs are
+// passed that way rather than as INPUT_* environment variables.
+const expectedNbArgs = 44
+
+// defaultConfigFile is the config file read, if it exists, when the
+// config_file input is not specified.
+const defaultConfigFile = ".notarize.yml"
+
+// secretInputs are the names of the inputs whose values are redacted from the
+// logs.
+var secretInputs = map[string]bool{
+	"github_token":             true,
+	"cnil_api_key":             true,
+	"cnil_personal_token":      true,
+	"cnil_api_keys":            true,
+	"audit_webhook_secret":     true,
+	"cnil_client_key":          true,
+	"immudb_password":          true,
+	"api_key_cache_passphrase": true,
+	"vault_token":              true,
+	"vault_secret_id":          true,
+	// the URL of the Slack and Teams incoming webhooks is their secret
+	"notify_webhook": true,
+	// the Pushgateway and proxy URLs might contain basic auth credentials
+	"metrics_pushgateway_url": true,
+	"proxy_url":               true,
+}
+
+// maskSecret emits the workflow command asking GitHub Actions to replace the
+// given secret with *** in the logs from now on.
+func maskSecret(secret string) {
+	// each line of a multi-line secret (e.g. a PEM key) is masked on its own
+	for _, line := range strings.Split(secret, "\n") {
+		if line = strings.TrimSpace(line); len(line) > 0 {
+			fmt.Printf("::add-mask::%s\n", line)
+		}
+	}
+}
+
+// redact returns the given secret with all but its first 4 characters (only
+// for long enough secrets) replaced.
+func redact(secret string) string {
+	switch {
+	case len(secret) == 0:
+		return ""
+	case len(secret) < 16:
+		return "****"
+	default:
+		return secret[:4] + "****"
+	}
+}
+
+// configFileInputs are the input values set in the config file, by input name.
+var configFileInputs = map[string]string{}
+
+// loadConfigFile reads the inputs set in the given YAML config file (e.g.
+// "cnil_host: cnil.example.com"), which are used for the inputs not specified
+// in the workflow. Lists are joined with commas and objects are converted to
+// JSON, e.g. for the cnil_ledger, signer_teams or metadata inputs.
+func loadConfigFile(filePath string) error {
+	filePath = strings.TrimSpace(filePath)
+	required := len(filePath) > 0
+	if !required {
+		filePath = defaultConfigFile
+	}
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		if !required && errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("error reading config file %s: %v", filePath, err)
+	}
+
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(content, &values); err != nil {
+		return fmt.Errorf("error YAML-unmarshaling config file %s: %v", filePath, err)
+	}
+	for name, value := range values {
+		switch v := value.(type) {
+		case nil:
+		case string:
+			configFileInputs[name] = strings.TrimSpace(v)
+		case []interface{}:
+			items := make([]string, 0, len(v))
+			for _, item := range v {
+				items = append(items, fmt.Sprint(item))
+			}
+			configFileInputs[name] = strings.Join(items, ",")
+		case map[string]interface{}:
+			valueJSON, err := json.Marshal(v)
+			if err != nil {
+				return fmt.Errorf("error JSON-marshaling config file %s value of %s: %v",
+					filePath, name, err)
+			}
+			configFileInputs[name] = string(valueJSON)
+		default:
+			configFileInputs[name] = fmt.Sprint(v)
+		}
+	}
+	logger.Infof("Using config file %s\n", filePath)
+
+	return nil
+}
@@ -0,0 +1,1365 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/codenotary/notarize-release-assets-action/pkg/bitbucket"
+	"github.com/codenotary/notarize-release-assets-action/pkg/cnil"
+	"github.com/codenotary/notarize-release-assets-action/pkg/github"
+	"github.com/codenotary/notarize-release-assets-action/pkg/notarize"
+	"github.com/codenotary/notarize-release-assets-action/pkg/vault"
+	"github.com/dustin/go-humanize"
+)
+
+const (
+	red    = "\033[1;31m%s\033[0m"
+	green  = "\033[1;32m%s\033[0m"
+	yellow = "\033[1;33m%s\033[0m"
+)
+
+// logger prints all the output of the action.
+var logger = newConsoleLogger()
+
+func main() {
+	// the inputs are read from the INPUT_* environment variables, unless
+	// passed as positional arguments (for backward compatibility)
+	if len(os.Args) > 1 && len(os.Args)-1 != expectedNbArgs {
+		logger.Errorf(
+			"invalid args %v: expecting %d arguments values, got %d\n",
+			os.Args, expectedNbArgs, len(os.Args)-1)
+		os.Exit(notarize.ExitInvalidConfig)
+	}
+	if err := loadConfigFile(os.Getenv("INPUT_CONFIG_FILE")); err != nil {
+		logger.Errorf("%v\n", err)
+		os.Exit(notarize.ExitInvalidConfig)
+	}
+
+	// validate inputs
+	// the CNIL host is not needed for signing into a plain immudb server
+	immudbHost := getArg(0, "immudb_host", "immudb host", false, "")
+	cnilHost := getArg(1, "cnil_host", "CNIL host", len(immudbHost) == 0, "")
+	cnilgRPCPort := getArg(2, "cnil_grpc_port", "CNIL gRPC API port", false, "443")
+	cnilNoTLS := getArg(3, "cnil_grpc_no_tls", "CNIL gRPC no TLS", false, "false")
+	cnilCACert := getArg(0, "cnil_ca_cert", "CNIL CA certificate", false, "")
+	cnilClientCert := getArg(0, "cnil_client_cert", "CNIL client certificate", false, "")
+	cnilClientKey := getArg(0, "cnil_client_key", "CNIL client key", false, "")
+	proxyURL := getArg(0, "proxy_url", "Proxy URL", false, "")
+	releaseURL := getArg(4, "release_url", "Release URL", false, "")
+	githubToken := getArg(5, "github_token", "GitHub token", false, "")
+	cnilAPIKey := getArg(6, "cnil_api_key", "CNIL API key", false, "")
+	cnilRESTPort := getArg(7, "cnil_http_port", "CNIL REST API port", false, "443")
+	cnilToken := getArg(8, "cnil_personal_token", "CNIL REST API personal token", false, "")
+	ledgerID := getArg(9, "cnil_ledger", "CNIL ledger ID", false, "")
+	cnilAPIKeys := getArg(10, "cnil_api_keys", "CNIL API keys per signer ID", false, "")
+	rotateIfOlderThan := getArg(11, "rotate_if_older_than", "Rotate API keys if older than", false, "")
+	validateAccounts := getArg(12, "validate_signer_accounts", "Validate signer GitHub accounts", false, "false")
+	minAccountAge := getArg(13, "signer_account_min_age", "Minimum signer GitHub account age", false, "7d")
+	signerTeams := getArg(14, "signer_teams", "Signer IDs per GitHub team", false, "")
+	repositoriesMapping := getArg(15, "repositories_mapping", "Repositories mapping location", false, "")
+	auditWebhookURL := getArg(16, "audit_webhook_url", "Audit webhook URL", false, "")
+	auditWebhookSecret := getArg(17, "audit_webhook_secret", "Audit webhook secret", false, "")
+	assetsInclude := getArg(18, "assets_include", "Assets include patterns", false, "")
+	assetsExclude := getArg(19, "assets_exclude", "Assets exclude patterns", false, "")
+	assetSelection := getArg(0, "assets", "Assets", false, "all")
+	maxParallel := getArg(20, "max_parallel", "Max parallel assets", false, "1")
+	mode := getArg(21, "mode", "Mode", false, "notarize")
+	tag := getArg(22, "tag", "Release tag", false, "")
+	releaseRepository := getArg(23, "repository", "Release repository", false, os.Getenv("GITHUB_REPOSITORY"))
+	streamAssets := getArg(24, "stream_assets", "Stream assets", false, "false")
+	signerIDTemplate := getArg(25, "signer_id_template", "Signer ID template", false, "")
+	metadata := getArg(26, "metadata", "Metadata", false, "")
+	uploadChecksums := getArg(27, "upload_checksums", "Upload checksums", false, "false")
+	githubAPIURL := getArg(28, "github_api_url", "GitHub API URL", false, os.Getenv("GITHUB_API_URL"))
+	notarizeSourceArchives := getArg(29, "notarize_source_archives", "Notarize source archives", false, "true")
+	linkSourceArchive := getArg(0, "link_source_archive", "Link source archive", false, "false")
+	notarizeGit := getArg(30, "notarize_git", "Notarize git commit and tag", false, "false")
+	gitDir := getArg(31, "git_dir", "Git repository directory", false, "")
+	sbomPath := getArg(32, "sbom", "SBOM path", false, "")
+	sbomFormat := getArg(33, "sbom_format", "SBOM format", false, "")
+	uploadSBOM := getArg(34, "upload_sbom", "Upload SBOM", false, "false")
+	notarizeDependencies := getArg(0, "notarize_dependencies", "Notarize dependencies", false, "false")
+	dependencyFiles := getArg(0, "dependency_files", "Dependency files", false, "")
+	uploadDependencyManifest := getArg(
+		0, "upload_dependency_manifest", "Upload dependency manifest", false, "false")
+	provenanceDir := getArg(35, "provenance_dir", "Provenance directory", false, "")
+	uploadProvenance := getArg(36, "upload_provenance", "Upload provenance", false, "false")
+	cosignSign := getArg(37, "also_sign_with_cosign", "Also sign with cosign", false, "false")
+	paths := getArg(38, "paths", "Local paths", false, "")
+	images := getArg(39, "images", "Container images", false, "")
+	apiTimeout := getArg(40, "api_timeout", "API timeout", false, "30s")
+	downloadTimeout := getArg(41, "download_timeout", "Download timeout", false, "10m")
+	runTimeout := getArg(42, "run_timeout", "Run timeout", false, "")
+	keyPolicy := getArg(43, "key_policy", "API key policy", false, "rotate")
+	reportFile := getArg(44, "report_file", "Report file", false, "")
+	// the inputs added after the positional arguments have no index
+	maxStreams := getArg(0, "max_streams", "Max concurrent CNIL streams", false, "4")
+	grpcDialTimeout := getArg(0, "cnil_grpc_dial_timeout", "CNIL gRPC dial timeout", false, "")
+	grpcKeepalive := getArg(0, "cnil_grpc_keepalive", "CNIL gRPC keepalive", false, "20s")
+	grpcKeepaliveTimeout := getArg(0, "cnil_grpc_keepalive_timeout", "CNIL gRPC keepalive timeout", false, "10s")
+	grpcMaxAttempts := getArg(0, "cnil_grpc_max_attempts", "CNIL gRPC max attempts", false, "1")
+	grpcRetryBackoff := getArg(0, "cnil_grpc_retry_backoff", "CNIL gRPC retry backoff", false, "1s")
+	maxAssetSize := getArg(0, "max_asset_size", "Max asset size", false, "")
+	deduplicateAssets := getArg(0, "deduplicate_assets", "Deduplicate assets", false, "true")
+	status := getArg(0, "status", "Status", false, "trusted")
+	statusPerAsset := getArg(0, "status_per_asset", "Status per asset", false, "")
+	normalizeAssetNames := getArg(0, "normalize_asset_names", "Normalize asset names", false, "")
+	ledgerNamePerAsset := getArg(0, "ledger_name_per_asset", "Ledger name per asset", false, "")
+	requireStatus := getArg(0, "require_status", "Require status", false, "")
+	verifyLedgers := getArg(0, "verify_ledgers", "Verify ledgers", false, "")
+	requireAllLedgers := getArg(0, "require_all_ledgers", "Require all ledgers", false, "false")
+	denyStatus := getArg(0, "deny_status", "Deny status", false, "")
+	verifyReleaseChecksums := getArg(
+		0, "verify_release_checksums", "Verify release checksums", false, "false")
+	gpgPublicKey := getArg(0, "gpg_public_key", "GPG public key", false, "")
+	verifyAuthenticode := getArg(0, "verify_authenticode", "Verify Authenticode", false, "false")
+	authenticodeSubject := getArg(0, "authenticode_subject", "Authenticode subject", false, "")
+	verifyAppleNotarization := getArg(
+		0, "verify_apple_notarization", "Verify Apple notarization", false, "false")
+	hashesFromChecksums := getArg(0, "hashes_from_checksums", "Hashes from checksums", false, "")
+	digests := getArg(0, "digests", "Digests", false, "")
+	primaryDigest := getArg(0, "primary_digest", "Primary digest", false, "sha256")
+	fallbackSignerID := getArg(0, "fallback_signer_id", "Fallback signer ID", false, "")
+	signerIDFromEmail := getArg(0, "signer_id_from_email", "Signer ID from email", false, "false")
+	metricsPushgatewayURL := getArg(0, "metrics_pushgateway_url", "Metrics Pushgateway URL", false, "")
+	metricsFile := getArg(0, "metrics_file", "Metrics file", false, "")
+	releases := getArg(0, "releases", "Releases", false, "")
+	latestRelease := getArg(0, "release", "Release", false, "")
+	updateReleaseNotes := getArg(0, "update_release_notes", "Update release notes", false, "false")
+	githubAttestations := getArg(0, "github_attestations", "GitHub attestations", false, "false")
+	immudbPort := getArg(0, "immudb_port", "immudb port", false, "3322")
+	immudbUsername := getArg(0, "immudb_username", "immudb username", false, "")
+	immudbPassword := getArg(0, "immudb_password", "immudb password", false, "")
+	immudbDatabase := getArg(0, "immudb_database", "immudb database", false, "defaultdb")
+	exportProofs := getArg(0, "export_proofs", "Export proofs", false, "false")
+	uploadProofFiles := getArg(0, "upload_proof_files", "Upload proof files", false, "false")
+	ledgerStateFile := getArg(0, "ledger_state_file", "Ledger state file", false, "")
+	etagCacheFile := getArg(0, "github_etag_cache_file", "GitHub ETag cache file", false, "")
+	releaseFromEvent := getArg(0, "release_from_event", "Release from event", false, "false")
+	apiKeyCacheFile := getArg(0, "api_key_cache_file", "API key cache file", false, "")
+	apiKeyCachePassphrase := getArg(
+		0, "api_key_cache_passphrase", "API key cache passphrase", false, "")
+	vaultAddr := getArg(0, "vault_addr", "Vault address", false, "")
+	vaultToken := getArg(0, "vault_token", "Vault token", false, "")
+	vaultRoleID := getArg(0, "vault_role_id", "Vault AppRole role ID", false, "")
+	vaultSecretID := getArg(0, "vault_secret_id", "Vault AppRole secret ID", false, "")
+	vaultNamespace := getArg(0, "vault_namespace", "Vault namespace", false, "")
+	vaultMount := getArg(0, "vault_kv_mount", "Vault KV mount", false, "secret")
+	vaultPath := getArg(0, "vault_path", "Vault secret path", false, "")
+	workDir := getArg(0, "work_dir", "Work dir", false, "")
+	helmOCIRegistry := getArg(0, "helm_oci_registry", "Helm OCI registry", false, "")
+	platformManifests := getArg(0, "platform_manifests", "Platform manifests", false, "")
+	platformManifestsOnly := getArg(
+		0, "platform_manifests_only", "Platform manifests only", false, "false")
+	driftBaselineFile := getArg(0, "drift_baseline_file", "Drift baseline file", false, "")
+	sarifFile := getArg(0, "sarif_file", "SARIF file", false, "")
+	notifyWebhook := getArg(0, "notify_webhook", "Notification webhook URL", false, "")
+	notifyWebhookFormat := getArg(0, "notify_webhook_format", "Notification webhook format", false, "auto")
+	createLedgerIfMissing := getArg(
+		0, "create_ledger_if_missing", "Create ledger if missing", false, "false")
+	organization := getArg(0, "organization", "Organization", false, "")
+	since := getArg(0, "since", "Since", false, "")
+	maxParallelReleases := getArg(0, "max_parallel_releases", "Max parallel releases", false, "1")
+	provider := getArg(0, "provider", "Provider", false, "github")
+	bitbucketToken := getArg(0, "bitbucket_token", "Bitbucket token", false, "")
+
+	// the releases of several repositories (e.g. of the product repositories,
+	// from a central "supply-chain" repository) are processed in turn
+	repositories, err := notarize.ParseRepositories(releaseRepository)
+	if err != nil {
+		invalidf("error parsing the \"release repository\" argument value \"%s\": %v", releaseRepository, err)
+	}
+	// the releases of a whole organization (or of the repositories) lacking
+	// notarizations are found and processed in bulk
+	bulk := mode == "bulk"
+	var bulkOptions notarize.BulkOptions
+	if bulk {
+		if len(releaseURL) > 0 || len(tag) > 0 || len(releases) > 0 || len(latestRelease) > 0 ||
+			len(paths) > 0 || len(images) > 0 {
+			invalidf("the bulk mode is mutually exclusive with the release URL, the release tag, " +
+				"the releases, the local paths and the images")
+		}
+		bulkOptions.Organization = organization
+		if len(organization) == 0 {
+			bulkOptions.Repositories = repositories
+		}
+		if len(bulkOptions.Organization) == 0 && len(bulkOptions.Repositories) == 0 {
+			invalidf("either the organization or the release repository is required in bulk mode")
+		}
+		if len(since) > 0 {
+			bulkOptions.Since, err = notarize.ParseCutoff(since)
+			if err != nil {
+				invalidf("error parsing the \"since\" argument value \"%s\": %v", since, err)
+			}
+		}
+	} else if len(organization) > 0 || len(since) > 0 {
+		invalidf("the organization and the cutoff date are only used in bulk mode")
+	}
+	multiRepository := len(repositories) > 1 && !bulk
+
+	// the releases mirrored on Bitbucket are single tags of single repositories
+	parsedProvider, err := notarize.ParseProvider(provider)
+	if err != nil {
+		invalidf("error parsing the \"provider\" argument value \"%s\": %v", provider, err)
+	}
+	bitbucketRelease := parsedProvider == notarize.ProviderBitbucket
+	if bitbucketRelease && (bulk || multiRepository || len(releases) > 0 || len(latestRelease) > 0) {
+		invalidf("the bulk mode, multiple repositories, the releases and the latest release " +
+			"are not supported with Bitbucket")
+	}
+	if multiRepository {
+		if len(releaseURL) > 0 || len(paths) > 0 || len(images) > 0 {
+			invalidf("multiple repositories are mutually exclusive with the release URL, " +
+				"the local paths and the images")
+		}
+		nbReleaseArgs := 0
+		for _, arg := range []string{latestRelease, tag, releases} {
+			if len(arg) > 0 {
+				nbReleaseArgs++
+			}
+		}
+		if nbReleaseArgs != 1 {
+			invalidf("exactly one of the latest release, the release tag and the releases is required " +
+				"for multiple repositories")
+		}
+		if len(latestRelease) > 0 && latestRelease != "latest" {
+			invalidf("invalid release %s: must be latest", latestRelease)
+		}
+	}
+
+	// the latest release (e.g. for scheduled workflows) is resolved by GitHub
+	if len(latestRelease) > 0 && !multiRepository {
+		if latestRelease != "latest" {
+			invalidf("invalid release %s: must be latest", latestRelease)
+		}
+		if len(releaseURL) > 0 || len(tag) > 0 || len(releases) > 0 {
+			invalidf("the latest release is mutually exclusive with the release URL, " +
+				"the release tag and the releases")
+		}
+		if len(releaseRepository) == 0 {
+			invalidf("the release repository is required for the latest release")
+		}
+		releaseURL = github.LatestReleaseURL(githubAPIURL, releaseRepository)
+		logger.Infof("Using release URL %s\n", releaseURL)
+	}
+
+	// the release can be specified by its tag (e.g. for push: tags or
+	// workflow_dispatch triggers) instead of its API URL
+	if multiRepository || bulk {
+		// the releases of each repository are resolved before the run
+	} else if len(releases) > 0 {
+		if len(releaseURL) > 0 || len(tag) > 0 {
+			invalidf("the releases are mutually exclusive with the release URL and the release tag")
+		}
+	} else if len(releaseURL) == 0 && len(tag) == 0 {
+		if len(paths) == 0 && len(images) == 0 {
+			invalidf("either the release URL, the release tag, the local paths or the images are required")
+		}
+	} else if len(releaseURL) == 0 {
+		if len(releaseRepository) == 0 {
+			invalidf("the release repository is required when the release is specified by its tag")
+		}
+		if bitbucketRelease {
+			releaseURL = bitbucket.TagURL("", releaseRepository, tag)
+		} else {
+			releaseURL = github.ReleaseURLFromTag(githubAPIURL, releaseRepository, tag)
+		}
+		logger.Infof("Using release URL %s\n", releaseURL)
+	} else if len(tag) > 0 {
+		invalidf("the release URL and the release tag are mutually exclusive")
+	}
+
+	var run func(context.Context, *notarize.Config) (*notarize.Report, error)
+	switch mode {
+	case "notarize":
+		run = notarize.NotarizeRelease
+	case "verify":
+		run = notarize.VerifyRelease
+	case "reverify":
+		run = notarize.ReverifyRelease
+	case "untrust":
+		run = notarize.UntrustRelease
+	case "bulk":
+		run = func(ctx context.Context, cfg *notarize.Config) (*notarize.Report, error) {
+			return notarize.BulkNotarize(ctx, cfg, bulkOptions)
+		}
+	default:
+		invalidf("invalid mode %s: must be either notarize, verify, reverify, untrust or bulk", mode)
+	}
+	if len(sarifFile) > 0 && mode != "verify" && mode != "reverify" {
+		invalidf("the SARIF file is only written in verify and reverify modes")
+	}
+
+	// set the action outputs and write the step summary, even if the run fails
+	exitHooks = append(exitHooks, func(errMsg string) {
+		if err := setOutputs(); err != nil {
+			logger.Errorf("error setting the action outputs: %v\n", err)
+		}
+		if err := writeStepSummary(errMsg); err != nil {
+			logger.Errorf("error writing the step summary: %v\n", err)
+		}
+		if len(reportFile) > 0 {
+			if err := writeReportFile(reportFile); err != nil {
+				logger.Errorf("error writing the report file: %v\n", err)
+			}
+		}
+		if len(sarifFile) > 0 {
+			if err := notarize.WriteSARIF(sarifFile, summary); err != nil {
+				logger.Errorf("error writing the SARIF file: %v\n", err)
+			} else {
+				logger.Infof("Wrote the verification findings into %s\n", sarifFile)
+			}
+		}
+	})
+
+	summary.Operation = mode
+	summary.ReleaseURL = releaseURL
+	summary.Repository = github.RepositoryFromReleaseURL(releaseURL)
+	if bitbucketRelease {
+		summary.Repository = bitbucket.RepositoryFromTagURL(releaseURL)
+	}
+	if len(summary.Repository) == 0 && (len(releases) > 0 || multiRepository || bulk) {
+		summary.Repository = strings.Join(repositories, ", ")
+		if len(organization) > 0 {
+			summary.Repository = organization
+		}
+	}
+	if len(summary.Repository) == 0 {
+		summary.Repository = os.Getenv("GITHUB_REPOSITORY")
+	}
+	var workflowRunURL string
+	if runID := os.Getenv("GITHUB_RUN_ID"); len(runID) > 0 {
+		workflowRunURL = fmt.Sprintf("%s/%s/actions/runs/%s",
+			os.Getenv("GITHUB_SERVER_URL"), os.Getenv("GITHUB_REPOSITORY"), runID)
+		summary.WorkflowRunURL = workflowRunURL
+	}
+
+	logger.Infof("\n")
+
+	// the CNIL hosts after the first one are its fallbacks
+	cnilHosts, err := notarize.ParsePatterns(cnilHost)
+	if err != nil {
+		invalidf("error parsing the \"CNIL host\" argument value \"%s\": %v", cnilHost, err)
+	}
+	var cnilFallbackHosts, cnilFallbackRESTURLs []string
+	if len(cnilHosts) > 0 {
+		cnilHost, cnilFallbackHosts = cnilHosts[0], cnilHosts[1:]
+	}
+	for _, host := range cnilFallbackHosts {
+		cnilFallbackRESTURLs = append(cnilFallbackRESTURLs,
+			fmt.Sprintf("https://%s:%s/api/v1", host, cnilRESTPort))
+	}
+
+	cfg := &notarize.Config{
+		CNILHost:             cnilHost,
+		CNILFallbackHosts:    cnilFallbackHosts,
+		CNILFallbackRESTURLs: cnilFallbackRESTURLs,
+		CNILGRPCPort:         cnilgRPCPort,
+		CNILRESTURL:          fmt.Sprintf("https://%s:%s/api/v1", cnilHost, cnilRESTPort),
+		CNILToken:            cnilToken,
+		APIKey:               cnilAPIKey,
+		ImmudbHost:           immudbHost,
+		ImmudbPort:           immudbPort,
+		ImmudbUsername:       immudbUsername,
+		ImmudbPassword:       immudbPassword,
+		ImmudbDatabase:       immudbDatabase,
+		ReleaseURL:           releaseURL,
+		Actor:                os.Getenv("GITHUB_ACTOR"),
+		Repository:           os.Getenv("GITHUB_REPOSITORY"),
+		GitHubAPIURL:         githubAPIURL,
+		GitHubToken:          githubToken,
+		Provider:             parsedProvider,
+		BitbucketToken:       bitbucketToken,
+		SignerIDTemplate:     signerIDTemplate,
+		RepositoriesMapping:  repositoriesMapping,
+		Logger:               logger,
+	}
+
+	cfg.LedgerID, cfg.LedgersPerAsset, err = notarize.ParseLedgers(ledgerID)
+	if err != nil {
+		invalidf("error parsing the \"CNIL ledger ID\" argument value \"%s\": %v", ledgerID, err)
+	}
+
+	cfg.Status, err = notarize.ParseStatus(status)
+	if err != nil {
+		invalidf("%v", err)
+	}
+	if len(statusPerAsset) > 0 {
+		cfg.StatusPerAsset, err = notarize.ParseStatusPerAsset(statusPerAsset)
+		if err != nil {
+			invalidf("%v", err)
+		}
+	}
+	cfg.AssetNames, err = notarize.ParseAssetNames(normalizeAssetNames, ledgerNamePerAsset)
+	if err != nil {
+		invalidf("%v", err)
+	}
+	cfg.RequireStatus = requireStatus
+	cfg.VerifyLedgers, err = notarize.ParseCrossLedgers(verifyLedgers)
+	if err != nil {
+		invalidf("error parsing the \"verify ledgers\" argument value \"%s\": %v", verifyLedgers, err)
+	}
+	cfg.RequireAllLedgers, err = strconv.ParseBool(requireAllLedgers)
+	if err != nil {
+		invalidf("error parsing the \"require all ledgers\" argument value \"%s\": %v",
+			requireAllLedgers, err)
+	}
+	cfg.DenyStatuses, err = notarize.ParseEntryStatuses(denyStatus)
+	if err != nil {
+		invalidf("error parsing the \"deny status\" argument value \"%s\": %v", denyStatus, err)
+	}
+
+	cfg.APITimeout, err = notarize.ParseAge(apiTimeout)
+	if err != nil {
+		invalidf("error parsing the \"API timeout\" argument value \"%s\": %v", apiTimeout, err)
+	}
+	cfg.DownloadTimeout, err = notarize.ParseAge(downloadTimeout)
+	if err != nil {
+		invalidf("error parsing the \"download timeout\" argument value \"%s\": %v",
+			downloadTimeout, err)
+	}
+
+	// abort cleanly (i.e. with the results of the assets processed so far)
+	// when the run deadline is hit or when the job is cancelled
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if len(runTimeout) > 0 {
+		timeout, err := notarize.ParseAge(runTimeout)
+		if err != nil {
+			invalidf("error parsing the \"run timeout\" argument value \"%s\": %v", runTimeout, err)
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if len(cnilNoTLS) > 0 {
+		cfg.CNILNoTLS, err = strconv.ParseBool(cnilNoTLS)
+		if err != nil {
+			invalidf("error parsing the \"no TLS\" argument value \"%s\": %v",
+				cnilNoTLS, err)
+		}
+	}
+	cfg.CNILCACert = cnilCACert
+	cfg.CNILClientCert = cnilClientCert
+	cfg.CNILClientKey = cnilClientKey
+	cfg.ProxyURL = proxyURL
+
+	cfg.KeyPolicy, err = cnil.ParseKeyPolicy(keyPolicy)
+	if err != nil {
+		invalidf("error parsing the \"API key policy\" argument value \"%s\": %v", keyPolicy, err)
+	}
+
+	if len(rotateIfOlderThan) > 0 {
+		if cfg.KeyPolicy != cnil.KeyPolicyRotate {
+			invalidf("the \"rotate if older than\" argument applies only to the %s API key policy",
+				cnil.KeyPolicyRotate)
+		}
+		cfg.RotateIfOlderThan, err = notarize.ParseAge(rotateIfOlderThan)
+		if err != nil {
+			invalidf("error parsing the \"rotate if older than\" argument value \"%s\": %v",
+				rotateIfOlderThan, err)
+		}
+	}
+
+	// the CNIL credentials which are not specified as inputs are read from
+	// Vault, where the API keys issued by the run are written back
+	if len(vaultAddr) > 0 {
+		if len(apiKeyCacheFile) > 0 {
+			invalidf("the \"API key cache file\" and \"Vault address\" arguments are mutually exclusive")
+		}
+		// Vault is not contacted with invalid inputs
+		checkConfig()
+		vaultCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		vaultClient, err := vault.NewClient(vaultCtx, http.DefaultClient, &vault.Options{
+			Address:   vaultAddr,
+			Token:     vaultToken,
+			RoleID:    vaultRoleID,
+			SecretID:  vaultSecretID,
+			Namespace: vaultNamespace,
+			Mount:     vaultMount,
+			Path:      vaultPath,
+		})
+		if err != nil {
+			exitf(notarize.ExitFailure, "%v", err)
+		}
+		maskSecret(vaultClient.Token())
+		secret, err := vaultClient.ReadSecret(vaultCtx)
+		cancel()
+		if err != nil {
+			exitf(notarize.ExitFailure, "%v", err)
+		}
+		if len(cfg.CNILToken) == 0 {
+			cfg.CNILToken = vault.PersonalToken(secret)
+			maskSecret(cfg.CNILToken)
+		}
+		if len(cnilAPIKey) == 0 && len(cnilAPIKeys) == 0 {
+			cfg.APIKeysPerSignerID, err = vault.APIKeysPerSignerID(secret)
+			if err != nil {
+				invalidf("%v", err)
+			}
+			for _, apiKey := range cfg.APIKeysPerSignerID {
+				maskSecret(apiKey)
+			}
+		}
+		cfg.APIKeyCache = vault.NewAPIKeyCache(vaultClient, secret)
+		logger.Infof("Read the CNIL credentials from Vault secret %s\n", vaultPath)
+	}
+
+	if len(apiKeyCacheFile) > 0 {
+		if cfg.KeyPolicy == cnil.KeyPolicyRotate && cfg.RotateIfOlderThan == 0 {
+			invalidf("the \"API key cache file\" argument requires either the \"rotate if older than\" "+
+				"argument or the %s API key policy", cnil.KeyPolicyReuse)
+		}
+		cfg.APIKeyCache, err = cnil.OpenFileAPIKeyCache(apiKeyCacheFile, apiKeyCachePassphrase)
+		if err != nil {
+			invalidf("%v", err)
+		}
+	}
+
+	cfg.CreateLedgerIfMissing, err = strconv.ParseBool(createLedgerIfMissing)
+	if err != nil {
+		invalidf("error parsing the \"create ledger if missing\" argument value \"%s\": %v",
+			createLedgerIfMissing, err)
+	}
+	if cfg.CreateLedgerIfMissing && len(cfg.CNILToken) == 0 {
+		invalidf("the \"create ledger if missing\" argument requires the CNIL personal token")
+	}
+
+	if len(validateAccounts) > 0 {
+		cfg.ValidateAccounts, err = strconv.ParseBool(validateAccounts)
+		if err != nil {
+			invalidf("error parsing the \"validate signer accounts\" argument value \"%s\": %v",
+				validateAccounts, err)
+		}
+		cfg.AccountsMinAge, err = notarize.ParseAge(minAccountAge)
+		if err != nil {
+			invalidf("error parsing the \"minimum account age\" argument value \"%s\": %v",
+				minAccountAge, err)
+		}
+	}
+
+	if len(signerTeams) > 0 {
+		cfg.SignerIDsPerTeam, err = notarize.ParseSignerIDsPerTeam(signerTeams)
+		if err != nil {
+			invalidf("%v", err)
+		}
+	}
+
+	cfg.AssetsInclude, err = notarize.ParsePatterns(assetsInclude)
+	if err != nil {
+		invalidf("error parsing the \"assets include\" argument value \"%s\": %v",
+			assetsInclude, err)
+	}
+	cfg.AssetsExclude, err = notarize.ParsePatterns(assetsExclude)
+	if err != nil {
+		invalidf("error parsing the \"assets exclude\" argument value \"%s\": %v",
+			assetsExclude, err)
+	}
+	cfg.AssetSelection, err = notarize.ParseAssetSelection(assetSelection)
+	if err != nil {
+		invalidf("error parsing the \"assets\" argument value \"%s\": %v", assetSelection, err)
+	}
+
+	cfg.Metadata, err = notarize.ParseMetadata(metadata)
+	if err != nil {
+		invalidf("error parsing the \"metadata\" argument value \"%s\": %v", metadata, err)
+	}
+
+	notarizeSourceArchivesVal, err := strconv.ParseBool(notarizeSourceArchives)
+	if err != nil {
+		invalidf("error parsing the \"notarize source archives\" argument value \"%s\": %v",
+			notarizeSourceArchives, err)
+	}
+	cfg.SkipSourceArchives = !notarizeSourceArchivesVal
+	cfg.LinkSourceArchive, err = strconv.ParseBool(linkSourceArchive)
+	if err != nil {
+		invalidf("error parsing the \"link source archive\" argument value \"%s\": %v",
+			linkSourceArchive, err)
+	}
+
+	deduplicateAssetsVal, err := strconv.ParseBool(deduplicateAssets)
+	if err != nil {
+		invalidf("error parsing the \"deduplicate assets\" argument value \"%s\": %v",
+			deduplicateAssets, err)
+	}
+	cfg.SkipDeduplication = !deduplicateAssetsVal
+
+	cfg.NotarizeGit, err = strconv.ParseBool(notarizeGit)
+	if err != nil {
+		invalidf("error parsing the \"notarize git\" argument value \"%s\": %v",
+			notarizeGit, err)
+	}
+	cfg.GitDir = gitDir
+
+	cfg.Paths, err = notarize.ParsePatterns(paths)
+	if err != nil {
+		invalidf("error parsing the \"paths\" argument value \"%s\": %v", paths, err)
+	}
+
+	cfg.Images, err = notarize.ParsePatterns(images)
+	if err != nil {
+		invalidf("error parsing the \"images\" argument value \"%s\": %v", images, err)
+	}
+
+	cfg.CosignSign, err = strconv.ParseBool(cosignSign)
+	if err != nil {
+		invalidf("error parsing the \"also sign with cosign\" argument value \"%s\": %v",
+			cosignSign, err)
+	}
+
+	cfg.GitHubAttestations, err = strconv.ParseBool(githubAttestations)
+	if err != nil {
+		invalidf("error parsing the \"GitHub attestations\" argument value \"%s\": %v",
+			githubAttestations, err)
+	}
+
+	// the GitHub attestations are made of the provenance statements
+	if len(provenanceDir) > 0 || cfg.GitHubAttestations {
+		cfg.Provenance = &notarize.ProvenanceOptions{
+			Dir:          provenanceDir,
+			ServerURL:    os.Getenv("GITHUB_SERVER_URL"),
+			WorkflowRef:  os.Getenv("GITHUB_WORKFLOW_REF"),
+			InvocationID: workflowRunURL,
+		}
+		if attempt := os.Getenv("GITHUB_RUN_ATTEMPT"); len(workflowRunURL) > 0 && len(attempt) > 0 {
+			cfg.Provenance.InvocationID = workflowRunURL + "/attempts/" + attempt
+		}
+		cfg.Provenance.Upload, err = strconv.ParseBool(uploadProvenance)
+		if err != nil {
+			invalidf("error parsing the \"upload provenance\" argument value \"%s\": %v",
+				uploadProvenance, err)
+		}
+	}
+
+	cfg.SBOMPath = sbomPath
+	cfg.SBOMFormat = strings.ToLower(sbomFormat)
+	if len(cfg.SBOMFormat) > 0 && cfg.SBOMFormat != "cyclonedx" && cfg.SBOMFormat != "spdx" {
+		invalidf("invalid SBOM format %s: must be either cyclonedx or spdx", sbomFormat)
+	}
+	cfg.UploadSBOM, err = strconv.ParseBool(uploadSBOM)
+	if err != nil {
+		invalidf("error parsing the \"upload SBOM\" argument value \"%s\": %v", uploadSBOM, err)
+	}
+	withDependencies, err := strconv.ParseBool(notarizeDependencies)
+	if err != nil {
+		invalidf("error parsing the \"notarize dependencies\" argument value \"%s\": %v",
+			notarizeDependencies, err)
+	}
+	if withDependencies {
+		cfg.Dependencies = &notarize.DependencyOptions{}
+		cfg.Dependencies.Files, err = notarize.ParsePatterns(dependencyFiles)
+		if err != nil {
+			invalidf("error parsing the \"dependency files\" argument value \"%s\": %v",
+				dependencyFiles, err)
+		}
+		cfg.Dependencies.Upload, err = strconv.ParseBool(uploadDependencyManifest)
+		if err != nil {
+			invalidf("error parsing the \"upload dependency manifest\" argument value \"%s\": %v",
+				uploadDependencyManifest, err)
+		}
+	}
+
+	cfg.UploadChecksums, err = strconv.ParseBool(uploadChecksums)
+	if err != nil {
+		invalidf("error parsing the \"upload checksums\" argument value \"%s\": %v",
+			uploadChecksums, err)
+	}
+	cfg.UpdateReleaseNotes, err = strconv.ParseBool(updateReleaseNotes)
+	if err != nil {
+		invalidf("error parsing the \"update release notes\" argument value \"%s\": %v",
+			updateReleaseNotes, err)
+	}
+	cfg.ExportProofs, err = strconv.ParseBool(exportProofs)
+	if err != nil {
+		invalidf("error parsing the \"export proofs\" argument value \"%s\": %v", exportProofs, err)
+	}
+	cfg.UploadProofFiles, err = strconv.ParseBool(uploadProofFiles)
+	if err != nil {
+		invalidf("error parsing the \"upload proof files\" argument value \"%s\": %v",
+			uploadProofFiles, err)
+	}
+
+	cfg.VerifyReleaseChecksums, err = strconv.ParseBool(verifyReleaseChecksums)
+	if err != nil {
+		invalidf("error parsing the \"verify release checksums\" argument value \"%s\": %v",
+			verifyReleaseChecksums, err)
+	}
+	cfg.LedgerStateFile = ledgerStateFile
+	cfg.GitHubETagCacheFile = etagCacheFile
+	readEvent, err := strconv.ParseBool(releaseFromEvent)
+	if err != nil {
+		invalidf("error parsing the \"release from event\" argument value \"%s\": %v",
+			releaseFromEvent, err)
+	}
+	// the payload of a re-run is the one of the original run, which might be
+	// stale by now
+	if readEvent && os.Getenv("GITHUB_EVENT_NAME") == "release" {
+		if attempt := os.Getenv("GITHUB_RUN_ATTEMPT"); len(attempt) == 0 || attempt == "1" {
+			cfg.ReleaseEventFile = os.Getenv("GITHUB_EVENT_PATH")
+			cfg.ReleaseEventCommitSHA = os.Getenv("GITHUB_SHA")
+		}
+	}
+	cfg.WorkDir = workDir
+	cfg.HelmOCIRegistry = helmOCIRegistry
+	if len(platformManifests) > 0 {
+		cfg.PlatformManifests = &notarize.PlatformManifestOptions{}
+		cfg.PlatformManifests.Groups, err = notarize.ParsePlatformGroups(platformManifests)
+		if err != nil {
+			invalidf("%v", err)
+		}
+		cfg.PlatformManifests.Only, err = strconv.ParseBool(platformManifestsOnly)
+		if err != nil {
+			invalidf("error parsing the \"platform manifests only\" argument value \"%s\": %v",
+				platformManifestsOnly, err)
+		}
+	}
+	cfg.DriftBaselineFile = driftBaselineFile
+	cfg.GPGPublicKey = gpgPublicKey
+	cfg.VerifyAuthenticode, err = strconv.ParseBool(verifyAuthenticode)
+	if err != nil {
+		invalidf("error parsing the \"verify Authenticode\" argument value \"%s\": %v",
+			verifyAuthenticode, err)
+	}
+	cfg.AuthenticodeSubject = authenticodeSubject
+	cfg.VerifyAppleNotarization, err = strconv.ParseBool(verifyAppleNotarization)
+	if err != nil {
+		invalidf("error parsing the \"verify Apple notarization\" argument value \"%s\": %v",
+			verifyAppleNotarization, err)
+	}
+	cfg.HashesFromChecksums = hashesFromChecksums
+	cfg.Digests, err = notarize.ParseDigests(digests)
+	if err != nil {
+		invalidf("error parsing the \"digests\" argument value \"%s\": %v", digests, err)
+	}
+	cfg.PrimaryDigest, err = notarize.ParseDigest(primaryDigest)
+	if err != nil {
+		invalidf("error parsing the \"primary digest\" argument value \"%s\": %v", primaryDigest, err)
+	}
+	cfg.FallbackSignerID = fallbackSignerID
+	cfg.MetricsPushgatewayURL = metricsPushgatewayURL
+	cfg.MetricsFile = metricsFile
+	cfg.SignerIDFromEmail, err = strconv.ParseBool(signerIDFromEmail)
+	if err != nil {
+		invalidf("error parsing the \"signer ID from email\" argument value \"%s\": %v",
+			signerIDFromEmail, err)
+	}
+
+	cfg.StreamAssets, err = strconv.ParseBool(streamAssets)
+	if err != nil {
+		invalidf("error parsing the \"stream assets\" argument value \"%s\": %v",
+			streamAssets, err)
+	}
+
+	cfg.MaxParallel, err = strconv.Atoi(maxParallel)
+	if err != nil || cfg.MaxParallel < 1 {
+		invalidf("invalid \"max parallel\" argument value \"%s\": must be a positive integer",
+			maxParallel)
+	}
+	cfg.MaxParallelReleases, err = strconv.Atoi(maxParallelReleases)
+	if err != nil || cfg.MaxParallelReleases < 1 {
+		invalidf("invalid \"max parallel releases\" argument value \"%s\": must be a positive integer",
+			maxParallelReleases)
+	}
+	cfg.MaxStreams, err = strconv.Atoi(maxStreams)
+	if err != nil || cfg.MaxStreams < 1 {
+		invalidf("invalid \"max streams\" argument value \"%s\": must be a positive integer",
+			maxStreams)
+	}
+	if len(grpcDialTimeout) > 0 {
+		cfg.GRPCDialTimeout, err = notarize.ParseAge(grpcDialTimeout)
+		if err != nil {
+			invalidf("error parsing the \"CNIL gRPC dial timeout\" argument value \"%s\": %v",
+				grpcDialTimeout, err)
+		}
+	}
+	cfg.GRPCKeepalive, err = notarize.ParseAge(grpcKeepalive)
+	if err != nil {
+		invalidf("error parsing the \"CNIL gRPC keepalive\" argument value \"%s\": %v", grpcKeepalive, err)
+	}
+	cfg.GRPCKeepaliveTimeout, err = notarize.ParseAge(grpcKeepaliveTimeout)
+	if err != nil {
+		invalidf("error parsing the \"CNIL gRPC keepalive timeout\" argument value \"%s\": %v",
+			grpcKeepaliveTimeout, err)
+	}
+	cfg.GRPCMaxAttempts, err = strconv.Atoi(grpcMaxAttempts)
+	if err != nil || cfg.GRPCMaxAttempts < 1 {
+		invalidf("invalid \"CNIL gRPC max attempts\" argument value \"%s\": must be a positive integer",
+			grpcMaxAttempts)
+	}
+	cfg.GRPCRetryBackoff, err = notarize.ParseAge(grpcRetryBackoff)
+	if err != nil {
+		invalidf("error parsing the \"CNIL gRPC retry backoff\" argument value \"%s\": %v",
+			grpcRetryBackoff, err)
+	}
+	cfg.MaxAssetSize, err = notarize.ParseSize(maxAssetSize)
+	if err != nil {
+		invalidf("error parsing the \"max asset size\" argument value \"%s\": %v", maxAssetSize, err)
+	}
+
+	if len(auditWebhookURL) > 0 {
+		if len(auditWebhookSecret) == 0 {
+			invalidf("an audit webhook secret is required for signing the audit webhook payloads")
+		}
+		exitHooks = append(exitHooks, func(errMsg string) {
+			if err := sendAuditEvent(auditWebhookURL, auditWebhookSecret, errMsg); err != nil {
+				logger.Errorf("error sending the audit event: %v\n", err)
+			}
+		})
+	}
+
+	if len(notifyWebhook) > 0 {
+		if !notifyFormats[notifyWebhookFormat] {
+			invalidf("invalid \"notification webhook format\" argument value \"%s\": "+
+				"must be auto, slack, teams or json", notifyWebhookFormat)
+		}
+		exitHooks = append(exitHooks, func(errMsg string) {
+			if err := sendNotification(notifyWebhook, notifyWebhookFormat, errMsg); err != nil {
+				logger.Errorf("error sending the notification: %v\n", err)
+			}
+		})
+	}
+
+	if len(cnilAPIKey) == 0 && len(cnilAPIKeys) > 0 {
+		cfg.APIKeysPerSignerID, err = notarize.ParseAPIKeysPerSignerID(cnilAPIKeys)
+		if err != nil {
+			invalidf("%v", err)
+		}
+		for _, apiKey := range cfg.APIKeysPerSignerID {
+			maskSecret(apiKey)
+		}
+	}
+
+	// the settings are validated as a whole, before the run, the releases
+	// processed in turn being resolved only then
+	if len(releases) > 0 || multiRepository || bulk {
+		configErr.Add(cfg.ValidateReleases())
+	} else {
+		configErr.Add(cfg.Validate())
+	}
+	checkConfig()
+
+	// the release might belong to a different repository than the one the
+	// workflow runs in (e.g. a central "release-ops" repository)
+	repository := summary.Repository
+	workflowRepository := os.Getenv("GITHUB_REPOSITORY")
+	crossRepository := len(workflowRepository) > 0 && len(repository) > 0 &&
+		!strings.EqualFold(repository, workflowRepository)
+	if bulk {
+		logger.Infof("Running in bulk mode for the releases of %s\n", repository)
+	} else if crossRepository && multiRepository {
+		logger.Infof("Running in %s mode for the releases of repositories %s from repository %s\n",
+			mode, repository, workflowRepository)
+	} else if crossRepository {
+		logger.Infof("Running in %s mode for a release of repository %s from repository %s\n",
+			mode, repository, workflowRepository)
+	}
+
+	// export the OpenTelemetry spans, if an OTLP endpoint is configured
+	shutdownTracing, err := notarize.StartTracing(ctx)
+	if err != nil {
+		exitf(notarize.ExitFailure, "%v", err)
+	}
+	exitHooks = append(exitHooks, func(string) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			logger.Errorf("error exporting the traces: %v\n", err)
+		}
+	})
+
+	// backfill multiple releases (e.g. all the historical ones) in one run,
+	// possibly of several repositories
+	if len(releases) > 0 || multiRepository {
+		var releaseURLs []string
+		if multiRepository {
+			releaseURLs, err = notarize.ResolveRepositoriesReleaseURLs(
+				ctx, cfg, repositories, len(latestRelease) > 0, tag, releases)
+		} else {
+			releaseURLs, err = notarize.ResolveReleaseURLs(ctx, cfg, releaseRepository, releases)
+		}
+		if err != nil {
+			exitf(notarize.ExitCode(err), "error resolving the releases: %v", err)
+		}
+		if multiRepository {
+			logger.Infof("Processing %d releases of %d repositories\n", len(releaseURLs), len(repositories))
+		} else {
+			logger.Infof("Processing %d releases of repository %s\n", len(releaseURLs), repository)
+		}
+		processRelease := run
+		run = func(ctx context.Context, cfg *notarize.Config) (*notarize.Report, error) {
+			return notarize.ProcessReleases(ctx, cfg, releaseURLs, processRelease)
+		}
+	}
+
+	report, err := run(ctx, cfg)
+	report.WorkflowRunURL = workflowRunURL
+	summary = report
+	if err != nil {
+		if crossRepository && errors.Is(err, github.ErrNotFound) {
+			err = fmt.Errorf(
+				"%v (note that the GITHUB_TOKEN of a workflow can access only the repository "+
+					"the workflow runs in: a token allowed to read the releases of %s is needed)",
+				err, repository)
+		}
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("the run timeout of %s has been hit: %v", runTimeout, err)
+		}
+		exitf(notarize.ExitCode(err), "%v", err)
+	}
+
+	// print success message
+	if mode == "verify" {
+		logger.Successf("All %d assets are notarized and trusted.\n", len(report.Assets))
+	} else if mode == "reverify" {
+		logger.Successf("All %d assets are notarized and trusted, without drift.\n", len(report.Assets))
+	} else if mode == "untrust" {
+		logger.Successf("All %d assets have been successfully untrusted.\n", len(report.Assets))
+	} else if bulk {
+		nbSkipped := 0
+		for _, releaseReport := range report.Releases {
+			if releaseReport.Skipped {
+				nbSkipped++
+			}
+		}
+		logger.Successf("All %d releases have been processed (%d of them notarized already).\n",
+			len(report.Releases), nbSkipped)
+	} else {
+		logger.Successf("All %d assets have been successfully notarized.\n", len(report.Assets))
+	}
+
+	runExitHooks("")
+}
+
+// consoleLogger prints the progress to the standard output, using colors
+// unless they are disabled. In GitHub Actions, the warnings and the failures
+// are emitted as workflow commands, so that they show up as annotations of
+// the run.
+type consoleLogger struct {
+	color    bool
+	annotate bool
+}
+
+// newConsoleLogger returns the console logger, whose colors are disabled if
+// the NO_COLOR environment variable is set (see https://no-color.org) or if
+// the standard output is not a terminal, except in GitHub Actions whose logs
+// render the colors.
+func newConsoleLogger() *consoleLogger {
+	annotate := os.Getenv("GITHUB_ACTIONS") == "true"
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return &consoleLogger{annotate: annotate}
+	}
+	if annotate {
+		return &consoleLogger{color: true, annotate: true}
+	}
+	info, err := os.Stdout.Stat()
+	return &consoleLogger{color: err == nil && info.Mode()&os.ModeCharDevice != 0}
+}
+
+// printf prints the formatted message with the given color, if enabled.
+func (l *consoleLogger) printf(color string, format string, a ...interface{}) {
+	msg := fmt.Sprintf(format, a...)
+	if l.color && len(color) > 0 {
+		msg = fmt.Sprintf(color, msg)
+	}
+	fmt.Print(msg)
+}
+
+func (l *consoleLogger) Infof(format string, a ...interface{}) {
+	l.printf("", format, a...)
+}
+
+func (l *consoleLogger) Successf(format string, a ...interface{}) {
+	l.printf(green, format, a...)
+}
+
+func (l *consoleLogger) Warningf(format string, a ...interface{}) {
+	if l.annotate {
+		msg := strings.TrimPrefix(fmt.Sprintf(format, a...), "WARNING: ")
+		l.annotatef("warning", "", "%s", msg)
+		return
+	}
+	l.printf(yellow, format, a...)
+}
+
+func (l *consoleLogger) Errorf(format string, a ...interface{}) {
+	l.printf(red, format, a...)
+}
+
+// annotatef emits the workflow command (i.e. error or warning) annotating the
+// run with the formatted message and the given title, if any, or else prints
+// the message in red.
+func (l *consoleLogger) annotatef(command string, title string, format string, a ...interface{}) {
+	msg := strings.TrimSuffix(fmt.Sprintf(format, a...), "\n")
+	if !l.annotate {
+		if len(title) > 0 {
+			msg = title + ": " + msg
+		}
+		l.printf(red, "%s\n", msg)
+		return
+	}
+	var properties string
+	if len(title) > 0 {
+		properties = " title=" + escapeWorkflowCommandProperty(title)
+	}
+	fmt.Printf("::%s%s::%s\n", command, properties, escapeWorkflowCommandData(msg))
+}
+
+// escapeWorkflowCommandData escapes the message of a workflow command.
+func escapeWorkflowCommandData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// escapeWorkflowCommandProperty escapes the value of a property of a workflow
+// command.
+func escapeWorkflowCommandProperty(s string) string {
+	return strings.NewReplacer(
+		"%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(s)
+}
+
+// annotateFailures annotates the run with the error of each failed asset, with
+// the asset (and its release and ledger, if any) as title.
+func annotateFailures() {
+	for _, asset := range summary.Assets {
+		if len(asset.Error) == 0 {
+			continue
+		}
+		title := "Asset " + asset.Name
+		if len(asset.ReleaseTag) > 0 {
+			title += " of release " + asset.ReleaseTag
+		}
+		if len(asset.Repository) > 0 {
+			title += " of " + asset.Repository
+		}
+		if len(asset.LedgerID) > 0 {
+			title += " in ledger " + asset.LedgerID
+		}
+		logger.annotatef("error", title, "%s", asset.Error)
+	}
+}
+
+// MaskSecret asks GitHub Actions to redact the given secret from the logs.
+func (l *consoleLogger) MaskSecret(secret string) {
+	maskSecret(secret)
+}
+
+var (
+	summary = &notarize.Report{Operation: "notarize", StartedAt: time.Now().UTC()}
+	// exitHooks are run right before the action exits, with the error message
+	// the action is aborting with (empty on success)
+	exitHooks []func(errMsg string)
+	// exitCode is the code the action exits with
+	exitCode = notarize.ExitSuccess
+)
+
+func runExitHooks(errMsg string) {
+	summary.FinishedAt = time.Now().UTC()
+	summary.Success = len(errMsg) == 0
+	summary.Error = errMsg
+	for _, hook := range exitHooks {
+		hook(errMsg)
+	}
+}
+
+// configErr collects the problems of the inputs, which are reported all at
+// once by checkConfig rather than one at a time.
+var configErr notarize.ConfigError
+
+// invalidf records the formatted problem of the inputs (see checkConfig).
+func invalidf(format string, a ...interface{}) {
+	configErr.Addf(format, a...)
+}
+
+// checkConfig prints all the problems of the inputs found so far, if any,
+// runs the exit hooks and exits.
+func checkConfig() {
+	if err := configErr.Err(); err != nil {
+		exitf(notarize.ExitInvalidConfig, "%v", err)
+	}
+}
+
+// exitf prints the formatted error message, runs the exit hooks and exits
+// with the given code (see notarize.ExitCode).
+func exitf(code int, format string, a ...interface{}) {
+	errMsg := fmt.Sprintf(format, a...)
+	exitCode = code
+	annotateFailures()
+	logger.annotatef("error", "ABORTING", "%s", errMsg)
+	runExitHooks(errMsg)
+	os.Exit(code)
+}
+
+// AssetOutput is the notarization result of an asset, as set in the assets
+// output of the action.
+type AssetOutput struct {
+	Name       string    `json:"name"`
+	Repository string    `json:"repository,omitempty"`
+	ReleaseTag string    `json:"releaseTag,omitempty"`
+	Hash       string    `json:"hash"`
+	SignerID   string    `json:"signerID"`
+	LedgerID   string    `json:"ledgerID,omitempty"`
+	Status     string    `json:"status"`
+	Timestamp  time.Time `json:"timestamp"`
+	TxID       uint64    `json:"txID,omitempty"`
+	UID        string    `json:"uid,omitempty"`
+}
+
+// writeReportFile writes the full report of the run, as JSON, into the given
+// file (creating its directory if needed).
+func writeReportFile(filePath string) error {
+	content, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error JSON-marshaling the report: %v", err)
+	}
+	if dir := filepath.Dir(filePath); len(dir) > 0 {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return fmt.Errorf("error creating report file dir %s: %v", dir, err)
+		}
+	}
+	if err := os.WriteFile(filePath, append(content, '\n'), 0644); err != nil {
+		return fmt.Errorf("error writing report file %s: %v", filePath, err)
+	}
+	logger.Infof("Wrote the report into %s\n", filePath)
+	return nil
+}
+
+// setOutputs appends the action outputs to the GITHUB_OUTPUT file, if any.
+func setOutputs() error {
+	outputFile := os.Getenv("GITHUB_OUTPUT")
+	if len(outputFile) == 0 {
+		return nil
+	}
+
+	assets := make([]AssetOutput, 0, len(summary.Assets))
+	for _, asset := range summary.Assets {
+		assets = append(assets, AssetOutput{
+			Name:       asset.Name,
+			Repository: asset.Repository,
+			ReleaseTag: asset.ReleaseTag,
+			Hash:       asset.Hash,
+			SignerID:   asset.SignerID,
+			LedgerID:   asset.LedgerID,
+			Status:     asset.Status,
+			Timestamp:  asset.Timestamp,
+			TxID:       asset.TxID,
+			UID:        asset.UID,
+		})
+	}
+	assetsJSON, err := json.Marshal(assets)
+	if err != nil {
+		return fmt.Errorf("error JSON-marshaling the assets output: %v", err)
+	}
+
+	f, err := os.OpenFile(outputFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening the GitHub output file %s: %v", outputFile, err)
+	}
+	defer f.Close()
+	if _, err := fmt.Fprintf(f, "assets=%s\nexit_code=%d\n", assetsJSON, exitCode); err != nil {
+		return fmt.Errorf("error writing to the GitHub output file %s: %v", outputFile, err)
+	}
+
+	return nil
+}
+
+// writeStepSummary appends a Markdown report of the run to the
+// GITHUB_STEP_SUMMARY file, if any, for showing it in the workflow run UI.
+func writeStepSummary(errMsg string) error {
+	summaryFile := os.Getenv("GITHUB_STEP_SUMMARY")
+	if len(summaryFile) == 0 {
+		return nil
+	}
+
+	var md strings.Builder
+	title := "Release assets notarization"
+	if summary.Operation == "verify" {
+		title = "Release assets verification"
+	} else if summary.Operation == "reverify" {
+		title = "Release assets re-verification"
+	} else if summary.Operation == "untrust" {
+		title = "Release assets untrusting"
+	} else if summary.Operation == "bulk" {
+		title = "Bulk release assets notarization"
+	}
+	fmt.Fprintf(&md, "## %s", title)
+	if len(summary.ReleaseTag) > 0 {
+		fmt.Fprintf(&md, " of %s %s", summary.Repository, summary.ReleaseTag)
+	} else if len(summary.Releases) > 0 {
+		fmt.Fprintf(&md, " of %d releases of %s", len(summary.Releases), summary.Repository)
+	}
+	md.WriteString("\n\n")
+	if len(errMsg) > 0 {
+		fmt.Fprintf(&md, ":x: %s\n\n", errMsg)
+	} else if summary.Operation == "verify" {
+		fmt.Fprintf(&md, ":white_check_mark: All %d assets are notarized and trusted.\n\n",
+			len(summary.Assets))
+	} else if summary.Operation == "reverify" {
+		fmt.Fprintf(&md, ":white_check_mark: All %d assets are notarized and trusted, without drift.\n\n",
+			len(summary.Assets))
+	} else if summary.Operation == "untrust" {
+		fmt.Fprintf(&md, ":white_check_mark: All %d assets have been untrusted.\n\n",
+			len(summary.Assets))
+	} else if summary.Operation == "bulk" {
+		nbSkipped := 0
+		for _, releaseReport := range summary.Releases {
+			if releaseReport.Skipped {
+				nbSkipped++
+			}
+		}
+		fmt.Fprintf(&md, ":white_check_mark: All %d releases have been processed (%d of them notarized already).\n\n",
+			len(summary.Releases), nbSkipped)
+	} else {
+		fmt.Fprintf(&md, ":white_check_mark: All %d assets have been notarized.\n\n",
+			len(summary.Assets))
+	}
+	if len(summary.Assets) > 0 {
+		md.WriteString("| Asset | Size | Hash | Signer | Status | Ledger |\n")
+		md.WriteString("| --- | --- | --- | --- | --- | --- |\n")
+		for _, asset := range summary.Assets {
+			ledgerID := asset.LedgerID
+			if len(ledgerID) == 0 {
+				ledgerID = summary.LedgerID
+			}
+			status := asset.Status
+			if asset.Drifted {
+				status = strings.TrimSpace(status + " :warning: drifted")
+				if len(asset.BaselineHash) > 0 {
+					status += fmt.Sprintf(" (was `%s`)", asset.BaselineHash)
+				}
+			}
+			if len(asset.Error) > 0 {
+				status = strings.TrimSpace(status + " :x: " + asset.Error)
+			}
+			name := asset.Name
+			if len(asset.ReleaseTag) > 0 {
+				name = asset.ReleaseTag + " / " + name
+			}
+			if len(asset.Repository) > 0 {
+				name = asset.Repository + " / " + name
+			}
+			fmt.Fprintf(&md, "| %s | %s | `%s` | %s | %s | %s |\n",
+				markdownCell(name), humanize.Bytes(asset.Size), asset.Hash,
+				markdownCell(asset.SignerID), markdownCell(status), markdownCell(ledgerID))
+		}
+		md.WriteString("\n")
+	}
+
+	f, err := os.OpenFile(summaryFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening the GitHub step summary file %s: %v", summaryFile, err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(md.String()); err != nil {
+		return fmt.Errorf("error writing to the GitHub step summary file %s: %v", summaryFile, err)
+	}
+
+	return nil
+}
+
+// markdownCell escapes the value of a Markdown table cell.
+func markdownCell(value string) string {
+	return strings.NewReplacer("|", "\\|", "\n", " ").Replace(value)
+}
+
+type AuditEvent struct {
+	Event   string           `json:"event"`
+	Summary *notarize.Report `json:"summary"`
+}
+
+// sendAuditEvent POSTs the run summary to the audit webhook URL. The payload
+// is signed with HMAC-SHA256 using the webhook secret and the hex-encoded
+// signature is sent in the X-Notarization-Signature-256 header, prefixed by
+// "sha256=" (as GitHub does for its own webhooks).
+func sendAuditEvent(webhookURL string, secret string, errMsg string) error {
+	event := AuditEvent{Event: "notarization.completed", Summary: summary}
+	if summary.Operation == "verify" {
+		event.Event = "verification.completed"
+	} else if summary.Operation == "reverify" {
+		event.Event = "reverification.completed"
+	} else if summary.Operation == "untrust" {
+		event.Event = "untrust.completed"
+	}
+	if len(errMsg) > 0 {
+		event.Event = strings.Replace(event.Event, ".completed", ".failed", 1)
+	}
+	for _, asset := range summary.Assets {
+		if asset.Drifted {
+			event.Event = "drift.detected"
+			break
+		}
+	}
+	payload, err := json.Marshal(&event)
+	if err != nil {
+		return fmt.Errorf("error JSON-marshaling audit event %+v: %v", event, err)
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	req, err := http.NewRequest(http.MethodPost, webhookURL, bytes.NewBuffer(payload))
+	if err != nil {
+		return fmt.Errorf("error creating HTTP request POST %s: %v", webhookURL, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Notarization-Event", event.Event)
+	req.Header.Set("X-Notarization-Signature-256", signature)
+
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request POST %s: %v", webhookURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("POST %s error: expected a 2xx HTTP code, got %d with body %s",
+			webhookURL, resp.StatusCode, respBody)
+	}
+
+	return nil
+}
+
+// getArg returns the value of an input: the positional argument of the given
+// index (if any, and if the index is not 0), or else the INPUT_* environment variable of the given input
+// name, falling back to the config file value and then to the default value.
+func getArg(argIndex int, inputName string, argName string, required bool, defaultVal string) string {
+	var argVal string
+	if len(os.Args) > 1 && argIndex > 0 {
+		argVal = os.Args[argIndex]
+	} else {
+		argVal = os.Getenv("INPUT_" + strings.ToUpper(inputName))
+	}
+	argVal = strings.TrimSpace(argVal)
+	if len(argVal) == 0 {
+		argVal = configFileInputs[inputName]
+	}
+	if secretInputs[inputName] {
+		maskSecret(argVal)
+		logger.Infof("  - %s: %s (length: %d)\n", argName, redact(argVal), len(argVal))
+	} else {
+		logger.Infof("  - %s: %s (length: %d)\n", argName, argVal, len(argVal))
+	}
+	if required && len(argVal) == 0 {
+		invalidf("required argument %s value is empty", argName)
+	}
+	if len(argVal) == 0 && len(defaultVal) > 0 {
+		argVal = defaultVal
+	}
+	return argVal
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxNotifiedFailures is the maximum number of failed assets listed in the
+// notifications, which are meant to be read at a glance.
+const maxNotifiedFailures = 10
+
+// notifyFormats are the payload formats of the notification webhooks.
+var notifyFormats = map[string]bool{"auto": true, "slack": true, "teams": true, "json": true}
+
+// Notification is the compact summary of the run POSTed to the notification
+// webhook in the json format.
+type Notification struct {
+	Event          string                `json:"event"`
+	Operation      string                `json:"operation"`
+	Repository     string                `json:"repository"`
+	Release        string                `json:"release,omitempty"`
+	NbReleases     int                   `json:"nb_releases,omitempty"`
+	Success        bool                  `json:"success"`
+	Error          string                `json:"error,omitempty"`
+	NbAssets       int                   `json:"nb_assets"`
+	Statuses       map[string]int        `json:"statuses"`
+	Failures       []NotificationFailure `json:"failures,omitempty"`
+	WorkflowRunURL string                `json:"workflow_run_url,omitempty"`
+}
+
+// NotificationFailure is an asset the run failed for.
+type NotificationFailure struct {
+	Name  string `json:"name"`
+	Error string `json:"error"`
+}
+
+// newNotification returns the notification summarizing the run.
+func newNotification(errMsg string) *Notification {
+	notification := &Notification{
+		Event:          "notarization.completed",
+		Operation:      summary.Operation,
+		Repository:     summary.Repository,
+		Release:        summary.ReleaseTag,
+		Success:        len(errMsg) == 0,
+		Error:          errMsg,
+		NbAssets:       len(summary.Assets),
+		Statuses:       make(map[string]int),
+		WorkflowRunURL: summary.WorkflowRunURL,
+	}
+	switch summary.Operation {
+	case "verify":
+		notification.Event = "verification.completed"
+	case "reverify":
+		notification.Event = "reverification.completed"
+	case "untrust":
+		notification.Event = "untrust.completed"
+	}
+	if len(errMsg) > 0 {
+		notification.Event = strings.Replace(notification.Event, ".completed", ".failed", 1)
+	}
+	for _, asset := range summary.Assets {
+		if asset.Drifted {
+			notification.Event = "drift.detected"
+			break
+		}
+	}
+	if len(summary.Releases) > 0 {
+		notification.Release = ""
+		notification.NbReleases = len(summary.Releases)
+	} else if len(notification.Release) == 0 {
+		notification.Release = summary.ReleaseURL
+	}
+	for _, asset := range summary.Assets {
+		if len(asset.Status) > 0 {
+			notification.Statuses[asset.Status]++
+		}
+		if len(asset.Error) > 0 || asset.Drifted {
+			name := asset.Name
+			if len(asset.ReleaseTag) > 0 && len(summary.Releases) > 0 {
+				name = asset.ReleaseTag + " / " + name
+			}
+			assetErr := asset.Error
+			if len(assetErr) == 0 {
+				assetErr = "drifted from its notarized bytes"
+			}
+			notification.Failures = append(notification.Failures,
+				NotificationFailure{Name: name, Error: assetErr})
+		}
+	}
+	return notification
+}
+
+// title returns the one-line summary of the notification.
+func (n *Notification) title() string {
+	operation := map[string]string{
+		"verify":   "Verification",
+		"reverify": "Re-verification",
+		"untrust":  "Untrust",
+	}[n.Operation]
+	if len(operation) == 0 {
+		operation = "Notarization"
+	}
+	release := n.Release
+	if n.NbReleases > 0 {
+		release = fmt.Sprintf("%d releases of %s", n.NbReleases, n.Repository)
+	} else if len(n.Repository) > 0 && len(release) > 0 && !strings.Contains(release, "/") {
+		release = n.Repository + "@" + release
+	}
+	if n.Success {
+		return fmt.Sprintf("%s of %s succeeded for %d assets", operation, release, n.NbAssets)
+	}
+	return fmt.Sprintf("%s of %s failed", operation, release)
+}
+
+// lines returns the details of the notification, one per line.
+func (n *Notification) lines() []string {
+	var lines []string
+	if len(n.Error) > 0 {
+		lines = append(lines, "Error: "+n.Error)
+	}
+	if len(n.Statuses) > 0 {
+		statuses := make([]string, 0, len(n.Statuses))
+		for status, count := range n.Statuses {
+			statuses = append(statuses, fmt.Sprintf("%s: %d", status, count))
+		}
+		sort.Strings(statuses)
+		lines = append(lines, fmt.Sprintf("Assets: %d (%s)", n.NbAssets, strings.Join(statuses, ", ")))
+	} else {
+		lines = append(lines, fmt.Sprintf("Assets: %d", n.NbAssets))
+	}
+	for i, failure := range n.Failures {
+		if i == maxNotifiedFailures {
+			lines = append(lines, fmt.Sprintf("... and %d more failures", len(n.Failures)-i))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s", failure.Name, failure.Error))
+	}
+	return lines
+}
+
+// notifyWebhookFormat returns the payload format of the given webhook URL,
+// guessed from its host unless the format is specified.
+func notifyWebhookFormat(webhookURL string, format string) string {
+	if format != "auto" {
+		return format
+	}
+	u, err := url.Parse(webhookURL)
+	if err != nil {
+		return "json"
+	}
+	host := strings.ToLower(u.Hostname())
+	switch {
+	case host == "hooks.slack.com":
+		return "slack"
+	case strings.HasSuffix(host, ".webhook.office.com") || strings.HasSuffix(host, ".logic.azure.com"):
+		return "teams"
+	}
+	return "json"
+}
+
+// notificationPayload returns the payload of the notification in the given
+// format: a Slack message, a Teams message holding an Adaptive Card, or else
+// the notification itself.
+func notificationPayload(n *Notification, format string) interface{} {
+	icon := ":white_check_mark:"
+	if !n.Success {
+		icon = ":x:"
+	}
+	switch format {
+	case "slack":
+		text := fmt.Sprintf("%s *%s*\n%s", icon, n.title(), strings.Join(n.lines(), "\n"))
+		if len(n.WorkflowRunURL) > 0 {
+			text += fmt.Sprintf("\n<%s|View the workflow run>", n.WorkflowRunURL)
+		}
+		return map[string]interface{}{"text": text}
+	case "teams":
+		titleColor := "Good"
+		if !n.Success {
+			titleColor = "Attention"
+		}
+		body := []map[string]interface{}{{
+			"type": "TextBlock", "text": n.title(), "weight": "Bolder", "color": titleColor, "wrap": true,
+		}}
+		for _, line := range n.lines() {
+			body = append(body, map[string]interface{}{
+				"type": "TextBlock", "text": line, "wrap": true, "spacing": "None",
+			})
+		}
+		card := map[string]interface{}{
+			"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+			"type":    "AdaptiveCard",
+			"version": "1.4",
+			"body":    body,
+		}
+		if len(n.WorkflowRunURL) > 0 {
+			card["actions"] = []map[string]interface{}{{
+				"type": "Action.OpenUrl", "title": "View the workflow run", "url": n.WorkflowRunURL,
+			}}
+		}
+		return map[string]interface{}{
+			"type": "message",
+			"attachments": []map[string]interface{}{{
+				"contentType": "application/vnd.microsoft.card.adaptive",
+				"content":     card,
+			}},
+		}
+	}
+	return n
+}
+
+// sendNotification POSTs the compact summary of the run to the notification
+// webhook URL, e.g. a Slack or Teams incoming webhook.
+func sendNotification(webhookURL string, format string, errMsg string) error {
+	n := newNotification(errMsg)
+	payload, err := json.Marshal(notificationPayload(n, notifyWebhookFormat(webhookURL, format)))
+	if err != nil {
+		return fmt.Errorf("error JSON-marshaling notification %+v: %v", n, err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, webhookURL, bytes.NewBuffer(payload))
+	if err != nil {
+		return fmt.Errorf("error creating HTTP request POST %s: %v", redact(webhookURL), err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		// the URL of the incoming webhooks is their secret
+		return fmt.Errorf("error sending request POST %s: %v", redact(webhookURL),
+			strings.ReplaceAll(err.Error(), webhookURL, redact(webhookURL)))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("POST %s error: expected a 2xx HTTP code, got %d with body %s",
+			redact(webhookURL), resp.StatusCode, respBody)
+	}
+
+	return nil
+}
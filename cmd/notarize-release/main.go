@@ -0,0 +1,1140 @@
+// Command notarize-release notarizes, verifies and reports the assets of GitHub
+// releases using the CodeNotary Immutable Ledger (CNIL), and manages the CNIL
+// API keys of the signer IDs, outside GitHub Actions (e.g. in Jenkins, local
+// runs or air-gapped pipelines). Every flag can also be set via the
+// environment variable mentioned in its usage.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/codenotary/notarize-release-assets-action/pkg/bitbucket"
+	"github.com/codenotary/notarize-release-assets-action/pkg/cnil"
+	"github.com/codenotary/notarize-release-assets-action/pkg/github"
+	"github.com/codenotary/notarize-release-assets-action/pkg/notarize"
+	"github.com/codenotary/notarize-release-assets-action/pkg/vault"
+)
+
+const usage = `Usage: notarize-release <command> [flags]
+
+Commands:
+  notarize  notarize (i.e. sign with the trusted status) the release assets
+  verify    verify the release assets against the ledger
+  reverify  re-verify past releases, detecting the assets which drifted from
+            their notarized bytes (e.g. from a cron job)
+  bulk      notarize the releases of all the repositories of an organization
+            (or of the -repository ones) created since a cutoff date, skipping
+            the ones notarized already
+  report    print the JSON report of the verification of the release assets,
+            without failing if some of them are not notarized or not trusted
+  submit    sign the artifacts of a signing bundle written by an -offline run
+  keys      get, create, rotate or revoke the API keys of signer IDs
+
+Run "notarize-release <command> -h" for the flags of each command.
+
+Exit codes:
+  0  success
+  1  any other failure, e.g. the run timeout
+  2  invalid command, flags or settings
+  3  GitHub API error, e.g. the release cannot be read
+  4  download error
+  5  CNIL authentication error
+  6  signing error
+  7  verification failed, i.e. assets not notarized or not trusted
+  8  drift detected by reverify
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch command, args := os.Args[1], os.Args[2:]; command {
+	case "notarize":
+		err = runRelease(command, args, notarize.NotarizeRelease)
+	case "verify":
+		err = runRelease(command, args, notarize.VerifyRelease)
+	case "reverify":
+		err = runRelease(command, args, notarize.ReverifyRelease)
+	case "bulk":
+		err = runRelease(command, args, nil)
+	case "report":
+		err = runReport(args)
+	case "submit":
+		err = runSubmit(args)
+	case "keys":
+		err = runKeys(args)
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %s\n\n%s", command, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(notarize.ExitCode(err))
+	}
+}
+
+// invalidConfig returns the given error of the flags as an invalid
+// configuration one, exiting with notarize.ExitInvalidConfig.
+func invalidConfig(err error) error {
+	return fmt.Errorf("%w: %v", notarize.ErrInvalidConfig, err)
+}
+
+// stderrLogger prints the progress to the standard error, keeping the
+// standard output for the results.
+type stderrLogger struct{}
+
+func (stderrLogger) Infof(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, format, a...)
+}
+
+func (stderrLogger) Successf(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, format, a...)
+}
+
+func (stderrLogger) Warningf(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, format, a...)
+}
+
+func (stderrLogger) Errorf(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, format, a...)
+}
+
+// envOr returns the value of the given environment variable, if set, or the
+// given default value.
+func envOr(name string, defaultVal string) string {
+	if val, ok := os.LookupEnv(name); ok {
+		return val
+	}
+	return defaultVal
+}
+
+func stringFlag(fs *flag.FlagSet, name string, env string, defaultVal string, usage string) *string {
+	return fs.String(name, envOr(env, defaultVal), fmt.Sprintf("%s (env %s)", usage, env))
+}
+
+func boolFlag(fs *flag.FlagSet, name string, env string, defaultVal bool, usage string) *bool {
+	val, err := strconv.ParseBool(envOr(env, strconv.FormatBool(defaultVal)))
+	if err != nil {
+		val = defaultVal
+	}
+	return fs.Bool(name, val, fmt.Sprintf("%s (env %s)", usage, env))
+}
+
+// cnilFlags are the flags of the CNIL settings, shared by all the commands.
+type cnilFlags struct {
+	host              *string
+	grpcPort          *string
+	httpPort          *string
+	noTLS             *bool
+	caCert            *string
+	clientCert        *string
+	clientKey         *string
+	proxyURL          *string
+	grpcDialTimeout   *string
+	grpcKeepalive     *string
+	grpcKeepaliveTO   *string
+	grpcMaxAttempts   *int
+	grpcRetryBackoff  *string
+	apiKey            *string
+	token             *string
+	ledger            *string
+	keyPolicy         *string
+	rotateIfOlderThan *string
+	createLedger      *bool
+	keyCacheFile      *string
+	keyCachePass      *string
+	vaultAddr         *string
+	vaultToken        *string
+	vaultRoleID       *string
+	vaultSecretID     *string
+	vaultNamespace    *string
+	vaultMount        *string
+	vaultPath         *string
+	immudbHost        *string
+	immudbPort        *string
+	immudbUsername    *string
+	immudbPassword    *string
+	immudbDatabase    *string
+}
+
+func newCNILFlags(fs *flag.FlagSet) *cnilFlags {
+	return &cnilFlags{
+		host: stringFlag(fs, "cnil-host", "CNIL_HOST", "",
+			"CNIL host (required unless -immudb-host is set), followed by the comma-separated hosts failed over to"),
+		grpcPort: stringFlag(fs, "cnil-grpc-port", "CNIL_GRPC_PORT", "443", "CNIL gRPC API port"),
+		httpPort: stringFlag(fs, "cnil-http-port", "CNIL_HTTP_PORT", "443", "CNIL REST API port"),
+		noTLS:    boolFlag(fs, "cnil-no-tls", "CNIL_NO_TLS", false, "disable TLS for the CNIL gRPC API"),
+		caCert: stringFlag(fs, "cnil-ca-cert", "CNIL_CA_CERT", "",
+			"CA certificate (PEM file path or content) of a CNIL instance with a private CA"),
+		clientCert: stringFlag(fs, "cnil-client-cert", "CNIL_CLIENT_CERT", "",
+			"client certificate (PEM file path or content) for mutual TLS with the CNIL APIs"),
+		clientKey: stringFlag(fs, "cnil-client-key", "CNIL_CLIENT_KEY", "",
+			"client key (PEM file path or content) for mutual TLS with the CNIL APIs"),
+		proxyURL: stringFlag(fs, "proxy-url", "PROXY_URL", "",
+			"HTTP(S) proxy of the GitHub and CNIL REST and gRPC APIs (defaults to HTTPS_PROXY for REST)"),
+		grpcDialTimeout: stringFlag(fs, "cnil-grpc-dial-timeout", "CNIL_GRPC_DIAL_TIMEOUT", "",
+			"timeout of the connection to the CNIL gRPC API, e.g. 10s (lazy connection by default)"),
+		grpcKeepalive: stringFlag(fs, "cnil-grpc-keepalive", "CNIL_GRPC_KEEPALIVE", "20s",
+			"interval of the keepalive pings of the CNIL gRPC connections"),
+		grpcKeepaliveTO: stringFlag(fs, "cnil-grpc-keepalive-timeout", "CNIL_GRPC_KEEPALIVE_TIMEOUT", "10s",
+			"time after which a CNIL gRPC connection whose keepalive ping is unanswered is closed"),
+		grpcMaxAttempts: fs.Int("cnil-grpc-max-attempts", 1,
+			"maximum number of attempts (up to 5) of the CNIL gRPC calls failing with an Unavailable error"),
+		grpcRetryBackoff: stringFlag(fs, "cnil-grpc-retry-backoff", "CNIL_GRPC_RETRY_BACKOFF", "1s",
+			"initial backoff between the attempts of the CNIL gRPC calls, doubled at each retry"),
+		apiKey: stringFlag(fs, "cnil-api-key", "CNIL_API_KEY", "",
+			"CNIL API key to use for all the assets"),
+		token: stringFlag(fs, "cnil-personal-token", "CNIL_PERSONAL_TOKEN", "",
+			"CNIL personal token, for managing the API keys"),
+		ledger: stringFlag(fs, "cnil-ledger", "CNIL_LEDGER", "",
+			"CNIL ledger ID, list of ledger IDs or JSON mapping of asset name patterns to ledger IDs"),
+		keyPolicy: stringFlag(fs, "key-policy", "CNIL_KEY_POLICY", string(cnil.KeyPolicyRotate),
+			"API keys lifecycle policy: reuse, rotate or ephemeral"),
+		rotateIfOlderThan: stringFlag(fs, "rotate-if-older-than", "CNIL_ROTATE_IF_OLDER_THAN", "",
+			"rotate an existing API key only if older than this age, e.g. 30d"),
+		keyCacheFile: stringFlag(fs, "api-key-cache-file", "CNIL_API_KEY_CACHE_FILE", "",
+			"file caching the API keys issued at the previous runs, for reusing them"),
+		keyCachePass: stringFlag(fs, "api-key-cache-passphrase", "CNIL_API_KEY_CACHE_PASSPHRASE", "",
+			"passphrase the API key cache file is encrypted with"),
+		vaultAddr: stringFlag(fs, "vault-addr", "VAULT_ADDR", "",
+			"address of the Vault server the CNIL credentials are read from"),
+		vaultToken: stringFlag(fs, "vault-token", "VAULT_TOKEN", "", "Vault token"),
+		vaultRoleID: stringFlag(fs, "vault-role-id", "VAULT_ROLE_ID", "",
+			"Vault AppRole role ID, instead of the Vault token"),
+		vaultSecretID: stringFlag(fs, "vault-secret-id", "VAULT_SECRET_ID", "",
+			"Vault AppRole secret ID"),
+		vaultNamespace: stringFlag(fs, "vault-namespace", "VAULT_NAMESPACE", "",
+			"Vault Enterprise namespace"),
+		vaultMount: stringFlag(fs, "vault-kv-mount", "VAULT_KV_MOUNT", "secret",
+			"mount path of the Vault KV v2 secrets engine"),
+		vaultPath: stringFlag(fs, "vault-path", "VAULT_PATH", "",
+			"path of the Vault secret holding the CNIL credentials, e.g. ci/cnil"),
+		createLedger: boolFlag(fs, "create-ledger-if-missing", "CNIL_CREATE_LEDGER_IF_MISSING", false,
+			"use the ledger named after the repository, creating it, if the ledger is missing"),
+		immudbHost: stringFlag(fs, "immudb-host", "IMMUDB_HOST", "",
+			"host of a plain immudb server to sign into directly instead of CNIL"),
+		immudbPort: stringFlag(fs, "immudb-port", "IMMUDB_PORT", "3322", "immudb gRPC port"),
+		immudbUsername: stringFlag(fs, "immudb-username", "IMMUDB_USERNAME", "",
+			"immudb user signing the artifacts"),
+		immudbPassword: stringFlag(fs, "immudb-password", "IMMUDB_PASSWORD", "",
+			"password of the immudb user"),
+		immudbDatabase: stringFlag(fs, "immudb-database", "IMMUDB_DATABASE", "defaultdb",
+			"immudb database, unless the ledger IDs specify other ones"),
+	}
+}
+
+// readVault sets the CNIL credentials of the given config which are not
+// specified from the Vault secret, where the API keys issued by the run are
+// written back.
+func (f *cnilFlags) readVault(cfg *notarize.Config) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	client, err := vault.NewClient(ctx, http.DefaultClient, &vault.Options{
+		Address:   *f.vaultAddr,
+		Token:     *f.vaultToken,
+		RoleID:    *f.vaultRoleID,
+		SecretID:  *f.vaultSecretID,
+		Namespace: *f.vaultNamespace,
+		Mount:     *f.vaultMount,
+		Path:      *f.vaultPath,
+	})
+	if err != nil {
+		return err
+	}
+	secret, err := client.ReadSecret(ctx)
+	if err != nil {
+		return err
+	}
+	if len(cfg.CNILToken) == 0 {
+		cfg.CNILToken = vault.PersonalToken(secret)
+	}
+	if len(cfg.APIKey) == 0 {
+		if cfg.APIKeysPerSignerID, err = vault.APIKeysPerSignerID(secret); err != nil {
+			return err
+		}
+	}
+	cfg.APIKeyCache = vault.NewAPIKeyCache(client, secret)
+	return nil
+}
+
+func (f *cnilFlags) restURL() string {
+	host, _ := f.hosts()
+	return f.restURLOf(host)
+}
+
+func (f *cnilFlags) restURLOf(host string) string {
+	return fmt.Sprintf("https://%s:%s/api/v1", host, *f.httpPort)
+}
+
+// hosts returns the CNIL host and its fallbacks, i.e. the next hosts of the
+// comma-separated -cnil-host list.
+func (f *cnilFlags) hosts() (string, []string) {
+	var hosts []string
+	for _, host := range strings.Split(*f.host, ",") {
+		if host = strings.TrimSpace(host); len(host) > 0 {
+			hosts = append(hosts, host)
+		}
+	}
+	if len(hosts) == 0 {
+		return "", nil
+	}
+	return hosts[0], hosts[1:]
+}
+
+// grpcSettings sets the settings of the gRPC connections to CNIL of the given
+// config.
+func (f *cnilFlags) grpcSettings(cfg *notarize.Config) error {
+	var err error
+	if len(*f.grpcDialTimeout) > 0 {
+		if cfg.GRPCDialTimeout, err = notarize.ParseAge(*f.grpcDialTimeout); err != nil {
+			return fmt.Errorf("invalid gRPC dial timeout: %v", err)
+		}
+	}
+	if cfg.GRPCKeepalive, err = notarize.ParseAge(*f.grpcKeepalive); err != nil {
+		return fmt.Errorf("invalid gRPC keepalive: %v", err)
+	}
+	if cfg.GRPCKeepaliveTimeout, err = notarize.ParseAge(*f.grpcKeepaliveTO); err != nil {
+		return fmt.Errorf("invalid gRPC keepalive timeout: %v", err)
+	}
+	if *f.grpcMaxAttempts < 1 {
+		return errors.New("the gRPC max attempts must be a positive integer")
+	}
+	cfg.GRPCMaxAttempts = *f.grpcMaxAttempts
+	if cfg.GRPCRetryBackoff, err = notarize.ParseAge(*f.grpcRetryBackoff); err != nil {
+		return fmt.Errorf("invalid gRPC retry backoff: %v", err)
+	}
+	return nil
+}
+
+// releaseFlags are the flags of the release (or local files) to process.
+type releaseFlags struct {
+	cnil                *cnilFlags
+	releaseURL          *string
+	tag                 *string
+	releases            *string
+	latestRelease       *string
+	repository          *string
+	githubToken         *string
+	githubAPIURL        *string
+	provider            *string
+	bitbucketToken      *string
+	bitbucketAPIURL     *string
+	actor               *string
+	include             *string
+	assetSelection      *string
+	exclude             *string
+	maxParallel         *int
+	maxStreams          *int
+	maxAssetSize        *string
+	status              *string
+	statusPerAsset      *string
+	normalizeAssetNames *string
+	ledgerNamePerAsset  *string
+	requireStatus       *string
+	verifyLedgers       *string
+	requireAllLedgers   *bool
+	denyStatus          *string
+	stream              *bool
+	sourceArchives      *bool
+	linkSourceArchive   *bool
+	deduplicate         *bool
+	uploadChecksums     *bool
+	releaseNotes        *bool
+	verifyChecksums     *bool
+	gpgPublicKey        *string
+	verifyAuthenticode  *bool
+	authenticodeSubject *string
+	verifyAppleNotary   *bool
+	hashesFromChecksums *string
+	digests             *string
+	primaryDigest       *string
+	metadata            *string
+	signerIDTemplate    *string
+	fallbackSignerID    *string
+	signerIDFromEmail   *bool
+	pushgatewayURL      *string
+	metricsFile         *string
+	repositoriesMapping *string
+	paths               *string
+	images              *string
+	helmOCIRegistry     *string
+	platformManifests   *string
+	platformOnly        *bool
+	dependencies        *bool
+	dependencyFiles     *string
+	uploadDependencies  *bool
+	apiTimeout          *string
+	downloadTimeout     *string
+	timeout             *string
+	reportFile          *string
+	sarifFile           *string
+	exportProofs        *bool
+	uploadProofFiles    *bool
+	ledgerStateFile     *string
+	etagCacheFile       *string
+	releaseEventFile    *string
+	offline             *bool
+	bundle              *string
+	bundleSHA256        *string
+	workDir             *string
+	driftBaselineFile   *string
+	maxParallelReleases *int
+	organization        *string
+	since               *string
+	// bulk tells that the releases are found by the bulk command
+	bulk bool
+}
+
+func newReleaseFlags(fs *flag.FlagSet) *releaseFlags {
+	return &releaseFlags{
+		cnil:        newCNILFlags(fs),
+		releaseURL:  stringFlag(fs, "release-url", "RELEASE_URL", "", "GitHub API URL of the release"),
+		tag:         stringFlag(fs, "tag", "RELEASE_TAG", "", "tag of the release, instead of its URL"),
+		repository:  stringFlag(fs, "repository", "GITHUB_REPOSITORY", "", "repository of the release, i.e. <owner>/<repo-name>, or comma-separated repositories"),
+		githubToken: stringFlag(fs, "github-token", "GITHUB_TOKEN", "", "GitHub token"),
+		provider: stringFlag(fs, "provider", "RELEASE_PROVIDER", "github",
+			"host of the release, either github or bitbucket (whose releases are the tags along with their downloads)"),
+		bitbucketToken: stringFlag(fs, "bitbucket-token", "BITBUCKET_TOKEN", "", "Bitbucket token"),
+		bitbucketAPIURL: stringFlag(fs, "bitbucket-api-url", "BITBUCKET_API_URL", bitbucket.DefaultAPIURL,
+			"base URL of the Bitbucket API"),
+		releases: stringFlag(fs, "releases", "RELEASES", "",
+			"URLs and/or tags of multiple releases separated by commas, or all-releases-since:<tag>"),
+		latestRelease: stringFlag(fs, "release", "RELEASE", "",
+			"latest for the latest release of the repository, instead of its URL"),
+		githubAPIURL: stringFlag(fs, "github-api-url", "GITHUB_API_URL", github.DefaultAPIURL,
+			"GitHub API base URL"),
+		actor: stringFlag(fs, "actor", "GITHUB_ACTOR", "",
+			"GitHub user signing the local files and images without release"),
+		include: stringFlag(fs, "include", "ASSETS_INCLUDE", "",
+			"glob patterns of the only assets to process, separated by commas"),
+		exclude: stringFlag(fs, "exclude", "ASSETS_EXCLUDE", "",
+			"glob patterns of the assets to skip, separated by commas"),
+		assetSelection: stringFlag(fs, "assets", "ASSETS_SELECTION", notarize.AssetsAll,
+			"release assets to process: all, uploaded-only (i.e. without the source code archives) or source-only"),
+		maxParallel: fs.Int("max-parallel", 1, "maximum number of assets processed at the same time"),
+		maxStreams:  fs.Int("max-streams", 4, "maximum number of concurrent gRPC calls to CNIL"),
+		maxParallelReleases: fs.Int("max-parallel-releases", 1,
+			"maximum number of releases processed at the same time (-releases, multiple repositories and bulk command)"),
+		organization: stringFlag(fs, "organization", "GITHUB_ORGANIZATION", "",
+			"organization (or user) whose repositories are processed by the bulk command, instead of -repository"),
+		since: stringFlag(fs, "since", "SINCE", "",
+			"cutoff date of the releases processed by the bulk command, e.g. 2024-01-31 or 90d"),
+		maxAssetSize: stringFlag(fs, "max-asset-size", "MAX_ASSET_SIZE", "",
+			"size above which a release asset fails the run, e.g. 2GB"),
+		status: stringFlag(fs, "status", "STATUS", "trusted",
+			"status the assets are notarized with (or expected to have): trusted, untrusted or unsupported"),
+		statusPerAsset: stringFlag(fs, "status-per-asset", "STATUS_PER_ASSET", "",
+			"JSON object mapping asset name glob patterns to statuses, e.g. {\"*-beta*\": \"unsupported\"}"),
+		normalizeAssetNames: stringFlag(fs, "normalize-asset-names", "NORMALIZE_ASSET_NAMES", "",
+			"normalizations of the names the file assets are notarized with: lowercase and / or strip-version"),
+		ledgerNamePerAsset: stringFlag(fs, "ledger-name-per-asset", "LEDGER_NAME_PER_ASSET", "",
+			"JSON object mapping asset name glob patterns to the names the assets are notarized with"),
+		requireStatus: stringFlag(fs, "require-status", "REQUIRE_STATUS", "",
+			"status the ledger entries must have when verifying, instead of the configured one"),
+		verifyLedgers: stringFlag(fs, "verify-ledgers", "VERIFY_LEDGERS", "",
+			"comma-separated <ledger ID>[:<signer ID>] ledgers the verified assets are also looked up into"),
+		requireAllLedgers: boolFlag(fs, "require-all-ledgers", "REQUIRE_ALL_LEDGERS", false,
+			"fail the verification of the assets missing from any of the -verify-ledgers"),
+		denyStatus: stringFlag(fs, "deny-status", "DENY_STATUS", "",
+			"comma-separated statuses of the ledger entries failing the verification, e.g. untrusted,unsupported,unknown"),
+		stream: boolFlag(fs, "stream", "STREAM_ASSETS", false,
+			"hash the assets while downloading them, without storing them on disk"),
+		sourceArchives: boolFlag(fs, "source-archives", "NOTARIZE_SOURCE_ARCHIVES", true,
+			"process the source code archives generated by GitHub too"),
+		linkSourceArchive: boolFlag(fs, "link-source-archive", "LINK_SOURCE_ARCHIVE", false,
+			"link the other assets to the source code archive they are built from"),
+		deduplicate: boolFlag(fs, "deduplicate", "DEDUPLICATE_ASSETS", true,
+			"sign the identical assets (i.e. with the same hash) only once"),
+		verifyChecksums: boolFlag(fs, "verify-release-checksums", "VERIFY_RELEASE_CHECKSUMS", false,
+			"check the assets against the checksums and GPG signatures published in the release"),
+		gpgPublicKey: stringFlag(fs, "gpg-public-key", "GPG_PUBLIC_KEY", "",
+			"armored GPG public key the signatures published in the release are verified with"),
+		verifyAuthenticode: boolFlag(fs, "verify-authenticode", "VERIFY_AUTHENTICODE", false,
+			"refuse to notarize the .exe and .msi assets without a valid Authenticode signature"),
+		authenticodeSubject: stringFlag(fs, "authenticode-subject", "AUTHENTICODE_SUBJECT", "",
+			"common name or distinguished name the Authenticode signer of the .exe and .msi assets must have"),
+		verifyAppleNotary: boolFlag(fs, "verify-apple-notarization", "VERIFY_APPLE_NOTARIZATION", false,
+			"refuse to notarize the .dmg and .pkg assets without a stapled Apple notarization ticket"),
+		digests: stringFlag(fs, "digests", "DIGESTS", "",
+			"comma-separated sha512 and/or blake2b digests of the assets recorded along with their SHA-256 hash"),
+		primaryDigest: stringFlag(fs, "primary-digest", "PRIMARY_DIGEST", "sha256",
+			"digest the assets are notarized and looked up by: sha256, sha512 or blake2b"),
+		hashesFromChecksums: stringFlag(fs, "hashes-from-checksums", "HASHES_FROM_CHECKSUMS", "",
+			"name of the trusted checksums file of the release whose hashes are used instead of downloading the assets"),
+		uploadChecksums: boolFlag(fs, "upload-checksums", "UPLOAD_CHECKSUMS", false,
+			"notarize and upload to the release the checksums file of the assets"),
+		releaseNotes: boolFlag(fs, "update-release-notes", "UPDATE_RELEASE_NOTES", false,
+			"maintain the vcn verification instructions of the assets in the release notes"),
+		metadata: stringFlag(fs, "metadata", "METADATA", "",
+			"custom metadata, as a JSON object or key=value pairs separated by commas"),
+		signerIDTemplate: stringFlag(fs, "signer-id-template", "SIGNER_ID_TEMPLATE", "",
+			"Go template of the signer IDs, e.g. {{.Login}}@github"),
+		fallbackSignerID: stringFlag(fs, "fallback-signer-id", "FALLBACK_SIGNER_ID", "",
+			"signer ID of the releases and assets without author or created by bots"),
+		signerIDFromEmail: boolFlag(fs, "signer-id-from-email", "SIGNER_ID_FROM_EMAIL", false,
+			"use the (public or else noreply) emails of the GitHub users as signer IDs"),
+		pushgatewayURL: stringFlag(fs, "metrics-pushgateway-url", "METRICS_PUSHGATEWAY_URL", "",
+			"URL of the Prometheus Pushgateway the metrics of the run are pushed to"),
+		metricsFile: stringFlag(fs, "metrics-file", "METRICS_FILE", "",
+			"file the metrics of the run are written into in the Prometheus text format"),
+		repositoriesMapping: stringFlag(fs, "repositories-mapping", "REPOSITORIES_MAPPING", "",
+			"location of the repositories mapping"),
+		paths: stringFlag(fs, "paths", "PATHS", "",
+			"glob patterns of local files to process too, separated by commas"),
+		images: stringFlag(fs, "images", "IMAGES", "",
+			"container images to process too, separated by commas"),
+		platformManifests: stringFlag(fs, "platform-manifests", "PLATFORM_MANIFESTS", "",
+			"group the per-OS / arch variants of the binaries under notarized platform manifests: "+
+				"auto, or a JSON object of the asset name patterns of each group"),
+		platformOnly: boolFlag(fs, "platform-manifests-only", "PLATFORM_MANIFESTS_ONLY", false,
+			"notarize the platform manifests instead of the variants they list"),
+		dependencies: boolFlag(fs, "dependencies", "NOTARIZE_DEPENDENCIES", false,
+			"notarize a manifest of the dependencies locked by the lockfiles of the repository at the release tag"),
+		dependencyFiles: stringFlag(fs, "dependency-files", "DEPENDENCY_FILES", "",
+			"paths of the go.sum, package-lock.json or requirements.txt lockfiles in the repository, "+
+				"separated by commas (defaults to the ones at its root)"),
+		uploadDependencies: boolFlag(fs, "upload-dependency-manifest", "UPLOAD_DEPENDENCY_MANIFEST", false,
+			"upload the dependency manifest to the release"),
+		helmOCIRegistry: stringFlag(fs, "helm-oci-registry", "HELM_OCI_REGISTRY", "",
+			"OCI registry the Helm charts among the assets are pushed to, e.g. oci://ghcr.io/my-org/charts"),
+		apiTimeout:      stringFlag(fs, "api-timeout", "API_TIMEOUT", "30s", "timeout of each API request"),
+		downloadTimeout: stringFlag(fs, "download-timeout", "DOWNLOAD_TIMEOUT", "10m", "timeout of each asset download"),
+		timeout:         stringFlag(fs, "timeout", "RUN_TIMEOUT", "", "overall deadline of the run, e.g. 30m"),
+		reportFile: stringFlag(fs, "report", "REPORT_FILE", "",
+			"path of the file to write the JSON report into"),
+		sarifFile: stringFlag(fs, "sarif", "SARIF_FILE", "",
+			"path of the file to write the verification findings into as SARIF (verify, reverify and report commands)"),
+		exportProofs: boolFlag(fs, "export-proofs", "EXPORT_PROOFS", false,
+			"add the inclusion proofs and the ledger state of the assets to the report"),
+		uploadProofFiles: boolFlag(fs, "upload-proof-files", "UPLOAD_PROOF_FILES", false,
+			"upload a <asset>.vcn.json proof file of each notarized asset to the release"),
+		ledgerStateFile: stringFlag(fs, "ledger-state-file", "LEDGER_STATE_FILE", "",
+			"file of the verified ledger states the ledgers are checked against and written back into"),
+		etagCacheFile: stringFlag(fs, "github-etag-cache-file", "GITHUB_ETAG_CACHE_FILE", "",
+			"file of the ETags of the GitHub API responses, for sending the API calls conditionally"),
+		releaseEventFile: stringFlag(fs, "release-event-file", "RELEASE_EVENT_FILE", "",
+			"payload file of the release webhook event the release details are read from, if not stale"),
+		offline: boolFlag(fs, "offline", "OFFLINE", false,
+			"write the artifacts to sign into the signing bundle instead of contacting the ledger"),
+		bundle: stringFlag(fs, "bundle", "SIGNING_BUNDLE", "signing-bundle.json",
+			"path of the signing bundle file of the -offline runs and of the submit command"),
+		bundleSHA256: stringFlag(fs, "bundle-sha256", "SIGNING_BUNDLE_SHA256", "",
+			"SHA-256 hash of the signing bundle printed by the -offline run, required by the submit command"),
+		workDir: stringFlag(fs, "work-dir", "WORK_DIR", "",
+			"directory the temp dir of the downloaded assets is created into (defaults to $RUNNER_TEMP or the system temp dir)"),
+		driftBaselineFile: stringFlag(fs, "drift-baseline-file", "DRIFT_BASELINE_FILE", "",
+			"file of the hashes of the assets at their last re-verification, which they are compared against and written back into"),
+	}
+}
+
+// config returns the notarization settings from the parsed flags.
+func (f *releaseFlags) config() (*notarize.Config, error) {
+	var problems notarize.ConfigError
+	releaseURL := *f.releaseURL
+	if len(releaseURL) > 0 && len(*f.tag) > 0 {
+		problems.Addf("the release URL and the release tag are mutually exclusive")
+	}
+	if len(*f.releases) > 0 && (len(releaseURL) > 0 || len(*f.tag) > 0) {
+		problems.Addf(
+			"the releases are mutually exclusive with the release URL and the release tag")
+	}
+	repositories, err := f.repositories()
+	if err != nil {
+		problems.Add(err)
+	}
+	provider, err := notarize.ParseProvider(*f.provider)
+	problems.Add(err)
+	bitbucketRelease := provider == notarize.ProviderBitbucket
+	if bitbucketRelease && (f.bulk || len(repositories) > 1 || len(*f.releases) > 0 || len(*f.latestRelease) > 0) {
+		problems.Addf("the bulk command, multiple repositories, the releases and the latest release " +
+			"are not supported with Bitbucket")
+	}
+	if f.bulk {
+		if len(releaseURL) > 0 || len(*f.tag) > 0 || len(*f.releases) > 0 || len(*f.latestRelease) > 0 ||
+			len(*f.paths) > 0 || len(*f.images) > 0 {
+			problems.Addf("the bulk command is mutually exclusive with the release URL, " +
+				"the release tag, the releases, the local paths and the images")
+		}
+		if len(*f.organization) == 0 && len(repositories) == 0 {
+			problems.Addf("either the organization or the repository is required by the bulk command")
+		}
+		if *f.offline {
+			problems.Addf("the bulk command cannot be run offline")
+		}
+	} else if len(*f.organization) > 0 || len(*f.since) > 0 {
+		problems.Addf("the organization and the cutoff date are only used by the bulk command")
+	} else if len(repositories) > 1 {
+		// the releases of each repository are resolved before the run
+		if len(releaseURL) > 0 || len(*f.paths) > 0 || len(*f.images) > 0 {
+			problems.Addf("multiple repositories are mutually exclusive with the release URL, " +
+				"the local paths and the images")
+		}
+		if len(*f.latestRelease) == 0 && len(*f.tag) == 0 && len(*f.releases) == 0 {
+			problems.Addf("either -release latest, -tag or -releases is required " +
+				"for multiple repositories")
+		}
+	}
+	if len(*f.latestRelease) > 0 {
+		if *f.latestRelease != "latest" {
+			problems.Addf("invalid release %s: must be latest", *f.latestRelease)
+		}
+		if len(releaseURL) > 0 || len(*f.tag) > 0 || len(*f.releases) > 0 {
+			problems.Addf("the latest release is mutually exclusive with the release URL, " +
+				"the release tag and the releases")
+		}
+		if len(*f.repository) == 0 {
+			problems.Addf("the repository is required for the latest release")
+		}
+		if len(repositories) == 1 {
+			releaseURL = github.LatestReleaseURL(*f.githubAPIURL, *f.repository)
+		}
+	}
+	if len(*f.tag) > 0 && len(repositories) <= 1 {
+		if len(*f.repository) == 0 {
+			problems.Addf("the repository is required when the release is specified by its tag")
+		}
+		if bitbucketRelease {
+			releaseURL = bitbucket.TagURL(*f.bitbucketAPIURL, *f.repository, *f.tag)
+		} else {
+			releaseURL = github.ReleaseURLFromTag(*f.githubAPIURL, *f.repository, *f.tag)
+		}
+	}
+
+	cnilHost, cnilFallbackHosts := f.cnil.hosts()
+	var cnilFallbackRESTURLs []string
+	for _, host := range cnilFallbackHosts {
+		cnilFallbackRESTURLs = append(cnilFallbackRESTURLs, f.cnil.restURLOf(host))
+	}
+	cfg := &notarize.Config{
+		CNILHost:                cnilHost,
+		CNILFallbackHosts:       cnilFallbackHosts,
+		CNILFallbackRESTURLs:    cnilFallbackRESTURLs,
+		CNILGRPCPort:            *f.cnil.grpcPort,
+		CNILNoTLS:               *f.cnil.noTLS,
+		CNILCACert:              *f.cnil.caCert,
+		CNILClientCert:          *f.cnil.clientCert,
+		CNILClientKey:           *f.cnil.clientKey,
+		ProxyURL:                *f.cnil.proxyURL,
+		CNILRESTURL:             f.cnil.restURL(),
+		CNILToken:               *f.cnil.token,
+		APIKey:                  *f.cnil.apiKey,
+		CreateLedgerIfMissing:   *f.cnil.createLedger,
+		ImmudbHost:              *f.cnil.immudbHost,
+		ImmudbPort:              *f.cnil.immudbPort,
+		ImmudbUsername:          *f.cnil.immudbUsername,
+		ImmudbPassword:          *f.cnil.immudbPassword,
+		ImmudbDatabase:          *f.cnil.immudbDatabase,
+		ReleaseURL:              releaseURL,
+		Actor:                   *f.actor,
+		Repository:              *f.repository,
+		GitHubAPIURL:            *f.githubAPIURL,
+		GitHubToken:             *f.githubToken,
+		Provider:                provider,
+		BitbucketToken:          *f.bitbucketToken,
+		SignerIDTemplate:        *f.signerIDTemplate,
+		FallbackSignerID:        *f.fallbackSignerID,
+		SignerIDFromEmail:       *f.signerIDFromEmail,
+		MetricsPushgatewayURL:   *f.pushgatewayURL,
+		MetricsFile:             *f.metricsFile,
+		RepositoriesMapping:     *f.repositoriesMapping,
+		MaxParallel:             *f.maxParallel,
+		MaxParallelReleases:     *f.maxParallelReleases,
+		MaxStreams:              *f.maxStreams,
+		StreamAssets:            *f.stream,
+		SkipSourceArchives:      !*f.sourceArchives,
+		LinkSourceArchive:       *f.linkSourceArchive,
+		SkipDeduplication:       !*f.deduplicate,
+		UploadChecksums:         *f.uploadChecksums,
+		UpdateReleaseNotes:      *f.releaseNotes,
+		VerifyReleaseChecksums:  *f.verifyChecksums,
+		GPGPublicKey:            *f.gpgPublicKey,
+		VerifyAuthenticode:      *f.verifyAuthenticode,
+		AuthenticodeSubject:     *f.authenticodeSubject,
+		VerifyAppleNotarization: *f.verifyAppleNotary,
+		HashesFromChecksums:     *f.hashesFromChecksums,
+		ExportProofs:            *f.exportProofs,
+		UploadProofFiles:        *f.uploadProofFiles,
+		LedgerStateFile:         *f.ledgerStateFile,
+		GitHubETagCacheFile:     *f.etagCacheFile,
+		ReleaseEventFile:        *f.releaseEventFile,
+		WorkDir:                 *f.workDir,
+		HelmOCIRegistry:         *f.helmOCIRegistry,
+		DriftBaselineFile:       *f.driftBaselineFile,
+		Logger:                  stderrLogger{},
+	}
+	if *f.offline {
+		cfg.OfflineBundle = *f.bundle
+	}
+
+	if cfg.LedgerID, cfg.LedgersPerAsset, err = notarize.ParseLedgers(*f.cnil.ledger); err != nil {
+		problems.Add(err)
+	}
+	if cfg.KeyPolicy, err = cnil.ParseKeyPolicy(*f.cnil.keyPolicy); err != nil {
+		problems.Add(err)
+	}
+	if cfg.Status, err = notarize.ParseStatus(*f.status); err != nil {
+		problems.Add(err)
+	}
+	if cfg.MaxAssetSize, err = notarize.ParseSize(*f.maxAssetSize); err != nil {
+		problems.Add(err)
+	}
+	if cfg.Digests, err = notarize.ParseDigests(*f.digests); err != nil {
+		problems.Add(err)
+	}
+	if cfg.PrimaryDigest, err = notarize.ParseDigest(*f.primaryDigest); err != nil {
+		problems.Add(err)
+	}
+	if len(*f.statusPerAsset) > 0 {
+		if cfg.StatusPerAsset, err = notarize.ParseStatusPerAsset(*f.statusPerAsset); err != nil {
+			problems.Add(err)
+		}
+	}
+	if cfg.AssetNames, err = notarize.ParseAssetNames(*f.normalizeAssetNames, *f.ledgerNamePerAsset); err != nil {
+		problems.Add(err)
+	}
+	cfg.RequireStatus = *f.requireStatus
+	if cfg.VerifyLedgers, err = notarize.ParseCrossLedgers(*f.verifyLedgers); err != nil {
+		problems.Add(err)
+	}
+	cfg.RequireAllLedgers = *f.requireAllLedgers
+	if cfg.DenyStatuses, err = notarize.ParseEntryStatuses(*f.denyStatus); err != nil {
+		problems.Add(err)
+	}
+	if len(*f.cnil.rotateIfOlderThan) > 0 {
+		if cfg.RotateIfOlderThan, err = notarize.ParseAge(*f.cnil.rotateIfOlderThan); err != nil {
+			problems.Addf("invalid rotate if older than age: %v", err)
+		}
+	}
+	if err := f.cnil.grpcSettings(cfg); err != nil {
+		problems.Add(err)
+	}
+	if len(*f.cnil.vaultAddr) > 0 {
+		if len(*f.cnil.keyCacheFile) > 0 {
+			problems.Addf("the API key cache file and Vault are mutually exclusive")
+		}
+		// Vault is not contacted with invalid flags
+		if len(problems.Problems) == 0 {
+			if err := f.cnil.readVault(cfg); err != nil {
+				problems.Add(err)
+			}
+		}
+	}
+	if len(*f.cnil.keyCacheFile) > 0 {
+		if cfg.KeyPolicy == cnil.KeyPolicyRotate && cfg.RotateIfOlderThan == 0 {
+			problems.Addf("the API key cache requires either -rotate-if-older-than "+
+				"or the %s key policy", cnil.KeyPolicyReuse)
+		}
+		if cfg.APIKeyCache, err = cnil.OpenFileAPIKeyCache(
+			*f.cnil.keyCacheFile, *f.cnil.keyCachePass); err != nil {
+			problems.Add(err)
+		}
+	}
+	if len(*f.platformManifests) > 0 {
+		cfg.PlatformManifests = &notarize.PlatformManifestOptions{Only: *f.platformOnly}
+		if cfg.PlatformManifests.Groups, err = notarize.ParsePlatformGroups(
+			*f.platformManifests); err != nil {
+			problems.Add(err)
+		}
+	}
+	if *f.dependencies {
+		cfg.Dependencies = &notarize.DependencyOptions{Upload: *f.uploadDependencies}
+		if cfg.Dependencies.Files, err = notarize.ParsePatterns(*f.dependencyFiles); err != nil {
+			problems.Add(err)
+		}
+	}
+	if cfg.AssetsInclude, err = notarize.ParsePatterns(*f.include); err != nil {
+		problems.Add(err)
+	}
+	if cfg.AssetsExclude, err = notarize.ParsePatterns(*f.exclude); err != nil {
+		problems.Add(err)
+	}
+	if cfg.AssetSelection, err = notarize.ParseAssetSelection(*f.assetSelection); err != nil {
+		problems.Add(err)
+	}
+	if cfg.Metadata, err = notarize.ParseMetadata(*f.metadata); err != nil {
+		problems.Add(err)
+	}
+	if cfg.Paths, err = notarize.ParsePatterns(*f.paths); err != nil {
+		problems.Add(err)
+	}
+	if cfg.Images, err = notarize.ParsePatterns(*f.images); err != nil {
+		problems.Add(err)
+	}
+	if cfg.APITimeout, err = notarize.ParseAge(*f.apiTimeout); err != nil {
+		problems.Addf("invalid API timeout: %v", err)
+	}
+	if cfg.DownloadTimeout, err = notarize.ParseAge(*f.downloadTimeout); err != nil {
+		problems.Addf("invalid download timeout: %v", err)
+	}
+
+	// the settings are validated as a whole, the releases processed in turn
+	// being resolved only once run
+	if f.bulk || len(*f.releases) > 0 || len(repositories) > 1 {
+		problems.Add(cfg.ValidateReleases())
+	} else {
+		problems.Add(cfg.Validate())
+	}
+	if err := problems.Err(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// bulkOptions returns the releases selection of the bulk command.
+func (f *releaseFlags) bulkOptions() (notarize.BulkOptions, error) {
+	options := notarize.BulkOptions{Organization: *f.organization}
+	if len(options.Organization) == 0 {
+		repositories, err := f.repositories()
+		if err != nil {
+			return options, err
+		}
+		options.Repositories = repositories
+	}
+	if len(*f.since) > 0 {
+		since, err := notarize.ParseCutoff(*f.since)
+		if err != nil {
+			return options, err
+		}
+		options.Since = since
+	}
+	return options, nil
+}
+
+// repositories returns the repositories of the -repository flag, i.e. several
+// ones for processing the releases of each of them in turn.
+func (f *releaseFlags) repositories() ([]string, error) {
+	return notarize.ParseRepositories(*f.repository)
+}
+
+// context returns the context of the run, cancelled on SIGINT / SIGTERM and
+// when the deadline (if any) is hit.
+func (f *releaseFlags) context() (context.Context, context.CancelFunc, error) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	if len(*f.timeout) == 0 {
+		return ctx, stop, nil
+	}
+	timeout, err := notarize.ParseAge(*f.timeout)
+	if err != nil {
+		stop()
+		return nil, nil, fmt.Errorf("invalid timeout: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, func() { cancel(); stop() }, nil
+}
+
+func writeReport(filePath string, report *notarize.Report) error {
+	content, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error JSON-marshaling the report: %v", err)
+	}
+	content = append(content, '\n')
+	if len(filePath) == 0 {
+		_, err = os.Stdout.Write(content)
+		return err
+	}
+	if err := os.WriteFile(filePath, content, 0644); err != nil {
+		return fmt.Errorf("error writing report file %s: %v", filePath, err)
+	}
+	return nil
+}
+
+// runRelease runs the notarize, verify, reverify or bulk command.
+func runRelease(
+	command string,
+	args []string,
+	run func(context.Context, *notarize.Config) (*notarize.Report, error),
+) error {
+
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	f := newReleaseFlags(fs)
+	fs.Parse(args)
+	f.bulk = command == "bulk"
+	cfg, err := f.config()
+	if err != nil {
+		return invalidConfig(err)
+	}
+	if f.bulk {
+		options, err := f.bulkOptions()
+		if err != nil {
+			return invalidConfig(err)
+		}
+		run = func(ctx context.Context, cfg *notarize.Config) (*notarize.Report, error) {
+			return notarize.BulkNotarize(ctx, cfg, options)
+		}
+	}
+	ctx, cancel, err := f.context()
+	if err != nil {
+		return invalidConfig(err)
+	}
+	defer cancel()
+	stopTracing, err := startTracing(ctx)
+	if err != nil {
+		return err
+	}
+	defer stopTracing()
+
+	report, err := f.process(ctx, cfg, run)
+	if len(*f.reportFile) > 0 && report != nil {
+		if err := writeReport(*f.reportFile, report); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+	}
+	if len(*f.sarifFile) > 0 && report != nil && command != "notarize" {
+		if err := notarize.WriteSARIF(*f.sarifFile, report); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if command == "verify" {
+		fmt.Printf("All %d assets are notarized and trusted.\n", len(report.Assets))
+	} else if command == "reverify" {
+		fmt.Printf("All %d assets are notarized and trusted, without drift.\n", len(report.Assets))
+	} else if f.bulk {
+		nbSkipped := 0
+		for _, releaseReport := range report.Releases {
+			if releaseReport.Skipped {
+				nbSkipped++
+			}
+		}
+		fmt.Printf("All %d releases have been processed (%d of them notarized already).\n",
+			len(report.Releases), nbSkipped)
+	} else if *f.offline {
+		fmt.Printf("All %d assets have been recorded into the signing bundle %s (SHA-256 hash %s).\n",
+			len(report.Assets), *f.bundle, report.BundleSHA256)
+	} else {
+		fmt.Printf("All %d assets have been successfully notarized.\n", len(report.Assets))
+	}
+	return nil
+}
+
+// process runs the given operation on the release of the flags or, if
+// multiple releases are specified, on each of them.
+func (f *releaseFlags) process(
+	ctx context.Context,
+	cfg *notarize.Config,
+	run func(context.Context, *notarize.Config) (*notarize.Report, error),
+) (*notarize.Report, error) {
+
+	if f.bulk {
+		return run(ctx, cfg)
+	}
+	repositories, err := f.repositories()
+	if err != nil {
+		return nil, err
+	}
+	if len(repositories) > 1 {
+		releaseURLs, err := notarize.ResolveRepositoriesReleaseURLs(
+			ctx, cfg, repositories, len(*f.latestRelease) > 0, *f.tag, *f.releases)
+		if err != nil {
+			return nil, fmt.Errorf("error resolving the releases: %w", err)
+		}
+		return notarize.ProcessReleases(ctx, cfg, releaseURLs, run)
+	}
+	if len(*f.releases) == 0 {
+		return run(ctx, cfg)
+	}
+	releaseURLs, err := notarize.ResolveReleaseURLs(ctx, cfg, *f.repository, *f.releases)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving the releases: %v", err)
+	}
+	return notarize.ProcessReleases(ctx, cfg, releaseURLs, run)
+}
+
+// startTracing exports the OpenTelemetry spans of the run, if an OTLP endpoint
+// is configured via the OTEL_EXPORTER_OTLP_* environment variables, and
+// returns the function flushing them.
+func startTracing(ctx context.Context) (func(), error) {
+	shutdown, err := notarize.StartTracing(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdown(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "error exporting the traces: %v\n", err)
+		}
+	}, nil
+}
+
+// runReport runs the report command, i.e. a verification whose report is
+// printed (or written into the report file) even if some assets are not
+// notarized or not trusted.
+func runReport(args []string) error {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	f := newReleaseFlags(fs)
+	fs.Parse(args)
+	cfg, err := f.config()
+	if err != nil {
+		return invalidConfig(err)
+	}
+	ctx, cancel, err := f.context()
+	if err != nil {
+		return invalidConfig(err)
+	}
+	defer cancel()
+	stopTracing, err := startTracing(ctx)
+	if err != nil {
+		return err
+	}
+	defer stopTracing()
+
+	report, err := f.process(ctx, cfg, notarize.VerifyRelease)
+	if err != nil && !errors.Is(err, notarize.ErrVerificationFailed) {
+		return err
+	}
+	if len(*f.sarifFile) > 0 {
+		if err := notarize.WriteSARIF(*f.sarifFile, report); err != nil {
+			return err
+		}
+	}
+	return writeReport(*f.reportFile, report)
+}
+
+// runSubmit runs the submit command, i.e. signs the artifacts of the signing
+// bundle written by an offline run, with the ledger flags (the release flags
+// are ignored).
+func runSubmit(args []string) error {
+	fs := flag.NewFlagSet("submit", flag.ExitOnError)
+	f := newReleaseFlags(fs)
+	fs.Parse(args)
+	cfg, err := f.config()
+	if err != nil {
+		return invalidConfig(err)
+	}
+	ctx, cancel, err := f.context()
+	if err != nil {
+		return invalidConfig(err)
+	}
+	defer cancel()
+	stopTracing, err := startTracing(ctx)
+	if err != nil {
+		return err
+	}
+	defer stopTracing()
+
+	report, err := notarize.SubmitBundle(ctx, cfg, *f.bundle, *f.bundleSHA256)
+	if len(*f.reportFile) > 0 {
+		if err := writeReport(*f.reportFile, report); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("All %d artifacts of the signing bundle %s have been successfully notarized.\n",
+		len(report.Assets), *f.bundle)
+	return nil
+}
+
+// keyOutput is the JSON output of the keys command for each signer ID.
+type keyOutput struct {
+	SignerID string `json:"signer_id"`
+	*cnil.APIKeyResponse
+}
+
+// runKeys runs the keys command, i.e. keys <get|create|rotate|revoke>
+// <signer ID>..., printing the resulting API keys as JSON.
+func runKeys(args []string) error {
+	fs := flag.NewFlagSet("keys", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(),
+			"Usage: notarize-release keys [flags] <get|create|rotate|revoke> <signer ID>...\n")
+		fs.PrintDefaults()
+	}
+	f := newCNILFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() < 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if len(*f.host) == 0 || len(*f.token) == 0 {
+		return errors.New("the CNIL host and personal token are required")
+	}
+	ledgerID, ledgersPerAsset, err := notarize.ParseLedgers(*f.ledger)
+	if err != nil {
+		return err
+	}
+	if len(ledgersPerAsset) > 0 {
+		return errors.New("the API keys can be managed for a single ledger at a time")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	tlsConfig, err := cnil.TLSConfig(*f.caCert, *f.clientCert, *f.clientKey)
+	if err != nil {
+		return err
+	}
+	baseClient := &http.Client{}
+	if len(*f.proxyURL) > 0 {
+		proxyURL, err := url.Parse(*f.proxyURL)
+		if err != nil {
+			return fmt.Errorf("invalid proxy URL %s: %v", *f.proxyURL, err)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = http.ProxyURL(proxyURL)
+		baseClient.Transport = transport
+	}
+	httpClient, err := cnil.WithTLSConfig(baseClient, tlsConfig)
+	if err != nil {
+		return err
+	}
+	_, fallbackHosts := f.hosts()
+	var fallbackRESTURLs []string
+	for _, host := range fallbackHosts {
+		fallbackRESTURLs = append(fallbackRESTURLs, f.restURLOf(host))
+	}
+	httpClient = cnil.WithFailover(httpClient, f.restURL(), fallbackRESTURLs, stderrLogger{})
+	options := &cnil.Options{BaseURL: f.restURL(), Token: *f.token, LedgerID: ledgerID}
+	action, signerIDs := fs.Arg(0), fs.Args()[1:]
+
+	var outputs []keyOutput
+	for _, signerID := range signerIDs {
+		var apiKey *cnil.APIKeyResponse
+		switch action {
+		case "get":
+			apiKey, err = cnil.GetAPIKey(ctx, httpClient, options, signerID)
+		case "create":
+			apiKey, err = cnil.CreateAPIKey(ctx, httpClient, options, signerID)
+		case "rotate", "revoke":
+			apiKey, err = cnil.GetAPIKey(ctx, httpClient, options, signerID)
+			if err == nil && action == "rotate" {
+				apiKey, err = cnil.RotateAPIKey(ctx, httpClient, options, apiKey.ID)
+			} else if err == nil {
+				err = cnil.RevokeAPIKeys(
+					ctx, httpClient, options, []*cnil.APIKeyResponse{apiKey}, stderrLogger{})
+			}
+		default:
+			return fmt.Errorf("invalid keys action %s: must be one of get, create, rotate or revoke",
+				action)
+		}
+		if err != nil {
+			return fmt.Errorf("error running keys %s for signer ID %s: %v", action, signerID, err)
+		}
+		outputs = append(outputs, keyOutput{SignerID: signerID, APIKeyResponse: apiKey})
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(outputs)
+}
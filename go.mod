@@ -3,10 +3,25 @@ module github.com/codenotary/notarize-release-assets-action
 go 1.16
 
 require (
+	github.com/blang/semver v3.5.1+incompatible
+	github.com/codenotary/immudb v0.9.2-0.20210324115202-e54bda6e1cc3
 	github.com/dustin/go-humanize v1.0.0
 	github.com/go-playground/universal-translator v0.17.0 // indirect
 	github.com/go-playground/validator v9.31.0+incompatible
+	github.com/golang/protobuf v1.5.2
+	github.com/h2non/filetype v1.0.10
 	github.com/leodido/go-urn v1.2.1 // indirect
+	github.com/vchain-us/ledger-compliance-go v0.9.2-0.20210409124508-8386e9700009
 	github.com/vchain-us/vcn v0.9.5-0.20210430101114-66908fde3a5c
+	go.opentelemetry.io/otel v1.0.1
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc v1.0.1
+	go.opentelemetry.io/otel/sdk v1.0.1
+	go.opentelemetry.io/otel/trace v1.0.1
+	golang.org/x/crypto v0.0.0-20201208171446-5f87f3452ae9
+	golang.org/x/net v0.0.0-20201209123823-ac852fbbde11
+	google.golang.org/grpc v1.41.0
+	google.golang.org/protobuf v1.27.1
 	gopkg.in/go-playground/assert.v1 v1.2.1 // indirect
+	gopkg.in/src-d/go-git.v4 v4.13.1
+	gopkg.in/yaml.v3 v3.0.1
 )
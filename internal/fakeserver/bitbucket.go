@@ -0,0 +1,175 @@
+package fakeserver
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// download is a file of the downloads of a repository of the fake Bitbucket
+// API, uploaded by the nickname of its Asset.Uploader.
+type download struct {
+	Asset
+	createdOn time.Time
+}
+
+// Bitbucket is a fake Bitbucket Cloud REST API (under /2.0) and website,
+// serving the tags, the downloads of the repositories (paginated with next
+// links as Bitbucket does, and redirected to their storage) and the source
+// code archives of the tags.
+type Bitbucket struct {
+	*httptest.Server
+	// APIURL is the base URL of the REST API, i.e. <server URL>/2.0.
+	APIURL string
+	// Token, if set, is the only token the requests are allowed with.
+	Token string
+	// PageLen is the maximum page size of the lists (defaults to 100).
+	PageLen int
+
+	mu        sync.Mutex
+	tags      map[string]string // tagger nickname by <workspace>/<repo-slug>@<tag>
+	downloads map[string][]*download
+	requests  []string
+}
+
+// NewBitbucket starts a fake Bitbucket API, which the caller must close.
+func NewBitbucket() *Bitbucket {
+	b := &Bitbucket{tags: make(map[string]string), downloads: make(map[string][]*download)}
+	b.Server = httptest.NewServer(http.HandlerFunc(b.serveHTTP))
+	b.APIURL = b.URL + "/2.0"
+	return b
+}
+
+// AddTag adds the given tag, created by the given nickname, to the given
+// repository (i.e. <workspace>/<repo-slug>) and returns its API URL. Its
+// commit is CommitSHA(repository, tag).
+func (b *Bitbucket) AddTag(repository string, tag string, tagger string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tags[repository+"@"+tag] = tagger
+	return fmt.Sprintf("%s/repositories/%s/refs/tags/%s", b.APIURL, repository, url.PathEscape(tag))
+}
+
+// AddDownloads adds the given files to the downloads of the given repository.
+func (b *Bitbucket) AddDownloads(repository string, files ...Asset) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, file := range files {
+		b.downloads[repository] = append(b.downloads[repository],
+			&download{Asset: file, createdOn: time.Now().UTC()})
+	}
+}
+
+// Requests returns the requests served so far, as "<method> <path>".
+func (b *Bitbucket) Requests() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.requests...)
+}
+
+func (b *Bitbucket) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.requests = append(b.requests, req.Method+" "+req.URL.Path)
+
+	// the storage of the downloads does not need the token
+	pieces := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	if len(pieces) == 4 && pieces[0] == "storage" {
+		for _, d := range b.downloads[pieces[1]+"/"+pieces[2]] {
+			if d.Name == pieces[3] {
+				w.Header().Set("Content-Type", "application/octet-stream")
+				w.Write(d.Content)
+				return
+			}
+		}
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+		return
+	}
+	if len(b.Token) > 0 && req.Header.Get("Authorization") != "Bearer "+b.Token {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid token"})
+		return
+	}
+
+	switch {
+	case len(pieces) == 7 && pieces[0] == "2.0" && pieces[1] == "repositories" &&
+		pieces[4] == "refs" && pieces[5] == "tags":
+		repository := pieces[2] + "/" + pieces[3]
+		tagger, ok := b.tags[repository+"@"+pieces[6]]
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "tag not found"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"name":   pieces[6],
+			"tagger": map[string]interface{}{"user": map[string]string{"nickname": tagger}},
+			"target": map[string]interface{}{"hash": CommitSHA(repository, pieces[6])},
+		})
+	case len(pieces) == 5 && pieces[0] == "2.0" && pieces[1] == "repositories" && pieces[4] == "downloads":
+		b.writeDownloads(w, req, pieces[2]+"/"+pieces[3])
+	case len(pieces) == 6 && pieces[0] == "2.0" && pieces[1] == "repositories" && pieces[4] == "downloads":
+		http.Redirect(w, req, fmt.Sprintf("%s/storage/%s/%s/%s", b.URL, pieces[2], pieces[3], pieces[5]),
+			http.StatusFound)
+	case len(pieces) == 4 && pieces[2] == "get":
+		repository := pieces[0] + "/" + pieces[1]
+		for format, suffix := range map[string]string{"tarball": ".tar.gz", "zipball": ".zip"} {
+			if tag := strings.TrimSuffix(pieces[3], suffix); tag != pieces[3] {
+				w.Header().Set("Content-Type", "application/octet-stream")
+				w.Write(SourceArchive(repository, format, tag))
+				return
+			}
+		}
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+	}
+}
+
+// writeDownloads writes the page of the downloads of the given repository
+// requested with the page and pagelen query parameters, with the next link of
+// the next page, if any.
+func (b *Bitbucket) writeDownloads(w http.ResponseWriter, req *http.Request, repository string) {
+	pageLen := b.PageLen
+	if n, err := strconv.Atoi(req.URL.Query().Get("pagelen")); err == nil && n > 0 &&
+		(pageLen == 0 || n < pageLen) {
+		pageLen = n
+	}
+	if pageLen == 0 {
+		pageLen = 100
+	}
+	page := 1
+	if n, err := strconv.Atoi(req.URL.Query().Get("page")); err == nil && n > 0 {
+		page = n
+	}
+	downloads := b.downloads[repository]
+	start, end := (page-1)*pageLen, page*pageLen
+	if start > len(downloads) {
+		start = len(downloads)
+	}
+	payload := map[string]interface{}{"page": page, "pagelen": pageLen, "size": len(downloads)}
+	if end > len(downloads) {
+		end = len(downloads)
+	} else if end < len(downloads) {
+		payload["next"] = fmt.Sprintf("%s%s?pagelen=%d&page=%d", b.URL, req.URL.Path, pageLen, page+1)
+	}
+	values := []interface{}{}
+	for _, d := range downloads[start:end] {
+		values = append(values, map[string]interface{}{
+			"name":       d.Name,
+			"size":       len(d.Content),
+			"created_on": d.createdOn,
+			"user":       map[string]string{"nickname": d.Uploader},
+			"links": map[string]interface{}{
+				"self": map[string]string{
+					"href": fmt.Sprintf("%s%s/%s", b.URL, req.URL.Path, url.PathEscape(d.Name)),
+				},
+			},
+		})
+	}
+	payload["values"] = values
+	writeJSON(w, http.StatusOK, payload)
+}
@@ -0,0 +1,250 @@
+package fakeserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APIKey is an API key of the fake CNIL REST API.
+type APIKey struct {
+	ID        string
+	Key       string
+	SignerID  string
+	LedgerID  string
+	CreatedAt time.Time
+	ReadOnly  bool
+}
+
+// CNIL is a fake CodeNotary Immutable Ledger REST API, managing the ledgers
+// and the API keys of the signer IDs as the CNIL API keys endpoints do.
+type CNIL struct {
+	*httptest.Server
+	// BaseURL is the base URL of the REST API, i.e. <server URL>/api/v1.
+	BaseURL string
+	// Token is the personal token the requests must be sent with.
+	Token string
+
+	mu       sync.Mutex
+	ledgers  map[string]string // name by ID
+	apiKeys  []*APIKey
+	nextID   int
+	failures map[string][]*failure
+	requests []string
+}
+
+// NewCNIL starts a fake CNIL REST API accepting the given personal token,
+// which the caller must close.
+func NewCNIL(token string) *CNIL {
+	c := &CNIL{Token: token, ledgers: make(map[string]string), failures: make(map[string][]*failure)}
+	c.Server = httptest.NewServer(http.HandlerFunc(c.serveHTTP))
+	c.BaseURL = c.URL + "/api/v1"
+	return c
+}
+
+// AddLedger adds a ledger with the given ID and name.
+func (c *CNIL) AddLedger(id string, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ledgers[id] = name
+}
+
+// AddAPIKey adds an existing API key of the given signer ID into the given
+// ledger, created at the given time, and returns it.
+func (c *CNIL) AddAPIKey(ledgerID string, signerID string, createdAt time.Time) APIKey {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	apiKey := c.newAPIKey(ledgerID, signerID)
+	apiKey.CreatedAt = createdAt
+	return *apiKey
+}
+
+// APIKeys returns the API keys of the given signer ID.
+func (c *CNIL) APIKeys(signerID string) []APIKey {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var apiKeys []APIKey
+	for _, apiKey := range c.apiKeys {
+		if apiKey.SignerID == signerID {
+			apiKeys = append(apiKeys, *apiKey)
+		}
+	}
+	return apiKeys
+}
+
+// Fail answers the next count requests of the given path (relative to the
+// base URL, e.g. /ledgers/<ledger ID>) with the given status.
+func (c *CNIL) Fail(path string, status int, count int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failures["/api/v1"+path] = append(c.failures["/api/v1"+path],
+		&failure{status: status, count: count, body: `{"message": "fake failure"}`})
+}
+
+// Requests returns the requests served so far, as "<method> <path>" with the
+// paths relative to the base URL.
+func (c *CNIL) Requests() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.requests...)
+}
+
+func (c *CNIL) newAPIKey(ledgerID string, signerID string) *APIKey {
+	c.nextID++
+	apiKey := &APIKey{
+		ID:        fmt.Sprintf("key-%d", c.nextID),
+		Key:       fmt.Sprintf("identity%d.secret%d", c.nextID, c.nextID),
+		SignerID:  signerID,
+		LedgerID:  ledgerID,
+		CreatedAt: time.Now().UTC(),
+	}
+	c.apiKeys = append(c.apiKeys, apiKey)
+	return apiKey
+}
+
+// apiKeyJSON returns the API key as listed, i.e. without its value, or as
+// created or rotated.
+func apiKeyJSON(apiKey *APIKey, withValue bool) map[string]interface{} {
+	payload := map[string]interface{}{
+		"id":         apiKey.ID,
+		"created_at": apiKey.CreatedAt,
+		"read_only":  apiKey.ReadOnly,
+	}
+	if withValue {
+		payload["key"] = apiKey.Key
+	}
+	return payload
+}
+
+func (c *CNIL) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	path := strings.TrimPrefix(req.URL.EscapedPath(), "/api/v1")
+	c.requests = append(c.requests, req.Method+" "+path)
+
+	if failures := c.failures[req.URL.Path]; len(failures) > 0 {
+		f := failures[0]
+		if f.count--; f.count <= 0 {
+			c.failures[req.URL.Path] = failures[1:]
+		}
+		writeJSON(w, f.status, json.RawMessage(f.body))
+		return
+	}
+	if req.Header.Get("Authorization") != "Bearer "+c.Token {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "invalid token"})
+		return
+	}
+
+	var pieces []string
+	for _, piece := range strings.Split(strings.Trim(path, "/"), "/") {
+		piece, _ = url.PathUnescape(piece)
+		pieces = append(pieces, piece)
+	}
+	notFound := func() {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "not found"})
+	}
+
+	switch {
+	case len(pieces) == 3 && pieces[0] == "api_keys" && pieces[1] == "identity" && req.Method == http.MethodGet:
+		var items []interface{}
+		for _, apiKey := range c.apiKeys {
+			if apiKey.SignerID == pieces[2] {
+				items = append(items, apiKeyJSON(apiKey, false))
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"total": len(items), "items": items})
+	case len(pieces) == 1 && pieces[0] == "ledgers" && req.Method == http.MethodGet:
+		var items []interface{}
+		for id, name := range c.ledgers {
+			if name == req.URL.Query().Get("name") {
+				items = append(items, map[string]string{"id": id, "name": name})
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"total": len(items), "items": items})
+	case len(pieces) == 1 && pieces[0] == "ledgers" && req.Method == http.MethodPost:
+		var payload struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+			return
+		}
+		c.nextID++
+		id := fmt.Sprintf("ledger-%d", c.nextID)
+		c.ledgers[id] = payload.Name
+		writeJSON(w, http.StatusCreated, map[string]string{"id": id, "name": payload.Name})
+	case len(pieces) >= 2 && pieces[0] == "ledgers":
+		name, ok := c.ledgers[pieces[1]]
+		if !ok {
+			notFound()
+			return
+		}
+		c.serveLedger(w, req, pieces[1], name, pieces[2:])
+	default:
+		notFound()
+	}
+}
+
+// serveLedger serves the requests of the /ledgers/<ledger ID> URLs, given the
+// pieces of their paths after the ledger ID.
+func (c *CNIL) serveLedger(w http.ResponseWriter, req *http.Request, ledgerID string, name string, pieces []string) {
+	findAPIKey := func(id string) *APIKey {
+		for _, apiKey := range c.apiKeys {
+			if apiKey.ID == id && apiKey.LedgerID == ledgerID {
+				return apiKey
+			}
+		}
+		return nil
+	}
+
+	switch {
+	case len(pieces) == 0 && req.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]string{"id": ledgerID, "name": name})
+	case len(pieces) == 1 && pieces[0] == "api_keys" && req.Method == http.MethodGet:
+		var items []interface{}
+		for _, apiKey := range c.apiKeys {
+			if apiKey.LedgerID == ledgerID {
+				items = append(items, apiKeyJSON(apiKey, false))
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"total": len(items), "items": items})
+	case len(pieces) == 1 && pieces[0] == "api_keys" && req.Method == http.MethodPost:
+		var payload struct {
+			Name     string `json:"name"`
+			ReadOnly bool   `json:"read_only"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+			return
+		}
+		apiKey := c.newAPIKey(ledgerID, payload.Name)
+		apiKey.ReadOnly = payload.ReadOnly
+		writeJSON(w, http.StatusCreated, apiKeyJSON(apiKey, true))
+	case len(pieces) == 2 && pieces[0] == "api_keys" && req.Method == http.MethodDelete:
+		for i, apiKey := range c.apiKeys {
+			if apiKey.ID == pieces[1] && apiKey.LedgerID == ledgerID {
+				c.apiKeys = append(c.apiKeys[:i:i], c.apiKeys[i+1:]...)
+				writeJSON(w, http.StatusOK, map[string]string{})
+				return
+			}
+		}
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "API key not found"})
+	case len(pieces) == 3 && pieces[0] == "api_keys" && pieces[2] == "rotate" && req.Method == http.MethodPut:
+		apiKey := findAPIKey(pieces[1])
+		if apiKey == nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"message": "API key not found"})
+			return
+		}
+		c.nextID++
+		apiKey.Key = fmt.Sprintf("identity%d.rotated%d", c.nextID, c.nextID)
+		apiKey.CreatedAt = time.Now().UTC()
+		writeJSON(w, http.StatusOK, apiKeyJSON(apiKey, true))
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "not found"})
+	}
+}
@@ -0,0 +1,465 @@
+// Package fakeserver implements in-memory fakes of the GitHub, Bitbucket and
+// CNIL REST APIs, served by httptest servers, for the integration tests of the
+// GitHub, Bitbucket and CNIL calls and of the notarization pipeline.
+package fakeserver
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Asset is a release asset of the fake GitHub API.
+type Asset struct {
+	Name        string
+	Content     []byte
+	ContentType string
+	Uploader    string
+}
+
+// release is a release of the fake GitHub API.
+type release struct {
+	id         int
+	repository string
+	tag        string
+	author     string
+	body       string
+	createdAt  time.Time
+	assets     []*asset
+}
+
+type asset struct {
+	Asset
+	id int
+}
+
+// failure is a canned response answered to the next requests of a path, e.g.
+// for exercising the retries.
+type failure struct {
+	status int
+	header http.Header
+	body   string
+	count  int
+}
+
+// GitHub is a fake GitHub REST API, serving the releases (with their assets
+// and source code archives), the users and the uploads of assets, with the
+// lists paginated with Link headers as GitHub does.
+type GitHub struct {
+	*httptest.Server
+	// Token, if set, is the only token the requests are allowed with.
+	Token string
+	// PerPage is the maximum page size of the lists (defaults to 100).
+	PerPage int
+
+	mu       sync.Mutex
+	releases []*release
+	nextID   int
+	failures map[string][]*failure
+	requests []string
+	// the regenerations of the source code archives, by
+	// <owner>/<repo-name>@<tag> or <owner>/<repo-name>@<tag>/<format>, and the
+	// moves of the tags, by <owner>/<repo-name>@<tag>
+	regenerations map[string]int
+	moves         map[string]int
+}
+
+// NewGitHub starts a fake GitHub API, which the caller must close.
+func NewGitHub() *GitHub {
+	g := &GitHub{
+		failures:      make(map[string][]*failure),
+		regenerations: make(map[string]int),
+		moves:         make(map[string]int),
+	}
+	g.Server = httptest.NewServer(http.HandlerFunc(g.serveHTTP))
+	return g
+}
+
+// AddRelease adds a release with the given tag and assets to the given
+// repository (i.e. <owner>/<repo-name>), authored by the given login, and
+// returns its API URL.
+func (g *GitHub) AddRelease(repository string, tag string, author string, assets ...Asset) string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	r := &release{
+		id:         g.newID(),
+		repository: repository,
+		tag:        tag,
+		author:     author,
+		createdAt:  time.Now().UTC().Add(time.Duration(len(g.releases)) * time.Second),
+	}
+	for _, a := range assets {
+		r.assets = append(r.assets, &asset{Asset: a, id: g.newID()})
+	}
+	g.releases = append(g.releases, r)
+	return g.releaseURL(r)
+}
+
+// Fail answers the next count requests of the given path (e.g.
+// /repos/<owner>/<repo-name>/releases/1) with the given status.
+func (g *GitHub) Fail(path string, status int, count int) {
+	g.addFailure(path, &failure{status: status, count: count, body: `{"message": "fake failure"}`})
+}
+
+// RateLimit answers the next count requests of the given path with a
+// secondary rate limit error, to be retried right away.
+func (g *GitHub) RateLimit(path string, count int) {
+	g.addFailure(path, &failure{
+		status: http.StatusForbidden,
+		header: http.Header{"Retry-After": []string{"0"}},
+		body:   `{"message": "You have exceeded a secondary rate limit."}`,
+		count:  count,
+	})
+}
+
+func (g *GitHub) addFailure(path string, f *failure) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.failures[path] = append(g.failures[path], f)
+}
+
+// Requests returns the requests served so far, as "<method> <path>".
+func (g *GitHub) Requests() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]string(nil), g.requests...)
+}
+
+// AssetNames returns the names of the assets of the release with the given
+// API URL, e.g. for checking the uploads.
+func (g *GitHub) AssetNames(releaseURL string) []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var names []string
+	for _, r := range g.releases {
+		if g.releaseURL(r) == releaseURL {
+			for _, a := range r.assets {
+				names = append(names, a.Name)
+			}
+		}
+	}
+	return names
+}
+
+// AssetContent returns the content of the asset with the given name of the
+// release with the given API URL, or nil if there is no such asset.
+func (g *GitHub) AssetContent(releaseURL string, name string) []byte {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, r := range g.releases {
+		if g.releaseURL(r) != releaseURL {
+			continue
+		}
+		for _, a := range r.assets {
+			if a.Name == name {
+				return a.Content
+			}
+		}
+	}
+	return nil
+}
+
+// Regenerate changes the bytes of the source code archives of the given tag
+// of the given repository, as GitHub regenerating them from the same commit:
+// the ones of the given formats (i.e. tarball or zipball), if any, or else
+// both.
+func (g *GitHub) Regenerate(repository string, tag string, formats ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(formats) == 0 {
+		g.regenerations[repository+"@"+tag]++
+	}
+	for _, format := range formats {
+		g.regenerations[repository+"@"+tag+"/"+format]++
+	}
+}
+
+// MoveTag moves the given tag of the given repository to another commit,
+// hence changing its source code archives as well.
+func (g *GitHub) MoveTag(repository string, tag string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.moves[repository+"@"+tag]++
+}
+
+// SourceArchive returns the content of the tarball (or zipball) of the given
+// release tag, as served by the fake API until regenerated (see Regenerate).
+func SourceArchive(repository string, format string, tag string) []byte {
+	return []byte(fmt.Sprintf("%s source code of %s at %s\n", format, repository, tag))
+}
+
+// CommitSHA returns the SHA of the commit the given ref (e.g. a tag) points
+// to, as served by the fake API until moved (see MoveTag).
+func CommitSHA(repository string, ref string) string {
+	return fmt.Sprintf("%x", sha1.Sum([]byte(repository+"@"+ref)))
+}
+
+// commitSHA returns the SHA of the commit the given ref points to, once moved.
+func (g *GitHub) commitSHA(repository string, ref string) string {
+	if moves := g.moves[repository+"@"+ref]; moves > 0 {
+		return CommitSHA(repository, fmt.Sprintf("%s~%d", ref, moves))
+	}
+	return CommitSHA(repository, ref)
+}
+
+// sourceArchive returns the content of the tarball (or zipball) of the given
+// tag, once regenerated or moved.
+func (g *GitHub) sourceArchive(repository string, format string, tag string) []byte {
+	content := SourceArchive(repository, format, tag)
+	key := repository + "@" + tag
+	regenerations := g.regenerations[key] + g.regenerations[key+"/"+format]
+	if regenerations > 0 || g.moves[key] > 0 {
+		content = append(content, fmt.Sprintf("regenerated %d, moved %d\n", regenerations, g.moves[key])...)
+	}
+	return content
+}
+
+func (g *GitHub) newID() int {
+	g.nextID++
+	return g.nextID
+}
+
+func (g *GitHub) releaseURL(r *release) string {
+	return fmt.Sprintf("%s/repos/%s/releases/%d", g.URL, r.repository, r.id)
+}
+
+func (g *GitHub) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.requests = append(g.requests, req.Method+" "+req.URL.Path)
+
+	if failures := g.failures[req.URL.Path]; len(failures) > 0 {
+		f := failures[0]
+		if f.count--; f.count <= 0 {
+			g.failures[req.URL.Path] = failures[1:]
+		}
+		for name, values := range f.header {
+			w.Header()[name] = values
+		}
+		writeJSON(w, f.status, json.RawMessage(f.body))
+		return
+	}
+	if len(g.Token) > 0 && req.Header.Get("Authorization") != "token "+g.Token {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "Bad credentials"})
+		return
+	}
+
+	pieces := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	switch {
+	case len(pieces) == 2 && pieces[0] == "users" && req.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"id":         len(pieces[1]),
+			"login":      pieces[1],
+			"type":       "User",
+			"created_at": time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		})
+	case len(pieces) >= 4 && pieces[0] == "repos" && pieces[3] == "releases":
+		g.serveReleases(w, req, pieces[1]+"/"+pieces[2], pieces[4:])
+	case len(pieces) == 5 && pieces[0] == "repos" && pieces[3] == "commits" && req.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]string{"sha": g.commitSHA(pieces[1]+"/"+pieces[2], pieces[4])})
+	case len(pieces) == 5 && pieces[0] == "repos" && (pieces[3] == "tarball" || pieces[3] == "zipball"):
+		w.Header().Set("Content-Type", "application/x-gzip")
+		w.Write(g.sourceArchive(pieces[1]+"/"+pieces[2], pieces[3], pieces[4]))
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+	}
+}
+
+// serveReleases serves the requests of the /repos/<owner>/<repo-name>/releases
+// API URLs, given the pieces of their paths after releases.
+func (g *GitHub) serveReleases(w http.ResponseWriter, req *http.Request, repository string, pieces []string) {
+	var releases []*release
+	for _, r := range g.releases {
+		if r.repository == repository {
+			releases = append(releases, r)
+		}
+	}
+	notFound := func() {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+	}
+
+	switch {
+	case len(pieces) == 0 && req.Method == http.MethodGet:
+		// the most recent first
+		summaries := make([]interface{}, 0, len(releases))
+		for i := len(releases) - 1; i >= 0; i-- {
+			r := releases[i]
+			summaries = append(summaries, map[string]interface{}{
+				"url":        g.releaseURL(r),
+				"tag_name":   r.tag,
+				"created_at": r.createdAt,
+			})
+		}
+		g.writePage(w, req, summaries)
+	case len(pieces) == 1 && pieces[0] == "latest" && req.Method == http.MethodGet:
+		if len(releases) == 0 {
+			notFound()
+			return
+		}
+		writeJSON(w, http.StatusOK, g.releaseJSON(releases[len(releases)-1]))
+	case len(pieces) == 2 && pieces[0] == "tags" && req.Method == http.MethodGet:
+		for _, r := range releases {
+			if r.tag == pieces[1] {
+				writeJSON(w, http.StatusOK, g.releaseJSON(r))
+				return
+			}
+		}
+		notFound()
+	case len(pieces) == 2 && pieces[0] == "assets" && (req.Method == http.MethodGet || req.Method == http.MethodDelete):
+		for _, r := range releases {
+			for i, a := range r.assets {
+				if strconv.Itoa(a.id) != pieces[1] {
+					continue
+				}
+				if req.Method == http.MethodDelete {
+					r.assets = append(r.assets[:i:i], r.assets[i+1:]...)
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+				contentType := a.ContentType
+				if len(contentType) == 0 {
+					contentType = "application/octet-stream"
+				}
+				w.Header().Set("Content-Type", contentType)
+				http.ServeContent(w, req, a.Name, time.Time{}, bytes.NewReader(a.Content))
+				return
+			}
+		}
+		notFound()
+	case len(pieces) >= 1:
+		r := findRelease(releases, pieces[0])
+		if r == nil {
+			notFound()
+			return
+		}
+		switch {
+		case len(pieces) == 1 && req.Method == http.MethodGet:
+			writeJSON(w, http.StatusOK, g.releaseJSON(r))
+		case len(pieces) == 1 && req.Method == http.MethodPatch:
+			var update struct {
+				Body string `json:"body"`
+			}
+			if err := json.NewDecoder(req.Body).Decode(&update); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+				return
+			}
+			r.body = update.Body
+			writeJSON(w, http.StatusOK, g.releaseJSON(r))
+		case len(pieces) == 2 && pieces[1] == "assets" && req.Method == http.MethodGet:
+			assets := make([]interface{}, 0, len(r.assets))
+			for _, a := range r.assets {
+				assets = append(assets, g.assetJSON(r, a))
+			}
+			g.writePage(w, req, assets)
+		case len(pieces) == 2 && pieces[1] == "assets" && req.Method == http.MethodPost:
+			content, err := io.ReadAll(req.Body)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+				return
+			}
+			a := &asset{id: g.newID(), Asset: Asset{
+				Name:        req.URL.Query().Get("name"),
+				Content:     content,
+				ContentType: req.Header.Get("Content-Type"),
+				Uploader:    "github-actions[bot]",
+			}}
+			r.assets = append(r.assets, a)
+			writeJSON(w, http.StatusCreated, g.assetJSON(r, a))
+		default:
+			notFound()
+		}
+	default:
+		notFound()
+	}
+}
+
+func findRelease(releases []*release, id string) *release {
+	for _, r := range releases {
+		if strconv.Itoa(r.id) == id {
+			return r
+		}
+	}
+	return nil
+}
+
+func (g *GitHub) releaseJSON(r *release) map[string]interface{} {
+	releaseURL := g.releaseURL(r)
+	assets := make([]interface{}, 0, len(r.assets))
+	for _, a := range r.assets {
+		assets = append(assets, g.assetJSON(r, a))
+	}
+	return map[string]interface{}{
+		"url":         releaseURL,
+		"tarball_url": fmt.Sprintf("%s/repos/%s/tarball/%s", g.URL, r.repository, r.tag),
+		"zipball_url": fmt.Sprintf("%s/repos/%s/zipball/%s", g.URL, r.repository, r.tag),
+		"tag_name":    r.tag,
+		"body":        r.body,
+		"assets_url":  releaseURL + "/assets",
+		"upload_url":  releaseURL + "/assets{?name,label}",
+		"author":      map[string]string{"login": r.author},
+		"created_at":  r.createdAt,
+		"assets":      assets,
+	}
+}
+
+func (g *GitHub) assetJSON(r *release, a *asset) map[string]interface{} {
+	uploader := a.Uploader
+	if len(uploader) == 0 {
+		uploader = r.author
+	}
+	return map[string]interface{}{
+		"url":          fmt.Sprintf("%s/repos/%s/releases/assets/%d", g.URL, r.repository, a.id),
+		"name":         a.Name,
+		"size":         len(a.Content),
+		"content_type": a.ContentType,
+		"uploader":     map[string]string{"login": uploader},
+	}
+}
+
+// writePage writes the page of the given items requested with the page and
+// per_page query parameters, with the Link header of the next page, if any.
+func (g *GitHub) writePage(w http.ResponseWriter, req *http.Request, items []interface{}) {
+	perPage := g.PerPage
+	if n, err := strconv.Atoi(req.URL.Query().Get("per_page")); err == nil && n > 0 &&
+		(perPage == 0 || n < perPage) {
+		perPage = n
+	}
+	if perPage == 0 {
+		perPage = 100
+	}
+	page := 1
+	if n, err := strconv.Atoi(req.URL.Query().Get("page")); err == nil && n > 0 {
+		page = n
+	}
+	start, end := (page-1)*perPage, page*perPage
+	if start > len(items) {
+		start = len(items)
+	}
+	if end > len(items) {
+		end = len(items)
+	} else if end < len(items) {
+		next := *req.URL
+		query := next.Query()
+		query.Set("page", strconv.Itoa(page+1))
+		next.RawQuery = query.Encode()
+		nextURL, _ := url.Parse(g.URL)
+		nextURL.Path, nextURL.RawQuery = next.Path, next.RawQuery
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, nextURL))
+	}
+	writeJSON(w, http.StatusOK, items[start:end])
+}
+
+func writeJSON(w http.ResponseWriter, status int, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(payload)
+}
@@ -0,0 +1,395 @@
+// Package bitbucket implements the Bitbucket Cloud API calls for getting the
+// "releases" of the repositories mirroring their releases there, i.e. their
+// tags along with the files uploaded to their downloads.
+package bitbucket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultAPIURL is the base URL of the Bitbucket Cloud API.
+const DefaultAPIURL = "https://api.bitbucket.org/2.0"
+
+// ErrNotFound is returned (wrapped) when a Bitbucket resource, e.g. the tag,
+// cannot be found or the token is not allowed to read it.
+var ErrNotFound = errors.New("Bitbucket resource not found")
+
+// HTTPClient sends the Bitbucket API requests, e.g. an *http.Client (see
+// WithToken) or a fake one in tests.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// User is a Bitbucket account, as embedded in the other resources.
+type User struct {
+	Nickname    string `json:"nickname"`
+	DisplayName string `json:"display_name"`
+	AccountID   string `json:"account_id"`
+}
+
+// Link is a link of a Bitbucket resource.
+type Link struct {
+	Href string `json:"href"`
+}
+
+// Download is a file uploaded to the downloads of a repository.
+type Download struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	CreatedOn time.Time `json:"created_on"`
+	// User is nil if the uploader account has been deleted
+	User  *User `json:"user"`
+	Links struct {
+		Self Link `json:"self"`
+	} `json:"links"`
+}
+
+// UploaderNickname returns the nickname of the uploader of the download, or
+// an empty string if unknown.
+func (d *Download) UploaderNickname() string {
+	if d.User == nil {
+		return ""
+	}
+	return d.User.Nickname
+}
+
+// Release is a tag of a repository along with its downloads, i.e. the files
+// of the downloads of the repository whose names contain the tag, with or
+// without its leading "v", as a whole token (e.g.
+// my-tool-1.2.3-linux-amd64.tar.gz for tag v1.2.3, but not
+// my-tool-1.2.3-linux-amd64.tar.gz for tag v1.2), Bitbucket not attaching
+// the downloads to the tags.
+type Release struct {
+	TagName   string
+	CommitSHA string
+	// AuthorNickname is the nickname of the tagger of the annotated tags, or
+	// else of the author of the tag commit, if known
+	AuthorNickname string
+	// TarballURL and ZipballURL are the URLs of the source code archives
+	// Bitbucket generates for the tag
+	TarballURL string
+	ZipballURL string
+	Downloads  []*Download
+}
+
+type tag struct {
+	Name   string `json:"name"`
+	Tagger *struct {
+		User *User `json:"user"`
+	} `json:"tagger"`
+	Target struct {
+		Hash   string `json:"hash"`
+		Author *struct {
+			User *User `json:"user"`
+		} `json:"author"`
+	} `json:"target"`
+}
+
+// TagURL returns the Bitbucket API URL of the given tag of the given
+// repository (i.e. <workspace>/<repo-slug>); an empty API base URL defaults
+// to https://api.bitbucket.org/2.0.
+func TagURL(apiBaseURL string, repository string, tagName string) string {
+	if len(apiBaseURL) == 0 {
+		apiBaseURL = DefaultAPIURL
+	}
+	tagName = strings.TrimPrefix(tagName, "refs/tags/")
+	return fmt.Sprintf("%s/repositories/%s/refs/tags/%s",
+		strings.TrimSuffix(apiBaseURL, "/"), repository, url.PathEscape(tagName))
+}
+
+// splitTagURL returns the API base URL, the repository (i.e.
+// <workspace>/<repo-slug>) and the tag name of the given tag URL, or empty
+// strings if it is not a tag URL.
+func splitTagURL(tagURL string) (string, string, string) {
+	u, err := url.Parse(tagURL)
+	if err != nil || len(u.Host) == 0 {
+		return "", "", ""
+	}
+	i := strings.Index(u.Path, "/repositories/")
+	if i < 0 {
+		return "", "", ""
+	}
+	pieces := strings.SplitN(u.Path[i+len("/repositories/"):], "/", 5)
+	if len(pieces) != 5 || len(pieces[0]) == 0 || len(pieces[1]) == 0 ||
+		pieces[2] != "refs" || pieces[3] != "tags" || len(pieces[4]) == 0 {
+		return "", "", ""
+	}
+	base := *u
+	base.Path, base.RawPath, base.RawQuery, base.Fragment = u.Path[:i], "", "", ""
+	return strings.TrimSuffix(base.String(), "/"), pieces[0] + "/" + pieces[1], pieces[4]
+}
+
+// RepositoryFromTagURL returns the repository (i.e. <workspace>/<repo-slug>)
+// of the given Bitbucket API URL of a tag, or an empty string if it is not
+// such a URL.
+func RepositoryFromTagURL(tagURL string) string {
+	_, repository, _ := splitTagURL(tagURL)
+	return repository
+}
+
+// webBaseURL returns the base URL of the Bitbucket website of the given API
+// base URL, e.g. https://bitbucket.org for https://api.bitbucket.org/2.0.
+func webBaseURL(apiBaseURL string) string {
+	u, err := url.Parse(apiBaseURL)
+	if err != nil {
+		return apiBaseURL
+	}
+	u.Host = strings.TrimPrefix(u.Host, "api.")
+	u.Path = strings.TrimSuffix(strings.TrimSuffix(u.Path, "/"), "/2.0")
+	return strings.TrimSuffix(u.String(), "/")
+}
+
+// GetRelease gets the tag with the given Bitbucket API URL along with its
+// downloads.
+func GetRelease(ctx context.Context, httpClient HTTPClient, tagURL string, token string) (*Release, error) {
+	apiBaseURL, repository, _ := splitTagURL(tagURL)
+	if len(repository) == 0 {
+		return nil, fmt.Errorf("invalid tag URL %s: must be the Bitbucket API URL of a tag, "+
+			"e.g. %s/repositories/<workspace>/<repo-slug>/refs/tags/<tag>", tagURL, DefaultAPIURL)
+	}
+
+	var t tag
+	if err := get(ctx, httpClient, tagURL, token, &t); err != nil {
+		return nil, fmt.Errorf("error getting the tag details from URL %s: %w", tagURL, err)
+	}
+	if len(t.Name) == 0 || len(t.Target.Hash) == 0 {
+		return nil, errors.New("validation of the tag details failed: the name and the target commit are required")
+	}
+	release := &Release{TagName: t.Name, CommitSHA: t.Target.Hash}
+	if t.Tagger != nil && t.Tagger.User != nil {
+		release.AuthorNickname = t.Tagger.User.Nickname
+	} else if t.Target.Author != nil && t.Target.Author.User != nil {
+		release.AuthorNickname = t.Target.Author.User.Nickname
+	}
+	archivesURL := fmt.Sprintf("%s/%s/get/%s", webBaseURL(apiBaseURL), repository, url.PathEscape(t.Name))
+	release.TarballURL = archivesURL + ".tar.gz"
+	release.ZipballURL = archivesURL + ".zip"
+
+	downloads, err := ListDownloads(ctx, httpClient, apiBaseURL, repository, token)
+	if err != nil {
+		return nil, err
+	}
+	for _, download := range downloads {
+		if containsVersion(download.Name, t.Name) {
+			release.Downloads = append(release.Downloads, download)
+		}
+	}
+	return release, nil
+}
+
+// preReleaseIdentifiers are the (lowercase) identifiers of the pre-release
+// versions, e.g. the rc of my-tool-1.2.3-rc1.tar.gz.
+var preReleaseIdentifiers = []string{"rc", "alpha", "beta", "pre", "preview", "dev", "snapshot", "nightly"}
+
+// containsVersion tells whether the given name contains the given tag, with
+// or without its leading "v", as a whole token, i.e. preceded by the start of
+// the name or a separator (-, _ or .) and followed by the end of the name, a
+// "_", a "." or "-" not followed by a pre-release identifier (nor by a digit
+// for the "."), or a -<digits> package revision, e.g. my-tool-1.2.linux.zip,
+// my-tool_1.2.zip, my-tool_1.2-1_amd64.deb or my-tool-1.2-1.x86_64.rpm for
+// tag v1.2, but neither my-tool-1.2.3.zip, my-tool-11.2.zip nor
+// my-tool-1.2-rc1.zip.
+func containsVersion(name string, tag string) bool {
+	for _, version := range []string{tag, strings.TrimPrefix(tag, "v")} {
+		if len(version) == 0 {
+			continue
+		}
+		for offset := 0; offset < len(name); {
+			i := strings.Index(name[offset:], version)
+			if i < 0 {
+				break
+			}
+			start, end := offset+i, offset+i+len(version)
+			offset = start + 1
+			if start > 0 && !isSeparator(name[start-1]) {
+				continue
+			}
+			if endsVersion(name[end:]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// endsVersion tells whether the given rest of a name, following a version,
+// ends that version (see containsVersion).
+func endsVersion(rest string) bool {
+	if len(rest) == 0 {
+		return true
+	}
+	switch rest[0] {
+	case '_':
+		return true
+	case '.':
+		return len(rest) == 1 || (!isDigit(rest[1]) && !isPreRelease(rest[1:]))
+	case '-':
+		// a -<digits> package revision, e.g. of the .deb and .rpm packages
+		revision := rest[1:]
+		for len(revision) > 0 && isDigit(revision[0]) {
+			revision = revision[1:]
+		}
+		if len(revision) < len(rest)-1 {
+			return len(revision) == 0 || revision[0] == '_' ||
+				(revision[0] == '.' && (len(revision) == 1 || !isDigit(revision[1])))
+		}
+		return !isPreRelease(rest[1:])
+	}
+	return false
+}
+
+// isPreRelease tells whether the given rest of a name starts with a
+// pre-release identifier, e.g. rc1, beta or beta.2.
+func isPreRelease(rest string) bool {
+	token := strings.ToLower(rest)
+	if i := strings.IndexAny(token, "-_."); i >= 0 {
+		token = token[:i]
+	}
+	token = strings.TrimRight(token, "0123456789")
+	for _, identifier := range preReleaseIdentifiers {
+		if token == identifier {
+			return true
+		}
+	}
+	return false
+}
+
+func isSeparator(c byte) bool {
+	return c == '-' || c == '_' || c == '.'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// ListDownloads gets all the pages of the downloads list of the given
+// repository (i.e. <workspace>/<repo-slug>).
+func ListDownloads(
+	ctx context.Context,
+	httpClient HTTPClient,
+	apiBaseURL string,
+	repository string,
+	token string,
+) ([]*Download, error) {
+
+	if len(apiBaseURL) == 0 {
+		apiBaseURL = DefaultAPIURL
+	}
+	var downloads []*Download
+	u := fmt.Sprintf("%s/repositories/%s/downloads?pagelen=100", strings.TrimSuffix(apiBaseURL, "/"), repository)
+	for len(u) > 0 {
+		var page struct {
+			Values []*Download `json:"values"`
+			Next   string      `json:"next"`
+		}
+		if err := get(ctx, httpClient, u, token, &page); err != nil {
+			return nil, fmt.Errorf("error listing the downloads of repository %s: %w", repository, err)
+		}
+		for _, download := range page.Values {
+			if len(download.Name) == 0 || len(download.Links.Self.Href) == 0 {
+				return nil, errors.New(
+					"validation of the download details failed: the name and the self link are required")
+			}
+		}
+		downloads = append(downloads, page.Values...)
+		u = page.Next
+	}
+	return downloads, nil
+}
+
+// get sends a GET request of the given URL and JSON-unmarshals the response
+// body into the given value.
+func get(ctx context.Context, httpClient HTTPClient, u string, token string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return fmt.Errorf("error creating new HTTP GET %s request: %v", u, err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if len(token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading response body: %v", err)
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("expected a 2xx HTTP code, got %d with body %s", resp.StatusCode, respBody)
+	}
+	if err := json.Unmarshal(respBody, v); err != nil {
+		return fmt.Errorf("error JSON-unmarshaling the response body %s: %v", respBody, err)
+	}
+	return nil
+}
+
+// tokenTransport authenticates the requests sent to the Bitbucket API and
+// website with its token, replacing any other credentials (e.g. the GitHub
+// token the release assets are downloaded with).
+type tokenTransport struct {
+	base  http.RoundTripper
+	hosts map[string]bool
+	token string
+}
+
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.hosts[req.URL.Host] {
+		return t.base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	req.Header.Del("Authorization")
+	if len(t.token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+t.token)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// WithToken returns a copy of the given HTTP client authenticating the
+// requests sent to the Bitbucket API of the given base URL (an empty one
+// defaulting to https://api.bitbucket.org/2.0) and to its website (e.g. the
+// downloads of the source code archives) with the given token, e.g. a
+// repository access token, or else without credentials. The redirections to
+// other hosts (e.g. the storage of the downloads) are not authenticated.
+func WithToken(httpClient *http.Client, apiBaseURL string, token string) *http.Client {
+	if len(apiBaseURL) == 0 {
+		apiBaseURL = DefaultAPIURL
+	}
+	hosts := make(map[string]bool)
+	for _, baseURL := range []string{apiBaseURL, webBaseURL(apiBaseURL)} {
+		if u, err := url.Parse(baseURL); err == nil {
+			hosts[u.Host] = true
+		}
+	}
+	base := httpClient.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	client := *httpClient
+	client.Transport = &tokenTransport{base: base, hosts: hosts, token: token}
+	return &client
+}
+
+// APIBaseURL returns the base URL of the Bitbucket API of the given tag URL,
+// e.g. https://api.bitbucket.org/2.0.
+func APIBaseURL(tagURL string) string {
+	if apiBaseURL, _, _ := splitTagURL(tagURL); len(apiBaseURL) > 0 {
+		return apiBaseURL
+	}
+	return DefaultAPIURL
+}
@@ -0,0 +1,35 @@
+package bitbucket
+
+import "testing"
+
+func TestContainsVersion(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		tag  string
+		want bool
+	}{
+		{"tool-1.2.3.tar.gz", "v1.2.3", true},
+		{"tool-v1.2.3-linux-amd64.tar.gz", "v1.2.3", true},
+		{"tool_1.2.3_amd64.deb", "v1.2.3", true},
+		{"tool_1.2.3-1_amd64.deb", "v1.2.3", true},
+		{"tool-1.2.3-1.x86_64.rpm", "v1.2.3", true},
+		{"tool-1.2.3-12", "v1.2.3", true},
+		{"tool-1.2.3", "v1.2.3", true},
+		{"tool-1.2.3-rc1.tar.gz", "v1.2.3", false},
+		{"tool-1.2.3-beta.2.tar.gz", "v1.2.3", false},
+		{"tool-1.2.3.RC1.zip", "v1.2.3", false},
+		{"tool-1.2.3-rc1.tar.gz", "v1.2.3-rc1", true},
+		{"tool-1.2.3.1.tar.gz", "v1.2.3", false},
+		{"tool-1.2.3.1.tar.gz", "v1.2.3.1", true},
+		{"tool-1.2.3-1.2.tar.gz", "v1.2.3", false},
+		{"tool-11.2.3.tar.gz", "v1.2.3", false},
+		{"tool-1.2.30.tar.gz", "v1.2.3", false},
+		{"tool1.2.3.tar.gz", "v1.2.3", false},
+		{"tool-1.2-linux.zip", "v1.2", true},
+		{"tool-1.2.3-linux.zip", "v1.2", false},
+	} {
+		if got := containsVersion(tc.name, tc.tag); got != tc.want {
+			t.Errorf("got containsVersion(%q, %q) = %t, want %t", tc.name, tc.tag, got, tc.want)
+		}
+	}
+}
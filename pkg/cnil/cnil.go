@@ -0,0 +1,605 @@
+// Package cnil implements the CodeNotary Immutable Ledger (CNIL) REST API
+// calls for managing the API keys of the signer IDs.
+package cnil
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// KeyPolicy is the lifecycle policy of the API keys managed via the CNIL API.
+type KeyPolicy string
+
+const (
+	// KeyPolicyRotate rotates the existing API keys (see also
+	// Config.RotateIfOlderThan) and creates the missing ones.
+	KeyPolicyRotate KeyPolicy = "rotate"
+	// KeyPolicyReuse reuses the existing API keys, never rotating them, and
+	// creates the missing ones.
+	KeyPolicyReuse KeyPolicy = "reuse"
+	// KeyPolicyEphemeral creates new API keys which are revoked at the end of
+	// the run, leaving the existing ones untouched.
+	KeyPolicyEphemeral KeyPolicy = "ephemeral"
+)
+
+// ParseKeyPolicy parses an API key lifecycle policy, defaulting to
+// KeyPolicyRotate.
+func ParseKeyPolicy(policy string) (KeyPolicy, error) {
+	switch keyPolicy := KeyPolicy(strings.ToLower(strings.TrimSpace(policy))); keyPolicy {
+	case "":
+		return KeyPolicyRotate, nil
+	case KeyPolicyRotate, KeyPolicyReuse, KeyPolicyEphemeral:
+		return keyPolicy, nil
+	default:
+		return "", fmt.Errorf("invalid API key policy %s: must be one of %s, %s or %s",
+			policy, KeyPolicyReuse, KeyPolicyRotate, KeyPolicyEphemeral)
+	}
+}
+
+// ErrAPIKeyNotFound is returned when the signer ID has no API key.
+var ErrAPIKeyNotFound = errors.New("API key not found")
+
+// ErrLedgerNotFound is returned when the ledger does not exist.
+var ErrLedgerNotFound = errors.New("ledger not found")
+
+// errNotFound is returned (wrapped) by the requests answered with a 404.
+var errNotFound = errors.New("not found")
+
+// errForbidden is returned (wrapped) by the requests answered with a 401 or
+// a 403.
+var errForbidden = errors.New("forbidden")
+
+// Logger reports the progress.
+type Logger interface {
+	Infof(format string, a ...interface{})
+	Successf(format string, a ...interface{})
+	Warningf(format string, a ...interface{})
+	Errorf(format string, a ...interface{})
+}
+
+// HTTPClient sends the CNIL REST API requests, e.g. an *http.Client (possibly
+// with failover, see WithFailover) or a fake one in tests.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Options are the settings of the CNIL REST API calls.
+type Options struct {
+	// BaseURL is the base URL of the CNIL REST API, e.g.
+	// https://<CNIL host>:443/api/v1.
+	BaseURL string
+	// Token is the CNIL personal token.
+	Token string
+	// LedgerID is the ID of the ledger the API keys belong to.
+	LedgerID string
+	// KeyPolicy is the lifecycle policy of the API keys (defaults to rotate).
+	KeyPolicy KeyPolicy
+	// RotateIfOlderThan is the minimum age an existing API key must have in
+	// order to be rotated; zero means existing API keys are always rotated.
+	RotateIfOlderThan time.Duration
+	// Cache, if any, keeps the values of the API keys issued at the previous
+	// runs, for reusing them. It is not used with KeyPolicyEphemeral.
+	Cache APIKeyCache
+}
+
+// GetAndRotateOrCreateAPIKeys gets (and, depending on the key policy, rotates)
+// or creates the API keys of the given signer IDs, returning them in the same
+// order. On error, it returns the API keys got so far too.
+func GetAndRotateOrCreateAPIKeys(
+	ctx context.Context,
+	httpClient HTTPClient,
+	options *Options,
+	signerIDs []string,
+	log Logger,
+) (apiKeys []*APIKeyResponse, err error) {
+
+	apiKeys = make([]*APIKeyResponse, 0, len(signerIDs))
+	apiKeysPerSignerID := make(map[string]*APIKeyResponse)
+
+	for _, signerID := range signerIDs {
+		if apiKey, ok := apiKeysPerSignerID[signerID]; ok {
+			apiKeys = append(apiKeys, apiKey)
+			continue
+		}
+
+		var apiKeyResp *APIKeyResponse
+		if options.KeyPolicy == KeyPolicyEphemeral {
+			// leave the existing API key (if any) untouched
+			apiKeyResp, err = CreateAPIKey(ctx, httpClient, options, signerID)
+		} else {
+			apiKeyResp, err = GetAPIKey(ctx, httpClient, options, signerID)
+			if err == nil && len(apiKeyResp.Key) == 0 && options.Cache != nil {
+				apiKeyResp = withCachedValue(apiKeyResp, options, signerID, log)
+			}
+			if errors.Is(err, ErrAPIKeyNotFound) {
+				apiKeyResp, err = CreateAPIKey(ctx, httpClient, options, signerID)
+			} else if err == nil && options.KeyPolicy == KeyPolicyReuse {
+				if len(apiKeyResp.Key) == 0 {
+					err = errors.New("the value of the existing API key is not available, " +
+						"hence it cannot be reused (pre-provision it instead)")
+				} else {
+					log.Infof("Reusing API key of signer ID %s\n", signerID)
+				}
+			} else if err == nil && !shouldRotateAPIKey(apiKeyResp, options.RotateIfOlderThan) {
+				log.Infof(
+					"Reusing API key of signer ID %s created at %s (not older than %s)\n",
+					signerID, apiKeyResp.CreatedAt.Format(time.UnixDate), options.RotateIfOlderThan)
+			} else if err == nil {
+				apiKeyResp, err = RotateAPIKey(ctx, httpClient, options, apiKeyResp.ID)
+			}
+		}
+
+		if err == nil && apiKeyResp.ReadOnly {
+			err = fmt.Errorf("API key %s is read-only, hence it cannot sign: "+
+				"delete it (or pre-provision a writable API key) so that a writable one is created",
+				apiKeyResp.ID)
+		}
+		if err != nil {
+			err = fmt.Errorf(
+				"error getting or creating / rotating API key for signer ID %s: %v",
+				signerID, err)
+			return
+		}
+
+		if options.Cache != nil && options.KeyPolicy != KeyPolicyEphemeral &&
+			len(apiKeyResp.Key) > 0 {
+			if err := options.Cache.Put(options.LedgerID, signerID, apiKeyResp); err != nil {
+				log.Warningf("WARNING: error caching API key of signer ID %s: %v\n", signerID, err)
+			}
+		}
+
+		apiKeysPerSignerID[signerID] = apiKeyResp
+		apiKeys = append(apiKeys, apiKeyResp)
+	}
+
+	return
+}
+
+// withCachedValue returns the given existing API key of the given signer ID
+// along with its cached value, if the cached API key is the same one (i.e.
+// it has not been rotated or recreated since then).
+func withCachedValue(
+	apiKey *APIKeyResponse,
+	options *Options,
+	signerID string,
+	log Logger,
+) *APIKeyResponse {
+
+	cached, err := options.Cache.Get(options.LedgerID, signerID)
+	if err != nil {
+		log.Warningf("WARNING: error getting the cached API key of signer ID %s: %v\n",
+			signerID, err)
+		return apiKey
+	}
+	if cached == nil || len(cached.Key) == 0 || cached.ID != apiKey.ID ||
+		(!apiKey.CreatedAt.IsZero() && !cached.CreatedAt.IsZero() &&
+			!apiKey.CreatedAt.Equal(cached.CreatedAt)) {
+		return apiKey
+	}
+	log.Infof("Using the cached value of API key %s of signer ID %s\n", apiKey.ID, signerID)
+	createdAt := apiKey.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = cached.CreatedAt
+	}
+	return &APIKeyResponse{ID: apiKey.ID, Key: cached.Key, CreatedAt: createdAt}
+}
+
+// APIKeysFromMapping returns the mapped API keys of the given signer IDs, in
+// the same order.
+func APIKeysFromMapping(
+	apiKeysPerSignerID map[string]string,
+	signerIDs []string,
+) ([]string, error) {
+
+	apiKeys := make([]string, 0, len(signerIDs))
+	for _, signerID := range signerIDs {
+		apiKey, ok := apiKeysPerSignerID[signerID]
+		if !ok {
+			return nil, fmt.Errorf("no API key has been provided for signer ID %s", signerID)
+		}
+		apiKeys = append(apiKeys, apiKey)
+	}
+
+	return apiKeys, nil
+}
+
+type APIKeyResponse struct {
+	ID        string    `json:"id"`
+	Key       string    `json:"key"`
+	CreatedAt time.Time `json:"created_at"`
+	ReadOnly  bool      `json:"read_only,omitempty"`
+}
+
+// shouldRotateAPIKey reports whether the given existing API key must be rotated
+// according to the specified maximum age: keys which are not older than that
+// are reused as long as their value is known.
+func shouldRotateAPIKey(apiKey *APIKeyResponse, maxAge time.Duration) bool {
+	if maxAge <= 0 || len(apiKey.Key) == 0 || apiKey.CreatedAt.IsZero() {
+		return true
+	}
+	return time.Since(apiKey.CreatedAt) > maxAge
+}
+
+type APIKeysPageResponse struct {
+	Total uint64            `json:"total"`
+	Items []*APIKeyResponse `json:"items"`
+}
+
+// GetAPIKey gets the (first) API key of the given signer ID.
+func GetAPIKey(
+	ctx context.Context,
+	httpClient HTTPClient,
+	options *Options,
+	signerID string,
+) (*APIKeyResponse, error) {
+	url := fmt.Sprintf(
+		"%s/api_keys/identity/%s", options.BaseURL, url.PathEscape(signerID))
+	responsePayload := APIKeysPageResponse{}
+	if err := sendHTTPRequest(
+		ctx,
+		httpClient,
+		http.MethodGet,
+		url,
+		options.Token,
+		http.StatusOK,
+		nil,
+		&responsePayload,
+	); err != nil {
+		return nil, err
+	}
+
+	if len(responsePayload.Items) == 0 {
+		return nil, ErrAPIKeyNotFound
+	}
+
+	return responsePayload.Items[0], nil
+}
+
+type APIKeyCreateReq struct {
+	Name     string `json:"name"`
+	ReadOnly bool   `json:"read_only"`
+}
+
+// CreateAPIKey creates a new API key for the given signer ID.
+func CreateAPIKey(
+	ctx context.Context,
+	httpClient HTTPClient,
+	options *Options,
+	signerID string,
+) (*APIKeyResponse, error) {
+
+	url := fmt.Sprintf("%s/ledgers/%s/api_keys", options.BaseURL, options.LedgerID)
+
+	payload := APIKeyCreateReq{Name: signerID}
+	payloadJSON, err := json.Marshal(&payload)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"error JSON-marshaling POST %s request with payload %+v: %v",
+			url, payload, err)
+	}
+
+	responsePayload := APIKeyResponse{}
+	if err := sendHTTPRequest(
+		ctx,
+		httpClient,
+		http.MethodPost,
+		url,
+		options.Token,
+		http.StatusCreated,
+		bytes.NewBuffer(payloadJSON),
+		&responsePayload,
+	); err != nil {
+		return nil, err
+	}
+
+	return &responsePayload, nil
+}
+
+// RotateAPIKey rotates the API key with the given ID.
+func RotateAPIKey(
+	ctx context.Context,
+	httpClient HTTPClient,
+	options *Options,
+	apiKeyID string,
+) (*APIKeyResponse, error) {
+
+	url := fmt.Sprintf("%s/ledgers/%s/api_keys/%s/rotate", options.BaseURL, options.LedgerID, apiKeyID)
+	responsePayload := APIKeyResponse{}
+	if err := sendHTTPRequest(
+		ctx,
+		httpClient,
+		http.MethodPut,
+		url,
+		options.Token,
+		http.StatusOK,
+		nil,
+		&responsePayload,
+	); err != nil {
+		return nil, err
+	}
+
+	return &responsePayload, nil
+}
+
+// RevokeAPIKeys revokes (i.e. deletes) the given API keys, e.g. the
+// ephemeral ones at the end of the run, reporting all the failures.
+func RevokeAPIKeys(
+	ctx context.Context,
+	httpClient HTTPClient,
+	options *Options,
+	apiKeys []*APIKeyResponse,
+	log Logger,
+) error {
+
+	var msgs []string
+	for _, apiKey := range apiKeys {
+		url := fmt.Sprintf("%s/ledgers/%s/api_keys/%s", options.BaseURL, options.LedgerID, apiKey.ID)
+		if err := sendHTTPRequest(
+			ctx,
+			httpClient,
+			http.MethodDelete,
+			url,
+			options.Token,
+			http.StatusOK,
+			nil,
+			nil,
+		); err != nil {
+			msgs = append(msgs, fmt.Sprintf("error revoking API key %s: %v", apiKey.ID, err))
+			continue
+		}
+		log.Infof("Revoked ephemeral API key %s\n", apiKey.ID)
+	}
+	if len(msgs) > 0 {
+		return errors.New(strings.Join(msgs, "; "))
+	}
+
+	return nil
+}
+
+type LedgerResponse struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type LedgersPageResponse struct {
+	Total uint64            `json:"total"`
+	Items []*LedgerResponse `json:"items"`
+}
+
+// GetLedger gets the ledger with the given ID.
+func GetLedger(
+	ctx context.Context,
+	httpClient HTTPClient,
+	options *Options,
+	ledgerID string,
+) (*LedgerResponse, error) {
+
+	url := fmt.Sprintf("%s/ledgers/%s", options.BaseURL, url.PathEscape(ledgerID))
+	responsePayload := LedgerResponse{}
+	if err := sendHTTPRequest(
+		ctx,
+		httpClient,
+		http.MethodGet,
+		url,
+		options.Token,
+		http.StatusOK,
+		nil,
+		&responsePayload,
+	); err != nil {
+		if errors.Is(err, errNotFound) {
+			return nil, ErrLedgerNotFound
+		}
+		return nil, err
+	}
+
+	return &responsePayload, nil
+}
+
+// GetLedgerByName gets the (first) ledger with the given name.
+func GetLedgerByName(
+	ctx context.Context,
+	httpClient HTTPClient,
+	options *Options,
+	name string,
+) (*LedgerResponse, error) {
+
+	url := fmt.Sprintf("%s/ledgers?name=%s", options.BaseURL, url.QueryEscape(name))
+	responsePayload := LedgersPageResponse{}
+	if err := sendHTTPRequest(
+		ctx,
+		httpClient,
+		http.MethodGet,
+		url,
+		options.Token,
+		http.StatusOK,
+		nil,
+		&responsePayload,
+	); err != nil {
+		return nil, err
+	}
+
+	for _, ledger := range responsePayload.Items {
+		if ledger.Name == name {
+			return ledger, nil
+		}
+	}
+	return nil, ErrLedgerNotFound
+}
+
+type LedgerCreateReq struct {
+	Name string `json:"name"`
+}
+
+// CreateLedger creates a new ledger with the given name.
+func CreateLedger(
+	ctx context.Context,
+	httpClient HTTPClient,
+	options *Options,
+	name string,
+) (*LedgerResponse, error) {
+
+	url := fmt.Sprintf("%s/ledgers", options.BaseURL)
+
+	payload := LedgerCreateReq{Name: name}
+	payloadJSON, err := json.Marshal(&payload)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"error JSON-marshaling POST %s request with payload %+v: %v",
+			url, payload, err)
+	}
+
+	responsePayload := LedgerResponse{}
+	if err := sendHTTPRequest(
+		ctx,
+		httpClient,
+		http.MethodPost,
+		url,
+		options.Token,
+		http.StatusCreated,
+		bytes.NewBuffer(payloadJSON),
+		&responsePayload,
+	); err != nil {
+		return nil, err
+	}
+
+	return &responsePayload, nil
+}
+
+// GetOrCreateLedger returns the ID of the given ledger if it exists, or else
+// the one of the ledger with the given name, creating it if missing.
+func GetOrCreateLedger(
+	ctx context.Context,
+	httpClient HTTPClient,
+	options *Options,
+	ledgerID string,
+	name string,
+	log Logger,
+) (string, error) {
+
+	if len(ledgerID) > 0 {
+		_, err := GetLedger(ctx, httpClient, options, ledgerID)
+		if err == nil {
+			return ledgerID, nil
+		}
+		if !errors.Is(err, ErrLedgerNotFound) {
+			return "", fmt.Errorf("error getting ledger %s: %v", ledgerID, err)
+		}
+		log.Warningf("WARNING: ledger %s does not exist\n", ledgerID)
+	}
+
+	ledger, err := GetLedgerByName(ctx, httpClient, options, name)
+	if err == nil {
+		log.Infof("Using ledger %s (%s)\n", ledger.ID, ledger.Name)
+		return ledger.ID, nil
+	}
+	if !errors.Is(err, ErrLedgerNotFound) {
+		return "", fmt.Errorf("error getting ledger %s: %v", name, err)
+	}
+	ledger, err = CreateLedger(ctx, httpClient, options, name)
+	if err != nil {
+		return "", fmt.Errorf("error creating ledger %s: %v", name, err)
+	}
+	log.Successf("Created ledger %s (%s)\n", ledger.ID, ledger.Name)
+	return ledger.ID, nil
+}
+
+// CheckPermissions checks, before doing any work, that the personal token is
+// allowed to read the ledger and to manage its API keys, failing with an
+// actionable message otherwise.
+func CheckPermissions(ctx context.Context, httpClient HTTPClient, options *Options) error {
+	if len(options.LedgerID) == 0 {
+		return errors.New("the ledger ID is required for creating or rotating API keys")
+	}
+	if _, err := GetLedger(ctx, httpClient, options, options.LedgerID); err != nil {
+		if errors.Is(err, ErrLedgerNotFound) {
+			return fmt.Errorf("ledger %s does not exist: check the ledger ID", options.LedgerID)
+		}
+		if errors.Is(err, errForbidden) {
+			return fmt.Errorf("the personal token is invalid, expired or not allowed to access "+
+				"ledger %s: %v", options.LedgerID, err)
+		}
+		return fmt.Errorf("error getting ledger %s: %v", options.LedgerID, err)
+	}
+
+	url := fmt.Sprintf("%s/ledgers/%s/api_keys", options.BaseURL, options.LedgerID)
+	if err := sendHTTPRequest(
+		ctx,
+		httpClient,
+		http.MethodGet,
+		url,
+		options.Token,
+		http.StatusOK,
+		nil,
+		&APIKeysPageResponse{},
+	); err != nil {
+		if errors.Is(err, errForbidden) {
+			return fmt.Errorf("the personal token is not allowed to manage the API keys of "+
+				"ledger %s (it must be allowed to list, create and rotate them): %v",
+				options.LedgerID, err)
+		}
+		return fmt.Errorf("error listing the API keys of ledger %s: %v", options.LedgerID, err)
+	}
+
+	return nil
+}
+
+func sendHTTPRequest(
+	ctx context.Context,
+	httpClient HTTPClient,
+	method string,
+	url string,
+	token string,
+	expectedStatus int,
+	payload io.Reader,
+	responsePayload interface{},
+) error {
+	req, err := http.NewRequestWithContext(ctx, method, url, payload)
+	if err != nil {
+		return fmt.Errorf("error creating HTTP request %s %s: %v", method, url, err)
+	}
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Accept", "application/json")
+	req.Header.Add("Authorization", "Bearer "+token)
+
+	response, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request %s %s: %v", method, url, err)
+	}
+	defer response.Body.Close()
+
+	responseBody, err := io.ReadAll(response.Body)
+	if err != nil {
+		return fmt.Errorf("%s %s: error reading response body: %v", method, url, err)
+	}
+
+	if response.StatusCode != expectedStatus {
+		err := fmt.Errorf("%s %s error: expected response status %d, got %s with body %s",
+			method, url, expectedStatus, response.Status, responseBody)
+		switch response.StatusCode {
+		case http.StatusNotFound:
+			return fmt.Errorf("%w: %v", errNotFound, err)
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return fmt.Errorf("%w: %v", errForbidden, err)
+		}
+		return err
+	}
+
+	if responsePayload == nil {
+		return nil
+	}
+	if err := json.Unmarshal(responseBody, responsePayload); err != nil {
+		return fmt.Errorf("error JSON-unmarshaling %s %s response body %s: %v",
+			method, url, responseBody, err)
+	}
+
+	return nil
+}
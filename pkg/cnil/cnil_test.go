@@ -0,0 +1,189 @@
+package cnil_test
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codenotary/notarize-release-assets-action/internal/fakeserver"
+	"github.com/codenotary/notarize-release-assets-action/pkg/cnil"
+)
+
+const (
+	personalToken = "personal-token"
+	ledgerID      = "ledger-a"
+)
+
+func newFakeCNIL(t *testing.T) (*fakeserver.CNIL, *cnil.Options) {
+	t.Helper()
+	fake := fakeserver.NewCNIL(personalToken)
+	t.Cleanup(fake.Close)
+	fake.AddLedger(ledgerID, "my-org/my-repo")
+	return fake, &cnil.Options{BaseURL: fake.BaseURL, Token: personalToken, LedgerID: ledgerID}
+}
+
+// countRequests returns the number of the requests of the fake API with the
+// given method.
+func countRequests(fake *fakeserver.CNIL, method string) int {
+	n := 0
+	for _, r := range fake.Requests() {
+		if strings.HasPrefix(r, method+" ") {
+			n++
+		}
+	}
+	return n
+}
+
+func TestAPIKeysAreRotatedOrCreated(t *testing.T) {
+	fake, options := newFakeCNIL(t)
+	existing := fake.AddAPIKey(ledgerID, "alice@github", time.Now().Add(-48*time.Hour))
+
+	apiKeys, err := cnil.GetAndRotateOrCreateAPIKeys(context.Background(), fake.Client(), options,
+		[]string{"alice@github", "bob@github", "alice@github"}, nopLogger{})
+	if err != nil {
+		t.Fatalf("GetAndRotateOrCreateAPIKeys: %v", err)
+	}
+	if len(apiKeys) != 3 {
+		t.Fatalf("got %d API keys, want 3", len(apiKeys))
+	}
+	if apiKeys[0].ID != existing.ID || apiKeys[0].Key == existing.Key || len(apiKeys[0].Key) == 0 {
+		t.Errorf("got API key %s with value %s, want API key %s rotated", apiKeys[0].ID, apiKeys[0].Key, existing.ID)
+	}
+	if apiKeys[2] != apiKeys[0] {
+		t.Errorf("the API key of the same signer ID is not reused")
+	}
+	bobKeys := fake.APIKeys("bob@github")
+	if len(bobKeys) != 1 || apiKeys[1].ID != bobKeys[0].ID || apiKeys[1].Key != bobKeys[0].Key {
+		t.Errorf("got API key %+v, want the created one %+v", apiKeys[1], bobKeys)
+	}
+	if n := countRequests(fake, http.MethodPut); n != 1 {
+		t.Errorf("got %d rotations, want 1", n)
+	}
+}
+
+func TestRecentAPIKeysAreReusedFromTheCache(t *testing.T) {
+	fake, options := newFakeCNIL(t)
+	cache, err := cnil.OpenFileAPIKeyCache(filepath.Join(t.TempDir(), "api-keys.json"), "passphrase")
+	if err != nil {
+		t.Fatalf("OpenFileAPIKeyCache: %v", err)
+	}
+	options.Cache = cache
+	options.RotateIfOlderThan = 24 * time.Hour
+
+	first, err := cnil.GetAndRotateOrCreateAPIKeys(context.Background(), fake.Client(), options,
+		[]string{"alice@github"}, nopLogger{})
+	if err != nil {
+		t.Fatalf("GetAndRotateOrCreateAPIKeys: %v", err)
+	}
+	second, err := cnil.GetAndRotateOrCreateAPIKeys(context.Background(), fake.Client(), options,
+		[]string{"alice@github"}, nopLogger{})
+	if err != nil {
+		t.Fatalf("GetAndRotateOrCreateAPIKeys: %v", err)
+	}
+	if second[0].ID != first[0].ID || second[0].Key != first[0].Key {
+		t.Errorf("got API key %+v, want the cached one %+v", second[0], first[0])
+	}
+	if n := countRequests(fake, http.MethodPut); n != 0 {
+		t.Errorf("got %d rotations, want none", n)
+	}
+}
+
+func TestReusePolicyRequiresTheKeyValue(t *testing.T) {
+	fake, options := newFakeCNIL(t)
+	fake.AddAPIKey(ledgerID, "alice@github", time.Now())
+	options.KeyPolicy = cnil.KeyPolicyReuse
+
+	_, err := cnil.GetAndRotateOrCreateAPIKeys(context.Background(), fake.Client(), options,
+		[]string{"alice@github"}, nopLogger{})
+	if err == nil || !strings.Contains(err.Error(), "cannot be reused") {
+		t.Errorf("got error %v, want the value of the API key to be unavailable", err)
+	}
+}
+
+func TestEphemeralAPIKeysAreRevoked(t *testing.T) {
+	fake, options := newFakeCNIL(t)
+	existing := fake.AddAPIKey(ledgerID, "alice@github", time.Now())
+	options.KeyPolicy = cnil.KeyPolicyEphemeral
+
+	apiKeys, err := cnil.GetAndRotateOrCreateAPIKeys(context.Background(), fake.Client(), options,
+		[]string{"alice@github"}, nopLogger{})
+	if err != nil {
+		t.Fatalf("GetAndRotateOrCreateAPIKeys: %v", err)
+	}
+	if apiKeys[0].ID == existing.ID {
+		t.Fatalf("the existing API key is used instead of an ephemeral one")
+	}
+	if err := cnil.RevokeAPIKeys(context.Background(), fake.Client(), options, apiKeys, nopLogger{}); err != nil {
+		t.Fatalf("RevokeAPIKeys: %v", err)
+	}
+	remaining := fake.APIKeys("alice@github")
+	if len(remaining) != 1 || remaining[0].ID != existing.ID || remaining[0].Key != existing.Key {
+		t.Errorf("got API keys %+v, want only the untouched existing one", remaining)
+	}
+}
+
+func TestCheckPermissions(t *testing.T) {
+	fake, options := newFakeCNIL(t)
+	if err := cnil.CheckPermissions(context.Background(), fake.Client(), options); err != nil {
+		t.Errorf("CheckPermissions: %v", err)
+	}
+
+	invalid := *options
+	invalid.Token = "expired"
+	err := cnil.CheckPermissions(context.Background(), fake.Client(), &invalid)
+	if err == nil || !strings.Contains(err.Error(), "personal token is invalid") {
+		t.Errorf("got error %v, want the personal token to be rejected", err)
+	}
+
+	missing := *options
+	missing.LedgerID = "ledger-z"
+	err = cnil.CheckPermissions(context.Background(), fake.Client(), &missing)
+	if err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("got error %v, want the ledger to be missing", err)
+	}
+}
+
+func TestGetOrCreateLedger(t *testing.T) {
+	fake, options := newFakeCNIL(t)
+
+	id, err := cnil.GetOrCreateLedger(context.Background(), fake.Client(), options, "", "my-org/my-repo", nopLogger{})
+	if err != nil || id != ledgerID {
+		t.Errorf("got ledger %s (error %v), want the existing %s", id, err, ledgerID)
+	}
+	id, err = cnil.GetOrCreateLedger(context.Background(), fake.Client(), options, "", "my-org/other-repo", nopLogger{})
+	if err != nil || len(id) == 0 || id == ledgerID {
+		t.Errorf("got ledger %s (error %v), want a new one", id, err)
+	}
+	if n := countRequests(fake, http.MethodPost); n != 1 {
+		t.Errorf("got %d creations, want 1", n)
+	}
+}
+
+func TestFailoverToTheFallbackInstance(t *testing.T) {
+	primary, options := newFakeCNIL(t)
+	fallback, _ := newFakeCNIL(t)
+	primary.Fail("/ledgers/"+ledgerID, http.StatusServiceUnavailable, 1)
+
+	httpClient := cnil.WithFailover(&http.Client{}, primary.BaseURL, []string{fallback.BaseURL}, nopLogger{})
+	for i := 0; i < 2; i++ {
+		if _, err := cnil.GetLedger(context.Background(), httpClient, options, ledgerID); err != nil {
+			t.Fatalf("GetLedger: %v", err)
+		}
+	}
+	if got, want := len(primary.Requests()), 1; got != want {
+		t.Errorf("got %d requests to the primary instance, want %d", got, want)
+	}
+	if got, want := len(fallback.Requests()), 2; got != want {
+		t.Errorf("got %d requests to the fallback instance, want %d", got, want)
+	}
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Infof(string, ...interface{})    {}
+func (nopLogger) Successf(string, ...interface{}) {}
+func (nopLogger) Warningf(string, ...interface{}) {}
+func (nopLogger) Errorf(string, ...interface{})   {}
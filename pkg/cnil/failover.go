@@ -0,0 +1,120 @@
+package cnil
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// WithFailover returns a copy of the given HTTP client whose requests to the
+// given CNIL REST API base URL (or to any of its fallbacks) are sent to the
+// first of them available: on a connection error or a 5xx response, the
+// request is sent again to the next one, which is used from then on.
+func WithFailover(
+	httpClient *http.Client,
+	baseURL string,
+	fallbackURLs []string,
+	log Logger,
+) *http.Client {
+
+	if len(fallbackURLs) == 0 {
+		return httpClient
+	}
+	transport := httpClient.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	baseURLs := make([]string, 0, len(fallbackURLs)+1)
+	for _, u := range append([]string{baseURL}, fallbackURLs...) {
+		baseURLs = append(baseURLs, strings.TrimSuffix(u, "/"))
+	}
+	failoverClient := *httpClient
+	failoverClient.Transport = &failoverTransport{transport: transport, baseURLs: baseURLs, log: log}
+	return &failoverClient
+}
+
+// failoverTransport sends the requests to the current base URL among the
+// primary one and its fallbacks.
+type failoverTransport struct {
+	transport http.RoundTripper
+	baseURLs  []string
+	log       Logger
+
+	mu      sync.Mutex
+	current int
+}
+
+func (t *failoverTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// the requests to other hosts are sent as is
+	var relativeURL string
+	found := false
+	for _, baseURL := range t.baseURLs {
+		if u := req.URL.String(); strings.HasPrefix(u, baseURL) {
+			relativeURL, found = u[len(baseURL):], true
+			break
+		}
+	}
+	if !found {
+		return t.transport.RoundTrip(req)
+	}
+
+	for attempt := 0; ; attempt++ {
+		t.mu.Lock()
+		current := t.current
+		t.mu.Unlock()
+
+		attemptReq := req.Clone(req.Context())
+		u, err := url.Parse(t.baseURLs[current] + relativeURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CNIL REST API URL %s: %v", t.baseURLs[current]+relativeURL, err)
+		}
+		attemptReq.URL = u
+		attemptReq.Host = ""
+		if attempt > 0 && req.Body != nil && req.Body != http.NoBody {
+			if attemptReq.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err := t.transport.RoundTrip(attemptReq)
+		var reason string
+		switch {
+		case err != nil:
+			reason = err.Error()
+		case resp.StatusCode >= http.StatusInternalServerError:
+			reason = resp.Status
+		default:
+			return resp, nil
+		}
+		// the requests whose body cannot be sent again are not failed over
+		replayable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+		if req.Context().Err() != nil || !replayable || !t.failover(current, reason) {
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+	}
+}
+
+// failover switches to the next base URL after the given failure of the
+// given one (unless already done by another request), returning false if
+// there is none left.
+func (t *failoverTransport) failover(from int, reason string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if from != t.current {
+		return true
+	}
+	if t.current+1 >= len(t.baseURLs) {
+		return false
+	}
+	t.current++
+	if t.log != nil {
+		t.log.Warningf("WARNING: CNIL REST API %s is unavailable (%s), failing over to %s\n",
+			t.baseURLs[from], reason, t.baseURLs[t.current])
+	}
+	return true
+}
@@ -0,0 +1,165 @@
+package cnil
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// APIKeyCache keeps the API keys issued at the previous runs, keyed by ledger
+// ID and signer ID, so that their values are known for reusing them (the CNIL
+// API does not return the values of the existing API keys). It can be backed
+// by an external secrets store.
+type APIKeyCache interface {
+	// Get returns the cached API key of the given signer ID into the given
+	// ledger, or nil if none.
+	Get(ledgerID, signerID string) (*APIKeyResponse, error)
+	// Put caches the given API key of the given signer ID into the given
+	// ledger.
+	Put(ledgerID, signerID string, apiKey *APIKeyResponse) error
+}
+
+// fileAPIKeyCacheVersion is the version of the format of the API key cache
+// files.
+const fileAPIKeyCacheVersion = 1
+
+// fileAPIKeyCacheContent is the content of an API key cache file, whose API
+// keys are encrypted with AES-256-GCM by a key derived from the passphrase
+// with scrypt.
+type fileAPIKeyCacheContent struct {
+	Version    int    `json:"version"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// FileAPIKeyCache is an APIKeyCache kept in a file encrypted with a
+// passphrase, e.g. restored from and saved into a workflow cache. The file is
+// written at each Put.
+type FileAPIKeyCache struct {
+	filePath   string
+	passphrase string
+
+	mu      sync.Mutex
+	apiKeys map[string]*APIKeyResponse
+}
+
+// OpenFileAPIKeyCache opens the API key cache of the given file, encrypted
+// with the given passphrase, which is empty if the file does not exist yet.
+func OpenFileAPIKeyCache(filePath, passphrase string) (*FileAPIKeyCache, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("the passphrase of the API key cache is required")
+	}
+	cache := &FileAPIKeyCache{
+		filePath:   filePath,
+		passphrase: passphrase,
+		apiKeys:    make(map[string]*APIKeyResponse),
+	}
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cache, nil
+		}
+		return nil, fmt.Errorf("error reading API key cache file %s: %v", filePath, err)
+	}
+	var encrypted fileAPIKeyCacheContent
+	if err := json.Unmarshal(content, &encrypted); err != nil {
+		return nil, fmt.Errorf("error JSON-unmarshaling API key cache file %s: %v", filePath, err)
+	}
+	if encrypted.Version != fileAPIKeyCacheVersion {
+		return nil, fmt.Errorf("unsupported version %d of API key cache file %s",
+			encrypted.Version, filePath)
+	}
+	aead, err := newAPIKeyCacheAEAD(passphrase, encrypted.Salt)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, encrypted.Nonce, encrypted.Ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting API key cache file %s (wrong passphrase?): %v",
+			filePath, err)
+	}
+	if err := json.Unmarshal(plaintext, &cache.apiKeys); err != nil {
+		return nil, fmt.Errorf("error JSON-unmarshaling the API keys of cache file %s: %v",
+			filePath, err)
+	}
+	return cache, nil
+}
+
+// newAPIKeyCacheAEAD returns the AES-256-GCM cipher of the key derived from
+// the given passphrase and salt.
+func newAPIKeyCacheAEAD(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, fmt.Errorf("error deriving the API key cache key: %v", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("error creating the API key cache cipher: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+func apiKeyCacheKey(ledgerID, signerID string) string {
+	return ledgerID + "/" + signerID
+}
+
+func (c *FileAPIKeyCache) Get(ledgerID, signerID string) (*APIKeyResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.apiKeys[apiKeyCacheKey(ledgerID, signerID)], nil
+}
+
+func (c *FileAPIKeyCache) Put(ledgerID, signerID string, apiKey *APIKeyResponse) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.apiKeys[apiKeyCacheKey(ledgerID, signerID)] = apiKey
+	return c.write()
+}
+
+// write encrypts the API keys with a new salt and nonce and writes them into
+// the file (creating its directory if needed).
+func (c *FileAPIKeyCache) write() error {
+	plaintext, err := json.Marshal(c.apiKeys)
+	if err != nil {
+		return fmt.Errorf("error JSON-marshaling the cached API keys: %v", err)
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return fmt.Errorf("error generating the API key cache salt: %v", err)
+	}
+	aead, err := newAPIKeyCacheAEAD(c.passphrase, salt)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("error generating the API key cache nonce: %v", err)
+	}
+	content, err := json.Marshal(&fileAPIKeyCacheContent{
+		Version:    fileAPIKeyCacheVersion,
+		Salt:       salt,
+		Nonce:      nonce,
+		Ciphertext: aead.Seal(nil, nonce, plaintext, nil),
+	})
+	if err != nil {
+		return fmt.Errorf("error JSON-marshaling the API key cache: %v", err)
+	}
+	if dir := filepath.Dir(c.filePath); len(dir) > 0 {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return fmt.Errorf("error creating API key cache file dir %s: %v", dir, err)
+		}
+	}
+	if err := os.WriteFile(c.filePath, content, 0600); err != nil {
+		return fmt.Errorf("error writing API key cache file %s: %v", c.filePath, err)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package cnil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// TLSConfig returns the TLS config of the connections to an on-premise CNIL
+// instance using a private CA and/or mutual TLS, or nil if none of the CA
+// certificate and of the client certificate and key is specified. Each of
+// them is either a PEM file path or the PEM content itself. The CA
+// certificate is trusted on top of the system ones.
+func TLSConfig(caCert string, clientCert string, clientKey string) (*tls.Config, error) {
+	if len(caCert) == 0 && len(clientCert) == 0 && len(clientKey) == 0 {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{}
+	if len(caCert) > 0 {
+		caPEM, err := readPEM(caCert, "CA certificate")
+		if err != nil {
+			return nil, err
+		}
+		rootCAs, err := x509.SystemCertPool()
+		if err != nil || rootCAs == nil {
+			rootCAs = x509.NewCertPool()
+		}
+		if !rootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, errors.New("error parsing the CNIL CA certificate: no PEM certificate found")
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+
+	if len(clientCert) > 0 || len(clientKey) > 0 {
+		if len(clientCert) == 0 || len(clientKey) == 0 {
+			return nil, errors.New(
+				"both the client certificate and the client key are required for mutual TLS")
+		}
+		certPEM, err := readPEM(clientCert, "client certificate")
+		if err != nil {
+			return nil, err
+		}
+		keyPEM, err := readPEM(clientKey, "client key")
+		if err != nil {
+			return nil, err
+		}
+		certificate, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("error loading the CNIL client certificate and key: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	return tlsConfig, nil
+}
+
+// WithTLSConfig returns a copy of the given HTTP client using the given TLS
+// config, if any, e.g. for the CNIL REST API calls.
+func WithTLSConfig(httpClient *http.Client, tlsConfig *tls.Config) (*http.Client, error) {
+	if tlsConfig == nil {
+		return httpClient, nil
+	}
+	transport := httpClient.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	httpTransport, ok := transport.(*http.Transport)
+	if !ok {
+		return nil, errors.New("the TLS config of the CNIL API calls requires an HTTP transport")
+	}
+	httpTransport = httpTransport.Clone()
+	httpTransport.TLSClientConfig = tlsConfig
+	tlsClient := *httpClient
+	tlsClient.Transport = httpTransport
+	return &tlsClient, nil
+}
+
+// readPEM returns the given PEM content, or the content of the given PEM file.
+func readPEM(value string, description string) ([]byte, error) {
+	if strings.Contains(value, "-----BEGIN") {
+		return []byte(value), nil
+	}
+	content, err := os.ReadFile(strings.TrimSpace(value))
+	if err != nil {
+		return nil, fmt.Errorf("error reading the CNIL %s file: %v", description, err)
+	}
+	return content, nil
+}
@@ -0,0 +1,227 @@
+package github
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// maxETagCacheBody is the size above which a response body is not cached.
+	maxETagCacheBody = 8 << 20
+	// etagCacheRetention is how long the entries of the ETag cache are kept
+	// without being used.
+	etagCacheRetention = 30 * 24 * time.Hour
+)
+
+// ETagCache keeps the ETags and the bodies of the GitHub API responses in a
+// file (e.g. restored from a workflow cache), so that the next requests of
+// the same resources are sent conditionally (i.e. with If-None-Match): the
+// ones of the unchanged resources are answered with 304 Not Modified, which
+// does not count against the rate limit, and served from the cache.
+type ETagCache struct {
+	filePath string
+
+	mu      sync.Mutex
+	entries map[string]*etagEntry
+	dirty   bool
+	hits    int
+	misses  int
+}
+
+// etagEntry is a cached GET response.
+type etagEntry struct {
+	ETag   string    `json:"etag"`
+	Link   string    `json:"link,omitempty"`
+	Body   string    `json:"body"`
+	UsedAt time.Time `json:"used_at"`
+}
+
+// OpenETagCache reads the ETag cache file, if it exists.
+func OpenETagCache(filePath string) (*ETagCache, error) {
+	cache := &ETagCache{filePath: filePath, entries: make(map[string]*etagEntry)}
+	content, err := os.ReadFile(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading ETag cache file %s: %v", filePath, err)
+	}
+	if err := json.Unmarshal(content, &cache.entries); err != nil {
+		return nil, fmt.Errorf("error JSON-unmarshaling ETag cache file %s: %v", filePath, err)
+	}
+	return cache, nil
+}
+
+// Save writes the cache back into its file, if it has changed, dropping the
+// entries unused for a month.
+func (c *ETagCache) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		return nil
+	}
+	for key, entry := range c.entries {
+		if time.Since(entry.UsedAt) > etagCacheRetention {
+			delete(c.entries, key)
+		}
+	}
+	content, err := json.Marshal(c.entries)
+	if err != nil {
+		return fmt.Errorf("error JSON-marshaling the ETag cache: %v", err)
+	}
+	if dir := filepath.Dir(c.filePath); len(dir) > 0 {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return fmt.Errorf("error creating ETag cache directory %s: %v", dir, err)
+		}
+	}
+	// the responses might be the ones of private repositories
+	tmpFile := c.filePath + ".tmp"
+	if err := os.WriteFile(tmpFile, content, 0600); err != nil {
+		return fmt.Errorf("error writing ETag cache file %s: %v", tmpFile, err)
+	}
+	if err := os.Rename(tmpFile, c.filePath); err != nil {
+		return fmt.Errorf("error renaming %s to %s: %v", tmpFile, c.filePath, err)
+	}
+	c.dirty = false
+	return nil
+}
+
+// Stats returns the number of requests served from the cache (i.e. answered
+// with 304 Not Modified) and of the other cacheable ones.
+func (c *ETagCache) Stats() (hits int, misses int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses
+}
+
+// etagCacheKey returns the key of the given request in the cache, i.e. its
+// URL along with its media type. The key does not depend on the token (e.g.
+// the GITHUB_TOKEN of each workflow run), since the response is served from
+// the cache only if GitHub tells that the one for the token has the same ETag.
+func etagCacheKey(req *http.Request) string {
+	return req.Header.Get("Accept") + " " + req.URL.String()
+}
+
+func (c *ETagCache) get(key string) *etagEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	copied := *entry
+	return &copied
+}
+
+func (c *ETagCache) put(key string, entry *etagEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = entry
+	c.dirty = true
+	c.misses++
+}
+
+func (c *ETagCache) hit(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.entries[key]; ok {
+		entry.UsedAt = time.Now().UTC()
+		c.dirty = true
+	}
+	c.hits++
+}
+
+// WithETagCache returns a copy of the given HTTP client whose GET requests
+// of JSON resources are sent conditionally using the ETags of the given
+// cache, a 304 Not Modified response being replaced with the cached one.
+func WithETagCache(httpClient *http.Client, cache *ETagCache) *http.Client {
+	transport := httpClient.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	cachingClient := *httpClient
+	cachingClient.Transport = &etagTransport{transport: transport, cache: cache}
+	return &cachingClient
+}
+
+// etagTransport sends the GET requests conditionally.
+type etagTransport struct {
+	transport http.RoundTripper
+	cache     *ETagCache
+}
+
+func (t *etagTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet || len(req.Header.Get("Range")) > 0 ||
+		len(req.Header.Get("If-None-Match")) > 0 {
+		return t.transport.RoundTrip(req)
+	}
+	key := etagCacheKey(req)
+	entry := t.cache.get(key)
+	if entry != nil {
+		req = req.Clone(req.Context())
+		req.Header.Set("If-None-Match", entry.ETag)
+	}
+
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotModified && entry != nil:
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		t.cache.hit(key)
+		resp.StatusCode, resp.Status = http.StatusOK, "200 OK (from ETag cache)"
+		resp.Header = resp.Header.Clone()
+		resp.Header.Set("Content-Type", "application/json")
+		resp.Header.Set("Content-Length", strconv.Itoa(len(entry.Body)))
+		if len(entry.Link) > 0 {
+			resp.Header.Set("Link", entry.Link)
+		}
+		resp.ContentLength = int64(len(entry.Body))
+		resp.Body = io.NopCloser(bytes.NewReader([]byte(entry.Body)))
+		return resp, nil
+	case resp.StatusCode != http.StatusOK || len(resp.Header.Get("ETag")) == 0 || !isJSON(resp.Header) ||
+		resp.ContentLength > maxETagCacheBody:
+		return resp, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxETagCacheBody+1))
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if len(body) > maxETagCacheBody {
+		// too large to be cached, the rest of the body is read as is
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return resp, nil
+	}
+	resp.Body.Close()
+	t.cache.put(key, &etagEntry{
+		ETag:   resp.Header.Get("ETag"),
+		Link:   resp.Header.Get("Link"),
+		Body:   string(body),
+		UsedAt: time.Now().UTC(),
+	})
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// isJSON returns whether the given response headers tell a JSON body.
+func isJSON(header http.Header) bool {
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	return err == nil && mediaType == "application/json"
+}
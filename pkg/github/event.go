@@ -0,0 +1,39 @@
+package github
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/go-playground/validator"
+)
+
+// ReadReleaseEvent reads the release of the payload of a release webhook
+// event, e.g. the one of the file at GITHUB_EVENT_PATH, saving the API call
+// getting it. It returns nil if the payload has no release, e.g. if it is
+// the one of another event.
+//
+// Unlike the ones of GetRelease, the assets of the returned release are the
+// ones at the time of the event, i.e. it is up to the caller to tell whether
+// they are stale.
+func ReadReleaseEvent(eventPath string) (*Release, error) {
+	payload, err := os.ReadFile(eventPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the event payload file %s: %v", eventPath, err)
+	}
+	var event struct {
+		Release *Release `json:"release"`
+	}
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, fmt.Errorf(
+			"error JSON-unmarshaling the event payload file %s: %v", eventPath, err)
+	}
+	if event.Release == nil {
+		return nil, nil
+	}
+	if err := validator.New().Struct(event.Release); err != nil {
+		return nil, fmt.Errorf(
+			"validation of the release details of the event payload file %s failed: %v", eventPath, err)
+	}
+	return event.Release, nil
+}
@@ -0,0 +1,946 @@
+// Package github implements the GitHub REST API calls needed for processing
+// the assets of releases: getting the release details, downloading and
+// uploading assets and validating the accounts of their authors.
+package github
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator"
+)
+
+// ErrNotFound is returned (wrapped) when a GitHub resource, e.g. the release,
+// cannot be found or the GitHub token is not allowed to read it.
+var ErrNotFound = errors.New("GitHub resource not found")
+
+// Logger reports the progress.
+type Logger interface {
+	Infof(format string, a ...interface{})
+	Successf(format string, a ...interface{})
+	Warningf(format string, a ...interface{})
+	Errorf(format string, a ...interface{})
+}
+
+// HTTPClient sends the GitHub API requests, e.g. an *http.Client (possibly
+// with an ETag cache, see WithETagCache) or a fake one in tests.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+type ReleaseAuthor struct {
+	Login string `json:"login" validate:"required"`
+}
+
+type ReleaseAssetUploader struct {
+	Login string `json:"login" validate:"required"`
+}
+
+type ReleaseAsset struct {
+	URL         string `json:"url" validate:"required"`
+	Name        string `json:"name" validate:"required"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type"`
+	// State is "uploaded", or "starter" while the asset is being uploaded
+	State string `json:"state"`
+	// Uploader is nil if the uploader account has been deleted
+	Uploader *ReleaseAssetUploader `json:"uploader"`
+}
+
+// UploaderLogin returns the login of the uploader of the asset, or an empty
+// string if unknown.
+func (a *ReleaseAsset) UploaderLogin() string {
+	if a.Uploader == nil {
+		return ""
+	}
+	return a.Uploader.Login
+}
+
+type Release struct {
+	URL        string `json:"url"`
+	TarballURL string `json:"tarball_url" validate:"required"`
+	ZipballURL string `json:"zipball_url" validate:"required"`
+	TagName    string `json:"tag_name" validate:"required"`
+	Body       string `json:"body"`
+	AssetsURL  string `json:"assets_url"`
+	UploadURL  string `json:"upload_url"`
+	// Author is nil e.g. for some drafts created via the API
+	Author *ReleaseAuthor  `json:"author"`
+	Assets []*ReleaseAsset `json:"assets"`
+}
+
+// AuthorLogin returns the login of the author of the release, or an empty
+// string if unknown.
+func (r *Release) AuthorLogin() string {
+	if r.Author == nil {
+		return ""
+	}
+	return r.Author.Login
+}
+
+// IsBotLogin tells whether the given login is the one of a bot (i.e. of a
+// GitHub App), e.g. github-actions[bot].
+func IsBotLogin(login string) bool {
+	return strings.HasSuffix(login, "[bot]")
+}
+
+// GetRelease gets the details of the release with the given API URL,
+// including all of its assets.
+func GetRelease(
+	ctx context.Context,
+	httpClient HTTPClient,
+	releaseURL string,
+	githubToken string,
+	release *Release,
+) error {
+
+	req, err := http.NewRequestWithContext(ctx, "GET", releaseURL, nil)
+	if err != nil {
+		return fmt.Errorf(
+			"error creating new HTTP GET %s request for getting the release details: %v",
+			releaseURL, err)
+	}
+
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	if len(githubToken) > 0 {
+		req.Header.Set("Authorization", "token "+githubToken)
+	}
+
+	resp, err := do(httpClient, req)
+	if err != nil {
+		return fmt.Errorf("error getting the release details from URL %s: %v", releaseURL, err)
+	}
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf(
+			"error getting the release details from URL %s: error reading response body: %v",
+			releaseURL, err)
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf(
+			"error getting the release details from URL %s: %w", releaseURL, ErrNotFound)
+	}
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf(
+			"error getting the release details from URL %s: expected a 2xx HTTP code, got %d with body %s",
+			releaseURL, resp.StatusCode, respBody)
+	}
+
+	if err := json.Unmarshal(respBody, release); err != nil {
+		return fmt.Errorf(
+			"error getting the release details from URL %s: error JSON-unmarshaling the response body %s: %v",
+			releaseURL, respBody, err)
+	}
+
+	if err := validator.New().Struct(release); err != nil {
+		return fmt.Errorf("validation of the release details failed: %v", err)
+	}
+
+	// the assets embedded in the release details might be truncated, hence
+	// list all of them
+	if len(release.AssetsURL) > 0 {
+		assets, err := ListReleaseAssets(ctx, httpClient, release.AssetsURL, githubToken)
+		if err != nil {
+			return err
+		}
+		release.Assets = assets
+	}
+
+	return nil
+}
+
+// ListReleaseAssets gets all the pages of the release assets list.
+func ListReleaseAssets(
+	ctx context.Context,
+	httpClient HTTPClient,
+	assetsURL string,
+	githubToken string,
+) ([]*ReleaseAsset, error) {
+
+	var assets []*ReleaseAsset
+	u := assetsURL + "?per_page=100"
+	for len(u) > 0 {
+		var page []*ReleaseAsset
+		nextPageURL, err := GetPage(ctx, httpClient, u, githubToken, &page)
+		if err != nil {
+			return nil, fmt.Errorf("error listing the release assets: %v", err)
+		}
+		for _, asset := range page {
+			if err := validator.New().Struct(asset); err != nil {
+				return nil, fmt.Errorf("validation of the release asset details failed: %v", err)
+			}
+		}
+		assets = append(assets, page...)
+		u = nextPageURL
+	}
+
+	return assets, nil
+}
+
+// ReleaseSummary is a release as listed by ListReleases, without its assets.
+type ReleaseSummary struct {
+	URL       string    `json:"url" validate:"required"`
+	TagName   string    `json:"tag_name"`
+	Draft     bool      `json:"draft"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ListReleases gets all the pages of the releases list of the given
+// repository (i.e. <owner>/<repo-name>), the most recently created first; an
+// empty API base URL defaults to https://api.github.com.
+func ListReleases(
+	ctx context.Context,
+	httpClient HTTPClient,
+	apiBaseURL string,
+	repository string,
+	githubToken string,
+) ([]*ReleaseSummary, error) {
+
+	if len(apiBaseURL) == 0 {
+		apiBaseURL = DefaultAPIURL
+	}
+	var releases []*ReleaseSummary
+	u := fmt.Sprintf("%s/repos/%s/releases?per_page=100", strings.TrimSuffix(apiBaseURL, "/"), repository)
+	for len(u) > 0 {
+		var page []*ReleaseSummary
+		nextPageURL, err := GetPage(ctx, httpClient, u, githubToken, &page)
+		if err != nil {
+			return nil, fmt.Errorf("error listing the releases of repository %s: %w", repository, err)
+		}
+		for _, release := range page {
+			if err := validator.New().Struct(release); err != nil {
+				return nil, fmt.Errorf("validation of the release details failed: %v", err)
+			}
+		}
+		releases = append(releases, page...)
+		u = nextPageURL
+	}
+
+	return releases, nil
+}
+
+// RepositorySummary is a repository as listed by ListRepositories.
+type RepositorySummary struct {
+	FullName string `json:"full_name" validate:"required"`
+	Archived bool   `json:"archived"`
+	Fork     bool   `json:"fork"`
+	Disabled bool   `json:"disabled"`
+}
+
+// ListRepositories gets all the pages of the repositories list of the given
+// organization, or else of the given user if there is no such organization;
+// an empty API base URL defaults to https://api.github.com.
+func ListRepositories(
+	ctx context.Context,
+	httpClient HTTPClient,
+	apiBaseURL string,
+	owner string,
+	githubToken string,
+) ([]*RepositorySummary, error) {
+
+	if len(apiBaseURL) == 0 {
+		apiBaseURL = DefaultAPIURL
+	}
+	apiBaseURL = strings.TrimSuffix(apiBaseURL, "/")
+	var repositories []*RepositorySummary
+	u := fmt.Sprintf("%s/orgs/%s/repos?per_page=100", apiBaseURL, owner)
+	isOrganization := true
+	for len(u) > 0 {
+		var page []*RepositorySummary
+		nextPageURL, err := GetPage(ctx, httpClient, u, githubToken, &page)
+		if isOrganization && len(repositories) == 0 && errors.Is(err, ErrNotFound) {
+			// not an organization, i.e. a user
+			isOrganization = false
+			u = fmt.Sprintf("%s/users/%s/repos?per_page=100", apiBaseURL, owner)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error listing the repositories of %s: %w", owner, err)
+		}
+		for _, repository := range page {
+			if err := validator.New().Struct(repository); err != nil {
+				return nil, fmt.Errorf("validation of the repository details failed: %v", err)
+			}
+		}
+		repositories = append(repositories, page...)
+		u = nextPageURL
+	}
+
+	return repositories, nil
+}
+
+// nextPageURL returns the URL of the next page from the Link header of a
+// paginated GitHub API response, if any.
+func nextPageURL(header http.Header) string {
+	for _, link := range strings.Split(header.Get("Link"), ",") {
+		pieces := strings.Split(link, ";")
+		if len(pieces) < 2 {
+			continue
+		}
+		for _, param := range pieces[1:] {
+			if strings.TrimSpace(param) == `rel="next"` {
+				return strings.Trim(strings.TrimSpace(pieces[0]), "<>")
+			}
+		}
+	}
+	return ""
+}
+
+// DefaultAPIURL is the API base URL of github.com.
+const DefaultAPIURL = "https://api.github.com"
+
+// APIBaseURL returns the GitHub API base URL the given release URL is
+// relative to, e.g. https://api.github.com for
+// https://api.github.com/repos/<owner>/<repo-name>/releases/<id> or
+// https://github.example.com/api/v3 for a GitHub Enterprise Server release.
+func APIBaseURL(releaseURL string) string {
+	u, err := url.Parse(releaseURL)
+	if err != nil || len(u.Host) == 0 {
+		return DefaultAPIURL
+	}
+	if i := strings.Index(u.Path, "/repos/"); i >= 0 {
+		u.Path = u.Path[:i]
+	}
+	u.RawQuery, u.Fragment = "", ""
+	return strings.TrimSuffix(u.String(), "/")
+}
+
+type User struct {
+	ID          int64      `json:"id"`
+	Login       string     `json:"login" validate:"required"`
+	Type        string     `json:"type"`
+	Email       string     `json:"email"`
+	CreatedAt   time.Time  `json:"created_at"`
+	SuspendedAt *time.Time `json:"suspended_at"`
+}
+
+// Get sends a GET request to the given GitHub API URL and
+// JSON-unmarshals the response body into responsePayload. It returns
+// ErrNotFound if the GitHub API responds with HTTP 404.
+func Get(
+	ctx context.Context,
+	httpClient HTTPClient,
+	u string,
+	githubToken string,
+	responsePayload interface{},
+) error {
+
+	_, err := GetPage(ctx, httpClient, u, githubToken, responsePayload)
+	return err
+}
+
+// GetPage is like Get, but also returns the URL of the
+// next page of a paginated response (empty for the last page).
+func GetPage(
+	ctx context.Context,
+	httpClient HTTPClient,
+	u string,
+	githubToken string,
+	responsePayload interface{},
+) (string, error) {
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return "", fmt.Errorf("error creating new HTTP GET %s request: %v", u, err)
+	}
+
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	if len(githubToken) > 0 {
+		req.Header.Set("Authorization", "token "+githubToken)
+	}
+
+	resp, err := do(httpClient, req)
+	if err != nil {
+		return "", fmt.Errorf("error sending request GET %s: %v", u, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("GET %s: error reading response body: %v", u, err)
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return "", ErrNotFound
+	}
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return "", fmt.Errorf(
+			"GET %s error: expected a 2xx HTTP code, got %d with body %s",
+			u, resp.StatusCode, respBody)
+	}
+
+	if err := json.Unmarshal(respBody, responsePayload); err != nil {
+		return "", fmt.Errorf("error JSON-unmarshaling GET %s response body %s: %v",
+			u, respBody, err)
+	}
+
+	return nextPageURL(resp.Header), nil
+}
+
+type Commit struct {
+	SHA string `json:"sha" validate:"required"`
+}
+
+// GetCommitSHA returns the SHA of the commit the given ref (e.g. a tag) of the
+// given repository (i.e. <owner>/<repo-name>) points to.
+func GetCommitSHA(
+	ctx context.Context,
+	httpClient HTTPClient,
+	apiBaseURL string,
+	repository string,
+	ref string,
+	githubToken string,
+) (string, error) {
+
+	commitURL := fmt.Sprintf("%s/repos/%s/commits/%s", apiBaseURL, repository, url.PathEscape(ref))
+	var commit Commit
+	if err := Get(ctx, httpClient, commitURL, githubToken, &commit); err != nil {
+		return "", fmt.Errorf("error getting the commit of %s in repository %s: %v",
+			ref, repository, err)
+	}
+	if err := validator.New().Struct(&commit); err != nil {
+		return "", fmt.Errorf("validation of the commit of %s failed: %v", ref, err)
+	}
+
+	return commit.SHA, nil
+}
+
+// GetFileContent returns the raw content of the file with the given path in
+// the given repository (i.e. <owner>/<repo-name>) at the given ref (e.g. a
+// tag). It returns ErrNotFound if the file does not exist at that ref.
+func GetFileContent(
+	ctx context.Context,
+	httpClient HTTPClient,
+	apiBaseURL string,
+	repository string,
+	filePath string,
+	ref string,
+	githubToken string,
+) ([]byte, error) {
+
+	u := fmt.Sprintf("%s/repos/%s/contents/%s?ref=%s",
+		apiBaseURL, repository, strings.TrimPrefix(filePath, "/"), url.QueryEscape(ref))
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating new HTTP GET %s request: %v", u, err)
+	}
+	req.Header.Set("Accept", "application/vnd.github.v3.raw")
+	if len(githubToken) > 0 {
+		req.Header.Set("Authorization", "token "+githubToken)
+	}
+
+	resp, err := do(httpClient, req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request GET %s: %v", u, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("GET %s: error reading response body: %v", u, err)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return nil, fmt.Errorf("GET %s error: expected a 2xx HTTP code, got %d with body %s",
+			u, resp.StatusCode, respBody)
+	}
+
+	return respBody, nil
+}
+
+func getUser(
+	ctx context.Context,
+	httpClient HTTPClient,
+	apiBaseURL string,
+	githubToken string,
+	login string,
+) (*User, error) {
+
+	userURL := fmt.Sprintf("%s/users/%s", apiBaseURL, url.PathEscape(login))
+	var user User
+	if err := Get(ctx, httpClient, userURL, githubToken, &user); err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// ValidateAccounts makes sure that each of the given (GitHub) logins
+// belongs to an existing account which is not suspended and prints a warning
+// for each account which has been created less than minAge ago.
+func ValidateAccounts(
+	ctx context.Context,
+	httpClient HTTPClient,
+	apiBaseURL string,
+	githubToken string,
+	logins []string,
+	minAge time.Duration,
+	log Logger,
+) error {
+
+	validated := make(map[string]bool)
+	for _, login := range logins {
+		if validated[login] {
+			continue
+		}
+
+		log.Infof("Validating GitHub account %s ...\n", login)
+		user, err := getUser(ctx, httpClient, apiBaseURL, githubToken, login)
+		if errors.Is(err, ErrNotFound) {
+			return fmt.Errorf(
+				"validation of GitHub account %s failed: account not found (it might have been deleted or suspended)",
+				login)
+		}
+		if err != nil {
+			return fmt.Errorf("validation of GitHub account %s failed: %v", login, err)
+		}
+		if user.SuspendedAt != nil && !user.SuspendedAt.IsZero() {
+			return fmt.Errorf(
+				"validation of GitHub account %s failed: account suspended at %s",
+				login, user.SuspendedAt.Format(time.UnixDate))
+		}
+		if minAge > 0 && time.Since(user.CreatedAt) < minAge {
+			log.Warningf("WARNING: GitHub account %s has been created recently (at %s)\n",
+				login, user.CreatedAt.Format(time.UnixDate))
+		}
+
+		validated[login] = true
+	}
+
+	return nil
+}
+
+// ResolveEmails returns the emails of the given logins, i.e. the public email
+// of each account or else its noreply email (e.g.
+// 1234+octocat@users.noreply.github.com), as used by default by git.
+func ResolveEmails(
+	ctx context.Context,
+	httpClient HTTPClient,
+	apiBaseURL string,
+	githubToken string,
+	logins []string,
+	log Logger,
+) (map[string]string, error) {
+
+	emailsPerLogin := make(map[string]string)
+	for _, login := range logins {
+		if _, ok := emailsPerLogin[login]; ok {
+			continue
+		}
+
+		user, err := getUser(ctx, httpClient, apiBaseURL, githubToken, login)
+		if err != nil {
+			return nil, fmt.Errorf("error getting the email of GitHub user %s: %v", login, err)
+		}
+		email := strings.TrimSpace(user.Email)
+		if len(email) == 0 {
+			email = noreplyEmail(apiBaseURL, user)
+		}
+		log.Infof("GitHub user %s has email %s\n", login, email)
+		emailsPerLogin[login] = email
+	}
+
+	return emailsPerLogin, nil
+}
+
+// noreplyEmail returns the noreply email of the given user, on github.com as
+// well as on GitHub Enterprise Server (i.e. <id>+<login>@noreply.<hostname>).
+func noreplyEmail(apiBaseURL string, user *User) string {
+	domain := "users.noreply.github.com"
+	if u, err := url.Parse(apiBaseURL); err == nil && u.Hostname() != "api.github.com" {
+		domain = "noreply." + u.Hostname()
+	}
+	return fmt.Sprintf("%d+%s@%s", user.ID, user.Login, domain)
+}
+
+type TeamMembership struct {
+	State string `json:"state"`
+}
+
+// ResolveTeams returns the values (e.g. signer IDs) mapped to the GitHub teams
+// (i.e. <org>/<team-slug>) those of the given logins are active members of.
+// Teams are checked in alphabetical order and the first match wins.
+func ResolveTeams(
+	ctx context.Context,
+	httpClient HTTPClient,
+	apiBaseURL string,
+	githubToken string,
+	logins []string,
+	valuesPerTeam map[string]string,
+	log Logger,
+) (map[string]string, error) {
+
+	teams := make([]string, 0, len(valuesPerTeam))
+	for team := range valuesPerTeam {
+		teams = append(teams, team)
+	}
+	sort.Strings(teams)
+
+	valuesPerLogin := make(map[string]string)
+	resolved := make(map[string]bool)
+	for _, login := range logins {
+		if resolved[login] {
+			continue
+		}
+		resolved[login] = true
+
+		for _, team := range teams {
+			pieces := strings.Split(team, "/")
+			membershipURL := fmt.Sprintf("%s/orgs/%s/teams/%s/memberships/%s",
+				apiBaseURL, url.PathEscape(pieces[0]), url.PathEscape(pieces[1]),
+				url.PathEscape(login))
+			var membership TeamMembership
+			err := Get(ctx, httpClient, membershipURL, githubToken, &membership)
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf(
+					"error checking the membership of %s in GitHub team %s: %v", login, team, err)
+			}
+			if membership.State == "active" {
+				log.Infof("GitHub user %s is a member of team %s\n", login, team)
+				valuesPerLogin[login] = valuesPerTeam[team]
+				break
+			}
+		}
+	}
+
+	return valuesPerLogin, nil
+}
+
+// RepositoryFromReleaseURL returns the <owner>/<repo-name> full name of the
+// repository of the given GitHub API URL (e.g. of a release or of a source
+// code archive), on github.com as well as on GitHub Enterprise Server.
+func RepositoryFromReleaseURL(releaseURL string) string {
+	u, err := url.Parse(releaseURL)
+	if err != nil {
+		return ""
+	}
+	pieces := strings.Split(strings.Trim(u.Path, "/"), "/")
+	for i := 0; i+2 < len(pieces); i++ {
+		if pieces[i] == "repos" && len(pieces[i+1]) > 0 && len(pieces[i+2]) > 0 {
+			return pieces[i+1] + "/" + pieces[i+2]
+		}
+	}
+	return ""
+}
+
+// ReleaseURLFromTag returns the GitHub API URL of the release of the given
+// repository (i.e. <owner>/<repo-name>) with the given tag name; an empty API
+// base URL defaults to https://api.github.com.
+func ReleaseURLFromTag(apiBaseURL string, repository string, tag string) string {
+	if len(apiBaseURL) == 0 {
+		apiBaseURL = DefaultAPIURL
+	}
+	tag = strings.TrimPrefix(tag, "refs/tags/")
+	return fmt.Sprintf("%s/repos/%s/releases/tags/%s",
+		strings.TrimSuffix(apiBaseURL, "/"), repository, url.PathEscape(tag))
+}
+
+// LatestReleaseURL returns the GitHub API URL resolving to the latest release
+// of the given repository (i.e. <owner>/<repo-name>), that is the most recent
+// non-prerelease, non-draft one; an empty API base URL defaults to
+// https://api.github.com.
+func LatestReleaseURL(apiBaseURL string, repository string) string {
+	if len(apiBaseURL) == 0 {
+		apiBaseURL = DefaultAPIURL
+	}
+	return fmt.Sprintf("%s/repos/%s/releases/latest", strings.TrimSuffix(apiBaseURL, "/"), repository)
+}
+
+// OpenAsset sends the request for downloading the release asset (or source
+// code archive) with the given URL and returns the response body, which the
+// caller must close.
+func OpenAsset(
+	ctx context.Context,
+	httpClient HTTPClient,
+	assetURL string,
+	githubToken string,
+	sourceArchive bool,
+) (io.ReadCloser, error) {
+
+	download, err := OpenAssetRange(ctx, httpClient, assetURL, githubToken, sourceArchive, 0)
+	if err != nil {
+		return nil, err
+	}
+	return download.Body, nil
+}
+
+// AssetDownload is an opened download of a release asset.
+type AssetDownload struct {
+	// Body is the response body, which the caller must close.
+	Body io.ReadCloser
+	// Offset is the one of the first byte of Body in the asset, i.e. 0 if
+	// the server does not support range requests.
+	Offset int64
+	// Size is the total size of the asset, or -1 if unknown.
+	Size int64
+	// ContentType is the media type of the response body, if any.
+	ContentType string
+}
+
+// OpenAssetRange is like OpenAsset, but requests the asset content starting
+// at the given offset (with a Range header) for resuming a download.
+func OpenAssetRange(
+	ctx context.Context,
+	httpClient HTTPClient,
+	assetURL string,
+	githubToken string,
+	sourceArchive bool,
+	offset int64,
+) (*AssetDownload, error) {
+
+	u := strings.TrimSpace(assetURL)
+	if len(u) == 0 {
+		return nil, errors.New("empty asset download URL")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"error creating new HTTP GET %s request for downloading asset: %v", u, err)
+	}
+	if !sourceArchive {
+		req.Header.Set("Accept", "application/octet-stream")
+	}
+	if len(githubToken) > 0 {
+		req.Header.Set("Authorization", "token "+githubToken)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := do(httpClient, req)
+	if err != nil {
+		return nil, fmt.Errorf("error downloading asset from URL %s: %v", u, err)
+	}
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		resp.Body.Close()
+		return nil, fmt.Errorf(
+			"error downloading asset from URL %s: expected a 2xx HTTP code, got %d",
+			u, resp.StatusCode)
+	}
+
+	download := &AssetDownload{Body: resp.Body, Size: resp.ContentLength}
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil {
+		download.ContentType = mediaType
+	}
+	if resp.StatusCode == http.StatusPartialContent {
+		// e.g. Content-Range: bytes 100-999/1000
+		var start, end int64
+		var size string
+		if _, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-%d/%s",
+			&start, &end, &size); err != nil {
+			resp.Body.Close()
+			return nil, fmt.Errorf("error downloading asset from URL %s: invalid Content-Range %s",
+				u, resp.Header.Get("Content-Range"))
+		}
+		download.Offset = start
+		download.Size = -1
+		if total, err := strconv.ParseInt(size, 10, 64); err == nil {
+			download.Size = total
+		}
+	}
+
+	return download, nil
+}
+
+// UploadReleaseAsset uploads the given file as an asset of the release, using
+// the upload URL (template) of the release.
+func UploadReleaseAsset(
+	ctx context.Context,
+	httpClient HTTPClient,
+	uploadURL string,
+	githubToken string,
+	filePath string,
+) error {
+
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return fmt.Errorf("error reading file %s to upload: %v", filePath, err)
+	}
+
+	// the upload URL is a template like https://uploads.github.com/repos/
+	// <owner>/<repo-name>/releases/<release ID>/assets{?name,label}
+	if i := strings.Index(uploadURL, "{"); i >= 0 {
+		uploadURL = uploadURL[:i]
+	}
+	u := uploadURL + "?name=" + url.QueryEscape(filepath.Base(filePath))
+
+	req, err := http.NewRequestWithContext(ctx, "POST", u, bytes.NewReader(content))
+	if err != nil {
+		return fmt.Errorf("error creating new HTTP POST %s request: %v", u, err)
+	}
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	req.Header.Set("Content-Type", "text/plain")
+	if len(githubToken) > 0 {
+		req.Header.Set("Authorization", "token "+githubToken)
+	}
+
+	return send(httpClient, req)
+}
+
+// ReplaceReleaseAsset uploads the given file as an asset of the release,
+// deleting first the previous asset with the same name (if any).
+func ReplaceReleaseAsset(
+	ctx context.Context,
+	httpClient HTTPClient,
+	uploadURL string,
+	previousAssetURL string,
+	githubToken string,
+	filePath string,
+	log Logger,
+) error {
+
+	name := filepath.Base(filePath)
+	if len(previousAssetURL) > 0 {
+		log.Infof("Deleting the previous %s release asset ...\n", name)
+		if err := DeleteReleaseAsset(ctx, httpClient, previousAssetURL, githubToken); err != nil {
+			return fmt.Errorf("error deleting the previous %s release asset: %v", name, err)
+		}
+	}
+	log.Infof("Uploading %s to the release ...\n", name)
+	if err := UploadReleaseAsset(ctx, httpClient, uploadURL, githubToken, filePath); err != nil {
+		return fmt.Errorf("error uploading %s to the release: %v", name, err)
+	}
+	log.Successf("Successfully uploaded %s to the release\n", name)
+
+	return nil
+}
+
+// DeleteReleaseAsset deletes the release asset with the given API URL.
+func DeleteReleaseAsset(
+	ctx context.Context,
+	httpClient HTTPClient,
+	assetURL string,
+	githubToken string,
+) error {
+
+	req, err := http.NewRequestWithContext(ctx, "DELETE", assetURL, nil)
+	if err != nil {
+		return fmt.Errorf("error creating new HTTP DELETE %s request: %v", assetURL, err)
+	}
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	if len(githubToken) > 0 {
+		req.Header.Set("Authorization", "token "+githubToken)
+	}
+
+	return send(httpClient, req)
+}
+
+// UpdateReleaseBody replaces the body (i.e. the release notes) of the release
+// with the given API URL, which requires a GitHub token allowed to write the
+// release.
+func UpdateReleaseBody(
+	ctx context.Context,
+	httpClient HTTPClient,
+	releaseURL string,
+	githubToken string,
+	body string,
+) error {
+
+	payload, err := json.Marshal(map[string]string{"body": body})
+	if err != nil {
+		return fmt.Errorf("error JSON-marshaling the release body: %v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "PATCH", releaseURL, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("error creating new HTTP PATCH %s request: %v", releaseURL, err)
+	}
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	req.Header.Set("Content-Type", "application/json")
+	if len(githubToken) > 0 {
+		req.Header.Set("Authorization", "token "+githubToken)
+	}
+
+	return send(httpClient, req)
+}
+
+// CreateAttestation uploads the given Sigstore bundle as an artifact
+// attestation of the given repository (i.e. <owner>/<repo-name>) and returns
+// its ID, which requires a GitHub token with the attestations: write
+// permission.
+func CreateAttestation(
+	ctx context.Context,
+	httpClient HTTPClient,
+	apiBaseURL string,
+	repository string,
+	githubToken string,
+	bundle json.RawMessage,
+) (int64, error) {
+
+	if len(apiBaseURL) == 0 {
+		apiBaseURL = DefaultAPIURL
+	}
+	u := fmt.Sprintf("%s/repos/%s/attestations", strings.TrimSuffix(apiBaseURL, "/"), repository)
+	payload, err := json.Marshal(map[string]json.RawMessage{"bundle": bundle})
+	if err != nil {
+		return 0, fmt.Errorf("error JSON-marshaling the attestation: %v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", u, bytes.NewReader(payload))
+	if err != nil {
+		return 0, fmt.Errorf("error creating new HTTP POST %s request: %v", u, err)
+	}
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	req.Header.Set("Content-Type", "application/json")
+	if len(githubToken) > 0 {
+		req.Header.Set("Authorization", "token "+githubToken)
+	}
+
+	resp, err := do(httpClient, req)
+	if err != nil {
+		return 0, fmt.Errorf("error sending request POST %s: %v", u, err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("POST %s: error reading response body: %v", u, err)
+	}
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return 0, fmt.Errorf("POST %s error: expected a 2xx HTTP code, got %d with body %s",
+			u, resp.StatusCode, respBody)
+	}
+	var attestation struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.Unmarshal(respBody, &attestation); err != nil {
+		return 0, fmt.Errorf("error JSON-unmarshaling POST %s response body %s: %v",
+			u, respBody, err)
+	}
+
+	return attestation.ID, nil
+}
+
+// send sends the given request, expecting a 2xx response.
+func send(httpClient HTTPClient, req *http.Request) error {
+	resp, err := do(httpClient, req)
+	if err != nil {
+		return fmt.Errorf("error sending request %s %s: %v", req.Method, req.URL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s %s error: expected a 2xx HTTP code, got %d with body %s",
+			req.Method, req.URL, resp.StatusCode, respBody)
+	}
+
+	return nil
+}
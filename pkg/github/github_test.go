@@ -0,0 +1,177 @@
+package github_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/codenotary/notarize-release-assets-action/internal/fakeserver"
+	"github.com/codenotary/notarize-release-assets-action/pkg/github"
+)
+
+// countRequests returns the number of the given requests of the fake API.
+func countRequests(gh *fakeserver.GitHub, request string) int {
+	n := 0
+	for _, r := range gh.Requests() {
+		if r == request {
+			n++
+		}
+	}
+	return n
+}
+
+func TestGetReleaseListsAllTheAssetPages(t *testing.T) {
+	gh := fakeserver.NewGitHub()
+	defer gh.Close()
+	gh.PerPage = 2
+	var assets []fakeserver.Asset
+	for i := 0; i < 5; i++ {
+		assets = append(assets, fakeserver.Asset{Name: fmt.Sprintf("app-%d.tar.gz", i), Content: []byte("content")})
+	}
+	releaseURL := gh.AddRelease("my-org/my-repo", "v1.0.0", "octocat", assets...)
+
+	var release github.Release
+	if err := github.GetRelease(context.Background(), gh.Client(), releaseURL, "", &release); err != nil {
+		t.Fatalf("GetRelease: %v", err)
+	}
+	if release.TagName != "v1.0.0" || release.AuthorLogin() != "octocat" {
+		t.Errorf("got release %s by %s, want v1.0.0 by octocat", release.TagName, release.AuthorLogin())
+	}
+	if len(release.Assets) != 5 {
+		t.Fatalf("got %d assets, want 5", len(release.Assets))
+	}
+	for i, asset := range release.Assets {
+		if want := fmt.Sprintf("app-%d.tar.gz", i); asset.Name != want {
+			t.Errorf("asset %d is %s, want %s", i, asset.Name, want)
+		}
+	}
+	if n := countRequests(gh, "GET /repos/my-org/my-repo/releases/1/assets"); n != 3 {
+		t.Errorf("got %d requests of the assets pages, want 3", n)
+	}
+}
+
+func TestListReleasesListsAllThePages(t *testing.T) {
+	gh := fakeserver.NewGitHub()
+	defer gh.Close()
+	gh.PerPage = 2
+	for _, tag := range []string{"v1.0.0", "v1.1.0", "v2.0.0"} {
+		gh.AddRelease("my-org/my-repo", tag, "octocat")
+	}
+
+	releases, err := github.ListReleases(context.Background(), gh.Client(), gh.URL, "my-org/my-repo", "")
+	if err != nil {
+		t.Fatalf("ListReleases: %v", err)
+	}
+	var tags []string
+	for _, release := range releases {
+		tags = append(tags, release.TagName)
+	}
+	if got, want := strings.Join(tags, ","), "v2.0.0,v1.1.0,v1.0.0"; got != want {
+		t.Errorf("got releases %s, want %s", got, want)
+	}
+}
+
+func TestRateLimitedRequestsAreRetried(t *testing.T) {
+	gh := fakeserver.NewGitHub()
+	defer gh.Close()
+	releaseURL := gh.AddRelease("my-org/my-repo", "v1.0.0", "octocat")
+	gh.RateLimit("/repos/my-org/my-repo/releases/1", 2)
+
+	var release github.Release
+	if err := github.GetRelease(context.Background(), gh.Client(), releaseURL, "", &release); err != nil {
+		t.Fatalf("GetRelease: %v", err)
+	}
+	if n := countRequests(gh, "GET /repos/my-org/my-repo/releases/1"); n != 3 {
+		t.Errorf("got %d requests of the release, want 3", n)
+	}
+}
+
+func TestGetReleaseFailures(t *testing.T) {
+	gh := fakeserver.NewGitHub()
+	defer gh.Close()
+	gh.Token = "secret"
+	releaseURL := gh.AddRelease("my-org/my-repo", "v1.0.0", "octocat")
+	gh.Fail("/repos/my-org/my-repo/releases/1", http.StatusInternalServerError, 1)
+
+	var release github.Release
+	err := github.GetRelease(context.Background(), gh.Client(), releaseURL, "secret", &release)
+	if err == nil || !strings.Contains(err.Error(), "got 500") {
+		t.Errorf("got error %v, want a 500 one", err)
+	}
+	err = github.GetRelease(context.Background(), gh.Client(), releaseURL, "wrong", &release)
+	if err == nil || !strings.Contains(err.Error(), "got 401") {
+		t.Errorf("got error %v, want a 401 one", err)
+	}
+	err = github.GetRelease(context.Background(), gh.Client(), gh.URL+"/repos/my-org/my-repo/releases/42", "secret", &release)
+	if !errors.Is(err, github.ErrNotFound) {
+		t.Errorf("got error %v, want ErrNotFound", err)
+	}
+}
+
+func TestOpenAssetRange(t *testing.T) {
+	gh := fakeserver.NewGitHub()
+	defer gh.Close()
+	releaseURL := gh.AddRelease("my-org/my-repo", "v1.0.0", "octocat",
+		fakeserver.Asset{Name: "app.tar.gz", Content: []byte("0123456789"), ContentType: "application/gzip"})
+	var release github.Release
+	if err := github.GetRelease(context.Background(), gh.Client(), releaseURL, "", &release); err != nil {
+		t.Fatalf("GetRelease: %v", err)
+	}
+
+	download, err := github.OpenAssetRange(context.Background(), gh.Client(), release.Assets[0].URL, "", false, 4)
+	if err != nil {
+		t.Fatalf("OpenAssetRange: %v", err)
+	}
+	defer download.Body.Close()
+	content, err := io.ReadAll(download.Body)
+	if err != nil {
+		t.Fatalf("error reading the asset: %v", err)
+	}
+	if string(content) != "456789" || download.Offset != 4 || download.Size != 10 {
+		t.Errorf("got %q at offset %d of %d bytes, want \"456789\" at offset 4 of 10 bytes",
+			content, download.Offset, download.Size)
+	}
+	if download.ContentType != "application/gzip" {
+		t.Errorf("got content type %s, want application/gzip", download.ContentType)
+	}
+}
+
+func TestReplaceReleaseAsset(t *testing.T) {
+	gh := fakeserver.NewGitHub()
+	defer gh.Close()
+	releaseURL := gh.AddRelease("my-org/my-repo", "v1.0.0", "octocat",
+		fakeserver.Asset{Name: "app.tar.gz", Content: []byte("app")},
+		fakeserver.Asset{Name: "checksums.txt", Content: []byte("previous")})
+	var release github.Release
+	if err := github.GetRelease(context.Background(), gh.Client(), releaseURL, "", &release); err != nil {
+		t.Fatalf("GetRelease: %v", err)
+	}
+
+	filePath := filepath.Join(t.TempDir(), "checksums.txt")
+	if err := os.WriteFile(filePath, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := github.ReplaceReleaseAsset(context.Background(), gh.Client(), release.UploadURL,
+		release.Assets[1].URL, "", filePath, nopLogger{}); err != nil {
+		t.Fatalf("ReplaceReleaseAsset: %v", err)
+	}
+	if got, want := strings.Join(gh.AssetNames(releaseURL), ","), "app.tar.gz,checksums.txt"; got != want {
+		t.Errorf("got assets %s, want %s", got, want)
+	}
+	if n := countRequests(gh, "DELETE /repos/my-org/my-repo/releases/assets/3"); n != 1 {
+		t.Errorf("got %d deletions of the previous asset, want 1", n)
+	}
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Infof(string, ...interface{})    {}
+func (nopLogger) Successf(string, ...interface{}) {}
+func (nopLogger) Warningf(string, ...interface{}) {}
+func (nopLogger) Errorf(string, ...interface{})   {}
@@ -0,0 +1,187 @@
+package github
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// rateLimitReserve is the number of remaining requests below which the
+	// next requests wait for the rate limit to reset.
+	rateLimitReserve = 10
+	// maxRateLimitRetries is the maximum number of times a rate limited
+	// request is retried.
+	maxRateLimitRetries = 5
+	// maxRateLimitWait is the longest wait for a rate limit, beyond which the
+	// request fails.
+	maxRateLimitWait = time.Hour
+	// secondaryRateLimitWait is the first wait after hitting a secondary rate
+	// limit without Retry-After header, doubled at each retry.
+	secondaryRateLimitWait = time.Minute
+)
+
+type loggerKey struct{}
+
+// WithLogger returns a copy of the given context with the logger reporting
+// the waits for the rate limits of the GitHub API calls using it.
+func WithLogger(ctx context.Context, log Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, log)
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Infof(string, ...interface{})    {}
+func (nopLogger) Successf(string, ...interface{}) {}
+func (nopLogger) Warningf(string, ...interface{}) {}
+func (nopLogger) Errorf(string, ...interface{})   {}
+
+func loggerFrom(ctx context.Context) Logger {
+	if log, ok := ctx.Value(loggerKey{}).(Logger); ok && log != nil {
+		return log
+	}
+	return nopLogger{}
+}
+
+// rateLimit is the last known state of the (core) rate limit of a token.
+type rateLimit struct {
+	remaining int
+	reset     time.Time
+}
+
+// rateLimits are the rate limits per token (i.e. per Authorization header),
+// shared by all the API calls of the process.
+var rateLimits = struct {
+	sync.Mutex
+	byToken map[string]*rateLimit
+}{byToken: make(map[string]*rateLimit)}
+
+// do sends the given request like httpClient.Do, but waits for the rate limit
+// to reset when it is close to exhaustion and retries the request when it
+// hits the primary or the secondary rate limit, as long as the wait ends
+// before the deadline of the request context (if any).
+func do(httpClient HTTPClient, req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	log := loggerFrom(ctx)
+	token := req.Header.Get("Authorization")
+	for attempt := 0; ; attempt++ {
+		if wait := waitBeforeRequest(token); wait > 0 && canWait(ctx, wait) {
+			log.Warningf("WARNING: the GitHub API rate limit is almost exhausted, "+
+				"waiting %s for it to reset ...\n", wait.Round(time.Second))
+			if err := sleep(ctx, wait); err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		updateRateLimit(token, resp)
+
+		wait, limited := rateLimitWait(resp, attempt)
+		if !limited {
+			return resp, nil
+		}
+		if attempt >= maxRateLimitRetries || !canWait(ctx, wait) ||
+			(req.Body != nil && req.GetBody == nil) {
+			return resp, nil
+		}
+		resp.Body.Close()
+		log.Warningf("WARNING: %s %s hit the GitHub API rate limit, retrying in %s ...\n",
+			req.Method, req.URL.Redacted(), wait.Round(time.Second))
+		if err := sleep(ctx, wait); err != nil {
+			return nil, err
+		}
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// waitBeforeRequest returns how long to wait before sending a request with the
+// given token, i.e. until the reset of its rate limit if almost exhausted.
+func waitBeforeRequest(token string) time.Duration {
+	rateLimits.Lock()
+	defer rateLimits.Unlock()
+	limit, ok := rateLimits.byToken[token]
+	if !ok || limit.remaining >= rateLimitReserve {
+		return 0
+	}
+	return time.Until(limit.reset)
+}
+
+// updateRateLimit records the state of the core rate limit of the given token
+// reported by the X-RateLimit-* headers of the response, if any.
+func updateRateLimit(token string, resp *http.Response) {
+	if resource := resp.Header.Get("X-RateLimit-Resource"); len(resource) > 0 && resource != "core" {
+		return
+	}
+	remaining, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+	reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return
+	}
+
+	rateLimits.Lock()
+	defer rateLimits.Unlock()
+	rateLimits.byToken[token] = &rateLimit{remaining: remaining, reset: time.Unix(reset, 0)}
+}
+
+// rateLimitWait returns whether the given response is a primary or secondary
+// rate limit error and, if so, how long to wait before retrying the request.
+func rateLimitWait(resp *http.Response, attempt int) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+	if retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		return time.Duration(retryAfter) * time.Second, true
+	}
+	if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			// give some slack to the clock skew
+			return time.Until(time.Unix(reset, 0)) + time.Second, true
+		}
+	}
+
+	// the secondary rate limits are reported only in the body, which is kept
+	// for the caller
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil || !strings.Contains(strings.ToLower(string(body)), "secondary rate limit") {
+		return 0, false
+	}
+	return secondaryRateLimitWait << attempt, true
+}
+
+// canWait returns whether the given wait ends before the deadline of the given
+// context, if any.
+func canWait(ctx context.Context, wait time.Duration) bool {
+	if wait > maxRateLimitWait {
+		return false
+	}
+	deadline, ok := ctx.Deadline()
+	return !ok || time.Until(deadline) > wait
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
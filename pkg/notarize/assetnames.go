@@ -0,0 +1,143 @@
+package notarize
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// assetNameMetadataKey is the metadata key of the actual name of the assets
+// renamed in the ledger.
+const assetNameMetadataKey = "asset_name"
+
+// AssetNameOptions are the settings of the names the file assets (i.e. the
+// release assets, the source archives and the local files, but not e.g. the
+// images) are notarized with, so that the ledger names of the same assets are
+// stable across the releases and can be compared release over release. The
+// actual name of the renamed assets is recorded in the asset_name metadata.
+type AssetNameOptions struct {
+	// Overrides are the ledger names of the assets whose names match the glob
+	// patterns (see path.Match), by pattern, e.g.
+	// {"my-tool-*-linux-amd64.tar.gz": "my-tool-linux-amd64.tar.gz"}. The
+	// overridden names are not normalized.
+	Overrides map[string]string
+
+	// StripVersion specifies to remove the release tag, with or without its
+	// leading "v", from the names along with one adjacent separator, e.g.
+	// my-tool-v1.2.3-linux-amd64.tar.gz becomes my-tool-linux-amd64.tar.gz.
+	StripVersion bool
+	// Lowercase specifies to lower the case of the names.
+	Lowercase bool
+}
+
+// ParseAssetNames parses the normalizations of the asset names, a list of
+// lowercase and / or strip-version separated by commas or new lines, and the
+// overrides of the asset names, a JSON object of the form
+// {"<asset name glob pattern>": "<ledger name>", ...}. It returns nil if both
+// are empty.
+func ParseAssetNames(normalizations string, overrides string) (*AssetNameOptions, error) {
+	var options AssetNameOptions
+	names, err := ParsePatterns(normalizations)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		switch strings.ToLower(name) {
+		case "lowercase":
+			options.Lowercase = true
+		case "strip-version":
+			options.StripVersion = true
+		default:
+			return nil, fmt.Errorf(
+				"invalid asset name normalization %s: must be either lowercase or strip-version", name)
+		}
+	}
+	if len(strings.TrimSpace(overrides)) > 0 {
+		if err := json.Unmarshal([]byte(overrides), &options.Overrides); err != nil {
+			return nil, fmt.Errorf("error JSON-unmarshaling the asset names mapping: %v", err)
+		}
+	}
+	if options.Overrides == nil && !options.StripVersion && !options.Lowercase {
+		return nil, nil
+	}
+	if err := options.validate(); err != nil {
+		return nil, err
+	}
+	return &options, nil
+}
+
+// validate checks the patterns and the names of the overrides.
+func (o *AssetNameOptions) validate() error {
+	var problems ConfigError
+	for pattern, name := range o.Overrides {
+		if _, err := path.Match(pattern, ""); err != nil {
+			problems.Addf("invalid asset name pattern %s: %v", pattern, err)
+		}
+		if len(strings.TrimSpace(name)) == 0 || strings.ContainsAny(name, "/\\") {
+			problems.Addf("invalid ledger name %q of asset name pattern %s: "+
+				"must be a non-empty file name", name, pattern)
+		}
+	}
+	return problems.Err()
+}
+
+// ledgerName returns the name the asset of the given name is notarized with
+// in the release of the given tag, i.e. the one of the override matching its
+// name, if any, or its normalized name.
+func (o *AssetNameOptions) ledgerName(name string, tag string) (string, error) {
+	if o == nil {
+		return name, nil
+	}
+	// the exact names take precedence over the patterns, which must not be
+	// ambiguous
+	if override, ok := o.Overrides[name]; ok {
+		return override, nil
+	}
+	var matches []string
+	for pattern := range o.Overrides {
+		if ok, _ := path.Match(pattern, name); ok {
+			matches = append(matches, pattern)
+		}
+	}
+	sort.Strings(matches)
+	for _, pattern := range matches {
+		if o.Overrides[pattern] != o.Overrides[matches[0]] {
+			return "", fmt.Errorf("asset %s matches both the %s and %s patterns of the asset names mapping",
+				name, matches[0], pattern)
+		}
+	}
+	if len(matches) > 0 {
+		return o.Overrides[matches[0]], nil
+	}
+
+	if o.StripVersion && len(tag) > 0 {
+		name = stripVersion(name, tag)
+	}
+	if o.Lowercase {
+		name = strings.ToLower(name)
+	}
+	return name, nil
+}
+
+// stripVersion removes the given release tag, or else the tag without its
+// leading "v", from the given name, along with the separator preceding it (or
+// else the one following it), e.g. from my-tool_1.2.3.tar.gz or
+// my-tool-v1.2.3-linux.tar.gz.
+func stripVersion(name string, tag string) string {
+	for _, version := range []string{tag, strings.TrimPrefix(tag, "v")} {
+		i := strings.Index(name, version)
+		if len(version) == 0 || i < 0 {
+			continue
+		}
+		start, end := i, i+len(version)
+		if start > 0 && strings.ContainsRune("-_.", rune(name[start-1])) {
+			start--
+		} else if end < len(name) && strings.ContainsRune("-_.", rune(name[end])) {
+			end++
+		}
+		return name[:start] + name[end:]
+	}
+	return name
+}
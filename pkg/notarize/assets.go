@@ -0,0 +1,345 @@
+package notarize
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	vcnAPI "github.com/vchain-us/vcn/pkg/api"
+	vcnDirExtractor "github.com/vchain-us/vcn/pkg/extractor/dir"
+	vcnDockerExtractor "github.com/vchain-us/vcn/pkg/extractor/docker"
+	vcnGitExtractor "github.com/vchain-us/vcn/pkg/extractor/git"
+	vcnMeta "github.com/vchain-us/vcn/pkg/meta"
+	vcnURI "github.com/vchain-us/vcn/pkg/uri"
+)
+
+// releaseAsset is an asset to be processed: either an uploaded one, one of
+// the source code archives generated by GitHub, one of the git objects of
+// the release (whose artifact is created from the local git repository), a
+// container image (whose artifact is created from the registry) or a local
+// file (e.g. the SBOM).
+type releaseAsset struct {
+	name          string
+	url           string
+	signerID      string
+	sourceArchive bool
+	artifact      *vcnAPI.Artifact
+	filePath      string
+	// size is the one reported by GitHub for the uploaded assets (0 if
+	// unknown), which the downloads are checked against
+	size int64
+	// contentType is the one reported by GitHub for the uploaded assets
+	contentType string
+	// status is the one the asset is notarized with (or expected to have
+	// when verifying)
+	status vcnMeta.Status
+	// platformManifest is the name of the platform manifest listing the
+	// asset, if it is a platform variant, and platform its <OS>/<arch> (see
+	// PlatformManifestOptions)
+	platformManifest string
+	platform         string
+	// ledgerName is the name the asset is notarized with, if other than its
+	// own one (see AssetNameOptions)
+	ledgerName string
+}
+
+// ledgerAsset is the processing of an asset into one of its ledgers.
+type ledgerAsset struct {
+	// index is the one of the asset in the assets list
+	index    int
+	ledgerID string
+}
+
+// unitsOfAsset returns the indexes of the units of the given asset.
+func unitsOfAsset(units []ledgerAsset, index int) []int {
+	var assetUnits []int
+	for u, unit := range units {
+		if unit.index == index {
+			assetUnits = append(assetUnits, u)
+		}
+	}
+	return assetUnits
+}
+
+// The selections of the release assets to process (see Config.AssetSelection).
+const (
+	// AssetsAll selects both the uploaded assets and the source code archives.
+	AssetsAll = "all"
+	// AssetsUploadedOnly selects the uploaded assets, skipping the source
+	// code archives (zipball and tarball) GitHub generates for the release.
+	AssetsUploadedOnly = "uploaded-only"
+	// AssetsSourceOnly selects the source code archives, skipping the
+	// uploaded assets.
+	AssetsSourceOnly = "source-only"
+)
+
+// ParseAssetSelection parses a selection of the release assets, i.e. all,
+// uploaded-only or source-only (case insensitive, all if empty).
+func ParseAssetSelection(selection string) (string, error) {
+	selection = strings.ToLower(strings.TrimSpace(selection))
+	switch selection {
+	case "":
+		return AssetsAll, nil
+	case AssetsAll, AssetsUploadedOnly, AssetsSourceOnly:
+		return selection, nil
+	default:
+		return "", fmt.Errorf("invalid asset selection %s: must be one of %s, %s or %s",
+			selection, AssetsAll, AssetsUploadedOnly, AssetsSourceOnly)
+	}
+}
+
+// ParsePatterns parses a list of glob patterns (see path.Match) separated by
+// commas or new lines, ignoring the empty ones.
+func ParsePatterns(list string) ([]string, error) {
+	var patterns []string
+	for _, pattern := range strings.FieldsFunc(list, func(r rune) bool {
+		return r == ',' || r == '\n'
+	}) {
+		pattern = strings.TrimSpace(pattern)
+		if len(pattern) == 0 {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %s: %v", pattern, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+func matchesAny(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// filterAssets returns the assets whose names match at least one of the
+// include patterns (if any) and none of the exclude patterns.
+func filterAssets(
+	assets []*releaseAsset,
+	include []string,
+	exclude []string,
+	log Logger,
+) []*releaseAsset {
+
+	filtered := make([]*releaseAsset, 0, len(assets))
+	for _, asset := range assets {
+		if len(include) > 0 && !matchesAny(asset.name, include) {
+			log.Infof("Skipping asset %s: it does not match any include pattern\n", asset.name)
+			continue
+		}
+		if matchesAny(asset.name, exclude) {
+			log.Infof("Skipping asset %s: it matches an exclude pattern\n", asset.name)
+			continue
+		}
+		filtered = append(filtered, asset)
+	}
+	return filtered
+}
+
+// localAssets returns the assets of the local files matching the given glob
+// patterns (see filepath.Glob). The patterns prefixed with dir:// match
+// directories instead, each of which is a single artifact (as vcn notarizes
+// dir:// artifacts, i.e. by the digest of the manifest of its files).
+func localAssets(patterns []string, signerID string, log Logger) ([]*releaseAsset, error) {
+	var assets []*releaseAsset
+	names := make(map[string]string)
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, vcnDirExtractor.Scheme+"://") {
+			dirAssets, err := localDirAssets(
+				strings.TrimPrefix(pattern, vcnDirExtractor.Scheme+"://"), signerID, names, log)
+			if err != nil {
+				return nil, err
+			}
+			assets = append(assets, dirAssets...)
+			continue
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid local paths pattern %s: %v", pattern, err)
+		}
+		if len(matches) == 0 {
+			log.Warningf("WARNING: no local file matches %s\n", pattern)
+		}
+		for _, match := range matches {
+			if info, err := os.Stat(match); err != nil {
+				return nil, fmt.Errorf("error reading local file %s: %v", match, err)
+			} else if info.IsDir() {
+				continue
+			}
+			name := filepath.Base(match)
+			if previous, ok := names[name]; ok {
+				if previous == match {
+					continue
+				}
+				return nil, fmt.Errorf("local files %s and %s have the same name", previous, match)
+			}
+			names[name] = match
+			assets = append(assets, &releaseAsset{name: name, signerID: signerID, filePath: match})
+		}
+	}
+	return assets, nil
+}
+
+// localDirAssets returns the assets of the local directories matching the
+// given glob pattern, named dir://<directory name>.
+func localDirAssets(
+	pattern string,
+	signerID string,
+	names map[string]string,
+	log Logger,
+) ([]*releaseAsset, error) {
+
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid local paths pattern %s: %v", pattern, err)
+	}
+	var assets []*releaseAsset
+	for _, match := range matches {
+		if info, err := os.Stat(match); err != nil {
+			return nil, fmt.Errorf("error reading local directory %s: %v", match, err)
+		} else if !info.IsDir() {
+			continue
+		}
+		dirURI, err := vcnURI.Parse(vcnDirExtractor.Scheme + "://" + match)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing URI from local directory %s: %v", match, err)
+		}
+		artifacts, err := vcnDirExtractor.Artifact(dirURI)
+		if err != nil {
+			return nil, fmt.Errorf("error creating vcn artifact from local directory %s: %v",
+				match, err)
+		}
+		artifact := artifacts[0]
+		artifact.Name = vcnDirExtractor.Scheme + "://" + artifact.Name
+		if previous, ok := names[artifact.Name]; ok {
+			if previous == match {
+				continue
+			}
+			return nil, fmt.Errorf("local directories %s and %s have the same name", previous, match)
+		}
+		names[artifact.Name] = match
+		assets = append(assets, &releaseAsset{
+			name:     artifact.Name,
+			signerID: signerID,
+			artifact: artifact,
+		})
+	}
+	if len(assets) == 0 {
+		log.Warningf("WARNING: no local directory matches %s\n", pattern)
+	}
+	return assets, nil
+}
+
+// forEachParallel calls f for each index in [0, n), running at most
+// maxParallel calls at the same time, and returns their errors (indexed like
+// the calls). If stopOnError is set, no more calls are started after the
+// first error.
+func forEachParallel(n int, maxParallel int, stopOnError bool, f func(i int) error) []error {
+	if maxParallel < 1 {
+		maxParallel = 1
+	}
+
+	errs := make([]error, n)
+	var failed int32
+	slots := make(chan struct{}, maxParallel)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		slots <- struct{}{}
+		if stopOnError && atomic.LoadInt32(&failed) > 0 {
+			break
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			if errs[i] = f(i); errs[i] != nil {
+				atomic.StoreInt32(&failed, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	return errs
+}
+
+// duplicateKey identifies the artifacts signed into the same ledger entry.
+type duplicateKey struct {
+	notarizer Notarizer
+	signerID  string
+	status    vcnMeta.Status
+	kind      string
+	hash      string
+}
+
+// duplicateUnits returns, for each unit, the first unit whose artifact is
+// identical (i.e. of the same kind and hash, e.g. the same installer under two
+// names) and is to be signed with the same status by the same signer into the
+// same ledger, which is the unit itself if there is none. The units without
+// artifact (i.e. whose artifact creation failed) are never duplicates.
+func duplicateUnits(
+	artifacts []*vcnAPI.Artifact,
+	unitsAssets []*releaseAsset,
+	notarizers []Notarizer,
+) []int {
+
+	originalUnits := make([]int, len(artifacts))
+	firstUnits := make(map[duplicateKey]int, len(artifacts))
+	for u, artifact := range artifacts {
+		originalUnits[u] = u
+		if artifact == nil {
+			continue
+		}
+		key := duplicateKey{
+			notarizer: notarizers[u],
+			signerID:  unitsAssets[u].signerID,
+			status:    unitsAssets[u].status,
+			kind:      artifact.Kind,
+			hash:      artifact.Hash,
+		}
+		if first, ok := firstUnits[key]; ok {
+			originalUnits[u] = first
+		} else {
+			firstUnits[key] = u
+		}
+	}
+	return originalUnits
+}
+
+// assetsError returns nil if there are no errors, the error itself if there
+// is only one, otherwise an error listing all of them per asset.
+func assetsError(assets []*releaseAsset, errs []error) error {
+	var msgs []string
+	var firstErr error
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		msgs = append(msgs, fmt.Sprintf("%s: %v", assets[i].name, err))
+	}
+	if len(msgs) <= 1 {
+		return firstErr
+	}
+	// the failure class is the one of the first failed asset
+	return classify(classOf(firstErr), fmt.Errorf("%d assets failed: %s", len(msgs), strings.Join(msgs, "; ")))
+}
+
+// isFileKind tells whether the assets of the given vcn artifact kind are files
+// (as opposed to e.g. git objects, container images, directories and Helm
+// charts in OCI registries).
+func isFileKind(kind string) bool {
+	return kind != vcnGitExtractor.Scheme && kind != vcnDockerExtractor.Scheme &&
+		kind != vcnDirExtractor.Scheme && kind != helmOCIKind
+}
@@ -0,0 +1,32 @@
+package notarize
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// writeFileAtomically writes the given content into the given file (creating
+// its directory if needed) by renaming a temp file written next to it, so
+// that the file is never read half-written.
+func writeFileAtomically(filePath string, content []byte) error {
+	dir := filepath.Dir(filePath)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	tmpFile, err := os.CreateTemp(dir, "."+filepath.Base(filePath)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write(content); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpFile.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), filePath)
+}
@@ -0,0 +1,110 @@
+package notarize
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/codenotary/notarize-release-assets-action/pkg/github"
+)
+
+// cosignAttestBlob attests the blob of the given name and SHA-256 hash with
+// the given in-toto predicate, using cosign (which must be in the PATH) with
+// the OIDC identity token of the workflow, and writes the resulting Sigstore
+// bundle into the given file. The blob itself is not needed.
+func cosignAttestBlob(
+	ctx context.Context,
+	name string,
+	hash string,
+	predicateType string,
+	predicatePath string,
+	bundlePath string,
+) error {
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "cosign", "attest-blob", "--yes", "--new-bundle-format",
+		"--hash", hash, "--type", predicateType, "--predicate", predicatePath,
+		"--bundle", bundlePath, name)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("error attesting %s with cosign: %v: %s",
+			name, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// createAttestations creates a GitHub artifact attestation of each notarized
+// file asset, i.e. its provenance statement signed with a keyless Sigstore
+// signature tied to the workflow identity, and records its ID in the asset
+// reports.
+func createAttestations(
+	ctx context.Context,
+	httpClient *http.Client,
+	apiBaseURL string,
+	githubToken string,
+	dir string,
+	options *ProvenanceOptions,
+	report *Report,
+	tagCommitSHA string,
+	log Logger,
+) error {
+
+	attestationIDs := make(map[string]int64)
+	for _, assetReport := range report.Assets {
+		// the assets notarized into multiple ledgers get a single attestation
+		if !isFileKind(assetReport.Kind) || len(assetReport.Error) > 0 {
+			continue
+		}
+		if _, ok := attestationIDs[assetReport.Name]; ok {
+			continue
+		}
+
+		log.Infof("Attesting asset %s ...\n", assetReport.Name)
+		statement := provenanceStatement(options, report, assetReport, tagCommitSHA)
+		predicate, err := json.Marshal(statement.Predicate)
+		if err != nil {
+			return fmt.Errorf("error JSON-marshaling the provenance of asset %s: %v",
+				assetReport.Name, err)
+		}
+		predicatePath := filepath.Join(dir, assetReport.Name+".predicate.json")
+		if err := os.WriteFile(predicatePath, predicate, 0644); err != nil {
+			return fmt.Errorf("error writing provenance predicate file %s: %v", predicatePath, err)
+		}
+		bundlePath := filepath.Join(dir, assetReport.Name+".sigstore.json")
+		if err := cosignAttestBlob(ctx, assetReport.Name, assetReport.sha256Hash(),
+			statement.PredicateType, predicatePath, bundlePath); err != nil {
+			return err
+		}
+		bundle, err := os.ReadFile(bundlePath)
+		if err != nil {
+			return fmt.Errorf("error reading Sigstore bundle file %s: %v", bundlePath, err)
+		}
+
+		attestationID, err := github.CreateAttestation(
+			ctx, httpClient, apiBaseURL, report.Repository, githubToken, bundle)
+		if err != nil {
+			return fmt.Errorf("error creating the attestation of asset %s: %v",
+				assetReport.Name, err)
+		}
+		attestationIDs[assetReport.Name] = attestationID
+		if len(options.ServerURL) > 0 {
+			log.Successf("Successfully attested asset %s: %s/%s/attestations/%d\n",
+				assetReport.Name, strings.TrimSuffix(options.ServerURL, "/"), report.Repository,
+				attestationID)
+		} else {
+			log.Successf("Successfully attested asset %s (attestation %d)\n",
+				assetReport.Name, attestationID)
+		}
+	}
+
+	for _, assetReport := range report.Assets {
+		assetReport.AttestationID = attestationIDs[assetReport.Name]
+	}
+	return nil
+}
@@ -0,0 +1,918 @@
+package notarize
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"debug/pe"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+
+	// the digest algorithms of the Authenticode signatures
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+var (
+	oidSignedData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidSpcIndirectData     = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 4}
+	oidTSTInfo             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+	oidAttrMessageDigest   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidAttrSigningTime     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidAttrCounterSign     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 6}
+	oidAttrRFC3161         = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 3, 3, 1}
+	oidDigestSHA1          = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidDigestSHA256        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidDigestSHA384        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidDigestSHA512        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+	oidEncryptionRSA       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidEncryptionECDSA     = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	oidSignatureSHA1RSA    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 5}
+	oidSignatureSHA256RSA  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+	oidSignatureSHA384RSA  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}
+	oidSignatureSHA512RSA  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 13}
+	oidSignatureSHA256ECDS = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidSignatureSHA384ECDS = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}
+	oidSignatureSHA512ECDS = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}
+)
+
+// digestAlgorithms maps the OIDs of the digest algorithms to their hash.
+var digestAlgorithms = map[string]crypto.Hash{
+	oidDigestSHA1.String():   crypto.SHA1,
+	oidDigestSHA256.String(): crypto.SHA256,
+	oidDigestSHA384.String(): crypto.SHA384,
+	oidDigestSHA512.String(): crypto.SHA512,
+}
+
+// signatureAlgorithms maps the hashes and the OIDs of the signature
+// algorithms of the PKCS#7 signers (either a public key algorithm or a
+// combined one) to the x509 signature algorithms.
+var signatureAlgorithms = map[crypto.Hash]map[string]x509.SignatureAlgorithm{
+	crypto.SHA1: {
+		oidEncryptionRSA.String():    x509.SHA1WithRSA,
+		oidSignatureSHA1RSA.String(): x509.SHA1WithRSA,
+		oidEncryptionECDSA.String():  x509.ECDSAWithSHA1,
+	},
+	crypto.SHA256: {
+		oidEncryptionRSA.String():       x509.SHA256WithRSA,
+		oidSignatureSHA256RSA.String():  x509.SHA256WithRSA,
+		oidEncryptionECDSA.String():     x509.ECDSAWithSHA256,
+		oidSignatureSHA256ECDS.String(): x509.ECDSAWithSHA256,
+	},
+	crypto.SHA384: {
+		oidEncryptionRSA.String():       x509.SHA384WithRSA,
+		oidSignatureSHA384RSA.String():  x509.SHA384WithRSA,
+		oidEncryptionECDSA.String():     x509.ECDSAWithSHA384,
+		oidSignatureSHA384ECDS.String(): x509.ECDSAWithSHA384,
+	},
+	crypto.SHA512: {
+		oidEncryptionRSA.String():       x509.SHA512WithRSA,
+		oidSignatureSHA512RSA.String():  x509.SHA512WithRSA,
+		oidEncryptionECDSA.String():     x509.ECDSAWithSHA512,
+		oidSignatureSHA512ECDS.String(): x509.ECDSAWithSHA512,
+	},
+}
+
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      pkcs7ContentInfo
+	Certificates     asn1.RawValue     `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue     `asn1:"optional,tag:1"`
+	SignerInfos      []pkcs7SignerInfo `asn1:"set"`
+}
+
+type pkcs7SignerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     pkcs7IssuerAndSerial
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+	UnauthenticatedAttributes asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type pkcs7IssuerAndSerial struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type pkcs7Attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+// spcIndirectDataContent is the signed content of the Authenticode
+// signatures, holding the digest of the signed file.
+type spcIndirectDataContent struct {
+	Data          asn1.RawValue
+	MessageDigest struct {
+		DigestAlgorithm pkix.AlgorithmIdentifier
+		Digest          []byte
+	}
+}
+
+// tstInfo is the signed content of the RFC 3161 timestamps.
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint struct {
+		HashAlgorithm pkix.AlgorithmIdentifier
+		HashedMessage []byte
+	}
+	SerialNumber *big.Int
+	GenTime      time.Time `asn1:"generalized"`
+}
+
+// authenticodeSignature is a parsed Authenticode signature, i.e. a PKCS#7
+// SignedData of an SpcIndirectDataContent.
+type authenticodeSignature struct {
+	signedData   pkcs7SignedData
+	certificates []*x509.Certificate
+	// content is the SpcIndirectDataContent
+	content spcIndirectDataContent
+	// contentBytes are the signed bytes of the content, i.e. its DER
+	// encoding without its tag and length
+	contentBytes []byte
+	hash         crypto.Hash
+}
+
+// authenticodeVerifier verifies the Authenticode signatures of the Windows
+// executables and installers (i.e. .exe and .msi assets) before they are
+// notarized.
+type authenticodeVerifier struct {
+	// subject is the expected common name or distinguished name of the
+	// signer, if any
+	subject string
+	// roots are the trusted root certificates, the system ones if nil
+	roots *x509.CertPool
+
+	mu      sync.Mutex
+	results map[string]error
+}
+
+func newAuthenticodeVerifier(subject string) *authenticodeVerifier {
+	return &authenticodeVerifier{
+		subject: strings.TrimSpace(subject),
+		results: make(map[string]error),
+	}
+}
+
+// isAuthenticodeFileName tells if the given asset is a Windows executable or
+// installer which must carry an Authenticode signature.
+func isAuthenticodeFileName(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".exe", ".msi":
+		return true
+	}
+	return false
+}
+
+// needsFile returns whether the given asset must be downloaded (even when
+// streaming the assets) to verify its Authenticode signature.
+func (v *authenticodeVerifier) needsFile(name string) bool {
+	return isAuthenticodeFileName(name)
+}
+
+// check verifies the Authenticode signature of the given asset file, if it is
+// a Windows executable or installer.
+func (v *authenticodeVerifier) check(asset *releaseAsset, filePath string) error {
+	if !isAuthenticodeFileName(asset.name) {
+		return nil
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if err, ok := v.results[asset.name]; ok {
+		return err
+	}
+	var err error
+	if len(filePath) == 0 {
+		err = fmt.Errorf("asset %s must be downloaded for verifying its Authenticode signature",
+			asset.name)
+	} else if signer, verr := v.verify(filePath); verr != nil {
+		err = fmt.Errorf("refusing to notarize asset %s: %v", asset.name, verr)
+	} else if len(v.subject) > 0 && !matchesSubject(signer, v.subject) {
+		err = fmt.Errorf("refusing to notarize asset %s: it is signed by %s instead of %s",
+			asset.name, signer.Subject, v.subject)
+	}
+	v.results[asset.name] = err
+	return err
+}
+
+// matchesSubject tells if the common name or the whole distinguished name of
+// the given certificate is the given subject.
+func matchesSubject(cert *x509.Certificate, subject string) bool {
+	return cert.Subject.CommonName == subject || cert.Subject.String() == subject
+}
+
+// verify verifies the Authenticode signature of the given PE (i.e. .exe) or
+// MSI file and returns the certificate of its signer.
+func (v *authenticodeVerifier) verify(filePath string) (*x509.Certificate, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s: %v", filePath, err)
+	}
+	defer f.Close()
+
+	magic := make([]byte, 8)
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return nil, errors.New("it is neither a PE nor an MSI file")
+	}
+	var signature *authenticodeSignature
+	var digest []byte
+	switch {
+	case bytes.Equal(magic[:2], []byte("MZ")):
+		signature, digest, err = peAuthenticode(f)
+	case bytes.Equal(magic, cfbSignature):
+		signature, digest, err = msiAuthenticode(f)
+	default:
+		return nil, errors.New("it is neither a PE nor an MSI file")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(digest, signature.content.MessageDigest.Digest) {
+		return nil, errors.New("its Authenticode signature does not match its content")
+	}
+
+	signerInfo := &signature.signedData.SignerInfos[0]
+	signer, err := verifySignerInfo(signerInfo, signature.certificates, signature.contentBytes)
+	if err != nil {
+		return nil, fmt.Errorf("its Authenticode signature is invalid: %v", err)
+	}
+
+	// the signing certificate must have been valid when the file was signed,
+	// i.e. at the time of the timestamp, if any
+	signingTime := time.Now()
+	timestamp, err := signature.timestamp(signerInfo, v.roots)
+	if err != nil {
+		return nil, fmt.Errorf("the timestamp of its Authenticode signature is invalid: %v", err)
+	}
+	if !timestamp.IsZero() {
+		signingTime = timestamp
+	}
+	if err := verifyChain(
+		signer, signature.certificates, v.roots, x509.ExtKeyUsageCodeSigning, signingTime); err != nil {
+		return nil, fmt.Errorf("the certificate of its Authenticode signer %s is not trusted: %v",
+			signer.Subject, err)
+	}
+	return signer, nil
+}
+
+// verifyChain verifies the certificate chain of the given certificate, up to
+// the given roots (or the system ones if nil), for the given usage at the
+// given time.
+func verifyChain(
+	cert *x509.Certificate,
+	certificates []*x509.Certificate,
+	roots *x509.CertPool,
+	usage x509.ExtKeyUsage,
+	at time.Time,
+) error {
+
+	intermediates := x509.NewCertPool()
+	for _, c := range certificates {
+		if c != cert {
+			intermediates.AddCert(c)
+		}
+	}
+	_, err := cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+		CurrentTime:   at,
+	})
+	return err
+}
+
+// parseAuthenticodeSignature parses the PKCS#7 SignedData of an Authenticode
+// signature.
+func parseAuthenticodeSignature(der []byte) (*authenticodeSignature, error) {
+	signedData, certificates, err := parseSignedData(der)
+	if err != nil {
+		return nil, err
+	}
+	if !signedData.ContentInfo.ContentType.Equal(oidSpcIndirectData) {
+		return nil, fmt.Errorf("unexpected content type %s", signedData.ContentInfo.ContentType)
+	}
+	if len(signedData.SignerInfos) != 1 {
+		return nil, fmt.Errorf("%d signers instead of one", len(signedData.SignerInfos))
+	}
+	signature := &authenticodeSignature{
+		signedData:   *signedData,
+		certificates: certificates,
+	}
+	// the raw values of the explicitly tagged contents include their tag
+	var content asn1.RawValue
+	if _, err := asn1.Unmarshal(signedData.ContentInfo.Content.Bytes, &content); err != nil {
+		return nil, fmt.Errorf("error parsing the signed content: %v", err)
+	}
+	signature.contentBytes = content.Bytes
+	if _, err := asn1.Unmarshal(content.FullBytes, &signature.content); err != nil {
+		return nil, fmt.Errorf("error parsing the signed content: %v", err)
+	}
+	var ok bool
+	signature.hash, ok = digestAlgorithms[signature.content.MessageDigest.DigestAlgorithm.Algorithm.String()]
+	if !ok {
+		return nil, fmt.Errorf("unsupported digest algorithm %s",
+			signature.content.MessageDigest.DigestAlgorithm.Algorithm)
+	}
+	return signature, nil
+}
+
+func parseSignedData(der []byte) (*pkcs7SignedData, []*x509.Certificate, error) {
+	var contentInfo pkcs7ContentInfo
+	if _, err := asn1.Unmarshal(der, &contentInfo); err != nil {
+		return nil, nil, fmt.Errorf("error parsing the PKCS#7 signature: %v", err)
+	}
+	if !contentInfo.ContentType.Equal(oidSignedData) {
+		return nil, nil, fmt.Errorf("unexpected PKCS#7 content type %s", contentInfo.ContentType)
+	}
+	var signedData pkcs7SignedData
+	if _, err := asn1.Unmarshal(contentInfo.Content.Bytes, &signedData); err != nil {
+		return nil, nil, fmt.Errorf("error parsing the PKCS#7 signed data: %v", err)
+	}
+	certificates, err := x509.ParseCertificates(signedData.Certificates.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing the certificates of the PKCS#7 signature: %v", err)
+	}
+	return &signedData, certificates, nil
+}
+
+// verifySignerInfo verifies that the given PKCS#7 signer signed the given
+// content, i.e. that the message digest of its authenticated attributes is the
+// hash of the content and that it signed its authenticated attributes, and
+// returns its certificate.
+func verifySignerInfo(
+	signerInfo *pkcs7SignerInfo,
+	certificates []*x509.Certificate,
+	content []byte,
+) (*x509.Certificate, error) {
+
+	var signer *x509.Certificate
+	for _, cert := range certificates {
+		if bytes.Equal(cert.RawIssuer, signerInfo.IssuerAndSerialNumber.Issuer.FullBytes) &&
+			cert.SerialNumber.Cmp(signerInfo.IssuerAndSerialNumber.SerialNumber) == 0 {
+			signer = cert
+		}
+	}
+	if signer == nil {
+		return nil, errors.New("no certificate of the signer")
+	}
+
+	hash, ok := digestAlgorithms[signerInfo.DigestAlgorithm.Algorithm.String()]
+	if !ok {
+		return nil, fmt.Errorf("unsupported digest algorithm %s", signerInfo.DigestAlgorithm.Algorithm)
+	}
+	algorithm, ok := signatureAlgorithms[hash][signerInfo.DigestEncryptionAlgorithm.Algorithm.String()]
+	if !ok {
+		return nil, fmt.Errorf("unsupported signature algorithm %s",
+			signerInfo.DigestEncryptionAlgorithm.Algorithm)
+	}
+	if len(signerInfo.AuthenticatedAttributes.FullBytes) == 0 {
+		return nil, errors.New("no authenticated attributes")
+	}
+	// the authenticated attributes are signed as a SET instead of their
+	// implicit [0] tag
+	signed := append([]byte{0x31}, signerInfo.AuthenticatedAttributes.FullBytes[1:]...)
+	messageDigest, err := attributeValue(signed, oidAttrMessageDigest)
+	if err != nil {
+		return nil, err
+	}
+	var expected []byte
+	if _, err := asn1.Unmarshal(messageDigest, &expected); err != nil {
+		return nil, fmt.Errorf("error parsing the message digest: %v", err)
+	}
+	h := hash.New()
+	h.Write(content)
+	if !bytes.Equal(h.Sum(nil), expected) {
+		return nil, errors.New("the message digest does not match the signed content")
+	}
+	if err := signer.CheckSignature(algorithm, signed, signerInfo.EncryptedDigest); err != nil {
+		return nil, err
+	}
+	return signer, nil
+}
+
+// attributeValue returns the DER encoding of the (first) value of the given
+// attribute in the given SET of PKCS#7 attributes.
+func attributeValue(attributesSet []byte, oid asn1.ObjectIdentifier) ([]byte, error) {
+	var attributes []pkcs7Attribute
+	if _, err := asn1.UnmarshalWithParams(attributesSet, &attributes, "set"); err != nil {
+		return nil, fmt.Errorf("error parsing the PKCS#7 attributes: %v", err)
+	}
+	for _, attribute := range attributes {
+		if attribute.Type.Equal(oid) && len(attribute.Values) > 0 {
+			return attribute.Values[0].FullBytes, nil
+		}
+	}
+	return nil, fmt.Errorf("no %s attribute", oid)
+}
+
+// timestamp returns the verified time of the timestamp countersigning the
+// given signer, either an RFC 3161 timestamp or a legacy Authenticode
+// countersignature, if any.
+func (s *authenticodeSignature) timestamp(
+	signerInfo *pkcs7SignerInfo,
+	roots *x509.CertPool,
+) (time.Time, error) {
+
+	if len(signerInfo.UnauthenticatedAttributes.FullBytes) == 0 {
+		return time.Time{}, nil
+	}
+	attributes := append([]byte{0x31}, signerInfo.UnauthenticatedAttributes.FullBytes[1:]...)
+
+	if token, err := attributeValue(attributes, oidAttrRFC3161); err == nil {
+		signedData, certificates, err := parseSignedData(token)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if !signedData.ContentInfo.ContentType.Equal(oidTSTInfo) || len(signedData.SignerInfos) != 1 {
+			return time.Time{}, errors.New("not an RFC 3161 timestamp token")
+		}
+		// the TSTInfo is wrapped in an OCTET STRING
+		var content []byte
+		if _, err := asn1.Unmarshal(signedData.ContentInfo.Content.Bytes, &content); err != nil {
+			return time.Time{}, fmt.Errorf("error parsing the timestamp token: %v", err)
+		}
+		var info tstInfo
+		if _, err := asn1.Unmarshal(content, &info); err != nil {
+			return time.Time{}, fmt.Errorf("error parsing the timestamp token: %v", err)
+		}
+		if err := checkImprint(info.MessageImprint.HashAlgorithm.Algorithm,
+			info.MessageImprint.HashedMessage, signerInfo.EncryptedDigest); err != nil {
+			return time.Time{}, err
+		}
+		tsa, err := verifySignerInfo(&signedData.SignerInfos[0], certificates, content)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return info.GenTime, verifyTimestamper(tsa, certificates, roots, info.GenTime)
+	}
+
+	counterSignature, err := attributeValue(attributes, oidAttrCounterSign)
+	if err != nil {
+		return time.Time{}, nil
+	}
+	var counterSigner pkcs7SignerInfo
+	if _, err := asn1.Unmarshal(counterSignature, &counterSigner); err != nil {
+		return time.Time{}, fmt.Errorf("error parsing the countersignature: %v", err)
+	}
+	tsa, err := verifySignerInfo(&counterSigner, s.certificates, signerInfo.EncryptedDigest)
+	if err != nil {
+		return time.Time{}, err
+	}
+	rawSigningTime, err := attributeValue(
+		append([]byte{0x31}, counterSigner.AuthenticatedAttributes.FullBytes[1:]...), oidAttrSigningTime)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var signingTime time.Time
+	if _, err := asn1.Unmarshal(rawSigningTime, &signingTime); err != nil {
+		return time.Time{}, fmt.Errorf("error parsing the signing time of the countersignature: %v", err)
+	}
+	return signingTime, verifyTimestamper(tsa, s.certificates, roots, signingTime)
+}
+
+// checkImprint checks that the given hash is the hash of the given message.
+func checkImprint(algorithm asn1.ObjectIdentifier, hashed []byte, message []byte) error {
+	hash, ok := digestAlgorithms[algorithm.String()]
+	if !ok {
+		return fmt.Errorf("unsupported digest algorithm %s", algorithm)
+	}
+	h := hash.New()
+	h.Write(message)
+	if !bytes.Equal(h.Sum(nil), hashed) {
+		return errors.New("the timestamp is not the one of the signature")
+	}
+	return nil
+}
+
+// verifyTimestamper verifies the certificate chain of the time-stamping
+// authority at the time of the timestamp.
+func verifyTimestamper(
+	tsa *x509.Certificate,
+	certificates []*x509.Certificate,
+	roots *x509.CertPool,
+	at time.Time,
+) error {
+
+	if err := verifyChain(tsa, certificates, roots, x509.ExtKeyUsageTimeStamping, at); err != nil {
+		return fmt.Errorf("the certificate of the time-stamping authority %s is not trusted: %v",
+			tsa.Subject, err)
+	}
+	return nil
+}
+
+// winCertTypePKCSSignedData is the type of the WIN_CERTIFICATE entries of
+// the PE files holding an Authenticode signature.
+const winCertTypePKCSSignedData = 0x0002
+
+// peAuthenticode returns the Authenticode signature of the given PE file, from
+// its certificate table, along with the Authenticode hash of the file, i.e.
+// the hash of the file except its checksum, its certificate table entry and
+// its certificate table.
+func peAuthenticode(f *os.File) (*authenticodeSignature, []byte, error) {
+	peFile, err := pe.NewFile(f)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing the PE file: %v", err)
+	}
+	var certTable pe.DataDirectory
+	var dataDirectoriesOffset int64
+	// the optional header follows the PE signature and the file header
+	peOffset := make([]byte, 4)
+	if _, err := f.ReadAt(peOffset, 0x3c); err != nil {
+		return nil, nil, fmt.Errorf("error reading the PE file: %v", err)
+	}
+	optionalHeaderOffset := int64(binary.LittleEndian.Uint32(peOffset)) + 4 + 20
+	switch header := peFile.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		if header.NumberOfRvaAndSizes > pe.IMAGE_DIRECTORY_ENTRY_SECURITY {
+			certTable = header.DataDirectory[pe.IMAGE_DIRECTORY_ENTRY_SECURITY]
+		}
+		dataDirectoriesOffset = optionalHeaderOffset + 96
+	case *pe.OptionalHeader64:
+		if header.NumberOfRvaAndSizes > pe.IMAGE_DIRECTORY_ENTRY_SECURITY {
+			certTable = header.DataDirectory[pe.IMAGE_DIRECTORY_ENTRY_SECURITY]
+		}
+		dataDirectoriesOffset = optionalHeaderOffset + 112
+	default:
+		return nil, nil, errors.New("the PE file has no optional header")
+	}
+	if certTable.Size == 0 {
+		return nil, nil, errors.New("it has no Authenticode signature")
+	}
+
+	// the virtual address of the certificate table is a file offset, which
+	// must follow the headers hashed before it, and the table must fit in the
+	// file, since its size is not trusted before allocating it
+	checksumOffset := optionalHeaderOffset + 64
+	certEntryOffset := dataDirectoriesOffset + pe.IMAGE_DIRECTORY_ENTRY_SECURITY*8
+	info, err := f.Stat()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading the PE file: %v", err)
+	}
+	tableOffset := int64(certTable.VirtualAddress)
+	if tableOffset < certEntryOffset+8 || int64(certTable.Size) > info.Size()-tableOffset {
+		return nil, nil, fmt.Errorf("malformed PE file: the certificate table (offset %d, size %d) "+
+			"does not fit between the headers and the end of the file (size %d)",
+			tableOffset, certTable.Size, info.Size())
+	}
+	if certTable.Size < 8 {
+		return nil, nil, errors.New("the certificate table is truncated")
+	}
+	table := make([]byte, certTable.Size)
+	if _, err := f.ReadAt(table, tableOffset); err != nil {
+		return nil, nil, fmt.Errorf("error reading the certificate table: %v", err)
+	}
+	length := binary.LittleEndian.Uint32(table[0:4])
+	certType := binary.LittleEndian.Uint16(table[6:8])
+	if certType != winCertTypePKCSSignedData || length < 8 || int(length) > len(table) {
+		return nil, nil, errors.New("it has no Authenticode signature")
+	}
+	signature, err := parseAuthenticodeSignature(table[8:length])
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing its Authenticode signature: %v", err)
+	}
+
+	h := signature.hash.New()
+	ranges := [][2]int64{
+		{0, checksumOffset},
+		{checksumOffset + 4, certEntryOffset},
+		{certEntryOffset + 8, tableOffset},
+	}
+	if end := tableOffset + int64(certTable.Size); end < info.Size() {
+		ranges = append(ranges, [2]int64{end, info.Size()})
+	}
+	for _, r := range ranges {
+		if _, err := io.Copy(h, io.NewSectionReader(f, r[0], r[1]-r[0])); err != nil {
+			return nil, nil, fmt.Errorf("error hashing the PE file: %v", err)
+		}
+	}
+	return signature, h.Sum(nil), nil
+}
+
+// cfbSignature is the signature of the OLE compound files, e.g. the MSI
+// installers.
+var cfbSignature = []byte{0xd0, 0xcf, 0x11, 0xe0, 0xa1, 0xb1, 0x1a, 0xe1}
+
+const (
+	cfbEndOfChain    = 0xfffffffe
+	cfbNoStream      = 0xffffffff
+	cfbTypeStorage   = 1
+	cfbTypeStream    = 2
+	cfbTypeRoot      = 5
+	cfbDirEntrySize  = 128
+	cfbHeaderDIFATs  = 109
+	cfbMaxSectorSize = 1 << 12
+)
+
+// the names of the streams of the MSI files holding their Authenticode
+// signature, which are not hashed
+const (
+	msiDigitalSignature   = "\x05DigitalSignature"
+	msiDigitalSignatureEx = "\x05MsiDigitalSignatureEx"
+)
+
+// cfbEntry is an entry of the directory of an OLE compound file.
+type cfbEntry struct {
+	// rawName is the UTF-16LE name, including its NUL terminator
+	rawName     []byte
+	name        string
+	entryType   byte
+	left, right uint32
+	child       uint32
+	clsid       []byte
+	start       uint32
+	size        uint64
+}
+
+// cfbFile is a read-only OLE compound file (a.k.a. structured storage).
+type cfbFile struct {
+	r               io.ReaderAt
+	sectorSize      int64
+	miniSectorSize  int64
+	miniStreamLimit uint64
+	fat             []uint32
+	miniFAT         []uint32
+	entries         []*cfbEntry
+	miniStream      []byte
+}
+
+func openCFB(r io.ReaderAt) (*cfbFile, error) {
+	header := make([]byte, 512)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil, fmt.Errorf("error reading the compound file header: %v", err)
+	}
+	if !bytes.Equal(header[:8], cfbSignature) {
+		return nil, errors.New("not a compound file")
+	}
+	le := binary.LittleEndian
+	c := &cfbFile{
+		r:               r,
+		sectorSize:      1 << le.Uint16(header[0x1e:]),
+		miniSectorSize:  1 << le.Uint16(header[0x20:]),
+		miniStreamLimit: uint64(le.Uint32(header[0x38:])),
+	}
+	if c.sectorSize != 512 && c.sectorSize != cfbMaxSectorSize {
+		return nil, fmt.Errorf("unsupported sector size %d", c.sectorSize)
+	}
+
+	// the sectors of the FAT are listed in the header, then in the DIFAT
+	// sectors
+	var fatSectors []uint32
+	for i := 0; i < cfbHeaderDIFATs; i++ {
+		fatSectors = append(fatSectors, le.Uint32(header[0x4c+4*i:]))
+	}
+	perSector := int(c.sectorSize / 4)
+	difatSector := le.Uint32(header[0x44:])
+	for n := le.Uint32(header[0x48:]); n > 0 && difatSector < cfbEndOfChain; n-- {
+		sector, err := c.sector(difatSector)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < perSector-1; i++ {
+			fatSectors = append(fatSectors, le.Uint32(sector[4*i:]))
+		}
+		difatSector = le.Uint32(sector[4*(perSector-1):])
+	}
+	nbFATSectors := int(le.Uint32(header[0x2c:]))
+	if nbFATSectors > len(fatSectors) {
+		return nil, errors.New("the compound file allocation table is truncated")
+	}
+	for _, s := range fatSectors[:nbFATSectors] {
+		sector, err := c.sector(s)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < perSector; i++ {
+			c.fat = append(c.fat, le.Uint32(sector[4*i:]))
+		}
+	}
+
+	directory, err := c.chain(le.Uint32(header[0x30:]), c.fat, c.sector)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the compound file directory: %v", err)
+	}
+	for i := 0; i+cfbDirEntrySize <= len(directory); i += cfbDirEntrySize {
+		raw := directory[i : i+cfbDirEntrySize]
+		nameLength := int(le.Uint16(raw[0x40:]))
+		if nameLength > 64 {
+			nameLength = 64
+		}
+		entry := &cfbEntry{
+			rawName:   raw[:nameLength],
+			entryType: raw[0x42],
+			left:      le.Uint32(raw[0x44:]),
+			right:     le.Uint32(raw[0x48:]),
+			child:     le.Uint32(raw[0x4c:]),
+			clsid:     raw[0x50:0x60],
+			start:     le.Uint32(raw[0x74:]),
+			size:      le.Uint64(raw[0x78:]),
+		}
+		if c.sectorSize == 512 {
+			// the high bits of the size are undefined in version 3 files
+			entry.size &= 0xffffffff
+		}
+		name := make([]uint16, 0, nameLength/2)
+		for j := 0; j+1 < nameLength; j += 2 {
+			if u := le.Uint16(entry.rawName[j:]); u != 0 {
+				name = append(name, u)
+			}
+		}
+		entry.name = string(utf16.Decode(name))
+		c.entries = append(c.entries, entry)
+	}
+	if len(c.entries) == 0 || c.entries[0].entryType != cfbTypeRoot {
+		return nil, errors.New("the compound file has no root entry")
+	}
+
+	miniFAT, err := c.chain(le.Uint32(header[0x3c:]), c.fat, c.sector)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the compound file mini allocation table: %v", err)
+	}
+	for i := 0; i+4 <= len(miniFAT); i += 4 {
+		c.miniFAT = append(c.miniFAT, le.Uint32(miniFAT[i:]))
+	}
+	root := c.entries[0]
+	c.miniStream, err = c.chain(root.start, c.fat, c.sector)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the compound file mini stream: %v", err)
+	}
+	return c, nil
+}
+
+func (c *cfbFile) sector(n uint32) ([]byte, error) {
+	sector := make([]byte, c.sectorSize)
+	if _, err := c.r.ReadAt(sector, (int64(n)+1)*c.sectorSize); err != nil {
+		return nil, fmt.Errorf("error reading sector %d of the compound file: %v", n, err)
+	}
+	return sector, nil
+}
+
+func (c *cfbFile) miniSector(n uint32) ([]byte, error) {
+	start := int64(n) * c.miniSectorSize
+	if start+c.miniSectorSize > int64(len(c.miniStream)) {
+		return nil, fmt.Errorf("mini sector %d is out of the compound file mini stream", n)
+	}
+	return c.miniStream[start : start+c.miniSectorSize], nil
+}
+
+// chain reads the chain of sectors starting at the given one in the given
+// allocation table.
+func (c *cfbFile) chain(
+	start uint32,
+	table []uint32,
+	read func(uint32) ([]byte, error),
+) ([]byte, error) {
+
+	var content []byte
+	for n, i := start, 0; n < cfbEndOfChain; n, i = table[n], i+1 {
+		if int(n) >= len(table) || i > len(table) {
+			return nil, errors.New("invalid sector chain")
+		}
+		sector, err := read(n)
+		if err != nil {
+			return nil, err
+		}
+		content = append(content, sector...)
+	}
+	return content, nil
+}
+
+// stream returns the content of the given stream entry.
+func (c *cfbFile) stream(entry *cfbEntry) ([]byte, error) {
+	var content []byte
+	var err error
+	if entry.size < c.miniStreamLimit {
+		content, err = c.chain(entry.start, c.miniFAT, c.miniSector)
+	} else {
+		content, err = c.chain(entry.start, c.fat, c.sector)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading stream %q: %v", entry.name, err)
+	}
+	if uint64(len(content)) < entry.size {
+		return nil, fmt.Errorf("stream %q is truncated", entry.name)
+	}
+	return content[:entry.size], nil
+}
+
+// children returns the entries of the given storage entry, i.e. the nodes of
+// the tree of siblings of its child.
+func (c *cfbFile) children(storage *cfbEntry) []*cfbEntry {
+	var children []*cfbEntry
+	visited := make(map[uint32]bool)
+	var walk func(uint32)
+	walk = func(n uint32) {
+		if n == cfbNoStream || int(n) >= len(c.entries) || visited[n] {
+			return
+		}
+		visited[n] = true
+		entry := c.entries[n]
+		walk(entry.left)
+		children = append(children, entry)
+		walk(entry.right)
+	}
+	walk(storage.child)
+	return children
+}
+
+// msiAuthenticode returns the Authenticode signature of the given MSI file,
+// from its DigitalSignature stream, along with the Authenticode hash of the
+// file, i.e. the hash of the content of its streams (but the signature ones)
+// and of the class IDs of its storages.
+func msiAuthenticode(f *os.File) (*authenticodeSignature, []byte, error) {
+	c, err := openCFB(f)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing the MSI file: %v", err)
+	}
+	root := c.entries[0]
+	var signatureEntry *cfbEntry
+	for _, entry := range c.children(root) {
+		switch entry.name {
+		case msiDigitalSignature:
+			signatureEntry = entry
+		case msiDigitalSignatureEx:
+			return nil, nil, errors.New("its extended (MsiDigitalSignatureEx) Authenticode " +
+				"signature is not supported")
+		}
+	}
+	if signatureEntry == nil {
+		return nil, nil, errors.New("it has no Authenticode signature")
+	}
+	content, err := c.stream(signatureEntry)
+	if err != nil {
+		return nil, nil, err
+	}
+	signature, err := parseAuthenticodeSignature(content)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing its Authenticode signature: %v", err)
+	}
+
+	h := signature.hash.New()
+	// a storage which is its own descendant would be hashed forever
+	visited := make(map[*cfbEntry]bool)
+	var hashStorage func(storage *cfbEntry, isRoot bool) error
+	hashStorage = func(storage *cfbEntry, isRoot bool) error {
+		if visited[storage] {
+			return errors.New("malformed compound file: its storages form a cycle")
+		}
+		visited[storage] = true
+		children := c.children(storage)
+		// the entries are hashed in the order of their raw UTF-16 names, the
+		// longer first when one is the prefix of the other
+		sort.Slice(children, func(i, j int) bool {
+			a, b := children[i].rawName, children[j].rawName
+			n := len(a)
+			if len(b) < n {
+				n = len(b)
+			}
+			if cmp := bytes.Compare(a[:n], b[:n]); cmp != 0 {
+				return cmp < 0
+			}
+			return len(a) > len(b)
+		})
+		for _, child := range children {
+			if isRoot && child.name == msiDigitalSignature {
+				continue
+			}
+			switch child.entryType {
+			case cfbTypeStream:
+				content, err := c.stream(child)
+				if err != nil {
+					return err
+				}
+				h.Write(content)
+			case cfbTypeStorage:
+				if err := hashStorage(child, false); err != nil {
+					return err
+				}
+			}
+		}
+		h.Write(storage.clsid)
+		return nil
+	}
+	if err := hashStorage(root, true); err != nil {
+		return nil, nil, fmt.Errorf("error hashing the MSI file: %v", err)
+	}
+	return signature, h.Sum(nil), nil
+}
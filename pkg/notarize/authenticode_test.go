@@ -0,0 +1,539 @@
+package notarize
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+// testSigner is a code signing certificate issued by a test root.
+type testSigner struct {
+	root *x509.Certificate
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestSigner(t *testing.T) *testSigner {
+	t.Helper()
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	rootDER, err := x509.CreateCertificate(rand.Reader, rootTemplate, rootTemplate, &rootKey.PublicKey, rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := x509.ParseCertificate(rootDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "Test Signer", Organization: []string{"My Org"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, root, &key.PublicKey, rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testSigner{root: root, cert: cert, key: key}
+}
+
+// verifier returns an Authenticode verifier trusting the test root.
+func (s *testSigner) verifier(subject string) *authenticodeVerifier {
+	v := newAuthenticodeVerifier(subject)
+	v.roots = x509.NewCertPool()
+	v.roots.AddCert(s.root)
+	return v
+}
+
+// sign returns the PKCS#7 SignedData of an Authenticode signature of the
+// given SHA-256 Authenticode hash.
+func (s *testSigner) sign(t *testing.T, digest []byte) []byte {
+	t.Helper()
+	mustMarshal := func(v interface{}, params string) []byte {
+		der, err := asn1.MarshalWithParams(v, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return der
+	}
+	sha256Algorithm := pkix.AlgorithmIdentifier{Algorithm: oidDigestSHA256}
+
+	var content spcIndirectDataContent
+	// SpcPeImageData, whose content is not verified
+	content.Data = asn1.RawValue{FullBytes: mustMarshal(struct {
+		Type asn1.ObjectIdentifier
+	}{asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 15}}, "")}
+	content.MessageDigest.DigestAlgorithm = sha256Algorithm
+	content.MessageDigest.Digest = digest
+	var contentValue asn1.RawValue
+	if _, err := asn1.Unmarshal(mustMarshal(content, ""), &contentValue); err != nil {
+		t.Fatal(err)
+	}
+
+	contentDigest := sha256.Sum256(contentValue.Bytes)
+	attributes := mustMarshal([]pkcs7Attribute{
+		{
+			Type:   asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3},
+			Values: []asn1.RawValue{{FullBytes: mustMarshal(oidSpcIndirectData, "")}},
+		},
+		{
+			Type:   oidAttrMessageDigest,
+			Values: []asn1.RawValue{{FullBytes: mustMarshal(contentDigest[:], "")}},
+		},
+	}, "set")
+	attributesDigest := sha256.Sum256(attributes)
+	signature, err := ecdsa.SignASN1(rand.Reader, s.key, attributesDigest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type contentInfo struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue
+	}
+	type signerInfo struct {
+		Version                   int
+		IssuerAndSerialNumber     pkcs7IssuerAndSerial
+		DigestAlgorithm           pkix.AlgorithmIdentifier
+		AuthenticatedAttributes   asn1.RawValue
+		DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+		EncryptedDigest           []byte
+	}
+	type signedData struct {
+		Version          int
+		DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+		ContentInfo      contentInfo
+		Certificates     asn1.RawValue
+		SignerInfos      []signerInfo `asn1:"set"`
+	}
+	explicit := func(der []byte) asn1.RawValue {
+		return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+	}
+	return mustMarshal(contentInfo{
+		ContentType: oidSignedData,
+		Content: explicit(mustMarshal(signedData{
+			Version:          1,
+			DigestAlgorithms: []pkix.AlgorithmIdentifier{sha256Algorithm},
+			ContentInfo: contentInfo{
+				ContentType: oidSpcIndirectData,
+				Content:     explicit(contentValue.FullBytes),
+			},
+			Certificates: explicit(append(append([]byte{}, s.cert.Raw...), s.root.Raw...)),
+			SignerInfos: []signerInfo{{
+				Version: 1,
+				IssuerAndSerialNumber: pkcs7IssuerAndSerial{
+					Issuer:       asn1.RawValue{FullBytes: s.cert.RawIssuer},
+					SerialNumber: s.cert.SerialNumber,
+				},
+				DigestAlgorithm: sha256Algorithm,
+				// the implicit [0] tag of the authenticated attributes
+				AuthenticatedAttributes: asn1.RawValue{
+					FullBytes: append([]byte{0xa0}, attributes[1:]...),
+				},
+				DigestEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidEncryptionECDSA},
+				EncryptedDigest:           signature,
+			}},
+		}, "")),
+	}, "")
+}
+
+// the offsets of a PE32+ file whose PE signature follows its 64 bytes DOS
+// header
+const (
+	testPEOptionalHeaderOffset = 0x40 + 4 + 20
+	testPEChecksumOffset       = testPEOptionalHeaderOffset + 64
+	testPECertEntryOffset      = testPEOptionalHeaderOffset + 112 + 4*8
+	testPEHeadersSize          = testPEOptionalHeaderOffset + 240
+)
+
+// minimalPE returns a PE32+ file without sections, followed by the given
+// code.
+func minimalPE(code []byte) []byte {
+	le := binary.LittleEndian
+	file := make([]byte, testPEHeadersSize)
+	copy(file, "MZ")
+	le.PutUint32(file[0x3c:], 0x40)
+	copy(file[0x40:], "PE\x00\x00")
+	le.PutUint16(file[0x44:], 0x8664)    // machine
+	le.PutUint16(file[0x44+16:], 240)    // size of the optional header
+	le.PutUint16(file[0x44+18:], 0x0022) // characteristics
+	le.PutUint16(file[testPEOptionalHeaderOffset:], 0x20b)
+	le.PutUint32(file[testPEChecksumOffset:], 0x1234)
+	le.PutUint32(file[testPEOptionalHeaderOffset+108:], 16) // number of data directories
+	return append(file, code...)
+}
+
+// signPE appends the certificate table of the Authenticode signature of the
+// given PE file to it.
+func signPE(t *testing.T, signer *testSigner, file []byte) []byte {
+	t.Helper()
+	h := sha256.New()
+	h.Write(file[:testPEChecksumOffset])
+	h.Write(file[testPEChecksumOffset+4 : testPECertEntryOffset])
+	h.Write(file[testPECertEntryOffset+8:])
+	signature := signer.sign(t, h.Sum(nil))
+
+	table := make([]byte, 8, 8+len(signature)+8)
+	binary.LittleEndian.PutUint32(table[0:], uint32(8+len(signature)))
+	binary.LittleEndian.PutUint16(table[4:], 0x0200)
+	binary.LittleEndian.PutUint16(table[6:], winCertTypePKCSSignedData)
+	table = append(table, signature...)
+	for len(table)%8 != 0 {
+		table = append(table, 0)
+	}
+	signed := append(append([]byte{}, file...), table...)
+	setPECertTable(signed, uint32(len(file)), uint32(len(table)))
+	return signed
+}
+
+func setPECertTable(file []byte, offset, size uint32) {
+	binary.LittleEndian.PutUint32(file[testPECertEntryOffset:], offset)
+	binary.LittleEndian.PutUint32(file[testPECertEntryOffset+4:], size)
+}
+
+// testCFBEntry is an entry of a test compound file, whose siblings and child
+// are indexes of the other entries.
+type testCFBEntry struct {
+	name        string
+	entryType   byte
+	left, right uint32
+	child       uint32
+	clsid       byte
+	content     []byte
+}
+
+// buildCFB returns a version 3 compound file of the given entries, the first
+// one being the root, whose streams are all stored in regular sectors.
+func buildCFB(entries []testCFBEntry) []byte {
+	le := binary.LittleEndian
+	const sectorSize = 512
+	dirSectors := (len(entries) + 3) / 4
+	fat := []uint32{0xfffffffd}
+	// the FAT sector, then the directory, then the streams
+	var sectors [][]byte
+	chain := func(content []byte) uint32 {
+		if len(content) == 0 {
+			return cfbEndOfChain
+		}
+		start := uint32(len(fat))
+		for off := 0; off < len(content); off += sectorSize {
+			sector := make([]byte, sectorSize)
+			copy(sector, content[off:])
+			sectors = append(sectors, sector)
+			fat = append(fat, uint32(len(fat)+1))
+		}
+		fat[len(fat)-1] = cfbEndOfChain
+		return start
+	}
+	sectors = append(sectors, nil)
+	directory := make([]byte, dirSectors*sectorSize)
+	directoryStart := chain(directory)
+	for i, entry := range entries {
+		raw := directory[i*cfbDirEntrySize : (i+1)*cfbDirEntrySize]
+		name := utf16.Encode([]rune(entry.name))
+		for j, u := range name {
+			le.PutUint16(raw[2*j:], u)
+		}
+		le.PutUint16(raw[0x40:], uint16(2*(len(name)+1)))
+		raw[0x42] = entry.entryType
+		raw[0x43] = 1 // black
+		le.PutUint32(raw[0x44:], entry.left)
+		le.PutUint32(raw[0x48:], entry.right)
+		le.PutUint32(raw[0x4c:], entry.child)
+		for j := 0x50; j < 0x60; j++ {
+			raw[j] = entry.clsid
+		}
+		le.PutUint32(raw[0x74:], chain(entry.content))
+		le.PutUint64(raw[0x78:], uint64(len(entry.content)))
+	}
+	for i := len(entries); i < dirSectors*4; i++ {
+		raw := directory[i*cfbDirEntrySize : (i+1)*cfbDirEntrySize]
+		le.PutUint32(raw[0x44:], cfbNoStream)
+		le.PutUint32(raw[0x48:], cfbNoStream)
+		le.PutUint32(raw[0x4c:], cfbNoStream)
+	}
+	// the directory sectors were allocated before being filled
+	for i := 0; i < dirSectors; i++ {
+		sectors[int(directoryStart)+i] = directory[i*sectorSize : (i+1)*sectorSize]
+	}
+
+	sectors[0] = make([]byte, sectorSize)
+	for i := 0; i < sectorSize/4; i++ {
+		next := uint32(cfbNoStream)
+		if i < len(fat) {
+			next = fat[i]
+		}
+		le.PutUint32(sectors[0][4*i:], next)
+	}
+
+	header := make([]byte, sectorSize)
+	copy(header, cfbSignature)
+	le.PutUint16(header[0x18:], 0x3e)
+	le.PutUint16(header[0x1a:], 3)
+	le.PutUint16(header[0x1c:], 0xfffe)
+	le.PutUint16(header[0x1e:], 9)
+	le.PutUint16(header[0x20:], 6)
+	le.PutUint32(header[0x2c:], 1) // number of FAT sectors
+	le.PutUint32(header[0x30:], directoryStart)
+	le.PutUint32(header[0x38:], 0) // no mini stream
+	le.PutUint32(header[0x3c:], cfbEndOfChain)
+	le.PutUint32(header[0x44:], cfbEndOfChain)
+	le.PutUint32(header[0x4c:], 0)
+	for i := 1; i < cfbHeaderDIFATs; i++ {
+		le.PutUint32(header[0x4c+4*i:], cfbNoStream)
+	}
+	file := header
+	for _, sector := range sectors {
+		file = append(file, sector...)
+	}
+	return file
+}
+
+// testMSIEntries returns the entries of an MSI file with a stream and a
+// storage holding another stream, along with its DigitalSignature stream
+// holding the given signature, if any.
+func testMSIEntries(streamContent []byte, signature []byte) []testCFBEntry {
+	entries := []testCFBEntry{
+		{name: "Root Entry", entryType: cfbTypeRoot, left: cfbNoStream, right: cfbNoStream, child: 2, clsid: 0x10},
+		{name: "A", entryType: cfbTypeStream, left: cfbNoStream, right: cfbNoStream, child: cfbNoStream,
+			content: streamContent},
+		{name: "S", entryType: cfbTypeStorage, left: 1, right: cfbNoStream, child: 3, clsid: 0x20},
+		{name: "B", entryType: cfbTypeStream, left: cfbNoStream, right: cfbNoStream, child: cfbNoStream,
+			content: []byte("content of B")},
+	}
+	if signature != nil {
+		entries[2].right = 4
+		entries = append(entries, testCFBEntry{name: msiDigitalSignature, entryType: cfbTypeStream,
+			left: cfbNoStream, right: cfbNoStream, child: cfbNoStream, content: signature})
+	}
+	return entries
+}
+
+// testMSIHash returns the Authenticode hash of the MSI file of the given
+// entries: the content of A, then the content of B and the class ID of S, then
+// the class ID of the root.
+func testMSIHash(entries []testCFBEntry) []byte {
+	h := sha256.New()
+	h.Write(entries[1].content)
+	h.Write(entries[3].content)
+	h.Write(bytes.Repeat([]byte{entries[2].clsid}, 16))
+	h.Write(bytes.Repeat([]byte{entries[0].clsid}, 16))
+	return h.Sum(nil)
+}
+
+func writeTestFile(t *testing.T, name string, content []byte) string {
+	t.Helper()
+	filePath := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(filePath, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filePath
+}
+
+func TestAuthenticodeVerify(t *testing.T) {
+	signer := newTestSigner(t)
+	code := []byte("the code of the executable")
+	signedPE := signPE(t, signer, minimalPE(code))
+
+	msiEntries := testMSIEntries([]byte("content of A"), []byte{})
+	signedMSI := buildCFB(testMSIEntries([]byte("content of A"), signer.sign(t, testMSIHash(msiEntries))))
+
+	for _, tc := range []struct {
+		name    string
+		file    string
+		content []byte
+		err     string
+	}{
+		{name: "signed PE", file: "app.exe", content: signedPE},
+		{name: "signed MSI", file: "app.msi", content: signedMSI},
+		{
+			name:    "unsigned PE",
+			file:    "app.exe",
+			content: minimalPE(code),
+			err:     "it has no Authenticode signature",
+		},
+		{
+			name:    "unsigned MSI",
+			file:    "app.msi",
+			content: buildCFB(testMSIEntries([]byte("content of A"), nil)),
+			err:     "it has no Authenticode signature",
+		},
+		{
+			name:    "neither PE nor MSI",
+			file:    "app.exe",
+			content: []byte("#!/bin/sh\n"),
+			err:     "it is neither a PE nor an MSI file",
+		},
+		{
+			name: "tampered PE",
+			file: "app.exe",
+			content: func() []byte {
+				tampered := append([]byte{}, signedPE...)
+				tampered[testPEHeadersSize] ^= 0xff
+				return tampered
+			}(),
+			err: "its Authenticode signature does not match its content",
+		},
+		{
+			name: "tampered MSI",
+			file: "app.msi",
+			content: buildCFB(testMSIEntries([]byte("content of Z"),
+				signer.sign(t, testMSIHash(msiEntries)))),
+			err: "its Authenticode signature does not match its content",
+		},
+		{
+			name: "PE signed with another hash",
+			file: "app.exe",
+			content: func() []byte {
+				// a signature of another file, which is otherwise valid
+				other := signPE(t, signer, minimalPE([]byte("the code of another executable")))
+				table := other[testPEHeadersSize+len("the code of another executable"):]
+				file := append(minimalPE(code), table...)
+				setPECertTable(file, uint32(testPEHeadersSize+len(code)), uint32(len(table)))
+				return file
+			}(),
+			err: "its Authenticode signature does not match its content",
+		},
+		{
+			name:    "truncated PE",
+			file:    "app.exe",
+			content: signedPE[:len(signedPE)-16],
+			err:     "malformed PE file",
+		},
+		{
+			name: "PE certificate table overlapping the headers",
+			file: "app.exe",
+			content: func() []byte {
+				file := append([]byte{}, signedPE...)
+				setPECertTable(file, 0x40, uint32(len(signedPE)-0x40))
+				return file
+			}(),
+			err: "malformed PE file",
+		},
+		{
+			name: "PE certificate table larger than the file",
+			file: "app.exe",
+			content: func() []byte {
+				file := append([]byte{}, signedPE...)
+				setPECertTable(file, uint32(testPEHeadersSize+len(code)), 0xffffffff)
+				return file
+			}(),
+			err: "malformed PE file",
+		},
+		{
+			name: "truncated PE certificate table",
+			file: "app.exe",
+			content: func() []byte {
+				file := append([]byte{}, signedPE...)
+				setPECertTable(file, uint32(testPEHeadersSize+len(code)), 4)
+				return file
+			}(),
+			err: "the certificate table is truncated",
+		},
+		{
+			name:    "truncated MSI",
+			file:    "app.msi",
+			content: signedMSI[:len(signedMSI)-600],
+			err:     "error reading sector",
+		},
+		{
+			name: "MSI with a cycle of storages",
+			file: "app.msi",
+			content: func() []byte {
+				entries := testMSIEntries([]byte("content of A"), signer.sign(t, testMSIHash(msiEntries)))
+				// S is its own child
+				entries[2].child = 2
+				return buildCFB(entries)
+			}(),
+			err: "malformed compound file",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			filePath := writeTestFile(t, tc.file, tc.content)
+			cert, err := signer.verifier("").verify(filePath)
+			if len(tc.err) == 0 {
+				if err != nil {
+					t.Fatalf("got error %v, want none", err)
+				}
+				if cert.Subject.CommonName != "Test Signer" {
+					t.Errorf("got signer %s, want Test Signer", cert.Subject)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("got error %v, want %q", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestAuthenticodeCheck(t *testing.T) {
+	signer := newTestSigner(t)
+	filePath := writeTestFile(t, "app.exe", signPE(t, signer, minimalPE([]byte("code"))))
+	asset := &releaseAsset{name: "app.exe"}
+
+	for _, tc := range []struct {
+		name     string
+		verifier *authenticodeVerifier
+		err      string
+	}{
+		{name: "any signer", verifier: signer.verifier("")},
+		{name: "expected common name", verifier: signer.verifier("Test Signer")},
+		{name: "expected distinguished name", verifier: signer.verifier("CN=Test Signer,O=My Org")},
+		{
+			name:     "unexpected signer",
+			verifier: signer.verifier("Other Signer"),
+			err:      "it is signed by CN=Test Signer,O=My Org instead of Other Signer",
+		},
+		{
+			name:     "untrusted root",
+			verifier: newAuthenticodeVerifier(""),
+			err:      "the certificate of its Authenticode signer CN=Test Signer,O=My Org is not trusted",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.verifier.check(asset, filePath)
+			if len(tc.err) == 0 {
+				if err != nil {
+					t.Errorf("got error %v, want none", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("got error %v, want %q", err, tc.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package notarize
+
+import (
+	"context"
+	"time"
+
+	vcnAPI "github.com/vchain-us/vcn/pkg/api"
+	vcnMeta "github.com/vchain-us/vcn/pkg/meta"
+)
+
+// LedgerEntry is the notarization of an artifact in a ledger.
+type LedgerEntry struct {
+	Name        string
+	Hash        string
+	Size        uint64
+	ContentType string
+	SignerID    string
+	Status      vcnMeta.Status
+	Timestamp   time.Time
+	// UID identifies the entry in the ledger (e.g. the one of a CNIL ledger)
+	UID string
+	// Metadata are the metadata recorded with the artifact, if known
+	Metadata map[string]interface{}
+	// Pending tells that the entry is yet to be written into the ledger, e.g.
+	// when recorded into an offline signing bundle
+	Pending bool
+}
+
+// Notarizer notarizes the artifacts into a ledger as a given signer and looks
+// up their notarizations. Its methods are called concurrently and its
+// dynamic type must be comparable (e.g. a pointer), the artifacts signed by the
+// same notarizer being deduplicated.
+type Notarizer interface {
+	// Notarize signs the given artifact with the given status and returns its
+	// resulting ledger entry along with the ID of the ledger transaction, if
+	// any.
+	Notarize(
+		ctx context.Context,
+		artifact *vcnAPI.Artifact,
+		status vcnMeta.Status,
+	) (*LedgerEntry, uint64, error)
+
+	// Verify returns the current ledger entry of the given artifact (i.e. of
+	// its hash), or nil if it is not notarized.
+	Verify(ctx context.Context, artifact *vcnAPI.Artifact) (*LedgerEntry, error)
+}
+
+// Backend is a notarization backend, e.g. CodeNotary Immutable Ledger (CNIL)
+// through vcn, which is the default one (see Config.Backend).
+type Backend interface {
+	// Notarizers returns the notarizer of each of the given signer IDs into
+	// the given ledger (empty for the default one). The same notarizer can be
+	// returned for several signer IDs.
+	Notarizers(ctx context.Context, ledgerID string, signerIDs []string) ([]Notarizer, error)
+
+	// Close releases the resources of the notarizers (e.g. their connections
+	// and credentials) once done with them, even if the run deadline has been
+	// hit.
+	Close() error
+}
+
+// Preflighter is implemented by the backends able to check, before any work,
+// that their credentials allow notarizing into the given ledgers, for the runs
+// to fail fast instead of on the first signature.
+type Preflighter interface {
+	Preflight(ctx context.Context, ledgerIDs []string) error
+}
+
+// notarizerKey identifies the notarizer of a signer ID into a ledger.
+type notarizerKey struct {
+	ledgerID string
+	signerID string
+}
@@ -0,0 +1,88 @@
+package notarize
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/codenotary/notarize-release-assets-action/pkg/bitbucket"
+	"github.com/codenotary/notarize-release-assets-action/pkg/github"
+)
+
+// The providers hosting the releases (see Config.Provider).
+const (
+	ProviderGitHub    = "github"
+	ProviderBitbucket = "bitbucket"
+)
+
+// bitbucketSignerIDTemplate is the template of the signer IDs of the
+// Bitbucket users when none is specified.
+const bitbucketSignerIDTemplate = "{{.Login}}@bitbucket"
+
+// ParseProvider parses the provider hosting the releases, i.e. github or
+// bitbucket (case insensitive, github if empty).
+func ParseProvider(provider string) (string, error) {
+	provider = strings.ToLower(strings.TrimSpace(provider))
+	switch provider {
+	case "":
+		return ProviderGitHub, nil
+	case ProviderGitHub, ProviderBitbucket:
+		return provider, nil
+	default:
+		return "", fmt.Errorf("invalid provider %s: must be either %s or %s",
+			provider, ProviderGitHub, ProviderBitbucket)
+	}
+}
+
+// bitbucketRelease returns whether the release of the given config is a
+// Bitbucket one.
+func bitbucketRelease(cfg *Config) bool {
+	provider, _ := ParseProvider(cfg.Provider)
+	return provider == ProviderBitbucket
+}
+
+// releaseRepository returns the repository of the release URL of the given
+// config, i.e. <owner>/<repo-name> (or <workspace>/<repo-slug> on Bitbucket).
+func releaseRepository(cfg *Config) string {
+	if bitbucketRelease(cfg) {
+		return bitbucket.RepositoryFromTagURL(cfg.ReleaseURL)
+	}
+	return github.RepositoryFromReleaseURL(cfg.ReleaseURL)
+}
+
+// getBitbucketRelease gets the Bitbucket tag of the release URL of the given
+// config along with its downloads, as the details of a GitHub release whose
+// downloads are the assets (uploaded by their uploaders), and returns them
+// along with the SHA of the tag commit.
+func getBitbucketRelease(
+	ctx context.Context,
+	httpClient bitbucket.HTTPClient,
+	cfg *Config,
+) (github.Release, string, error) {
+
+	tag, err := bitbucket.GetRelease(ctx, httpClient, cfg.ReleaseURL, cfg.BitbucketToken)
+	if err != nil {
+		return github.Release{}, "", err
+	}
+	release := github.Release{
+		URL:        cfg.ReleaseURL,
+		TagName:    tag.TagName,
+		TarballURL: tag.TarballURL,
+		ZipballURL: tag.ZipballURL,
+	}
+	if len(tag.AuthorNickname) > 0 {
+		release.Author = &github.ReleaseAuthor{Login: tag.AuthorNickname}
+	}
+	for _, download := range tag.Downloads {
+		asset := &github.ReleaseAsset{
+			URL:  download.Links.Self.Href,
+			Name: download.Name,
+			Size: download.Size,
+		}
+		if nickname := download.UploaderNickname(); len(nickname) > 0 {
+			asset.Uploader = &github.ReleaseAssetUploader{Login: nickname}
+		}
+		release.Assets = append(release.Assets, asset)
+	}
+	return release, tag.CommitSHA, nil
+}
@@ -0,0 +1,149 @@
+package notarize
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/codenotary/notarize-release-assets-action/pkg/github"
+)
+
+// opBulk is the operation of the bulk notarizations of the releases of
+// several repositories (see BulkNotarize).
+const opBulk operation = "bulk"
+
+// BulkOptions selects the releases notarized by BulkNotarize.
+type BulkOptions struct {
+	// Organization is the organization (or user) whose repositories are
+	// processed, except the archived and forked ones.
+	Organization string
+	// Repositories are the repositories (i.e. <owner>/<repo-name>) processed
+	// when there is no organization.
+	Repositories []string
+	// Since is the cutoff date of the releases, only the ones created since
+	// then being processed (all of them by default).
+	Since time.Time
+}
+
+// BulkNotarize notarizes, in a single run, the (non-draft) releases created
+// since the cutoff date of all the repositories of an organization (or of
+// the given repositories) whose assets are not notarized yet: the assets of
+// each release are verified first and the release is skipped if they all
+// are notarized already. The releases are processed by ProcessReleases (see
+// Config.MaxParallelReleases), the oldest first, and the combined report of
+// all of them is returned.
+func BulkNotarize(ctx context.Context, cfg *Config, options BulkOptions) (*Report, error) {
+	report := &Report{Operation: string(opBulk), Repository: options.Organization}
+	if len(cfg.ReleaseURL) > 0 || len(cfg.Paths) > 0 || len(cfg.Images) > 0 {
+		return report, classify(ErrInvalidConfig, errors.New(
+			"the release, the local paths and the images cannot be processed along with a bulk notarization"))
+	}
+	if len(options.Organization) == 0 && len(options.Repositories) == 0 {
+		return report, classify(ErrInvalidConfig, errors.New(
+			"either the organization or the repositories are required for a bulk notarization"))
+	}
+	log := cfg.Logger
+	if log == nil {
+		log = nopLogger{}
+	}
+	httpClient, _, _, err := newHTTPClients(cfg)
+	if err != nil {
+		return report, err
+	}
+
+	repositories := options.Repositories
+	if len(options.Organization) > 0 {
+		summaries, err := github.ListRepositories(
+			ctx, httpClient, cfg.GitHubAPIURL, options.Organization, cfg.GitHubToken)
+		if err != nil {
+			return report, classify(ErrGitHubAPI, err)
+		}
+		repositories = nil
+		for _, summary := range summaries {
+			if !summary.Archived && !summary.Fork && !summary.Disabled {
+				repositories = append(repositories, summary.FullName)
+			}
+		}
+		log.Infof("Found %d repositories (archived and forked ones excluded) in %s\n",
+			len(repositories), options.Organization)
+	}
+
+	type release struct {
+		url       string
+		createdAt time.Time
+	}
+	var releases []release
+	for _, repository := range repositories {
+		summaries, err := github.ListReleases(ctx, httpClient, cfg.GitHubAPIURL, repository, cfg.GitHubToken)
+		if err != nil {
+			return report, classify(ErrGitHubAPI, err)
+		}
+		for _, summary := range summaries {
+			if !summary.Draft && !summary.CreatedAt.Before(options.Since) {
+				releases = append(releases, release{url: summary.URL, createdAt: summary.CreatedAt})
+			}
+		}
+	}
+	sort.SliceStable(releases, func(i, j int) bool {
+		return releases[i].createdAt.Before(releases[j].createdAt)
+	})
+	releaseURLs := make([]string, len(releases))
+	for i, release := range releases {
+		releaseURLs[i] = release.url
+	}
+	if options.Since.IsZero() {
+		log.Infof("Found %d releases in %d repositories\n", len(releaseURLs), len(repositories))
+	} else {
+		log.Infof("Found %d releases created since %s in %d repositories\n",
+			len(releaseURLs), options.Since.Format(time.RFC3339), len(repositories))
+	}
+
+	report, err = ProcessReleases(ctx, cfg, releaseURLs, notarizeIfMissing)
+	report.Operation = string(opBulk)
+	if len(options.Organization) > 0 {
+		report.Repository = options.Organization
+	}
+	return report, err
+}
+
+// notarizeIfMissing notarizes the assets of the given release unless they are
+// notarized already, in which case the verification report is returned
+// marked as skipped. A release whose assets are notarized with another status
+// than the required one (e.g. untrusted on purpose) is skipped too, as
+// notarizing them again would override that status.
+func notarizeIfMissing(ctx context.Context, cfg *Config) (*Report, error) {
+	log := cfg.Logger
+	if log == nil {
+		log = nopLogger{}
+	}
+	verifyCfg := *cfg
+	verifyCfg.Logger = nopLogger{}
+	verifyCfg.MetricsPushgatewayURL = ""
+	verifyCfg.MetricsFile = ""
+	verifyCfg.ExportProofs = false
+	report, err := VerifyRelease(ctx, &verifyCfg)
+	if err == nil {
+		log.Infof("The assets of release %s are notarized already, skipping it\n", releaseName(report, true))
+		report.Skipped = true
+		return report, nil
+	}
+	if !errors.Is(err, ErrVerificationFailed) {
+		return report, err
+	}
+	var mismatches []string
+	for _, assetReport := range report.Assets {
+		if len(assetReport.Error) > 0 && len(assetReport.Status) > 0 {
+			mismatches = append(mismatches, fmt.Sprintf("%s (%s)", assetReport.Name, assetReport.Status))
+		}
+	}
+	if len(mismatches) > 0 {
+		log.Warningf("WARNING: skipping release %s whose assets %s are notarized with another status already\n",
+			releaseName(report, true), strings.Join(mismatches, ", "))
+		report.Skipped = true
+		return report, nil
+	}
+	return NotarizeRelease(ctx, cfg)
+}
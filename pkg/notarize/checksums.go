@@ -0,0 +1,39 @@
+package notarize
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// checksumsAssetName is the name of the checksums file uploaded to the
+// release.
+const checksumsAssetName = "checksums-sha256.txt"
+
+// writeChecksumsFile writes the checksums file of the given assets into the
+// given dir, in the format of the sha256sum tool (i.e. "<hash>  <name>"), and
+// returns its path.
+func writeChecksumsFile(dir string, assetsReports []*AssetReport) (string, error) {
+	// the assets notarized into multiple ledgers are listed once
+	var sorted []*AssetReport
+	listed := make(map[string]bool)
+	for _, assetReport := range assetsReports {
+		if isFileKind(assetReport.Kind) && !listed[assetReport.Name] {
+			listed[assetReport.Name] = true
+			sorted = append(sorted, assetReport)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	var content strings.Builder
+	for _, assetReport := range sorted {
+		fmt.Fprintf(&content, "%s  %s\n", assetReport.sha256Hash(), assetReport.Name)
+	}
+
+	filePath := filepath.Join(dir, checksumsAssetName)
+	if err := os.WriteFile(filePath, []byte(content.String()), 0644); err != nil {
+		return "", fmt.Errorf("error writing checksums file %s: %v", filePath, err)
+	}
+	return filePath, nil
+}
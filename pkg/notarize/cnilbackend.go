@@ -0,0 +1,460 @@
+package notarize
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/codenotary/notarize-release-assets-action/pkg/cnil"
+	vcnAPI "github.com/vchain-us/vcn/pkg/api"
+	vcnMeta "github.com/vchain-us/vcn/pkg/meta"
+	vcnStore "github.com/vchain-us/vcn/pkg/store"
+)
+
+// cnilBackend is the CodeNotary Immutable Ledger backend: each signer ID
+// signs with its API key, either the specified one or a pre-provisioned one
+// or else one created (or rotated) via the CNIL REST API, through the vcn
+// client of that API key.
+type cnilBackend struct {
+	cfg        *Config
+	httpClient *http.Client
+	restURL    string
+	grpcPort   string
+	tlsConfig  *tls.Config
+	proxy      proxyFunc
+	log        Logger
+
+	scheduler *signScheduler
+	// hosts are the CNIL host and its fallbacks (see Config.CNILFallbackHosts),
+	// the vcn clients connecting to the current one
+	hosts          []string
+	mu             sync.Mutex
+	currentHost    int
+	usersPerAPIKey map[cnilClientKey]*vcnAPI.LcUser
+	// resolvedLedgerIDs are the IDs of the ledgers got or created in place
+	// of the missing ones (see Config.CreateLedgerIfMissing)
+	resolvedLedgerIDs map[string]string
+	// revocations revoke the ephemeral API keys once done with them
+	revocations []func() error
+}
+
+// vcnStoreMu serializes the accesses to the vcn store, which is global, e.g.
+// when several releases are processed at the same time.
+var vcnStoreMu sync.Mutex
+
+// cnilGRPCPort returns the gRPC port of the CNIL instance, 443 by default.
+func cnilGRPCPort(cfg *Config) string {
+	if len(cfg.CNILGRPCPort) == 0 {
+		return "443"
+	}
+	return cfg.CNILGRPCPort
+}
+
+// newCNILBackend returns the CNIL backend configured by the CNIL settings of
+// the given config, initializing the local vcn store.
+func newCNILBackend(
+	cfg *Config,
+	httpClient *http.Client,
+	proxy proxyFunc,
+	log Logger,
+) (*cnilBackend, error) {
+
+	restURL := cfg.CNILRESTURL
+	if len(restURL) == 0 {
+		restURL = fmt.Sprintf("https://%s:443/api/v1", cfg.CNILHost)
+	}
+	fallbackRESTURLs := cfg.CNILFallbackRESTURLs
+	if len(fallbackRESTURLs) == 0 {
+		for _, host := range cfg.CNILFallbackHosts {
+			fallbackRESTURLs = append(fallbackRESTURLs, fmt.Sprintf("https://%s:443/api/v1", host))
+		}
+	}
+	// the private CA and the client certificate of an on-premise CNIL
+	// instance, if any, apply to both its REST and gRPC APIs
+	tlsConfig, err := cnil.TLSConfig(cfg.CNILCACert, cfg.CNILClientCert, cfg.CNILClientKey)
+	if err != nil {
+		return nil, err
+	}
+	cnilHTTPClient, err := cnil.WithTLSConfig(httpClient, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	cnilHTTPClient = cnil.WithFailover(cnilHTTPClient, restURL, fallbackRESTURLs, log)
+
+	// make sure the local VCN store directory exists
+	storeDir := cfg.StoreDir
+	if len(storeDir) == 0 {
+		storeDir = "./.vcn"
+	}
+	if err := os.MkdirAll(storeDir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("error creating local vcn store directory %s: %v", storeDir, err)
+	}
+	// initialize VCN store
+	vcnStoreMu.Lock()
+	vcnStore.SetDir(storeDir)
+	vcnStore.LoadConfig()
+	vcnStoreMu.Unlock()
+
+	return &cnilBackend{
+		cfg:               cfg,
+		httpClient:        cnilHTTPClient,
+		restURL:           restURL,
+		grpcPort:          cnilGRPCPort(cfg),
+		tlsConfig:         tlsConfig,
+		proxy:             proxy,
+		log:               log,
+		scheduler:         newSignScheduler(cfg.MaxParallel, cfg.MaxStreams),
+		hosts:             append([]string{cfg.CNILHost}, cfg.CNILFallbackHosts...),
+		usersPerAPIKey:    make(map[cnilClientKey]*vcnAPI.LcUser),
+		resolvedLedgerIDs: make(map[string]string),
+	}, nil
+}
+
+// Notarizers returns the notarizers of the API keys of the given signer IDs,
+// connecting their vcn clients.
+func (b *cnilBackend) Notarizers(
+	ctx context.Context,
+	ledgerID string,
+	signerIDs []string,
+) ([]Notarizer, error) {
+
+	if b.cfg.CreateLedgerIfMissing && len(b.cfg.APIKey) == 0 {
+		resolvedLedgerID, err := b.resolveLedgerID(ctx, ledgerID)
+		if err != nil {
+			return nil, err
+		}
+		ledgerID = resolvedLedgerID
+	}
+	apiKeys, err := b.apiKeys(ctx, ledgerID, signerIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	ledger := b.cfg.CNILHost
+	if len(ledgerID) > 0 {
+		ledger += "/" + ledgerID
+	}
+
+	// create and connect the vcn clients
+	notarizers := make([]Notarizer, 0, len(apiKeys))
+	for _, apiKey := range apiKeys {
+		for {
+			_, host, err := b.vcnUser(apiKey)
+			if err == nil {
+				break
+			}
+			if !b.failover(host, err) {
+				return nil, err
+			}
+		}
+		notarizers = append(notarizers, &cnilNotarizer{
+			backend:   b,
+			apiKey:    apiKey,
+			scheduler: b.scheduler,
+			ledger:    ledger,
+		})
+	}
+	return notarizers, nil
+}
+
+// cnilClientKey identifies the vcn client of an API key to a CNIL host.
+type cnilClientKey struct {
+	apiKey string
+	host   string
+}
+
+// vcnUser returns the vcn client of the given API key to the current CNIL
+// host, connecting it if needed, along with the index of that host.
+func (b *cnilBackend) vcnUser(apiKey string) (*vcnAPI.LcUser, int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	key := cnilClientKey{apiKey: apiKey, host: b.hosts[b.currentHost]}
+	if vcnUser, ok := b.usersPerAPIKey[key]; ok {
+		return vcnUser, b.currentHost, nil
+	}
+	vcnStoreMu.Lock()
+	vcnUser, err := newVCNUser(apiKey, key.host, b.grpcPort, b.cfg.CNILNoTLS, b.tlsConfig, b.proxy,
+		grpcSettingsOf(b.cfg))
+	vcnStoreMu.Unlock()
+	if err != nil {
+		return nil, 0, fmt.Errorf("error initializing vcn client: %v", err)
+	}
+	if err := vcnUser.Client.Connect(); err != nil {
+		// i.e. the dial timeout, if any, is hit
+		return nil, b.currentHost, fmt.Errorf("error connecting vcn client to %s: %w", key.host, err)
+	}
+	b.usersPerAPIKey[key] = vcnUser
+	return vcnUser, b.currentHost, nil
+}
+
+// failover switches to the next CNIL host after the given error of the given
+// one (unless already done by another call), returning false if the error is
+// not an unavailability of the host or if there is no host left.
+func (b *cnilBackend) failover(from int, err error) bool {
+	if !isUnavailable(err) {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if from != b.currentHost {
+		return true
+	}
+	if b.currentHost+1 >= len(b.hosts) {
+		return false
+	}
+	b.currentHost++
+	b.log.Warningf("WARNING: CNIL host %s is unavailable (%v), failing over to %s\n",
+		b.hosts[from], err, b.hosts[b.currentHost])
+	return true
+}
+
+// isUnavailable tells whether the given error of a gRPC call is a connection
+// error or a server error, i.e. an Unavailable or Internal one, or the dial
+// timeout of a vcn client.
+func isUnavailable(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var grpcErr interface{ GRPCStatus() *status.Status }
+	if !errors.As(err, &grpcErr) {
+		return false
+	}
+	switch grpcErr.GRPCStatus().Code() {
+	case codes.Unavailable, codes.Internal:
+		return true
+	}
+	return false
+}
+
+// Preflight checks that the personal token, if the API keys are provisioned
+// with it, is allowed to manage the API keys of the given ledgers (once
+// resolved, see Config.CreateLedgerIfMissing).
+func (b *cnilBackend) Preflight(ctx context.Context, ledgerIDs []string) error {
+	if len(b.cfg.APIKey) > 0 || len(b.cfg.CNILToken) == 0 {
+		return nil
+	}
+	for _, ledgerID := range ledgerIDs {
+		if b.cfg.CreateLedgerIfMissing {
+			resolvedLedgerID, err := b.resolveLedgerID(ctx, ledgerID)
+			if err != nil {
+				return err
+			}
+			ledgerID = resolvedLedgerID
+		}
+		_, endSpan := startSpan(ctx, "check permissions", attribute.String("ledger.id", ledgerID))
+		err := cnil.CheckPermissions(ctx, b.httpClient, &cnil.Options{
+			BaseURL:  b.restURL,
+			Token:    b.cfg.CNILToken,
+			LedgerID: ledgerID,
+		})
+		endSpan(err)
+		if err != nil {
+			return err
+		}
+		b.log.Infof("Checked the permissions of the personal token on ledger %s\n", ledgerID)
+	}
+	return nil
+}
+
+// resolveLedgerID returns the ID of the given ledger if it exists, or else
+// the one of the ledger named after the repository, creating it if needed.
+func (b *cnilBackend) resolveLedgerID(ctx context.Context, ledgerID string) (string, error) {
+	if resolvedLedgerID, ok := b.resolvedLedgerIDs[ledgerID]; ok {
+		return resolvedLedgerID, nil
+	}
+	name := releaseRepository(b.cfg)
+	if len(name) == 0 {
+		name = b.cfg.Repository
+	}
+	if len(name) == 0 {
+		return "", errors.New("the repository is required for creating the missing ledger")
+	}
+	_, endSpan := startSpan(ctx, "get or create ledger", attribute.String("ledger.name", name))
+	resolvedLedgerID, err := cnil.GetOrCreateLedger(ctx, b.httpClient, &cnil.Options{
+		BaseURL: b.restURL,
+		Token:   b.cfg.CNILToken,
+	}, ledgerID, name, b.log)
+	endSpan(err)
+	if err != nil {
+		return "", err
+	}
+	b.resolvedLedgerIDs[ledgerID] = resolvedLedgerID
+	return resolvedLedgerID, nil
+}
+
+// apiKeys returns the API keys of the given signer IDs into the given ledger.
+func (b *cnilBackend) apiKeys(
+	ctx context.Context,
+	ledgerID string,
+	signerIDs []string,
+) ([]string, error) {
+
+	cfg := b.cfg
+	if len(cfg.APIKey) > 0 {
+		// just use the specified API key for all assets
+		apiKeys := make([]string, len(signerIDs))
+		for i := range apiKeys {
+			apiKeys[i] = cfg.APIKey
+		}
+		return apiKeys, nil
+	}
+
+	// use the pre-provisioned API keys (if any) and get and rotate or create
+	// API keys for each (unique) signer ID which has no pre-provisioned one
+	apiKeysPerSignerID := make(map[string]string, len(cfg.APIKeysPerSignerID))
+	for signerID, apiKey := range cfg.APIKeysPerSignerID {
+		apiKeysPerSignerID[signerID] = apiKey
+	}
+	var unmappedSignerIDs []string
+	for _, signerID := range signerIDs {
+		if _, ok := apiKeysPerSignerID[signerID]; !ok {
+			unmappedSignerIDs = append(unmappedSignerIDs, signerID)
+		}
+	}
+	if len(unmappedSignerIDs) > 0 &&
+		(len(cfg.APIKeysPerSignerID) == 0 || len(cfg.CNILToken) > 0) {
+		cnilAPIOptions := &cnil.Options{
+			BaseURL:           b.restURL,
+			Token:             cfg.CNILToken,
+			LedgerID:          ledgerID,
+			KeyPolicy:         cfg.KeyPolicy,
+			RotateIfOlderThan: cfg.RotateIfOlderThan,
+			Cache:             cfg.APIKeyCache,
+		}
+		_, endSpan := startSpan(ctx, "provision API keys",
+			attribute.StringSlice("signer_ids", unmappedSignerIDs),
+			attribute.String("key_policy", string(cfg.KeyPolicy)))
+		provisionedAPIKeys, err := cnil.GetAndRotateOrCreateAPIKeys(
+			ctx, b.httpClient, cnilAPIOptions, unmappedSignerIDs, b.log)
+		endSpan(err)
+		for _, apiKey := range provisionedAPIKeys {
+			maskSecret(b.log, apiKey.Key)
+		}
+		if cfg.KeyPolicy == cnil.KeyPolicyEphemeral {
+			// revoke the ephemeral API keys (even if the deadline has been
+			// hit) once done with them
+			b.revocations = append(b.revocations, func() error {
+				_, endSpan := startSpan(ctx, "revoke API keys",
+					attribute.StringSlice("signer_ids", unmappedSignerIDs))
+				err := cnil.RevokeAPIKeys(context.Background(), b.httpClient,
+					cnilAPIOptions, provisionedAPIKeys, b.log)
+				endSpan(err)
+				return err
+			})
+		}
+		if err != nil {
+			return nil, err
+		}
+		for i, signerID := range unmappedSignerIDs {
+			apiKeysPerSignerID[signerID] = provisionedAPIKeys[i].Key
+		}
+	}
+	return cnil.APIKeysFromMapping(apiKeysPerSignerID, signerIDs)
+}
+
+// Close waits for the pending signatures, disconnects the vcn clients and
+// revokes the ephemeral API keys.
+func (b *cnilBackend) Close() error {
+	b.scheduler.close()
+	for _, vcnUser := range b.usersPerAPIKey {
+		if err := vcnUser.Client.Disconnect(); err != nil {
+			b.log.Errorf("error disconnecting vcn client: %v\n", err)
+		}
+	}
+	var msgs []string
+	for _, revoke := range b.revocations {
+		if err := revoke(); err != nil {
+			msgs = append(msgs, err.Error())
+		}
+	}
+	if len(msgs) > 0 {
+		return errors.New(strings.Join(msgs, "; "))
+	}
+	return nil
+}
+
+// cnilNotarizer signs through the vcn client of an API key to the current
+// CNIL host, failing over to the next one when it is unavailable.
+type cnilNotarizer struct {
+	backend   *cnilBackend
+	apiKey    string
+	scheduler *signScheduler
+	// ledger is the CNIL host and ledger ID (if any) of the API key
+	ledger string
+
+	mu sync.Mutex
+	// trustedState is the ledger state the vcn clients are checked against
+	// (see CheckState), set on the client of each host it fails over to
+	trustedState *LedgerState
+	stateSet     map[*vcnAPI.LcUser]bool
+}
+
+// withFailover calls f with the vcn client of the current CNIL host, again
+// with the one of the next host as long as the host is unavailable.
+func (n *cnilNotarizer) withFailover(f func(vcnUser *vcnAPI.LcUser) error) error {
+	for {
+		vcnUser, host, err := n.backend.vcnUser(n.apiKey)
+		if err == nil {
+			if err = n.setTrustedState(vcnUser); err != nil {
+				return err
+			}
+			err = f(vcnUser)
+		}
+		if err == nil || !n.backend.failover(host, err) {
+			return err
+		}
+	}
+}
+
+func (n *cnilNotarizer) Notarize(
+	ctx context.Context,
+	artifact *vcnAPI.Artifact,
+	status vcnMeta.Status,
+) (*LedgerEntry, uint64, error) {
+
+	var cnilArtifact *vcnAPI.LcArtifact
+	var txID uint64
+	err := n.withFailover(func(vcnUser *vcnAPI.LcUser) error {
+		var err error
+		cnilArtifact, txID, err = notarizeAndVerify(vcnUser, artifact, status, n.scheduler)
+		return err
+	})
+	return ledgerEntryOf(cnilArtifact), txID, err
+}
+
+func (n *cnilNotarizer) Verify(ctx context.Context, artifact *vcnAPI.Artifact) (*LedgerEntry, error) {
+	var cnilArtifact *vcnAPI.LcArtifact
+	err := n.withFailover(func(vcnUser *vcnAPI.LcUser) error {
+		var err error
+		cnilArtifact, err = verify(vcnUser, artifact, n.scheduler)
+		return err
+	})
+	return ledgerEntryOf(cnilArtifact), err
+}
+
+// ledgerEntryOf returns the ledger entry of the given CNIL artifact, if any.
+func ledgerEntryOf(cnilArtifact *vcnAPI.LcArtifact) *LedgerEntry {
+	if cnilArtifact == nil {
+		return nil
+	}
+	return &LedgerEntry{
+		Name:        cnilArtifact.Name,
+		Hash:        cnilArtifact.Hash,
+		Size:        cnilArtifact.Size,
+		ContentType: cnilArtifact.ContentType,
+		SignerID:    cnilArtifact.Signer,
+		Status:      cnilArtifact.Status,
+		Timestamp:   cnilArtifact.Timestamp,
+		UID:         cnilArtifact.Uid,
+		Metadata:    cnilArtifact.Metadata,
+	}
+}
@@ -0,0 +1,430 @@
+package notarize
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	vcnMeta "github.com/vchain-us/vcn/pkg/meta"
+)
+
+// defaultSignerIDTemplate is the template of the signer IDs of the GitHub
+// users when none is specified.
+const defaultSignerIDTemplate = "{{.Login}}@github"
+
+// ParseAPIKeysPerSignerID parses a JSON object of the form
+// {"<signer ID>": "<API key>", ...} and validates each of its API keys.
+func ParseAPIKeysPerSignerID(mapping string) (map[string]string, error) {
+	apiKeysPerSignerID := make(map[string]string)
+	if err := json.Unmarshal([]byte(mapping), &apiKeysPerSignerID); err != nil {
+		return nil, fmt.Errorf(
+			"error JSON-unmarshaling the API keys per signer ID mapping: %v", err)
+	}
+
+	for signerID, apiKey := range apiKeysPerSignerID {
+		apiKey = strings.TrimSpace(apiKey)
+		if len(strings.Split(apiKey, ".")) < 2 {
+			return nil, fmt.Errorf(
+				"the API key specified for signer ID %s is not supported: "+
+					"must be of the form <identity>.<secret>", signerID)
+		}
+		apiKeysPerSignerID[signerID] = apiKey
+	}
+
+	return apiKeysPerSignerID, nil
+}
+
+// ParseLedgers parses the ledger(s) the assets are notarized into: either a
+// single ledger ID, a list of ledger IDs separated by commas or new lines
+// (i.e. every asset is notarized into all of them) or a JSON object of the
+// form {"<asset name glob pattern>": "<ledger ID>" | ["<ledger ID>", ...], ...}.
+// It returns either the single ledger ID or the ledger IDs per pattern.
+func ParseLedgers(ledgers string) (string, map[string][]string, error) {
+	ledgers = strings.TrimSpace(ledgers)
+	if !strings.HasPrefix(ledgers, "{") {
+		ledgerIDs, err := ParsePatterns(ledgers)
+		if err != nil || len(ledgerIDs) < 2 {
+			return ledgers, nil, err
+		}
+		return "", map[string][]string{"*": ledgerIDs}, nil
+	}
+
+	rawLedgersPerAsset := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(ledgers), &rawLedgersPerAsset); err != nil {
+		return "", nil, fmt.Errorf("error JSON-unmarshaling the ledgers per asset mapping: %v", err)
+	}
+	ledgersPerAsset := make(map[string][]string, len(rawLedgersPerAsset))
+	for pattern, rawLedgerIDs := range rawLedgersPerAsset {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return "", nil, fmt.Errorf("invalid asset name pattern %s: %v", pattern, err)
+		}
+		switch v := rawLedgerIDs.(type) {
+		case string:
+			ledgersPerAsset[pattern] = []string{v}
+		case []interface{}:
+			for _, ledgerID := range v {
+				ledgerID, ok := ledgerID.(string)
+				if !ok {
+					return "", nil, fmt.Errorf(
+						"invalid ledger IDs of asset name pattern %s: must be strings", pattern)
+				}
+				ledgersPerAsset[pattern] = append(ledgersPerAsset[pattern], ledgerID)
+			}
+		default:
+			return "", nil, fmt.Errorf("invalid ledger IDs of asset name pattern %s: "+
+				"must be either a string or an array of strings", pattern)
+		}
+	}
+	return "", ledgersPerAsset, nil
+}
+
+// ledgersOf returns the (sorted, unique) IDs of the ledgers the given asset is
+// routed to, i.e. the ones of all the patterns matching its name, or the
+// default ledger ID if there is no routing at all.
+func ledgersOf(
+	ledgersPerAsset map[string][]string,
+	defaultLedgerID string,
+	name string,
+) ([]string, error) {
+
+	if len(ledgersPerAsset) == 0 {
+		return []string{defaultLedgerID}, nil
+	}
+	unique := make(map[string]bool)
+	for pattern, ledgerIDs := range ledgersPerAsset {
+		// "*" matches also the names with slashes (e.g. of the images)
+		if ok, _ := path.Match(pattern, name); ok || pattern == "*" {
+			for _, ledgerID := range ledgerIDs {
+				unique[ledgerID] = true
+			}
+		}
+	}
+	if len(unique) == 0 {
+		return nil, fmt.Errorf("asset %s does not match any pattern of the ledgers mapping", name)
+	}
+	ledgerIDs := make([]string, 0, len(unique))
+	for ledgerID := range unique {
+		ledgerIDs = append(ledgerIDs, ledgerID)
+	}
+	sort.Strings(ledgerIDs)
+	return ledgerIDs, nil
+}
+
+// statuses are the statuses the assets can be notarized with, by name.
+var statuses = map[string]vcnMeta.Status{
+	"trusted":     vcnMeta.StatusTrusted,
+	"untrusted":   vcnMeta.StatusUntrusted,
+	"unsupported": vcnMeta.StatusUnsupported,
+}
+
+// statusPriorities rank the statuses from the most to the least trusted one.
+var statusPriorities = map[vcnMeta.Status]int{
+	vcnMeta.StatusTrusted:     0,
+	vcnMeta.StatusUnsupported: 1,
+	vcnMeta.StatusUntrusted:   2,
+}
+
+// ParseStatus validates the given status name, i.e. trusted, untrusted or
+// unsupported (case insensitive, trusted if empty), and returns it in lower
+// case.
+func ParseStatus(status string) (string, error) {
+	status = strings.ToLower(strings.TrimSpace(status))
+	if len(status) == 0 {
+		return "trusted", nil
+	}
+	if _, ok := statuses[status]; !ok {
+		return "", fmt.Errorf(
+			"invalid status %s: must be one of trusted, untrusted or unsupported", status)
+	}
+	return status, nil
+}
+
+// entryStatuses are the statuses the ledger entries of the assets can have,
+// by name.
+var entryStatuses = map[string]vcnMeta.Status{
+	"trusted":     vcnMeta.StatusTrusted,
+	"untrusted":   vcnMeta.StatusUntrusted,
+	"unknown":     vcnMeta.StatusUnknown,
+	"unsupported": vcnMeta.StatusUnsupported,
+	"revoked":     vcnMeta.StatusApikeyRevoked,
+}
+
+// ParseEntryStatuses validates the given comma-separated status names of
+// ledger entries, i.e. trusted, untrusted, unknown, unsupported or revoked
+// (case insensitive), and returns them in lower case.
+func ParseEntryStatuses(list string) ([]string, error) {
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if len(name) == 0 {
+			continue
+		}
+		if _, ok := entryStatuses[name]; !ok {
+			return nil, fmt.Errorf("invalid status %s: must be one of trusted, untrusted, "+
+				"unknown, unsupported or revoked", name)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// statusPolicy is the policy the statuses of the ledger entries of the assets
+// are checked against when verifying.
+type statusPolicy struct {
+	// required is the status the entries must have, if requireStatus is set,
+	// instead of the one of their assets
+	required      vcnMeta.Status
+	requireStatus bool
+	denied        map[vcnMeta.Status]bool
+}
+
+// newStatusPolicy creates the verification policy of the given required
+// status and denied statuses (see Config.RequireStatus and
+// Config.DenyStatuses).
+func newStatusPolicy(requireStatus string, denyStatuses []string) (*statusPolicy, error) {
+	policy := &statusPolicy{denied: make(map[vcnMeta.Status]bool)}
+	if len(strings.TrimSpace(requireStatus)) > 0 {
+		names, err := ParseEntryStatuses(requireStatus)
+		if err != nil {
+			return nil, err
+		}
+		if len(names) != 1 {
+			return nil, fmt.Errorf("invalid required status %s: must be a single status", requireStatus)
+		}
+		policy.required, policy.requireStatus = entryStatuses[names[0]], true
+	}
+	names, err := ParseEntryStatuses(strings.Join(denyStatuses, ","))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		policy.denied[entryStatuses[name]] = true
+	}
+	if policy.requireStatus && policy.denied[policy.required] {
+		return nil, fmt.Errorf("the required status %s is denied", strings.ToLower(policy.required.String()))
+	}
+	return policy, nil
+}
+
+// check checks the status of the ledger entry of the given artifact, whose
+// asset has the given configured status. Without a required status nor
+// denied statuses, the entry must have the configured status; with denied
+// statuses only, it can have any other one.
+func (p *statusPolicy) check(name string, status vcnMeta.Status, expected vcnMeta.Status) error {
+	switch {
+	case p.denied[status]:
+		return fmt.Errorf("%s is notarized with the denied status %s", name, status)
+	case p.requireStatus && status != p.required:
+		return fmt.Errorf("%s is notarized with status %s instead of the required %s",
+			name, status, p.required)
+	case !p.requireStatus && len(p.denied) == 0 && status != expected:
+		return fmt.Errorf("%s is notarized with status %s instead of %s", name, status, expected)
+	}
+	return nil
+}
+
+// ParseStatusPerAsset parses a JSON object of the form
+// {"<asset name glob pattern>": "<status>", ...}, e.g. {"*-beta*": "unsupported"}.
+func ParseStatusPerAsset(mapping string) (map[string]string, error) {
+	statusPerAsset := make(map[string]string)
+	if err := json.Unmarshal([]byte(mapping), &statusPerAsset); err != nil {
+		return nil, fmt.Errorf("error JSON-unmarshaling the status per asset mapping: %v", err)
+	}
+	for pattern, status := range statusPerAsset {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid asset name pattern %s: %v", pattern, err)
+		}
+		status, err := ParseStatus(status)
+		if err != nil {
+			return nil, fmt.Errorf("invalid status of asset name pattern %s: %v", pattern, err)
+		}
+		statusPerAsset[pattern] = status
+	}
+	return statusPerAsset, nil
+}
+
+// statusOf returns the status the given asset is notarized with, i.e. the
+// least trusted one of all the patterns matching its name, or the default
+// status if no pattern matches.
+func statusOf(statusPerAsset map[string]string, defaultStatus string, name string) (vcnMeta.Status, error) {
+	defaultStatus, err := ParseStatus(defaultStatus)
+	if err != nil {
+		return 0, err
+	}
+	var matched bool
+	var status vcnMeta.Status
+	for pattern, patternStatus := range statusPerAsset {
+		// "*" matches also the names with slashes (e.g. of the images)
+		if ok, _ := path.Match(pattern, name); !ok && pattern != "*" {
+			continue
+		}
+		patternStatus, err := ParseStatus(patternStatus)
+		if err != nil {
+			return 0, fmt.Errorf("invalid status of asset name pattern %s: %v", pattern, err)
+		}
+		if !matched || statusPriorities[statuses[patternStatus]] > statusPriorities[status] {
+			status = statuses[patternStatus]
+		}
+		matched = true
+	}
+	if !matched {
+		status = statuses[defaultStatus]
+	}
+	return status, nil
+}
+
+// ParseSignerIDsPerTeam parses a JSON object of the form
+// {"<org>/<team-slug>": "<signer ID>", ...}; a leading "@" in the team names
+// is ignored.
+func ParseSignerIDsPerTeam(mapping string) (map[string]string, error) {
+	rawSignerIDsPerTeam := make(map[string]string)
+	if err := json.Unmarshal([]byte(mapping), &rawSignerIDsPerTeam); err != nil {
+		return nil, fmt.Errorf(
+			"error JSON-unmarshaling the signer IDs per GitHub team mapping: %v", err)
+	}
+
+	signerIDsPerTeam := make(map[string]string, len(rawSignerIDsPerTeam))
+	for team, signerID := range rawSignerIDsPerTeam {
+		team = strings.TrimPrefix(strings.TrimSpace(team), "@")
+		if pieces := strings.Split(team, "/"); len(pieces) != 2 ||
+			len(pieces[0]) == 0 || len(pieces[1]) == 0 {
+			return nil, fmt.Errorf(
+				"invalid GitHub team %s in the signer IDs per team mapping: "+
+					"must be of the form <org>/<team-slug>", team)
+		}
+		if signerID = strings.TrimSpace(signerID); len(signerID) == 0 {
+			return nil, fmt.Errorf(
+				"empty signer ID for GitHub team %s in the signer IDs per team mapping", team)
+		}
+		signerIDsPerTeam[team] = signerID
+	}
+
+	return signerIDsPerTeam, nil
+}
+
+// ParseAge parses a duration like time.ParseDuration does, additionally
+// accepting a number of days with the "d" suffix (e.g. "30d").
+func ParseAge(age string) (time.Duration, error) {
+	if strings.HasSuffix(age, "d") {
+		days, err := strconv.ParseUint(strings.TrimSuffix(age, "d"), 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number of days in %s: %v", age, err)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(age)
+}
+
+// ParseCutoff parses a cutoff date, either as a date (e.g. "2024-01-31"), a
+// RFC 3339 timestamp or an age (e.g. "90d", see ParseAge) before now.
+func ParseCutoff(cutoff string) (time.Time, error) {
+	cutoff = strings.TrimSpace(cutoff)
+	if t, err := time.Parse("2006-01-02", cutoff); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, cutoff); err == nil {
+		return t, nil
+	}
+	age, err := ParseAge(cutoff)
+	if err != nil || age < 0 {
+		return time.Time{}, fmt.Errorf(
+			"invalid cutoff %s, neither a date (YYYY-MM-DD), a RFC 3339 timestamp nor an age (e.g. 90d)", cutoff)
+	}
+	return time.Now().UTC().Add(-age), nil
+}
+
+// ParseSize parses a size in bytes, either as a number of bytes or with a unit
+// (e.g. "500MB" or "2GiB"), an empty size meaning no limit (i.e. 0).
+func ParseSize(size string) (int64, error) {
+	size = strings.TrimSpace(size)
+	if len(size) == 0 {
+		return 0, nil
+	}
+	bytes, err := humanize.ParseBytes(size)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %s: %v", size, err)
+	}
+	return int64(bytes), nil
+}
+
+// ParseMetadata parses either a JSON object or a list of key=value pairs,
+// separated by commas or new lines.
+func ParseMetadata(metadata string) (map[string]interface{}, error) {
+	metadata = strings.TrimSpace(metadata)
+	if len(metadata) == 0 {
+		return nil, nil
+	}
+
+	values := make(map[string]interface{})
+	if strings.HasPrefix(metadata, "{") {
+		if err := json.Unmarshal([]byte(metadata), &values); err != nil {
+			return nil, fmt.Errorf("error JSON-unmarshaling the metadata: %v", err)
+		}
+		return values, nil
+	}
+
+	for _, pair := range strings.FieldsFunc(metadata, func(r rune) bool {
+		return r == ',' || r == '\n'
+	}) {
+		if pair = strings.TrimSpace(pair); len(pair) == 0 {
+			continue
+		}
+		pieces := strings.SplitN(pair, "=", 2)
+		if len(pieces) != 2 || len(strings.TrimSpace(pieces[0])) == 0 {
+			return nil, fmt.Errorf("invalid metadata %s: must be of the form <key>=<value>", pair)
+		}
+		values[strings.TrimSpace(pieces[0])] = strings.TrimSpace(pieces[1])
+	}
+	return values, nil
+}
+
+// SignerIDTemplateData are the fields available in the signer ID template.
+type SignerIDTemplateData struct {
+	Login string
+	Org   string
+	Repo  string
+}
+
+func parseSignerIDTemplate(signerIDTemplate string) (*template.Template, error) {
+	if len(strings.TrimSpace(signerIDTemplate)) == 0 {
+		signerIDTemplate = defaultSignerIDTemplate
+	}
+	tmpl, err := template.New("signer-id").Option("missingkey=error").Parse(signerIDTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing the signer ID template %s: %v", signerIDTemplate, err)
+	}
+	return tmpl, nil
+}
+
+// normalizeLogin returns the given GitHub login usable in signer IDs, i.e.
+// with the [bot] suffix of the bots replaced by -bot (e.g.
+// github-actions[bot] becomes github-actions-bot).
+func normalizeLogin(login string) string {
+	if strings.HasSuffix(login, "[bot]") {
+		return strings.TrimSuffix(login, "[bot]") + "-bot"
+	}
+	return login
+}
+
+// signerIDFromTemplate renders the signer ID of the given GitHub user for a
+// release of the given repository (i.e. <owner>/<repo-name>).
+func signerIDFromTemplate(tmpl *template.Template, login string, repository string) (string, error) {
+	data := SignerIDTemplateData{Login: login}
+	if pieces := strings.SplitN(repository, "/", 2); len(pieces) == 2 {
+		data.Org, data.Repo = pieces[0], pieces[1]
+	}
+	var signerID strings.Builder
+	if err := tmpl.Execute(&signerID, data); err != nil {
+		return "", fmt.Errorf("error rendering the signer ID template for user %s: %v", login, err)
+	}
+	if len(strings.TrimSpace(signerID.String())) == 0 {
+		return "", fmt.Errorf("the signer ID template renders an empty signer ID for user %s", login)
+	}
+	return strings.TrimSpace(signerID.String()), nil
+}
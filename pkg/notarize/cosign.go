@@ -0,0 +1,29 @@
+package notarize
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// cosignSignBlob signs the given file with a keyless Sigstore signature,
+// using cosign (which must be in the PATH) with the OIDC identity token of
+// the workflow, and returns the paths of the written <file>.sig signature
+// and <file>.pem certificate.
+func cosignSignBlob(ctx context.Context, filePath string) (string, string, error) {
+	sigPath := filePath + ".sig"
+	pemPath := filePath + ".pem"
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "cosign", "sign-blob", "--yes",
+		"--output-signature", sigPath, "--output-certificate", pemPath, filePath)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", "", fmt.Errorf("error signing %s with cosign: %v: %s",
+			filePath, err, strings.TrimSpace(stderr.String()))
+	}
+
+	return sigPath, pemPath, nil
+}
@@ -0,0 +1,168 @@
+package notarize
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	vcnAPI "github.com/vchain-us/vcn/pkg/api"
+)
+
+// CrossLedger is a ledger the assets are looked up into by the cross-ledger
+// verification (see Config.VerifyLedgers), e.g. the one of another
+// environment the notarizations are mirrored into.
+type CrossLedger struct {
+	LedgerID string
+	// SignerID is the signer ID the assets are looked up as, defaulting to
+	// their own signer ID
+	SignerID string
+}
+
+func (l CrossLedger) String() string {
+	if len(l.SignerID) == 0 {
+		return l.LedgerID
+	}
+	return l.LedgerID + ":" + l.SignerID
+}
+
+// ParseCrossLedgers parses the ledgers of the cross-ledger verification, of
+// the form <ledger ID>[:<signer ID>] and separated by commas or new lines.
+func ParseCrossLedgers(ledgers string) ([]CrossLedger, error) {
+	var crossLedgers []CrossLedger
+	unique := make(map[CrossLedger]bool)
+	for _, ledger := range strings.FieldsFunc(ledgers, func(r rune) bool {
+		return r == ',' || r == '\n'
+	}) {
+		ledger = strings.TrimSpace(ledger)
+		if len(ledger) == 0 {
+			continue
+		}
+		parts := strings.SplitN(ledger, ":", 2)
+		crossLedger := CrossLedger{LedgerID: strings.TrimSpace(parts[0])}
+		if len(parts) == 2 {
+			crossLedger.SignerID = strings.TrimSpace(parts[1])
+			if len(crossLedger.SignerID) == 0 {
+				return nil, fmt.Errorf("invalid ledger %s: empty signer ID", ledger)
+			}
+		}
+		if len(crossLedger.LedgerID) == 0 {
+			return nil, fmt.Errorf("invalid ledger %s: empty ledger ID", ledger)
+		}
+		if !unique[crossLedger] {
+			unique[crossLedger] = true
+			crossLedgers = append(crossLedgers, crossLedger)
+		}
+	}
+	return crossLedgers, nil
+}
+
+// LedgerPresence is the outcome of the lookup of an asset into a ledger of
+// the cross-ledger verification.
+type LedgerPresence struct {
+	LedgerID  string     `json:"ledger_id"`
+	SignerID  string     `json:"signer_id"`
+	Found     bool       `json:"found"`
+	Status    string     `json:"status,omitempty"`
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+	UID       string     `json:"uid,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// verifyAcrossLedgers looks up the verified assets of the given units (the
+// first one of each asset) into each of the given ledgers, recording into
+// their reports the ledgers they are found in. If requireAll is set, it
+// returns (indexed like the units) the errors of the assets missing from any
+// of them.
+func verifyAcrossLedgers(
+	ctx context.Context,
+	backend Backend,
+	crossLedgers []CrossLedger,
+	requireAll bool,
+	assets []*releaseAsset,
+	units []ledgerAsset,
+	artifacts []*vcnAPI.Artifact,
+	assetsReports []*AssetReport,
+	maxParallel int,
+	log Logger,
+) ([]error, error) {
+
+	var lookedUp []int
+	seen := make(map[int]bool)
+	for u, artifact := range artifacts {
+		i := units[u].index
+		if artifact == nil || assetsReports[u] == nil || seen[i] {
+			continue
+		}
+		seen[i] = true
+		lookedUp = append(lookedUp, u)
+	}
+	if len(lookedUp) == 0 {
+		return nil, nil
+	}
+
+	log.Infof("\nVerifying %d release assets across %d ledgers ...\n\n", len(lookedUp), len(crossLedgers))
+	// notarizers[l][k] is the one of the k-th looked up asset into the l-th
+	// ledger
+	notarizers := make([][]Notarizer, len(crossLedgers))
+	for l, crossLedger := range crossLedgers {
+		signerIDs := make([]string, len(lookedUp))
+		for k, u := range lookedUp {
+			signerIDs[k] = crossLedger.SignerID
+			if len(signerIDs[k]) == 0 {
+				signerIDs[k] = assets[units[u].index].signerID
+			}
+		}
+		var err error
+		if notarizers[l], err = backend.Notarizers(ctx, crossLedger.LedgerID, signerIDs); err != nil {
+			return nil, fmt.Errorf("error getting the notarizers of ledger %s: %v", crossLedger.LedgerID, err)
+		}
+	}
+
+	lookupErrs := forEachParallel(len(lookedUp), maxParallel, false, func(k int) error {
+		u := lookedUp[k]
+		presences := make([]LedgerPresence, len(crossLedgers))
+		var missing []string
+		for l, crossLedger := range crossLedgers {
+			presence := &presences[l]
+			presence.LedgerID = crossLedger.LedgerID
+			presence.SignerID = crossLedger.SignerID
+			if len(presence.SignerID) == 0 {
+				presence.SignerID = assets[units[u].index].signerID
+			}
+			entry, err := notarizers[l][k].Verify(ctx, artifacts[u])
+			switch {
+			case err != nil:
+				presence.Error = err.Error()
+			case entry != nil:
+				presence.Found = true
+				presence.Status = entry.Status.String()
+				timestamp := entry.Timestamp
+				presence.Timestamp = &timestamp
+				presence.UID = entry.UID
+			}
+			if !presence.Found {
+				missing = append(missing, crossLedger.String())
+			}
+		}
+		assetsReports[u].Ledgers = presences
+		if len(missing) == 0 {
+			log.Successf("Asset %s is notarized in all the %d ledgers\n",
+				assets[units[u].index].name, len(crossLedgers))
+			return nil
+		}
+		err := fmt.Errorf("%s is not notarized in ledgers %s",
+			assets[units[u].index].name, strings.Join(missing, ", "))
+		if requireAll {
+			return err
+		}
+		log.Warningf("WARNING: %v\n", err)
+		return nil
+	})
+
+	errs := make([]error, len(artifacts))
+	for k, u := range lookedUp {
+		errs[u] = lookupErrs[k]
+	}
+	return errs, nil
+}
@@ -0,0 +1,305 @@
+package notarize
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/codenotary/notarize-release-assets-action/pkg/github"
+)
+
+// dependencyManifestSuffix is the suffix of the name of the dependency
+// manifest, i.e. <repo-name>-<tag>.deps.json.
+const dependencyManifestSuffix = ".deps.json"
+
+// defaultDependencyFiles are the lockfiles read by default, the missing ones
+// being skipped.
+var defaultDependencyFiles = []string{"go.sum", "package-lock.json", "requirements.txt"}
+
+// DependencyOptions are the settings of the dependency manifest, listing the
+// dependencies locked by the lockfiles of the repository at the release tag.
+type DependencyOptions struct {
+	// Files are the paths (in the repository) of the lockfiles, i.e. of go.sum,
+	// package-lock.json or requirements.txt files, which must exist. If empty,
+	// the ones at the root of the repository are read, if any.
+	Files []string
+
+	// Upload specifies to upload the dependency manifest to the release.
+	Upload bool
+}
+
+// DependencyManifest is the content of the dependency manifest, which is
+// notarized (and possibly uploaded) along with the release assets.
+type DependencyManifest struct {
+	Repository   string             `json:"repository"`
+	ReleaseTag   string             `json:"release_tag"`
+	Commit       string             `json:"commit"`
+	Lockfiles    []DependencySource `json:"lockfiles"`
+	Dependencies []Dependency       `json:"dependencies"`
+}
+
+// DependencySource is a lockfile the dependencies are read from.
+type DependencySource struct {
+	Path string `json:"path"`
+	Hash string `json:"hash"`
+}
+
+// Dependency is a dependency locked by a lockfile.
+type Dependency struct {
+	Ecosystem string `json:"ecosystem"`
+	Name      string `json:"name"`
+	// Version is the locked version or, for the unpinned Python requirements,
+	// the version specifier (e.g. >=1.2)
+	Version string `json:"version,omitempty"`
+	Hash    string `json:"hash,omitempty"`
+	PURL    string `json:"purl,omitempty"`
+	// Lockfile is the path of the lockfile locking the dependency
+	Lockfile string `json:"lockfile"`
+}
+
+// dependencyParsers are the parsers of the supported lockfiles, by file name.
+var dependencyParsers = map[string]func(content []byte) ([]Dependency, error){
+	"go.sum":            parseGoSum,
+	"package-lock.json": parsePackageLock,
+	"requirements.txt":  parseRequirements,
+}
+
+// dependencyManifestAsset reads the lockfiles of the given repository at the
+// given commit and returns the asset of the dependency manifest, written into
+// the given dir, along with its digest (i.e. sha256:<hex>).
+func dependencyManifestAsset(
+	ctx context.Context,
+	httpClient *http.Client,
+	apiBaseURL string,
+	githubToken string,
+	options *DependencyOptions,
+	dir string,
+	repository string,
+	repoAndTag string,
+	releaseTag string,
+	tagCommitSHA string,
+	signerID string,
+	log Logger,
+) (*releaseAsset, string, error) {
+
+	files, required := options.Files, true
+	if len(files) == 0 {
+		files, required = defaultDependencyFiles, false
+	}
+	manifest := &DependencyManifest{
+		Repository:   repository,
+		ReleaseTag:   releaseTag,
+		Commit:       tagCommitSHA,
+		Lockfiles:    []DependencySource{},
+		Dependencies: []Dependency{},
+	}
+	for _, filePath := range files {
+		parse, ok := dependencyParsers[path.Base(filePath)]
+		if !ok {
+			return nil, "", fmt.Errorf("unsupported lockfile %s: must be either a go.sum, "+
+				"a package-lock.json or a requirements.txt file", filePath)
+		}
+		content, err := github.GetFileContent(
+			ctx, httpClient, apiBaseURL, repository, filePath, tagCommitSHA, githubToken)
+		if errors.Is(err, github.ErrNotFound) && !required {
+			continue
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("error reading lockfile %s at commit %s: %v",
+				filePath, tagCommitSHA, err)
+		}
+		dependencies, err := parse(content)
+		if err != nil {
+			return nil, "", fmt.Errorf("error parsing lockfile %s: %v", filePath, err)
+		}
+		log.Infof("Read %d dependencies from lockfile %s\n", len(dependencies), filePath)
+		hash := sha256.Sum256(content)
+		manifest.Lockfiles = append(manifest.Lockfiles,
+			DependencySource{Path: filePath, Hash: hex.EncodeToString(hash[:])})
+		for _, dependency := range dependencies {
+			dependency.Lockfile = filePath
+			manifest.Dependencies = append(manifest.Dependencies, dependency)
+		}
+	}
+	if len(manifest.Lockfiles) == 0 {
+		return nil, "", fmt.Errorf("no lockfile (i.e. %s) found at commit %s",
+			strings.Join(files, ", "), tagCommitSHA)
+	}
+
+	// the manifest is the same for the same commit, e.g. when verifying
+	sort.SliceStable(manifest.Dependencies, func(i, j int) bool {
+		a, b := manifest.Dependencies[i], manifest.Dependencies[j]
+		if a.Ecosystem != b.Ecosystem {
+			return a.Ecosystem < b.Ecosystem
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Version < b.Version
+	})
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, "", fmt.Errorf("error JSON-marshaling the dependency manifest: %v", err)
+	}
+	content = append(content, '\n')
+	filePath := filepath.Join(dir, repoAndTag+dependencyManifestSuffix)
+	if err := os.WriteFile(filePath, content, 0644); err != nil {
+		return nil, "", fmt.Errorf("error writing dependency manifest %s: %v", filePath, err)
+	}
+	log.Infof("Recorded %d dependencies into manifest %s\n", len(manifest.Dependencies), filepath.Base(filePath))
+	digest := sha256.Sum256(content)
+
+	return &releaseAsset{
+		name:     filepath.Base(filePath),
+		signerID: signerID,
+		filePath: filePath,
+	}, "sha256:" + hex.EncodeToString(digest[:]), nil
+}
+
+// parseGoSum returns the Go modules listed in the given go.sum file, i.e. the
+// lines "<module> <version> <hash>" (the ones of the go.mod files only are
+// skipped).
+func parseGoSum(content []byte) ([]Dependency, error) {
+	var dependencies []Dependency
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid line %d: expecting <module> <version> <hash>", n)
+		}
+		if strings.HasSuffix(fields[1], "/go.mod") {
+			continue
+		}
+		dependencies = append(dependencies, Dependency{
+			Ecosystem: "go",
+			Name:      fields[0],
+			Version:   fields[1],
+			Hash:      fields[2],
+			PURL:      "pkg:golang/" + fields[0] + "@" + url.PathEscape(fields[1]),
+		})
+	}
+	return dependencies, scanner.Err()
+}
+
+// packageLock is the content of a package-lock.json file, whose packages are
+// listed (by path) in packages since its version 2, and (recursively) in
+// dependencies before.
+type packageLock struct {
+	LockfileVersion int                              `json:"lockfileVersion"`
+	Packages        map[string]packageLockDependency `json:"packages"`
+	Dependencies    map[string]packageLockDependency `json:"dependencies"`
+}
+
+type packageLockDependency struct {
+	Version      string                           `json:"version"`
+	Integrity    string                           `json:"integrity"`
+	Link         bool                             `json:"link"`
+	Dependencies map[string]packageLockDependency `json:"dependencies"`
+}
+
+// parsePackageLock returns the npm packages listed in the given
+// package-lock.json file (the root package and the links excluded).
+func parsePackageLock(content []byte) ([]Dependency, error) {
+	var lock packageLock
+	if err := json.Unmarshal(content, &lock); err != nil {
+		return nil, fmt.Errorf("error JSON-unmarshaling the lockfile: %v", err)
+	}
+	var dependencies []Dependency
+	add := func(name string, pkg packageLockDependency) {
+		if len(name) == 0 || pkg.Link || len(pkg.Version) == 0 {
+			return
+		}
+		// the scope of the scoped packages is a namespace
+		purlName := name
+		if strings.HasPrefix(name, "@") {
+			purlName = "%40" + name[1:]
+		}
+		dependencies = append(dependencies, Dependency{
+			Ecosystem: "npm",
+			Name:      name,
+			Version:   pkg.Version,
+			Hash:      pkg.Integrity,
+			PURL:      "pkg:npm/" + purlName + "@" + url.PathEscape(pkg.Version),
+		})
+	}
+	if len(lock.Packages) > 0 {
+		for pkgPath, pkg := range lock.Packages {
+			i := strings.LastIndex(pkgPath, "node_modules/")
+			if i < 0 {
+				continue
+			}
+			add(pkgPath[i+len("node_modules/"):], pkg)
+		}
+		return dependencies, nil
+	}
+	var walk func(deps map[string]packageLockDependency)
+	walk = func(deps map[string]packageLockDependency) {
+		for name, pkg := range deps {
+			add(name, pkg)
+			walk(pkg.Dependencies)
+		}
+	}
+	walk(lock.Dependencies)
+	return dependencies, nil
+}
+
+// parseRequirements returns the Python packages listed in the given
+// requirements.txt file, along with their first hash (see --hash), if any. The
+// options (e.g. -r or --index-url) and the URL requirements are skipped.
+func parseRequirements(content []byte) ([]Dependency, error) {
+	var dependencies []Dependency
+	// the lines ending with a backslash continue on the next one
+	text := strings.ReplaceAll(string(content), "\\\r\n", " ")
+	text = strings.ReplaceAll(text, "\\\n", " ")
+	for _, line := range strings.Split(text, "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "-") || strings.Contains(fields[0], "://") {
+			continue
+		}
+		requirement := fields[0]
+		// the environment markers (e.g. ; python_version < "3.8") are ignored
+		if i := strings.Index(requirement, ";"); i >= 0 {
+			requirement = requirement[:i]
+		}
+		name, version := requirement, ""
+		if i := strings.IndexAny(requirement, "=<>!~"); i >= 0 {
+			name, version = requirement[:i], requirement[i:]
+		}
+		// the extras (e.g. requests[socks]) are part of the requirement only
+		if i := strings.Index(name, "["); i >= 0 {
+			name = name[:i]
+		}
+		dependency := Dependency{Ecosystem: "pypi", Name: name, Version: version}
+		if strings.HasPrefix(version, "==") && !strings.Contains(version, ",") {
+			dependency.Version = strings.TrimPrefix(version, "==")
+			dependency.PURL = "pkg:pypi/" + strings.ToLower(strings.ReplaceAll(name, "_", "-")) +
+				"@" + url.PathEscape(dependency.Version)
+		}
+		for _, field := range fields[1:] {
+			if strings.HasPrefix(field, "--hash=") {
+				dependency.Hash = strings.TrimPrefix(field, "--hash=")
+				break
+			}
+		}
+		dependencies = append(dependencies, dependency)
+	}
+	return dependencies, nil
+}
@@ -0,0 +1,182 @@
+package notarize
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+
+	vcnAPI "github.com/vchain-us/vcn/pkg/api"
+	"golang.org/x/crypto/blake2b"
+)
+
+// The digest algorithms of the assets (see Config.Digests and
+// Config.PrimaryDigest).
+const (
+	DigestSHA256  = "sha256"
+	DigestSHA512  = "sha512"
+	DigestBLAKE2b = "blake2b" // i.e. BLAKE2b-512
+)
+
+// digestsMetadataKey is the key of the digests of an asset in the metadata of
+// its ledger entry.
+const digestsMetadataKey = "digests"
+
+// newDigestHash returns the hash of the given digest algorithm.
+func newDigestHash(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case DigestSHA256:
+		return sha256.New(), nil
+	case DigestSHA512:
+		return sha512.New(), nil
+	case DigestBLAKE2b:
+		return blake2b.New512(nil)
+	default:
+		return nil, fmt.Errorf("invalid digest algorithm %s: must be one of %s, %s or %s",
+			algorithm, DigestSHA256, DigestSHA512, DigestBLAKE2b)
+	}
+}
+
+// ParseDigest parses a digest algorithm, an empty one meaning sha256.
+func ParseDigest(algorithm string) (string, error) {
+	algorithm = strings.ToLower(strings.TrimSpace(algorithm))
+	if len(algorithm) == 0 {
+		return DigestSHA256, nil
+	}
+	if _, err := newDigestHash(algorithm); err != nil {
+		return "", err
+	}
+	return algorithm, nil
+}
+
+// ParseDigests parses a list of digest algorithms, separated by commas or new
+// lines (e.g. "sha512, blake2b").
+func ParseDigests(algorithms string) ([]string, error) {
+	var parsed []string
+	for _, algorithm := range strings.FieldsFunc(algorithms, func(r rune) bool {
+		return r == ',' || r == '\n'
+	}) {
+		if len(strings.TrimSpace(algorithm)) == 0 {
+			continue
+		}
+		algorithm, err := ParseDigest(algorithm)
+		if err != nil {
+			return nil, err
+		}
+		if !containsString(parsed, algorithm) {
+			parsed = append(parsed, algorithm)
+		}
+	}
+	return parsed, nil
+}
+
+// extraDigestAlgorithms returns the algorithms of the digests computed in
+// addition to the SHA-256 hash of vcn, i.e. the recorded ones and the primary
+// one.
+func extraDigestAlgorithms(cfg *Config) []string {
+	var algorithms []string
+	for _, algorithm := range append(append([]string{}, cfg.Digests...), cfg.PrimaryDigest) {
+		if len(algorithm) > 0 && algorithm != DigestSHA256 && !containsString(algorithms, algorithm) {
+			algorithms = append(algorithms, algorithm)
+		}
+	}
+	return algorithms
+}
+
+// digester computes the digests of the given algorithms of the bytes written
+// into it.
+type digester struct {
+	io.Writer
+	hashes map[string]hash.Hash
+}
+
+// newDigester returns the digester of the given algorithms, or nil if there
+// is none.
+func newDigester(algorithms []string) (*digester, error) {
+	if len(algorithms) == 0 {
+		return nil, nil
+	}
+	d := &digester{hashes: make(map[string]hash.Hash, len(algorithms))}
+	var writers []io.Writer
+	for _, algorithm := range algorithms {
+		h, err := newDigestHash(algorithm)
+		if err != nil {
+			return nil, err
+		}
+		d.hashes[algorithm] = h
+		writers = append(writers, h)
+	}
+	d.Writer = io.MultiWriter(writers...)
+	return d, nil
+}
+
+// sums returns the hex-encoded digests, by algorithm.
+func (d *digester) sums() map[string]string {
+	sums := make(map[string]string, len(d.hashes))
+	for algorithm, h := range d.hashes {
+		sums[algorithm] = hex.EncodeToString(h.Sum(nil))
+	}
+	return sums
+}
+
+// fileDigests returns the digests of the given algorithms of the given file,
+// if any.
+func fileDigests(filePath string, algorithms []string) (map[string]string, error) {
+	d, err := newDigester(algorithms)
+	if err != nil || d == nil {
+		return nil, err
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening asset file %s for computing its digests: %v", filePath, err)
+	}
+	defer file.Close()
+	if _, err := io.Copy(d, file); err != nil {
+		return nil, fmt.Errorf("error reading asset file %s for computing its digests: %v", filePath, err)
+	}
+	return d.sums(), nil
+}
+
+// sha256Hash returns the SHA-256 hash of the asset of the given report,
+// whichever its primary digest is.
+func (r *AssetReport) sha256Hash() string {
+	if hash, ok := r.Digests[DigestSHA256]; ok {
+		return hash
+	}
+	return r.Hash
+}
+
+// digestSet returns the digests of the asset of the given report by
+// algorithm, e.g. for the subjects of the in-toto statements.
+func (r *AssetReport) digestSet() map[string]string {
+	if len(r.Digests) > 0 {
+		return r.Digests
+	}
+	return map[string]string{DigestSHA256: r.Hash}
+}
+
+// applyDigests records the given digests of the asset of the given artifact,
+// along with its SHA-256 hash, in its metadata, and makes the one of the
+// given primary algorithm its hash, i.e. the key of its ledger entry.
+func applyDigests(artifact *vcnAPI.Artifact, digests map[string]string, primary string) error {
+	if len(digests) == 0 {
+		return nil
+	}
+	recorded := map[string]string{DigestSHA256: artifact.Hash}
+	for algorithm, digest := range digests {
+		recorded[algorithm] = digest
+	}
+	artifact.Metadata.Set(digestsMetadataKey, recorded)
+	if len(primary) > 0 && primary != DigestSHA256 {
+		digest, ok := recorded[primary]
+		if !ok {
+			return fmt.Errorf("the %s digest of asset %s is unknown", primary, artifact.Name)
+		}
+		artifact.Hash = digest
+	}
+	return nil
+}
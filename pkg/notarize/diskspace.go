@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package notarize
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// availableDiskSpace returns the disk space available to the current user in
+// the filesystem of the given dir.
+func availableDiskSpace(dir string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, fmt.Errorf("error getting the available disk space in %s: %v", dir, err)
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
@@ -0,0 +1,9 @@
+package notarize
+
+import "errors"
+
+// availableDiskSpace is not implemented on Windows, where the disk space
+// preflight check is skipped.
+func availableDiskSpace(dir string) (uint64, error) {
+	return 0, errors.New("getting the available disk space is not supported on Windows")
+}
@@ -0,0 +1,288 @@
+package notarize
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	vcnAPI "github.com/vchain-us/vcn/pkg/api"
+	vcnDockerExtractor "github.com/vchain-us/vcn/pkg/extractor/docker"
+)
+
+const (
+	mediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+)
+
+// imageReference is a parsed container image reference, e.g.
+// ghcr.io/org/app:v1.2.3 or ghcr.io/org/app@sha256:...
+type imageReference struct {
+	registry   string
+	repository string
+	// reference is either the tag or the digest
+	reference string
+}
+
+func parseImageReference(image string) (*imageReference, error) {
+	ref := &imageReference{registry: "registry-1.docker.io", reference: "latest"}
+	name := strings.TrimPrefix(strings.TrimSpace(image), "docker://")
+	if len(name) == 0 {
+		return nil, errors.New("empty image reference")
+	}
+
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, ref.reference = name[:i], name[i+1:]
+	} else if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, ref.reference = name[:i], name[i+1:]
+	}
+
+	// the first component is the registry only if it looks like a host
+	if i := strings.Index(name, "/"); i >= 0 &&
+		(strings.ContainsAny(name[:i], ".:") || name[:i] == "localhost") {
+		ref.registry, name = name[:i], name[i+1:]
+	} else if !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	if ref.registry == "docker.io" {
+		ref.registry = "registry-1.docker.io"
+	}
+	ref.repository = name
+
+	return ref, nil
+}
+
+type imageManifestDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      uint64 `json:"size"`
+	Platform  *struct {
+		Architecture string `json:"architecture"`
+		OS           string `json:"os"`
+		Variant      string `json:"variant"`
+	} `json:"platform"`
+}
+
+type imageManifest struct {
+	MediaType string                    `json:"mediaType"`
+	Config    imageManifestDescriptor   `json:"config"`
+	Layers    []imageManifestDescriptor `json:"layers"`
+	Manifests []imageManifestDescriptor `json:"manifests"`
+}
+
+type imageConfig struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+}
+
+// errRegistryNotFound is returned (wrapped) by the registry requests answered
+// with a 404.
+var errRegistryNotFound = errors.New("not found in the registry")
+
+// registryClient gets manifests and blobs from a container registry (see the
+// OCI distribution spec), authenticating with bearer tokens when requested.
+type registryClient struct {
+	httpClient *http.Client
+	// username and password, if any, are used for getting the bearer tokens
+	username string
+	password string
+	token    string
+}
+
+func (c *registryClient) get(
+	ctx context.Context,
+	u string,
+	accept []string,
+	responsePayload interface{},
+) (http.Header, error) {
+
+	for attempt := 0; attempt < 2; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+		if err != nil {
+			return nil, fmt.Errorf("error creating new HTTP GET %s request: %v", u, err)
+		}
+		req.Header.Set("Accept", strings.Join(accept, ", "))
+		if len(c.token) > 0 {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("error sending request GET %s: %v", u, err)
+		}
+		respBody, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("GET %s: error reading response body: %v", u, err)
+		}
+
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			if err := c.authenticate(ctx, resp.Header.Get("WWW-Authenticate")); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("%w: GET %s", errRegistryNotFound, u)
+		}
+		if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+			return nil, fmt.Errorf("GET %s error: expected a 2xx HTTP code, got %d with body %s",
+				u, resp.StatusCode, respBody)
+		}
+		if err := json.Unmarshal(respBody, responsePayload); err != nil {
+			return nil, fmt.Errorf("error JSON-unmarshaling GET %s response body: %v", u, err)
+		}
+		return resp.Header, nil
+	}
+
+	return nil, fmt.Errorf("GET %s error: unauthorized", u)
+}
+
+// authenticate gets a bearer token as requested by the WWW-Authenticate
+// header of an unauthorized response.
+func (c *registryClient) authenticate(ctx context.Context, challenge string) error {
+	if !strings.HasPrefix(challenge, "Bearer ") {
+		return fmt.Errorf("unsupported registry authentication challenge %s", challenge)
+	}
+	params := make(map[string]string)
+	for _, param := range strings.Split(strings.TrimPrefix(challenge, "Bearer "), ",") {
+		if pieces := strings.SplitN(strings.TrimSpace(param), "=", 2); len(pieces) == 2 {
+			params[pieces[0]] = strings.Trim(pieces[1], `"`)
+		}
+	}
+	realm, err := url.Parse(params["realm"])
+	if err != nil || len(realm.Host) == 0 {
+		return fmt.Errorf("invalid registry authentication realm in challenge %s", challenge)
+	}
+	query := realm.Query()
+	for _, key := range []string{"service", "scope"} {
+		if len(params[key]) > 0 {
+			query.Set(key, params[key])
+		}
+	}
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", realm.String(), nil)
+	if err != nil {
+		return fmt.Errorf("error creating new HTTP GET %s request: %v", realm, err)
+	}
+	if len(c.password) > 0 {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error getting registry token from %s: %v", realm, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error getting registry token from %s: expected HTTP code 200, got %d",
+			realm, resp.StatusCode)
+	}
+	var tokenResp struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return fmt.Errorf("error JSON-decoding registry token from %s: %v", realm, err)
+	}
+	c.token = tokenResp.Token
+	if len(c.token) == 0 {
+		c.token = tokenResp.AccessToken
+	}
+	return nil
+}
+
+// imageArtifacts creates the vcn artifacts of the given container image, one
+// per platform for multi-platform images. Like vcn does for docker://
+// artifacts, the hash is the image ID (i.e. the digest of the image config),
+// so that the images can be authenticated with vcn once pulled.
+func imageArtifacts(
+	ctx context.Context,
+	httpClient *http.Client,
+	image string,
+	githubToken string,
+) ([]*vcnAPI.Artifact, error) {
+
+	ref, err := parseImageReference(image)
+	if err != nil {
+		return nil, err
+	}
+	client := &registryClient{httpClient: httpClient}
+	// the GitHub token can read the GitHub container registry packages
+	if ref.registry == "ghcr.io" && len(githubToken) > 0 {
+		client.username, client.password = "token", githubToken
+	}
+	manifestURL := func(reference string) string {
+		return fmt.Sprintf("https://%s/v2/%s/manifests/%s", ref.registry, ref.repository, reference)
+	}
+	accept := []string{
+		mediaTypeOCIIndex, mediaTypeDockerManifestList, mediaTypeOCIManifest, mediaTypeDockerManifest}
+
+	var manifest imageManifest
+	header, err := client.get(ctx, manifestURL(ref.reference), accept, &manifest)
+	if err != nil {
+		return nil, fmt.Errorf("error getting the manifest of image %s: %v", image, err)
+	}
+	repoDigest := header.Get("Docker-Content-Digest")
+
+	manifests := []imageManifest{manifest}
+	if len(manifest.Manifests) > 0 {
+		manifests = nil
+		for _, descriptor := range manifest.Manifests {
+			// skip the attestation manifests, which are not images
+			if descriptor.Platform == nil || descriptor.Platform.OS == "unknown" {
+				continue
+			}
+			var platformManifest imageManifest
+			if _, err := client.get(
+				ctx, manifestURL(descriptor.Digest), accept, &platformManifest); err != nil {
+				return nil, fmt.Errorf("error getting the %s/%s manifest of image %s: %v",
+					descriptor.Platform.OS, descriptor.Platform.Architecture, image, err)
+			}
+			manifests = append(manifests, platformManifest)
+		}
+	}
+
+	var artifacts []*vcnAPI.Artifact
+	for _, m := range manifests {
+		if len(m.Config.Digest) == 0 {
+			return nil, fmt.Errorf("no config found in the manifest of image %s", image)
+		}
+		var config imageConfig
+		if _, err := client.get(ctx, fmt.Sprintf("https://%s/v2/%s/blobs/%s",
+			ref.registry, ref.repository, m.Config.Digest), []string{"*/*"}, &config); err != nil {
+			return nil, fmt.Errorf("error getting the config of image %s: %v", image, err)
+		}
+		var size uint64
+		for _, layer := range m.Layers {
+			size += layer.Size
+		}
+
+		metadata := vcnAPI.Metadata{
+			"architecture": config.Architecture,
+			"platform":     config.OS,
+			vcnDockerExtractor.Scheme: map[string]interface{}{
+				"Id":          m.Config.Digest,
+				"RepoDigests": []string{ref.repository + "@" + repoDigest},
+			},
+		}
+		if !strings.HasPrefix(ref.reference, "sha256:") && ref.reference != "latest" {
+			metadata["version"] = ref.reference
+		}
+		artifacts = append(artifacts, &vcnAPI.Artifact{
+			Kind:     vcnDockerExtractor.Scheme,
+			Name:     vcnDockerExtractor.Scheme + "://" + strings.TrimPrefix(image, "docker://"),
+			Hash:     strings.TrimPrefix(m.Config.Digest, "sha256:"),
+			Size:     size,
+			Metadata: metadata,
+		})
+	}
+
+	return artifacts, nil
+}
@@ -0,0 +1,438 @@
+package notarize
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/codenotary/notarize-release-assets-action/pkg/github"
+	"github.com/dustin/go-humanize"
+	vcnAPI "github.com/vchain-us/vcn/pkg/api"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// downloadAssets downloads the assets into the given dir, using at most
+// maxParallel concurrent downloads, and returns the paths of the downloaded
+// files along with the durations of their downloads (in the same order as
+// the assets).
+func downloadAssets(
+	ctx context.Context,
+	httpClient *http.Client,
+	dir string,
+	assets []*releaseAsset,
+	githubToken string,
+	maxParallel int,
+	log Logger,
+) ([]string, []time.Duration, error) {
+
+	filePaths := make([]string, len(assets))
+	durations := make([]time.Duration, len(assets))
+	counter := newDownloadCounter(len(assets))
+	errs := forEachParallel(len(assets), maxParallel, true, func(i int) error {
+		started := time.Now()
+		filePath, err := downloadAsset(
+			ctx, httpClient, dir, assets[i], counter.next(), githubToken, log)
+		filePaths[i] = filePath
+		durations[i] = time.Since(started)
+		return err
+	})
+	if err := assetsError(assets, errs); err != nil {
+		return nil, nil, err
+	}
+
+	return filePaths, durations, nil
+}
+
+// checkAssetsSizes makes sure, before downloading anything, that none of the
+// assets (whose size is known, i.e. all but the source code archives) is
+// larger than the given maximum size, if any, and that there is enough disk
+// space in the given dir for the assets to download.
+func checkAssetsSizes(
+	assets []*releaseAsset,
+	assetsToDownload []*releaseAsset,
+	maxAssetSize int64,
+	dir string,
+	log Logger,
+) error {
+
+	if maxAssetSize > 0 {
+		var tooLarge []string
+		for _, asset := range assets {
+			if asset.artifact == nil && asset.size > maxAssetSize {
+				tooLarge = append(tooLarge, fmt.Sprintf("%s (%s)",
+					asset.name, humanize.Bytes(uint64(asset.size))))
+			}
+		}
+		if len(tooLarge) > 0 {
+			return fmt.Errorf("the release assets %s are larger than the maximum asset size of %s",
+				strings.Join(tooLarge, ", "), humanize.Bytes(uint64(maxAssetSize)))
+		}
+	}
+
+	var needed uint64
+	for _, asset := range assetsToDownload {
+		needed += uint64(asset.size)
+	}
+	if needed == 0 {
+		return nil
+	}
+	available, err := availableDiskSpace(dir)
+	if err != nil {
+		log.Warningf("WARNING: skipping the disk space check: %v\n", err)
+		return nil
+	}
+	if needed > available {
+		return fmt.Errorf("not enough disk space in %s for downloading the release assets: "+
+			"%s needed, %s available (the stream_assets input avoids storing them on disk)",
+			dir, humanize.Bytes(needed), humanize.Bytes(available))
+	}
+	log.Infof("Downloading %s of release assets (%s of disk space available)\n",
+		humanize.Bytes(needed), humanize.Bytes(available))
+
+	return nil
+}
+
+// downloadAsset downloads the given asset into the given dir, reporting its
+// progress with the given position among the downloads (e.g. 7/23).
+func downloadAsset(
+	ctx context.Context,
+	httpClient *http.Client,
+	dir string,
+	asset *releaseAsset,
+	position string,
+	githubToken string,
+	log Logger,
+) (_ string, err error) {
+
+	ctx, endSpan := startSpan(ctx, "download asset", attribute.String("asset.name", asset.name))
+	defer func() { endSpan(err) }()
+
+	u := strings.TrimSpace(asset.url)
+	fileName := asset.name
+	filePath := filepath.Join(dir, fileName)
+
+	log.Infof("Downloading asset %s%s to temp file %s ...\n", positionPrefix(position), u, filePath)
+	file, err := os.Create(filePath)
+	if err != nil {
+		return "", fmt.Errorf("error creating temp file %s", filePath)
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Errorf("error closing asset temp file %s: %v\n", filePath, err)
+		}
+	}()
+
+	body, err := openAsset(ctx, httpClient, asset, position, githubToken, log)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err := body.Close(); err != nil {
+			log.Errorf("error closing HTTP response body after downloading asset %s: %v\n",
+				fileName, err)
+		}
+	}()
+
+	written, err := io.Copy(file, body)
+	if err != nil {
+		return "", fmt.Errorf(
+			"error saving downloaded asset %s to temp file %s: %v",
+			fileName, filePath, err)
+	}
+	if asset.size > 0 && written != asset.size {
+		return "", fmt.Errorf("error saving downloaded asset %s to temp file %s: "+
+			"wrote %d bytes instead of its size of %d", fileName, filePath, written, asset.size)
+	}
+
+	return filePath, nil
+}
+
+// streamAsset downloads the asset and creates the vcn artifact from the
+// response body as it is received, without storing it on disk, reporting its
+// progress with the given position among the streams (e.g. 7/23). The assets
+// which need a local file (see needsLocalFile), e.g. the executables whose
+// artifact the vcn file extractor creates from the whole file, are spooled to
+// a file of the given dir instead, whose path is returned too. It also
+// returns the digests of the asset of the given algorithms, if any.
+func streamAsset(
+	ctx context.Context,
+	httpClient *http.Client,
+	spoolDir string,
+	asset *releaseAsset,
+	position string,
+	githubToken string,
+	algorithms []string,
+	log Logger,
+) (_ *vcnAPI.Artifact, _ map[string]string, spooledFile string, err error) {
+
+	ctx, endSpan := startSpan(ctx, "stream asset", attribute.String("asset.name", asset.name))
+	defer func() { endSpan(err) }()
+
+	digester, err := newDigester(algorithms)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	log.Infof("Streaming asset %s%s ...\n", positionPrefix(position), asset.url)
+	body, err := openAsset(ctx, httpClient, asset, position, githubToken, log)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	defer func() {
+		if err := body.Close(); err != nil {
+			log.Errorf("error closing HTTP response body after streaming asset %s: %v\n",
+				asset.name, err)
+		}
+	}()
+
+	var r io.Reader = body
+	if digester != nil {
+		r = io.TeeReader(body, digester)
+	}
+	head := make([]byte, sniffedHeadSize)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, nil, "", fmt.Errorf("error streaming asset from URL %s: %v", asset.url, err)
+	}
+	head = head[:n]
+	r = io.MultiReader(bytes.NewReader(head), r)
+	var artifact *vcnAPI.Artifact
+	if needsLocalFile(head) {
+		if spooledFile, err = spoolAsset(spoolDir, asset.name, r); err == nil {
+			log.Infof("Spooled asset %s to temp file %s, since its metadata needs a local file\n",
+				asset.name, spooledFile)
+			artifact, err = vcnArtifactFromAssetFile(spooledFile)
+		}
+	} else {
+		artifact, err = vcnArtifactFromReader(asset.name, r)
+	}
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("error streaming asset from URL %s: %v", asset.url, err)
+	}
+	if asset.size > 0 && int64(artifact.Size) != asset.size {
+		return nil, nil, "", fmt.Errorf("error streaming asset from URL %s: hashed %d bytes instead of its size of %d",
+			asset.url, artifact.Size, asset.size)
+	}
+	if digester == nil {
+		return artifact, nil, spooledFile, nil
+	}
+	return artifact, digester.sums(), spooledFile, nil
+}
+
+// spoolAsset writes the given content of the asset of the given name into a
+// file of a new temp dir of the given dir (deleted along with the given dir),
+// and returns its path.
+func spoolAsset(dir string, name string, r io.Reader) (string, error) {
+	spoolDir, err := os.MkdirTemp(dir, "stream-")
+	if err != nil {
+		return "", fmt.Errorf("error creating spool dir of asset %s: %v", name, err)
+	}
+	filePath := filepath.Join(spoolDir, name)
+	file, err := os.Create(filePath)
+	if err != nil {
+		return "", fmt.Errorf("error creating spool file %s: %v", filePath, err)
+	}
+	_, err = io.Copy(file, r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.RemoveAll(spoolDir)
+		return "", fmt.Errorf("error spooling asset %s to file %s: %v", name, filePath, err)
+	}
+	return filePath, nil
+}
+
+// downloadAttempts is the maximum number of attempts for opening (or
+// resuming) the download of an asset.
+const downloadAttempts = 3
+
+// resumableAsset is the content of an asset being downloaded, which resumes
+// the download (with a range request) from where it stopped if the connection
+// fails or is closed early, and checks the final size against the expected
+// one, so that a truncated download is never hashed.
+type resumableAsset struct {
+	ctx         context.Context
+	httpClient  *http.Client
+	asset       *releaseAsset
+	githubToken string
+	log         Logger
+
+	download *github.AssetDownload
+	// offset is the number of bytes read so far
+	offset int64
+	// size is the expected size, or -1 if unknown
+	size     int64
+	attempts int
+	progress *downloadProgress
+}
+
+// openAsset opens the download of the given asset, retrying on failure.
+func openAsset(
+	ctx context.Context,
+	httpClient *http.Client,
+	asset *releaseAsset,
+	position string,
+	githubToken string,
+	log Logger,
+) (*resumableAsset, error) {
+
+	r := &resumableAsset{
+		ctx:         ctx,
+		httpClient:  httpClient,
+		asset:       asset,
+		githubToken: githubToken,
+		log:         log,
+		size:        -1,
+		progress:    newDownloadProgress(asset.name, position, log),
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	if asset.size > 0 {
+		r.size = asset.size
+	} else if r.download.Size >= 0 {
+		r.size = r.download.Size
+	}
+	return r, nil
+}
+
+// open (re)opens the download from the current offset, skipping the bytes
+// already read if the server does not support range requests.
+func (r *resumableAsset) open() error {
+	var err error
+	for r.attempts < downloadAttempts {
+		if r.attempts > 0 {
+			select {
+			case <-time.After(time.Duration(r.attempts) * time.Second):
+			case <-r.ctx.Done():
+				return r.ctx.Err()
+			}
+		}
+		r.attempts++
+		var download *github.AssetDownload
+		download, err = github.OpenAssetRange(
+			r.ctx, r.httpClient, r.asset.url, r.githubToken, r.asset.sourceArchive, r.offset)
+		if err != nil {
+			continue
+		}
+		if err = checkContentType(r.asset, download.ContentType); err != nil {
+			r.log.Warningf("WARNING: %v\n", err)
+			download.Body.Close()
+			continue
+		}
+		if download.Offset < r.offset {
+			if _, err = io.CopyN(io.Discard, download.Body, r.offset-download.Offset); err != nil {
+				download.Body.Close()
+				continue
+			}
+		}
+		r.download = download
+		return nil
+	}
+	return err
+}
+
+func (r *resumableAsset) Read(p []byte) (int, error) {
+	for {
+		n, err := r.download.Body.Read(p)
+		r.offset += int64(n)
+		metricsFrom(r.ctx).addDownloadedBytes(n)
+		switch {
+		case r.size >= 0 && r.offset > r.size:
+			return n, fmt.Errorf("downloaded more than the %d bytes of asset %s", r.size, r.asset.name)
+		case err == io.EOF && r.size >= 0 && r.offset < r.size &&
+			r.ctx.Err() == nil && r.attempts < downloadAttempts:
+			// the connection has been closed early, e.g. by a flaky network
+			err = fmt.Errorf("the download stopped at %d bytes of %d", r.offset, r.size)
+		case err == io.EOF:
+			if r.size >= 0 && r.offset != r.size {
+				return n, fmt.Errorf("downloaded %d bytes of asset %s instead of %d",
+					r.offset, r.asset.name, r.size)
+			}
+			r.progress.done(r.offset)
+			return n, io.EOF
+		case err == nil:
+			r.progress.update(r.offset, r.size)
+			return n, nil
+		case r.ctx.Err() != nil || r.attempts >= downloadAttempts:
+			return n, err
+		}
+
+		r.log.Warningf("WARNING: error downloading asset %s after %d bytes, resuming: %v\n",
+			r.asset.name, r.offset, err)
+		r.download.Body.Close()
+		if err := r.open(); err != nil {
+			return n, fmt.Errorf("error resuming the download of asset %s: %v", r.asset.name, err)
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+func (r *resumableAsset) Close() error {
+	return r.download.Body.Close()
+}
+
+// checkContentType returns an error if the given media type of a download of
+// the given asset contradicts the content type reported by GitHub for the
+// asset, e.g. an HTML error page of a proxy instead of the asset bytes. The
+// generic application/octet-stream type (e.g. of the redirected downloads)
+// matches any asset.
+func checkContentType(asset *releaseAsset, mediaType string) error {
+	expected, _, err := mime.ParseMediaType(asset.contentType)
+	if err != nil || asset.sourceArchive || len(mediaType) == 0 ||
+		mediaType == "application/octet-stream" || strings.EqualFold(mediaType, expected) {
+		return nil
+	}
+	return fmt.Errorf("the download of asset %s has the content type %s instead of %s",
+		asset.name, mediaType, expected)
+}
+
+// createTempDir creates a unique temp dir for storing the downloaded assets
+// into the given work dir (see Config.WorkDir), creating it if needed, so that
+// the concurrent runs in the same workspace do not collide.
+func createTempDir(workDir string) (string, error) {
+	if len(workDir) == 0 {
+		workDir = os.Getenv("RUNNER_TEMP")
+	}
+	if len(workDir) > 0 {
+		if err := os.MkdirAll(workDir, os.ModePerm); err != nil {
+			return "", fmt.Errorf("error creating work dir %s: %v", workDir, err)
+		}
+	}
+	tmpDir, err := os.MkdirTemp(workDir, "notarize-release-assets-")
+	if err != nil {
+		return "", fmt.Errorf("error creating temp dir for storing downloaded assets: %v", err)
+	}
+	if tmpDir, err = filepath.Abs(tmpDir); err != nil {
+		return "", fmt.Errorf("error getting the absolute path of temp dir %s: %v", tmpDir, err)
+	}
+	return tmpDir, nil
+}
+
+// removeTempDir deletes the given temp dir. If some of its entries cannot be
+// deleted (e.g. the ones of read-only dirs), it makes its dirs writable and
+// retries, so that as few leftovers as possible remain.
+func removeTempDir(tmpDir string) error {
+	if err := os.RemoveAll(tmpDir); err == nil {
+		return nil
+	}
+	_ = filepath.Walk(tmpDir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.IsDir() {
+			_ = os.Chmod(path, 0700)
+		}
+		return nil
+	})
+	if err := os.RemoveAll(tmpDir); err != nil {
+		return fmt.Errorf("error deleting temp dir %s: %v", tmpDir, err)
+	}
+	return nil
+}
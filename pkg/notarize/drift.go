@@ -0,0 +1,207 @@
+package notarize
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// opReverify is the operation of the scheduled re-verifications of past
+// releases, i.e. verifications along with drift detection.
+const opReverify operation = "reverify"
+
+// ErrDriftDetected is returned (wrapped) by ReverifyRelease when the bytes of
+// at least one of the release assets no longer match the notarized ones.
+var ErrDriftDetected = errors.New("the release assets have drifted from their notarized bytes")
+
+// driftBaseline holds the hashes of the assets of each release (by release,
+// i.e. <owner>/<repo>@<tag>, then asset name) at their last successful
+// verification.
+type driftBaseline map[string]map[string]string
+
+// driftBaselineKey returns the key of the release of the given report in the
+// drift baseline, i.e. its repository and tag (or else its URL), which do not
+// change when e.g. the latest release is re-verified by its generic URL.
+func driftBaselineKey(report *Report) string {
+	if len(report.Repository) > 0 && len(report.ReleaseTag) > 0 {
+		return report.Repository + "@" + report.ReleaseTag
+	}
+	return report.ReleaseURL
+}
+
+// ReverifyRelease re-verifies the assets of a past release against the
+// ledger, e.g. from a scheduled workflow, to detect their drift: an asset has
+// drifted if its hash is not notarized anymore (e.g. the asset has been
+// replaced after the release, or GitHub regenerated a source archive) or if it
+// differs from its hash at the last successful verification, as recorded in
+// Config.DriftBaselineFile. It returns ErrDriftDetected (wrapped) if any asset
+// has drifted, or else the error of the verification, if any.
+func ReverifyRelease(ctx context.Context, cfg *Config) (*Report, error) {
+	var baseline *driftBaselineFile
+	if len(cfg.DriftBaselineFile) > 0 {
+		var err error
+		if baseline, err = driftBaselineOf(cfg.DriftBaselineFile); err != nil {
+			return &Report{Operation: string(opReverify), ReleaseURL: cfg.ReleaseURL}, err
+		}
+	}
+
+	report, err := run(ctx, cfg, opVerify)
+	report.Operation = string(opReverify)
+	log := cfg.Logger
+	if log == nil {
+		log = nopLogger{}
+	}
+
+	key := driftBaselineKey(report)
+	var hashes map[string]string
+	if baseline != nil {
+		hashes = baseline.hashes(key)
+	}
+	var drifted []string
+	for _, assetReport := range report.Assets {
+		if len(assetReport.Hash) == 0 {
+			continue
+		}
+		previous := hashes[assetReport.Name]
+		switch {
+		case len(previous) > 0 && previous != assetReport.Hash:
+			assetReport.Drifted = true
+			assetReport.BaselineHash = previous
+			log.Errorf("DRIFT: asset %s has changed since its last verification (hash %s instead of %s)\n",
+				assetReport.Name, assetReport.Hash, previous)
+		case assetReport.notNotarized:
+			assetReport.Drifted = true
+			log.Errorf("DRIFT: asset %s no longer matches its notarized bytes (hash %s is not notarized)\n",
+				assetReport.Name, assetReport.Hash)
+		default:
+			continue
+		}
+		drifted = append(drifted, assetReport.Name)
+	}
+
+	// record the hashes of the assets verified successfully, keeping the
+	// previous ones of the drifted assets
+	if baseline != nil && ctx.Err() == nil {
+		verified := make(map[string]string)
+		for _, assetReport := range report.Assets {
+			if len(assetReport.Error) == 0 && !assetReport.Drifted && len(assetReport.Hash) > 0 {
+				verified[assetReport.Name] = assetReport.Hash
+			}
+		}
+		if werr := baseline.record(key, verified); werr != nil {
+			log.Warningf("WARNING: %v\n", werr)
+		}
+	}
+
+	if len(drifted) > 0 {
+		err = fmt.Errorf("%w: %s", ErrDriftDetected, strings.Join(uniqueStrings(drifted), ", "))
+		report.Success = false
+		report.Error = err.Error()
+	}
+	return report, err
+}
+
+// readDriftBaseline reads the drift baseline file, if it exists.
+func readDriftBaseline(filePath string) (driftBaseline, error) {
+	baseline := make(driftBaseline)
+	content, err := os.ReadFile(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return baseline, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading drift baseline file %s: %v", filePath, err)
+	}
+	if err := json.Unmarshal(content, &baseline); err != nil {
+		return nil, fmt.Errorf("error JSON-unmarshaling drift baseline file %s: %v", filePath, err)
+	}
+	return baseline, nil
+}
+
+// writeDriftBaseline writes the given drift baseline into the given file
+// (creating its directory if needed), atomically replacing it.
+func writeDriftBaseline(filePath string, baseline driftBaseline) error {
+	content, err := json.MarshalIndent(baseline, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error JSON-marshaling the drift baseline: %v", err)
+	}
+	if err := writeFileAtomically(filePath, append(content, '\n')); err != nil {
+		return fmt.Errorf("error writing drift baseline file %s: %v", filePath, err)
+	}
+	return nil
+}
+
+// driftBaselineFile is a drift baseline file read by the process, shared by
+// its runs (e.g. the releases re-verified in parallel), which record the
+// hashes of their releases into it in turn.
+type driftBaselineFile struct {
+	mu       sync.Mutex
+	filePath string
+	baseline driftBaseline
+}
+
+// driftBaselines are the drift baseline files read by the process, by file
+// path.
+var driftBaselines = struct {
+	sync.Mutex
+	byPath map[string]*driftBaselineFile
+}{byPath: make(map[string]*driftBaselineFile)}
+
+// driftBaselineOf returns the drift baseline of the given file, reading it
+// unless already done.
+func driftBaselineOf(filePath string) (*driftBaselineFile, error) {
+	driftBaselines.Lock()
+	defer driftBaselines.Unlock()
+	if f, ok := driftBaselines.byPath[filePath]; ok {
+		return f, nil
+	}
+	baseline, err := readDriftBaseline(filePath)
+	if err != nil {
+		return nil, err
+	}
+	f := &driftBaselineFile{filePath: filePath, baseline: baseline}
+	driftBaselines.byPath[filePath] = f
+	return f, nil
+}
+
+// hashes returns a copy of the hashes of the assets of the release of the
+// given key.
+func (f *driftBaselineFile) hashes(key string) map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	hashes := make(map[string]string, len(f.baseline[key]))
+	for name, hash := range f.baseline[key] {
+		hashes[name] = hash
+	}
+	return hashes
+}
+
+// record records the given hashes of the assets of the release of the given
+// key, keeping the other ones, and writes the baseline back into its file.
+func (f *driftBaselineFile) record(key string, hashes map[string]string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.baseline[key] == nil {
+		f.baseline[key] = make(map[string]string)
+	}
+	for name, hash := range hashes {
+		f.baseline[key][name] = hash
+	}
+	return writeDriftBaseline(f.filePath, f.baseline)
+}
+
+// uniqueStrings returns the given strings without the duplicates, in order.
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	var unique []string
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
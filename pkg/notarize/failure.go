@@ -0,0 +1,105 @@
+package notarize
+
+import (
+	"errors"
+)
+
+// The classes of the failures of a run, returned wrapped (i.e. to be checked
+// with errors.Is), along with ErrVerificationFailed and ErrDriftDetected.
+var (
+	// ErrInvalidConfig is returned when the settings of the run are invalid or
+	// inconsistent.
+	ErrInvalidConfig = errors.New("invalid configuration")
+	// ErrGitHubAPI is returned when the release (or other resources, e.g. the
+	// teams members) cannot be read from the GitHub API, or from the
+	// Bitbucket one (see Config.Provider).
+	ErrGitHubAPI = errors.New("GitHub API error")
+	// ErrDownload is returned when the assets cannot be downloaded.
+	ErrDownload = errors.New("download error")
+	// ErrCNILAuth is returned when the CNIL credentials (i.e. the personal
+	// token or the API keys) are rejected.
+	ErrCNILAuth = errors.New("CNIL authentication error")
+	// ErrSigning is returned when the assets cannot be signed into the ledger.
+	ErrSigning = errors.New("signing error")
+)
+
+// The exit codes of the commands, per failure class.
+const (
+	ExitSuccess            = 0
+	ExitFailure            = 1 // any other failure, e.g. the run timeout
+	ExitInvalidConfig      = 2
+	ExitGitHubAPI          = 3
+	ExitDownload           = 4
+	ExitCNILAuth           = 5
+	ExitSigning            = 6
+	ExitVerificationFailed = 7
+	ExitDriftDetected      = 8
+)
+
+// failureClasses are the failure classes by decreasing precedence, e.g. a
+// failed verification is reported as such even if some assets could not be
+// downloaded.
+var failureClasses = []struct {
+	class    error
+	exitCode int
+}{
+	{ErrDriftDetected, ExitDriftDetected},
+	{ErrVerificationFailed, ExitVerificationFailed},
+	{ErrInvalidConfig, ExitInvalidConfig},
+	{ErrCNILAuth, ExitCNILAuth},
+	{ErrGitHubAPI, ExitGitHubAPI},
+	{ErrDownload, ExitDownload},
+	{ErrSigning, ExitSigning},
+}
+
+// ExitCode returns the exit code of the given error of a run, according to
+// its failure class.
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitSuccess
+	}
+	for _, failureClass := range failureClasses {
+		if errors.Is(err, failureClass.class) {
+			return failureClass.exitCode
+		}
+	}
+	return ExitFailure
+}
+
+// classifiedError is an error of the given failure class, keeping its message
+// (and the errors it wraps) as is.
+type classifiedError struct {
+	class error
+	err   error
+}
+
+func (e *classifiedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *classifiedError) Unwrap() error {
+	return e.err
+}
+
+func (e *classifiedError) Is(target error) bool {
+	return target == e.class
+}
+
+// classify returns the given error as one of the given failure class, unless
+// it is nil or already classified.
+func classify(class error, err error) error {
+	if err == nil || class == nil || classOf(err) != nil {
+		return err
+	}
+	return &classifiedError{class: class, err: err}
+}
+
+// classOf returns the failure class of the given error, if any.
+func classOf(err error) error {
+	for _, failureClass := range failureClasses {
+		if errors.Is(err, failureClass.class) {
+			return failureClass.class
+		}
+	}
+	return nil
+}
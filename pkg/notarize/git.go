@@ -0,0 +1,109 @@
+package notarize
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	vcnAPI "github.com/vchain-us/vcn/pkg/api"
+	vcnGitExtractor "github.com/vchain-us/vcn/pkg/extractor/git"
+	vcnURI "github.com/vchain-us/vcn/pkg/uri"
+	git "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// gitArtifacts creates the vcn artifacts of the git commit the release tag
+// points to (the same way vcn does for git:// artifacts) and of the
+// annotated tag object itself, if any, from the local git repository in the
+// given dir, which must have the release tag checked out.
+func gitArtifacts(
+	dir string,
+	tagName string,
+	tagCommitSHA string,
+	log Logger,
+) ([]*vcnAPI.Artifact, error) {
+
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error getting the absolute path of git repository %s: %v", dir, err)
+	}
+
+	gitURI, err := vcnURI.Parse("git://" + dir)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing URI from git repository path %s: %v", dir, err)
+	}
+	artifacts, err := vcnGitExtractor.Artifact(gitURI)
+	if err != nil {
+		return nil, fmt.Errorf("error creating vcn artifact from git repository %s: %v", dir, err)
+	}
+	commitArtifact := artifacts[0]
+	if gitMetadata, ok := commitArtifact.Metadata[vcnGitExtractor.Scheme].(map[string]interface{}); !ok ||
+		gitMetadata["Commit"] != tagCommitSHA {
+		return nil, fmt.Errorf(
+			"the HEAD of git repository %s is not commit %s the release tag %s points to: "+
+				"the release tag must be checked out", dir, tagCommitSHA, tagName)
+	}
+	artifacts = []*vcnAPI.Artifact{commitArtifact}
+
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error opening git repository %s: %v", dir, err)
+	}
+	tagRef, err := repo.Tag(tagName)
+	if err != nil {
+		return nil, fmt.Errorf("error getting tag %s from git repository %s: %v", tagName, dir, err)
+	}
+	tag, err := repo.TagObject(tagRef.Hash())
+	if errors.Is(err, plumbing.ErrObjectNotFound) {
+		log.Infof("Release tag %s is a lightweight tag, hence only its commit is notarized\n",
+			tagName)
+		return artifacts, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting tag object %s from git repository %s: %v",
+			tagName, dir, err)
+	}
+
+	o := &plumbing.MemoryObject{}
+	if err := tag.Encode(o); err != nil {
+		return nil, fmt.Errorf("error encoding tag object %s: %v", tagName, err)
+	}
+	reader, err := o.Reader()
+	if err != nil {
+		return nil, fmt.Errorf("error reading tag object %s: %v", tagName, err)
+	}
+	defer reader.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, reader)
+	if err != nil {
+		return nil, fmt.Errorf("error hashing tag object %s: %v", tagName, err)
+	}
+
+	name := filepath.Base(dir)
+	if remotes, err := repo.Remotes(); err == nil && len(remotes) > 0 {
+		if urls := remotes[0].Config().URLs; len(urls) > 0 {
+			name = urls[0]
+		}
+	}
+	artifacts = append(artifacts, &vcnAPI.Artifact{
+		Kind: vcnGitExtractor.Scheme,
+		Name: name + "@" + tagName,
+		Hash: hex.EncodeToString(h.Sum(nil)),
+		Size: uint64(size),
+		Metadata: vcnAPI.Metadata{
+			vcnGitExtractor.Scheme: map[string]interface{}{
+				"Tag":          tag.Hash.String(),
+				"Name":         tag.Name,
+				"Target":       tag.Target.String(),
+				"Tagger":       tag.Tagger,
+				"Message":      tag.Message,
+				"PGPSignature": tag.PGPSignature,
+			},
+		},
+	})
+
+	return artifacts, nil
+}
@@ -0,0 +1,174 @@
+package notarize
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	vcnAPI "github.com/vchain-us/vcn/pkg/api"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// helmOCIKind is the artifact kind of the Helm charts published to an OCI
+	// registry, whose hash is the digest of their manifest.
+	helmOCIKind = "helm-oci"
+	// mediaTypeHelmConfig is the media type of the config of the Helm charts
+	// stored in OCI registries, i.e. their Chart.yaml as JSON.
+	mediaTypeHelmConfig = "application/vnd.cncf.helm.config.v1+json"
+)
+
+// helmChart holds the identifying fields of the Chart.yaml of a Helm chart.
+type helmChart struct {
+	APIVersion string `yaml:"apiVersion" json:"apiVersion"`
+	Name       string `yaml:"name" json:"name"`
+	Version    string `yaml:"version" json:"version"`
+	AppVersion string `yaml:"appVersion" json:"appVersion"`
+}
+
+// metadata returns the artifact attributes of the chart.
+func (c *helmChart) metadata() map[string]interface{} {
+	metadata := map[string]interface{}{
+		"chart_name":    c.Name,
+		"chart_version": c.Version,
+	}
+	if len(c.AppVersion) > 0 {
+		metadata["app_version"] = c.AppVersion
+	}
+	if len(c.APIVersion) > 0 {
+		metadata["chart_api_version"] = c.APIVersion
+	}
+	return metadata
+}
+
+// helmChartMetadata gets the chart name, version and app version from the
+// Chart.yaml at the root of the chart dir of a packaged Helm chart (i.e. a
+// <name>-<version>.tgz file created by helm package).
+func helmChartMetadata(filePath string) (map[string]interface{}, bool, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, false, fmt.Errorf("error opening tarball %s: %v", filePath, err)
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, false, nil
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			// not a Helm chart
+			return nil, false, nil
+		}
+		pieces := strings.Split(strings.TrimPrefix(header.Name, "./"), "/")
+		if len(pieces) != 2 || pieces[1] != "Chart.yaml" {
+			continue
+		}
+		content, err := io.ReadAll(io.LimitReader(tr, 1<<20))
+		if err != nil {
+			return nil, true, fmt.Errorf("error reading the Chart.yaml of Helm chart %s: %v",
+				filePath, err)
+		}
+		var chart helmChart
+		if err := yaml.Unmarshal(content, &chart); err != nil {
+			return nil, true, fmt.Errorf("error parsing the Chart.yaml of Helm chart %s: %v",
+				filePath, err)
+		}
+		if len(chart.Name) == 0 || len(chart.Version) == 0 {
+			return nil, false, nil
+		}
+		return chart.metadata(), true, nil
+	}
+}
+
+// helmChartFileNameRegexp matches the file names of the packaged Helm charts,
+// i.e. <name>-<SemVer version>.tgz.
+var helmChartFileNameRegexp = regexp.MustCompile(
+	`^([a-z0-9]+(?:-[a-z0-9]+)*?)-(v?[0-9]+\.[0-9]+\.[0-9]+(?:[-+][0-9A-Za-z.+-]*)?)\.tgz$`)
+
+// helmChartNameAndVersion returns the chart name and version of the given
+// packaged Helm chart file name, if it looks like one.
+func helmChartNameAndVersion(fileName string) (string, string, bool) {
+	matches := helmChartFileNameRegexp.FindStringSubmatch(fileName)
+	if matches == nil {
+		return "", "", false
+	}
+	return matches[1], matches[2], true
+}
+
+// helmOCIArtifact creates the vcn artifact of the given Helm chart as pushed
+// (with helm push) to the given OCI registry, e.g. ghcr.io/my-org/charts.
+// Its hash is the digest of the chart manifest, which the Helm consumers pull
+// the chart by (i.e. oci://<registry>/<name>@sha256:<hash>). It returns nil
+// if the chart is not in the registry.
+func helmOCIArtifact(
+	ctx context.Context,
+	httpClient *http.Client,
+	registry string,
+	name string,
+	version string,
+	githubToken string,
+) (*vcnAPI.Artifact, error) {
+
+	// the OCI tags cannot contain +, which helm push replaces with _
+	chartRef := fmt.Sprintf("%s/%s:%s", strings.TrimSuffix(strings.TrimPrefix(registry, "oci://"), "/"),
+		name, strings.ReplaceAll(version, "+", "_"))
+	ref, err := parseImageReference(chartRef)
+	if err != nil {
+		return nil, err
+	}
+	client := &registryClient{httpClient: httpClient}
+	// the GitHub token can read the GitHub container registry packages
+	if ref.registry == "ghcr.io" && len(githubToken) > 0 {
+		client.username, client.password = "token", githubToken
+	}
+
+	var manifest imageManifest
+	header, err := client.get(ctx, fmt.Sprintf("https://%s/v2/%s/manifests/%s",
+		ref.registry, ref.repository, ref.reference), []string{mediaTypeOCIManifest}, &manifest)
+	if errors.Is(err, errRegistryNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting the manifest of Helm chart %s: %v", chartRef, err)
+	}
+	if manifest.Config.MediaType != mediaTypeHelmConfig {
+		return nil, fmt.Errorf("%s is not a Helm chart (config media type %s)",
+			chartRef, manifest.Config.MediaType)
+	}
+	digest := header.Get("Docker-Content-Digest")
+	if !strings.HasPrefix(digest, "sha256:") {
+		return nil, fmt.Errorf("no digest returned for the manifest of Helm chart %s", chartRef)
+	}
+	var chart helmChart
+	if _, err := client.get(ctx, fmt.Sprintf("https://%s/v2/%s/blobs/%s",
+		ref.registry, ref.repository, manifest.Config.Digest), []string{"*/*"}, &chart); err != nil {
+		return nil, fmt.Errorf("error getting the config of Helm chart %s: %v", chartRef, err)
+	}
+	var size uint64
+	for _, layer := range manifest.Layers {
+		size += layer.Size
+	}
+
+	metadata := vcnAPI.Metadata{}
+	metadata.SetValues(chart.metadata())
+	metadata.Set("oci_reference", "oci://"+ref.registry+"/"+ref.repository+"@"+digest)
+	return &vcnAPI.Artifact{
+		Kind:        helmOCIKind,
+		Name:        "oci://" + chartRef,
+		Hash:        strings.TrimPrefix(digest, "sha256:"),
+		Size:        size,
+		ContentType: mediaTypeOCIManifest,
+		Metadata:    metadata,
+	}, nil
+}
@@ -0,0 +1,284 @@
+package notarize
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	immustore "github.com/codenotary/immudb/embedded/store"
+	immuschema "github.com/codenotary/immudb/pkg/api/schema"
+	immuclient "github.com/codenotary/immudb/pkg/client"
+	immucache "github.com/codenotary/immudb/pkg/client/cache"
+	immustate "github.com/codenotary/immudb/pkg/client/state"
+	immulogger "github.com/codenotary/immudb/pkg/logger"
+	vcnAPI "github.com/vchain-us/vcn/pkg/api"
+	vcnMeta "github.com/vchain-us/vcn/pkg/meta"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	defaultImmudbPort     = "3322"
+	defaultImmudbDatabase = "defaultdb"
+)
+
+// immudbBackend signs the artifacts straight into a plain immudb server (i.e.
+// without CNIL and its API keys), as the immudb user of the given
+// credentials: the signer IDs are not bound to any credentials and the ledger
+// IDs, if any, select the immudb databases. The entries are the same vcn
+// writes into CNIL, so that they are keyed by signer ID and hash.
+type immudbBackend struct {
+	cfg      *Config
+	port     int
+	stateDir string
+	log      Logger
+
+	mu sync.Mutex
+	// clientsPerDatabase are the connected and logged in clients, one per
+	// database since the client state is bound to it
+	clientsPerDatabase map[string]*immudbClient
+	notarizers         map[notarizerKey]*immudbNotarizer
+}
+
+// newImmudbBackend returns the immudb backend configured by the immudb
+// settings of the given config.
+func newImmudbBackend(cfg *Config, log Logger) (*immudbBackend, error) {
+	port := cfg.ImmudbPort
+	if len(port) == 0 {
+		port = defaultImmudbPort
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, fmt.Errorf("invalid immudb port %s", port)
+	}
+	storeDir := cfg.StoreDir
+	if len(storeDir) == 0 {
+		storeDir = "./.vcn"
+	}
+	return &immudbBackend{
+		cfg:  cfg,
+		port: p,
+		// the verified reads and writes check the server against the last
+		// state seen, which is kept along with the local vcn store
+		stateDir:           filepath.Join(storeDir, "immudb"),
+		log:                log,
+		clientsPerDatabase: make(map[string]*immudbClient),
+		notarizers:         make(map[notarizerKey]*immudbNotarizer),
+	}, nil
+}
+
+// Notarizers returns the notarizers of the given signer IDs into the
+// database of the given ledger ID (the default database if empty).
+func (b *immudbBackend) Notarizers(
+	ctx context.Context,
+	ledgerID string,
+	signerIDs []string,
+) ([]Notarizer, error) {
+
+	database := ledgerID
+	if len(database) == 0 {
+		database = b.cfg.ImmudbDatabase
+	}
+	if len(database) == 0 {
+		database = defaultImmudbDatabase
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	client, ok := b.clientsPerDatabase[database]
+	if !ok {
+		var err error
+		client, err = b.connect(ctx, database)
+		if err != nil {
+			return nil, err
+		}
+		b.clientsPerDatabase[database] = client
+	}
+	notarizers := make([]Notarizer, 0, len(signerIDs))
+	for _, signerID := range signerIDs {
+		key := notarizerKey{ledgerID: database, signerID: signerID}
+		notarizer, ok := b.notarizers[key]
+		if !ok {
+			notarizer = &immudbNotarizer{client: client, signerID: signerID}
+			b.notarizers[key] = notarizer
+		}
+		notarizers = append(notarizers, notarizer)
+	}
+	return notarizers, nil
+}
+
+// connect connects a client to the immudb server, logs it in and selects the
+// given database.
+func (b *immudbBackend) connect(ctx context.Context, database string) (*immudbClient, error) {
+	options := immuclient.DefaultOptions().
+		WithAddress(b.cfg.ImmudbHost).
+		WithPort(b.port).
+		WithDir(b.stateDir).
+		WithMetrics(false)
+	client, err := immuclient.NewImmuClient(options)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to immudb %s:%d: %v",
+			b.cfg.ImmudbHost, b.port, err)
+	}
+	login, err := client.Login(ctx, []byte(b.cfg.ImmudbUsername), []byte(b.cfg.ImmudbPassword))
+	if err != nil {
+		client.Disconnect()
+		return nil, fmt.Errorf("error logging into immudb as %s: %v", b.cfg.ImmudbUsername, err)
+	}
+	use, err := client.UseDatabase(
+		metadata.AppendToOutgoingContext(ctx, "authorization", login.Token),
+		&immuschema.Database{Databasename: database})
+	if err != nil {
+		client.Disconnect()
+		return nil, fmt.Errorf("error selecting immudb database %s: %v", database, err)
+	}
+
+	// the state service of the client is replaced by one of its own, for
+	// checking and reading the trusted state (see StateKeeper)
+	serviceClient := client.GetServiceClient()
+	stateService, err := immustate.NewStateService(
+		immucache.NewFileCache(b.stateDir),
+		immulogger.NewSimpleLogger("immuclient", os.Stderr),
+		immustate.NewStateProvider(serviceClient),
+		immustate.NewUUIDProvider(serviceClient))
+	if err != nil {
+		client.Disconnect()
+		return nil, fmt.Errorf("error initializing the immudb state service: %v", err)
+	}
+	client.WithStateService(stateService)
+	return &immudbClient{
+		client:       client,
+		stateService: stateService,
+		token:        use.Token,
+		address:      fmt.Sprintf("%s:%d", b.cfg.ImmudbHost, b.port),
+		database:     database,
+	}, nil
+}
+
+// Close logs out and disconnects the clients.
+func (b *immudbBackend) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, client := range b.clientsPerDatabase {
+		if err := client.client.Logout(client.authorized(context.Background())); err != nil {
+			b.log.Warningf("WARNING: error logging out of immudb database %s: %v\n",
+				client.database, err)
+		}
+		if err := client.client.Disconnect(); err != nil {
+			b.log.Errorf("error disconnecting immudb client: %v\n", err)
+		}
+	}
+	return nil
+}
+
+// immudbClient is a client logged into a database. Its calls are serialized,
+// since they check and update its local state.
+type immudbClient struct {
+	mu           sync.Mutex
+	client       immuclient.ImmuClient
+	stateService immustate.StateService
+	token        string
+	address      string
+	database     string
+}
+
+// authorized returns the given context along with the session token.
+func (c *immudbClient) authorized(ctx context.Context) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "authorization", c.token)
+}
+
+// immudbNotarizer signs as a signer ID into an immudb database.
+type immudbNotarizer struct {
+	client   *immudbClient
+	signerID string
+}
+
+func (n *immudbNotarizer) key(hash string) []byte {
+	key := vcnAPI.AppendPrefix(vcnMeta.VcnPrefix, []byte(n.signerID))
+	return vcnAPI.AppendSignerId(hash, key)
+}
+
+func (n *immudbNotarizer) Notarize(
+	ctx context.Context,
+	artifact *vcnAPI.Artifact,
+	status vcnMeta.Status,
+) (*LedgerEntry, uint64, error) {
+
+	value, err := json.Marshal(&vcnAPI.LcArtifact{
+		Kind:        artifact.Kind,
+		Name:        artifact.Name,
+		Hash:        artifact.Hash,
+		Size:        artifact.Size,
+		ContentType: artifact.ContentType,
+		Metadata:    artifact.Metadata,
+		Signer:      n.signerID,
+		Status:      status,
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("error JSON-marshaling artifact %s: %v", artifact.Name, err)
+	}
+
+	n.client.mu.Lock()
+	txMeta, err := n.client.client.VerifiedSet(
+		n.client.authorized(ctx), n.key(artifact.Hash), value)
+	n.client.mu.Unlock()
+	if err != nil {
+		return nil, 0, fmt.Errorf("error signing artifact: %v", err)
+	}
+
+	entry, err := n.Verify(ctx, artifact)
+	if err != nil {
+		return nil, txMeta.Id, fmt.Errorf(
+			"%s was notarized without errors, but there was an error when verifying it: %v",
+			artifact.Name, err)
+	}
+	if entry == nil {
+		return nil, txMeta.Id, fmt.Errorf(
+			"%s was notarized without error, but there was an error when verifying it: artifact not found",
+			artifact.Name)
+	}
+	return entry, txMeta.Id, nil
+}
+
+func (n *immudbNotarizer) Verify(ctx context.Context, artifact *vcnAPI.Artifact) (*LedgerEntry, error) {
+	n.client.mu.Lock()
+	defer n.client.mu.Unlock()
+	authorizedCtx := n.client.authorized(ctx)
+	immuEntry, err := n.client.client.VerifiedGet(authorizedCtx, n.key(artifact.Hash))
+	if err != nil {
+		if errors.Is(err, immustore.ErrCorruptedData) {
+			return nil, fmt.Errorf("ledger might be compromised: %v", err)
+		}
+		if strings.Contains(err.Error(), "key not found") {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error reading artifact %s from immudb: %v", artifact.Name, err)
+	}
+	var lcArtifact vcnAPI.LcArtifact
+	if err := json.Unmarshal(immuEntry.Value, &lcArtifact); err != nil {
+		return nil, fmt.Errorf("error JSON-unmarshaling the immudb entry of artifact %s: %v",
+			artifact.Name, err)
+	}
+	tx, err := n.client.client.TxByID(authorizedCtx, immuEntry.Tx)
+	if err != nil {
+		return nil, fmt.Errorf("error reading immudb transaction %d: %v", immuEntry.Tx, err)
+	}
+
+	return &LedgerEntry{
+		Name:        lcArtifact.Name,
+		Hash:        lcArtifact.Hash,
+		Size:        lcArtifact.Size,
+		ContentType: lcArtifact.ContentType,
+		SignerID:    lcArtifact.Signer,
+		Status:      lcArtifact.Status,
+		Timestamp:   time.Unix(tx.Metadata.Ts, 0).UTC(),
+		UID:         strconv.FormatUint(immuEntry.Tx, 10),
+		Metadata:    lcArtifact.Metadata,
+	}, nil
+}
@@ -0,0 +1,737 @@
+package notarize_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/codenotary/notarize-release-assets-action/internal/fakeserver"
+	"github.com/codenotary/notarize-release-assets-action/pkg/notarize"
+	vcnAPI "github.com/vchain-us/vcn/pkg/api"
+	vcnMeta "github.com/vchain-us/vcn/pkg/meta"
+)
+
+// memoryBackend is a notarization backend keeping the ledger entries in
+// memory, keyed by ledger ID and hash.
+type memoryBackend struct {
+	mu         sync.Mutex
+	entries    map[string]*notarize.LedgerEntry
+	notarizers map[string]*memoryNotarizer
+}
+
+func newMemoryBackend() *memoryBackend {
+	return &memoryBackend{
+		entries:    make(map[string]*notarize.LedgerEntry),
+		notarizers: make(map[string]*memoryNotarizer),
+	}
+}
+
+func (b *memoryBackend) Notarizers(
+	ctx context.Context,
+	ledgerID string,
+	signerIDs []string,
+) ([]notarize.Notarizer, error) {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	notarizers := make([]notarize.Notarizer, 0, len(signerIDs))
+	for _, signerID := range signerIDs {
+		key := ledgerID + "/" + signerID
+		if _, ok := b.notarizers[key]; !ok {
+			b.notarizers[key] = &memoryNotarizer{backend: b, ledgerID: ledgerID, signerID: signerID}
+		}
+		notarizers = append(notarizers, b.notarizers[key])
+	}
+	return notarizers, nil
+}
+
+func (b *memoryBackend) Close() error {
+	return nil
+}
+
+// signerIDs returns the signer IDs of the ledger entries, by asset name.
+func (b *memoryBackend) signerIDs() map[string]string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	signerIDs := make(map[string]string)
+	for _, entry := range b.entries {
+		signerIDs[entry.Name] = entry.SignerID
+	}
+	return signerIDs
+}
+
+// entriesByName returns the ledger entries, by asset name.
+func (b *memoryBackend) entriesByName() map[string]*notarize.LedgerEntry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	entries := make(map[string]*notarize.LedgerEntry)
+	for _, entry := range b.entries {
+		entries[entry.Name] = entry
+	}
+	return entries
+}
+
+// forget removes the ledger entries of the given name, as if it had never
+// been notarized.
+func (b *memoryBackend) forget(name string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for key, entry := range b.entries {
+		if entry.Name == name {
+			delete(b.entries, key)
+		}
+	}
+}
+
+type memoryNotarizer struct {
+	backend  *memoryBackend
+	ledgerID string
+	signerID string
+}
+
+func (n *memoryNotarizer) Notarize(
+	ctx context.Context,
+	artifact *vcnAPI.Artifact,
+	status vcnMeta.Status,
+) (*notarize.LedgerEntry, uint64, error) {
+
+	n.backend.mu.Lock()
+	defer n.backend.mu.Unlock()
+	entry := &notarize.LedgerEntry{
+		Name:        artifact.Name,
+		Hash:        artifact.Hash,
+		Size:        artifact.Size,
+		ContentType: artifact.ContentType,
+		SignerID:    n.signerID,
+		Status:      status,
+		Timestamp:   time.Now().UTC(),
+		UID:         artifact.Hash,
+		Metadata:    artifact.Metadata,
+	}
+	n.backend.entries[n.ledgerID+"/"+artifact.Hash] = entry
+	return entry, uint64(len(n.backend.entries)), nil
+}
+
+func (n *memoryNotarizer) Verify(ctx context.Context, artifact *vcnAPI.Artifact) (*notarize.LedgerEntry, error) {
+	n.backend.mu.Lock()
+	defer n.backend.mu.Unlock()
+	return n.backend.entries[n.ledgerID+"/"+artifact.Hash], nil
+}
+
+// newRelease starts a fake GitHub API with a release of three assets, the one
+// of the CLI being uploaded by another user than the release author.
+func newRelease(t *testing.T) (*fakeserver.GitHub, string) {
+	t.Helper()
+	gh := fakeserver.NewGitHub()
+	t.Cleanup(gh.Close)
+	releaseURL := gh.AddRelease("my-org/my-repo", "v1.2.3", "octocat",
+		fakeserver.Asset{Name: "app-linux-amd64.tar.gz", Content: []byte("linux build"), ContentType: "application/gzip"},
+		fakeserver.Asset{Name: "app-windows-amd64.zip", Content: []byte("windows build"), ContentType: "application/zip"},
+		fakeserver.Asset{Name: "cli.tar.gz", Content: []byte("cli build"), Uploader: "hubot"})
+	return gh, releaseURL
+}
+
+func newConfig(gh *fakeserver.GitHub, releaseURL string, backend notarize.Backend) *notarize.Config {
+	return &notarize.Config{
+		Backend:      backend,
+		ReleaseURL:   releaseURL,
+		GitHubAPIURL: gh.URL,
+		GitHubToken:  gh.Token,
+		HTTPClient:   gh.Client(),
+	}
+}
+
+// assetNames returns the sorted names of the assets of the given report.
+func assetNames(report *notarize.Report) string {
+	var names []string
+	for _, asset := range report.Assets {
+		names = append(names, asset.Name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func TestNotarizeAndVerifyRelease(t *testing.T) {
+	gh, releaseURL := newRelease(t)
+	gh.Token = "github-token"
+	backend := newMemoryBackend()
+
+	report, err := notarize.NotarizeRelease(context.Background(), newConfig(gh, releaseURL, backend))
+	if err != nil {
+		t.Fatalf("NotarizeRelease: %v", err)
+	}
+	if !report.Success || report.Repository != "my-org/my-repo" || report.ReleaseTag != "v1.2.3" {
+		t.Errorf("got report of %s %s (success: %t), want a successful one of my-org/my-repo v1.2.3",
+			report.Repository, report.ReleaseTag, report.Success)
+	}
+	want := "app-linux-amd64.tar.gz,app-windows-amd64.zip,cli.tar.gz,my-repo-v1.2.3.tar.gz,my-repo-v1.2.3.zip"
+	if got := assetNames(report); got != want {
+		t.Errorf("got assets %s, want %s", got, want)
+	}
+	for _, asset := range report.Assets {
+		if asset.TxID == 0 || asset.Timings == nil {
+			t.Errorf("got asset %s without transaction ID or timings", asset.Name)
+		}
+	}
+	signerIDs := backend.signerIDs()
+	if signerIDs["app-linux-amd64.tar.gz"] != "octocat@github" || signerIDs["cli.tar.gz"] != "hubot@github" {
+		t.Errorf("got signer IDs %v, want the ones of the uploaders", signerIDs)
+	}
+
+	report, err = notarize.VerifyRelease(context.Background(), newConfig(gh, releaseURL, backend))
+	if err != nil {
+		t.Fatalf("VerifyRelease: %v", err)
+	}
+	for _, asset := range report.Assets {
+		if asset.Status != vcnMeta.StatusTrusted.String() {
+			t.Errorf("got status %s of asset %s, want %s", asset.Status, asset.Name, vcnMeta.StatusTrusted)
+		}
+		if asset.Timings == nil || asset.Timings.SignMS != 0 {
+			t.Errorf("got timings %+v of asset %s, want verification ones", asset.Timings, asset.Name)
+		}
+	}
+}
+
+func TestVerifyReleaseNotNotarized(t *testing.T) {
+	gh, releaseURL := newRelease(t)
+
+	_, err := notarize.VerifyRelease(context.Background(), newConfig(gh, releaseURL, newMemoryBackend()))
+	if !errors.Is(err, notarize.ErrVerificationFailed) {
+		t.Errorf("got error %v, want ErrVerificationFailed", err)
+	}
+	if code := notarize.ExitCode(err); code != notarize.ExitVerificationFailed {
+		t.Errorf("got exit code %d, want %d", code, notarize.ExitVerificationFailed)
+	}
+}
+
+// warningsLogger records the warnings of a run.
+type warningsLogger struct {
+	mu       sync.Mutex
+	warnings []string
+}
+
+func (l *warningsLogger) Infof(string, ...interface{})    {}
+func (l *warningsLogger) Successf(string, ...interface{}) {}
+func (l *warningsLogger) Errorf(string, ...interface{})   {}
+
+func (l *warningsLogger) Warningf(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.warnings = append(l.warnings, fmt.Sprintf(format, args...))
+}
+
+// contains tells whether any warning contains the given text.
+func (l *warningsLogger) contains(text string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, warning := range l.warnings {
+		if strings.Contains(warning, text) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestVerifyReleaseExplainsTheUnnotarizedSourceArchives(t *testing.T) {
+	movedFrom := "the release tag v1.2.3 has moved from the notarized commit " +
+		fakeserver.CommitSHA("my-org/my-repo", "v1.2.3")
+	for _, tc := range []struct {
+		name    string
+		link    bool
+		change  func(gh *fakeserver.GitHub)
+		warning string
+	}{
+		{"never notarized", true, nil, "the release might not have been notarized"},
+		{"regenerated", true, func(gh *fakeserver.GitHub) { gh.Regenerate("my-org/my-repo", "v1.2.3") },
+			"GitHub regenerated the archive"},
+		{"moved tag", true, func(gh *fakeserver.GitHub) { gh.MoveTag("my-org/my-repo", "v1.2.3") }, movedFrom},
+		{"never notarized without links", false, nil, "the release might not have been notarized"},
+		{"regenerated tarball without links", false,
+			func(gh *fakeserver.GitHub) { gh.Regenerate("my-org/my-repo", "v1.2.3", "tarball") },
+			"points to the commit " + fakeserver.CommitSHA("my-org/my-repo", "v1.2.3") +
+				" of the notarized source archive my-repo-v1.2.3.zip: GitHub regenerated the archive"},
+		{"regenerated without links", false,
+			func(gh *fakeserver.GitHub) { gh.Regenerate("my-org/my-repo", "v1.2.3") }, "or its tag might have moved"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh, releaseURL := newRelease(t)
+			backend := newMemoryBackend()
+			cfg := newConfig(gh, releaseURL, backend)
+			cfg.LinkSourceArchive = tc.link
+			if tc.change != nil {
+				if _, err := notarize.NotarizeRelease(context.Background(), cfg); err != nil {
+					t.Fatalf("NotarizeRelease: %v", err)
+				}
+				tc.change(gh)
+			}
+
+			log := &warningsLogger{}
+			cfg.Logger = log
+			if _, err := notarize.VerifyRelease(context.Background(), cfg); !errors.Is(err, notarize.ErrVerificationFailed) {
+				t.Fatalf("got error %v, want ErrVerificationFailed", err)
+			}
+			if !log.contains(tc.warning) {
+				t.Errorf("got warnings %q, want one containing %q", log.warnings, tc.warning)
+			}
+		})
+	}
+}
+
+func TestVerifyReleaseWalksTheChildrenBackToTheirSourceArchive(t *testing.T) {
+	gh, releaseURL := newRelease(t)
+	backend := newMemoryBackend()
+	cfg := newConfig(gh, releaseURL, backend)
+	cfg.LinkSourceArchive = true
+	if _, err := notarize.NotarizeRelease(context.Background(), cfg); err != nil {
+		t.Fatalf("NotarizeRelease: %v", err)
+	}
+	gh.Regenerate("my-org/my-repo", "v1.2.3")
+
+	// the children do not depend on the bytes of the regenerated archive
+	report, _ := notarize.VerifyRelease(context.Background(), cfg)
+	for _, asset := range report.Assets {
+		failed := len(asset.Error) > 0
+		if source := strings.HasPrefix(asset.Name, "my-repo-"); failed != source {
+			t.Errorf("got error %q of asset %s, want only the source archives to fail", asset.Error, asset.Name)
+		}
+	}
+
+	backend.forget("my-repo-v1.2.3.tar.gz")
+	report, _ = notarize.VerifyRelease(context.Background(), cfg)
+	children := 0
+	for _, asset := range report.Assets {
+		if asset.SourceArchive != "my-repo-v1.2.3.tar.gz" {
+			continue
+		}
+		children++
+		if !strings.Contains(asset.Error, "which is not notarized") {
+			t.Errorf("got error %q of asset %s, want its source archive not to be notarized", asset.Error, asset.Name)
+		}
+	}
+	if children != 3 {
+		t.Errorf("got %d children of the source archive, want 3", children)
+	}
+}
+
+func TestNotarizeReleaseStreamsTheAssetsLikeItDownloadsThem(t *testing.T) {
+	gh := fakeserver.NewGitHub()
+	t.Cleanup(gh.Close)
+	releaseURL := gh.AddRelease("my-org/my-repo", "v1.2.3", "octocat",
+		fakeserver.Asset{Name: "app-v1.2.3-linux-amd64.tar.gz", Content: []byte("linux build")},
+		fakeserver.Asset{Name: "notes.txt", Content: []byte(strings.Repeat("release notes\n", 64))},
+		fakeserver.Asset{Name: "app-linux-amd64", Content: minimalELF()},
+		fakeserver.Asset{Name: "plugin", Content: []byte("\x00asm\x01\x00\x00\x00")})
+
+	downloaded, streamed := newMemoryBackend(), newMemoryBackend()
+	if _, err := notarize.NotarizeRelease(context.Background(), newConfig(gh, releaseURL, downloaded)); err != nil {
+		t.Fatalf("NotarizeRelease: %v", err)
+	}
+	cfg := newConfig(gh, releaseURL, streamed)
+	cfg.StreamAssets = true
+	if _, err := notarize.NotarizeRelease(context.Background(), cfg); err != nil {
+		t.Fatalf("NotarizeRelease with streamed assets: %v", err)
+	}
+
+	want, got := downloaded.entriesByName(), streamed.entriesByName()
+	for name, entry := range want {
+		if got[name] == nil || got[name].Hash != entry.Hash || got[name].Size != entry.Size ||
+			got[name].ContentType != entry.ContentType || !reflect.DeepEqual(got[name].Metadata, entry.Metadata) {
+			t.Errorf("got streamed entry %+v of %s, want %+v", got[name], name, entry)
+		}
+	}
+	if version := want["app-v1.2.3-linux-amd64.tar.gz"].Metadata["version"]; version != "1.2.3" {
+		t.Errorf("got version %v, want 1.2.3", version)
+	}
+	if architecture := want["app-linux-amd64"].Metadata["architecture"]; architecture != "x86_64" {
+		t.Errorf("got architecture %v of the executable, want x86_64", architecture)
+	}
+	if plugin := got["plugin"]; plugin == nil || plugin.Metadata["wasm_version"] != 1 {
+		t.Errorf("got streamed entry %+v of the WebAssembly module, want its version", plugin)
+	}
+}
+
+// minimalELF returns the header of an x86-64 ELF executable, without any
+// program or section.
+func minimalELF() []byte {
+	header := make([]byte, 64)
+	copy(header, []byte{0x7f, 'E', 'L', 'F', 2, 1, 1})
+	binary.LittleEndian.PutUint16(header[16:], 2)    // executable
+	binary.LittleEndian.PutUint16(header[18:], 0x3e) // x86-64
+	binary.LittleEndian.PutUint32(header[20:], 1)    // version
+	binary.LittleEndian.PutUint16(header[52:], 64)   // header size
+	binary.LittleEndian.PutUint16(header[54:], 56)   // program header size
+	binary.LittleEndian.PutUint16(header[58:], 64)   // section header size
+	return header
+}
+
+func TestNotarizeReleaseUsesTheHashesOfTheChecksumsFile(t *testing.T) {
+	assets := []fakeserver.Asset{
+		{Name: "app-v1.2.3-linux-amd64.tar.gz", Content: []byte("linux build"), ContentType: "application/gzip"},
+		{Name: "app-linux-amd64", Content: []byte("\x7fELF linux executable")},
+		{Name: "plugin", Content: []byte("\x00asm\x01\x00\x00\x00")},
+		{Name: "app.jar", Content: []byte("PK\x03\x04 not a real jar")},
+	}
+	var checksums strings.Builder
+	for _, asset := range assets {
+		hash := sha256.Sum256(asset.Content)
+		fmt.Fprintf(&checksums, "%s  %s\n", hex.EncodeToString(hash[:]), asset.Name)
+	}
+	gh := fakeserver.NewGitHub()
+	t.Cleanup(gh.Close)
+	releaseURL := gh.AddRelease("my-org/my-repo", "v1.2.3", "octocat", append(assets,
+		fakeserver.Asset{Name: "checksums.txt", Content: []byte(checksums.String())})...)
+
+	downloaded, hashed := newMemoryBackend(), newMemoryBackend()
+	if _, err := notarize.NotarizeRelease(context.Background(), newConfig(gh, releaseURL, downloaded)); err != nil {
+		t.Fatalf("NotarizeRelease: %v", err)
+	}
+	cfg := newConfig(gh, releaseURL, hashed)
+	cfg.HashesFromChecksums = "checksums.txt"
+	if _, err := notarize.NotarizeRelease(context.Background(), cfg); err != nil {
+		t.Fatalf("NotarizeRelease with the hashes of the checksums file: %v", err)
+	}
+
+	want, got := downloaded.entriesByName(), hashed.entriesByName()
+	for name, entry := range want {
+		if got[name] == nil || got[name].Hash != entry.Hash || got[name].Size != entry.Size ||
+			got[name].ContentType != entry.ContentType || !reflect.DeepEqual(got[name].Metadata, entry.Metadata) {
+			t.Errorf("got entry %+v of %s from its checksum, want %+v", got[name], name, entry)
+		}
+	}
+
+	cfg.ReleaseURL = gh.AddRelease("my-org/my-repo", "v1.2.4", "octocat", append(assets,
+		fakeserver.Asset{Name: "checksums.txt", Content: []byte(strings.ToUpper(checksums.String()))})...)
+	if _, err := notarize.NotarizeRelease(context.Background(), cfg); !errors.Is(err, notarize.ErrDownload) {
+		t.Errorf("got error %v with uppercase checksums, want ErrDownload", err)
+	}
+}
+
+func TestSubmitBundleChecksTheBundleAndItsSignerIDs(t *testing.T) {
+	gh, releaseURL := newRelease(t)
+	cfg := newConfig(gh, releaseURL, nil)
+	cfg.OfflineBundle = filepath.Join(t.TempDir(), "signing-bundle.json")
+	cfg.SkipSourceArchives = true
+	report, err := notarize.NotarizeRelease(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("NotarizeRelease offline: %v", err)
+	}
+	content, err := os.ReadFile(cfg.OfflineBundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash := sha256.Sum256(content); report.BundleSHA256 != hex.EncodeToString(hash[:]) {
+		t.Errorf("got bundle hash %s, want the one of the bundle file", report.BundleSHA256)
+	}
+
+	backend := newMemoryBackend()
+	submitCfg := &notarize.Config{
+		Backend:    backend,
+		Repository: "my-org/my-repo",
+		APIKeysPerSignerID: map[string]string{
+			"octocat@github": "octocat-key",
+		},
+	}
+	for _, tc := range []struct {
+		name         string
+		repository   string
+		bundleSHA256 string
+		want         string
+	}{
+		{"without hash", "my-org/my-repo", "", "is required"},
+		{"with another hash", "my-org/my-repo", strings.Repeat("0", 64), "has been modified"},
+		{"of another repository", "my-org/other-repo", report.BundleSHA256, "not of the configured repository"},
+		{"with a signer ID not allowed", "my-org/my-repo", report.BundleSHA256, "hubot@github"},
+	} {
+		submitCfg.Repository = tc.repository
+		_, err := notarize.SubmitBundle(context.Background(), submitCfg, cfg.OfflineBundle, tc.bundleSHA256)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("got error %v submitting the bundle %s, want one containing %q", err, tc.name, tc.want)
+		}
+	}
+	if signerIDs := backend.signerIDs(); len(signerIDs) > 0 {
+		t.Errorf("got signed artifacts %v, want none", signerIDs)
+	}
+
+	submitCfg.APIKeysPerSignerID["hubot@github"] = "hubot-key"
+	if _, err := notarize.SubmitBundle(
+		context.Background(), submitCfg, cfg.OfflineBundle, report.BundleSHA256); err != nil {
+		t.Fatalf("SubmitBundle: %v", err)
+	}
+	signerIDs := backend.signerIDs()
+	if signerIDs["app-linux-amd64.tar.gz"] != "octocat@github" || signerIDs["cli.tar.gz"] != "hubot@github" {
+		t.Errorf("got signer IDs %v, want the ones of the uploaders", signerIDs)
+	}
+}
+
+func TestReverifyReleasesInParallelRecordsAllTheirHashes(t *testing.T) {
+	gh := fakeserver.NewGitHub()
+	t.Cleanup(gh.Close)
+	backend := newMemoryBackend()
+	var releaseURLs []string
+	for i := 0; i < 8; i++ {
+		releaseURL := gh.AddRelease("my-org/my-repo", fmt.Sprintf("v1.0.%d", i), "octocat",
+			fakeserver.Asset{Name: "app.tar.gz", Content: []byte(fmt.Sprintf("build %d", i))})
+		if _, err := notarize.NotarizeRelease(context.Background(), newConfig(gh, releaseURL, backend)); err != nil {
+			t.Fatalf("NotarizeRelease: %v", err)
+		}
+		releaseURLs = append(releaseURLs, releaseURL)
+	}
+
+	cfg := newConfig(gh, "", backend)
+	cfg.Repository = "my-org/my-repo"
+	cfg.DriftBaselineFile = filepath.Join(t.TempDir(), "baseline", "drift.json")
+	cfg.MaxParallelReleases = 4
+	if _, err := notarize.ProcessReleases(
+		context.Background(), cfg, releaseURLs, notarize.ReverifyRelease); err != nil {
+		t.Fatalf("ProcessReleases: %v", err)
+	}
+	content, err := os.ReadFile(cfg.DriftBaselineFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var baseline map[string]map[string]string
+	if err := json.Unmarshal(content, &baseline); err != nil {
+		t.Fatalf("got invalid drift baseline file: %v", err)
+	}
+	if len(baseline) != len(releaseURLs) {
+		t.Errorf("got the baseline of %d releases, want %d: %s", len(baseline), len(releaseURLs), content)
+	}
+}
+
+func TestNotarizeReleaseRetriesTheFailedRequests(t *testing.T) {
+	gh, releaseURL := newRelease(t)
+	gh.PerPage = 1
+	gh.RateLimit("/repos/my-org/my-repo/releases/1", 1)
+	gh.Fail("/repos/my-org/my-repo/releases/assets/2", http.StatusBadGateway, 1)
+
+	report, err := notarize.NotarizeRelease(context.Background(), newConfig(gh, releaseURL, newMemoryBackend()))
+	if err != nil {
+		t.Fatalf("NotarizeRelease: %v", err)
+	}
+	if got := len(report.Assets); got != 5 {
+		t.Errorf("got %d assets, want 5", got)
+	}
+	downloads := 0
+	for _, request := range gh.Requests() {
+		if request == "GET /repos/my-org/my-repo/releases/assets/2" {
+			downloads++
+		}
+	}
+	if downloads != 2 {
+		t.Errorf("got %d downloads of the asset failing once, want 2", downloads)
+	}
+}
+
+func TestNotarizeReleaseUploadsTheChecksums(t *testing.T) {
+	gh, releaseURL := newRelease(t)
+	cfg := newConfig(gh, releaseURL, newMemoryBackend())
+	cfg.UploadChecksums = true
+	cfg.SkipSourceArchives = true
+
+	if _, err := notarize.NotarizeRelease(context.Background(), cfg); err != nil {
+		t.Fatalf("NotarizeRelease: %v", err)
+	}
+	names := strings.Join(gh.AssetNames(releaseURL), ",")
+	if want := "app-linux-amd64.tar.gz,app-windows-amd64.zip,cli.tar.gz,checksums-sha256.txt"; names != want {
+		t.Errorf("got assets %s, want %s", names, want)
+	}
+	hash := sha256.Sum256([]byte("linux build"))
+	checksums := string(gh.AssetContent(releaseURL, "checksums-sha256.txt"))
+	if want := hex.EncodeToString(hash[:]) + "  app-linux-amd64.tar.gz\n"; !strings.Contains(checksums, want) {
+		t.Errorf("got checksums %q, want them to contain %q", checksums, want)
+	}
+}
+
+func TestNotarizeReleaseReadsTheReleaseFromTheEventPayload(t *testing.T) {
+	gh, releaseURL := newRelease(t)
+	resp, err := gh.Client().Get(releaseURL)
+	if err != nil {
+		t.Fatalf("error getting the release: %v", err)
+	}
+	defer resp.Body.Close()
+	var release json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
+		t.Fatalf("error decoding the release: %v", err)
+	}
+	payload, err := json.Marshal(map[string]interface{}{"action": "published", "release": release})
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventFile := filepath.Join(t.TempDir(), "event.json")
+	if err := os.WriteFile(eventFile, payload, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := newConfig(gh, releaseURL, newMemoryBackend())
+	cfg.ReleaseEventFile = eventFile
+	cfg.ReleaseEventCommitSHA = fakeserver.CommitSHA("my-org/my-repo", "v1.2.3")
+
+	requests := len(gh.Requests())
+	report, err := notarize.NotarizeRelease(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("NotarizeRelease: %v", err)
+	}
+	if got := len(report.Assets); got != 5 {
+		t.Errorf("got %d assets, want 5", got)
+	}
+	for _, request := range gh.Requests()[requests:] {
+		if !strings.HasPrefix(request, "GET /repos/my-org/my-repo/releases/assets/") &&
+			!strings.HasPrefix(request, "GET /repos/my-org/my-repo/tarball/") &&
+			!strings.HasPrefix(request, "GET /repos/my-org/my-repo/zipball/") {
+			t.Errorf("got request %s, want only the downloads of the assets", request)
+		}
+	}
+
+	// the payload of another release is ignored
+	cfg.ReleaseURL = gh.AddRelease("my-org/my-repo", "v1.2.4", "octocat",
+		fakeserver.Asset{Name: "app-linux-amd64.tar.gz", Content: []byte("next linux build")})
+	report, err = notarize.NotarizeRelease(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("NotarizeRelease: %v", err)
+	}
+	if report.ReleaseTag != "v1.2.4" {
+		t.Errorf("got release %s, want v1.2.4", report.ReleaseTag)
+	}
+}
+
+func TestNotarizeReleaseRenamesTheAssetsInTheLedger(t *testing.T) {
+	gh, releaseURL := newRelease(t)
+	backend := newMemoryBackend()
+	cfg := newConfig(gh, releaseURL, backend)
+	var err error
+	cfg.AssetNames, err = notarize.ParseAssetNames("strip-version, lowercase", `{"cli.*": "Command-Line.tar.gz"}`)
+	if err != nil {
+		t.Fatalf("ParseAssetNames: %v", err)
+	}
+
+	report, err := notarize.NotarizeRelease(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("NotarizeRelease: %v", err)
+	}
+	var names []string
+	for name := range backend.signerIDs() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	want := "Command-Line.tar.gz,app-linux-amd64.tar.gz,app-windows-amd64.zip,my-repo.tar.gz,my-repo.zip"
+	if got := strings.Join(names, ","); got != want {
+		t.Errorf("got ledger names %s, want %s", got, want)
+	}
+	for _, asset := range report.Assets {
+		if asset.Name == "my-repo-v1.2.3.zip" && asset.LedgerName != "my-repo.zip" {
+			t.Errorf("got ledger name %q of asset %s, want my-repo.zip", asset.LedgerName, asset.Name)
+		}
+	}
+
+	cfg.AssetNames.Overrides = map[string]string{"app-*": "app.tar.gz"}
+	_, err = notarize.NotarizeRelease(context.Background(), cfg)
+	if !errors.Is(err, notarize.ErrInvalidConfig) {
+		t.Errorf("got error %v, want the ledger names to collide", err)
+	}
+}
+
+func TestNotarizeReleaseSelectsTheAssets(t *testing.T) {
+	gh, releaseURL := newRelease(t)
+	for selection, want := range map[string]string{
+		notarize.AssetsUploadedOnly: "app-linux-amd64.tar.gz,app-windows-amd64.zip,cli.tar.gz",
+		notarize.AssetsSourceOnly:   "my-repo-v1.2.3.tar.gz,my-repo-v1.2.3.zip",
+		"Uploaded-Only":             "app-linux-amd64.tar.gz,app-windows-amd64.zip,cli.tar.gz",
+	} {
+		cfg := newConfig(gh, releaseURL, newMemoryBackend())
+		cfg.AssetSelection = selection
+		report, err := notarize.NotarizeRelease(context.Background(), cfg)
+		if err != nil {
+			t.Fatalf("NotarizeRelease of the %s assets: %v", selection, err)
+		}
+		if got := assetNames(report); got != want {
+			t.Errorf("got %s assets %s, want %s", selection, got, want)
+		}
+	}
+}
+
+func TestNotarizeBitbucketRelease(t *testing.T) {
+	bb := fakeserver.NewBitbucket()
+	t.Cleanup(bb.Close)
+	bb.Token = "bitbucket-token"
+	bb.PageLen = 2
+	tagURL := bb.AddTag("my-workspace/my-repo", "v1.2.3", "alice")
+	bb.AddDownloads("my-workspace/my-repo",
+		fakeserver.Asset{Name: "app-1.2.3-linux-amd64.tar.gz", Content: []byte("linux build"), Uploader: "bob"},
+		fakeserver.Asset{Name: "app-1.2.2-linux-amd64.tar.gz", Content: []byte("old linux build"), Uploader: "bob"},
+		fakeserver.Asset{Name: "app-v1.2.3-windows-amd64.zip", Content: []byte("windows build"), Uploader: "alice"},
+		fakeserver.Asset{Name: "app-1.2.3.1-linux-amd64.tar.gz", Content: []byte("patch build"), Uploader: "bob"},
+		fakeserver.Asset{Name: "app-11.2.3-linux-amd64.tar.gz", Content: []byte("next build"), Uploader: "bob"},
+		fakeserver.Asset{Name: "app-1.2-linux-amd64.tar.gz", Content: []byte("minor build"), Uploader: "bob"})
+	backend := newMemoryBackend()
+	cfg := &notarize.Config{
+		Backend:        backend,
+		Provider:       notarize.ProviderBitbucket,
+		ReleaseURL:     tagURL,
+		GitHubToken:    "github-token",
+		BitbucketToken: bb.Token,
+		HTTPClient:     bb.Client(),
+	}
+
+	report, err := notarize.NotarizeRelease(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("NotarizeRelease: %v", err)
+	}
+	if !report.Success || report.Repository != "my-workspace/my-repo" || report.ReleaseTag != "v1.2.3" {
+		t.Errorf("got report of %s %s (success: %t), want a successful one of my-workspace/my-repo v1.2.3",
+			report.Repository, report.ReleaseTag, report.Success)
+	}
+	want := "app-1.2.3-linux-amd64.tar.gz,app-v1.2.3-windows-amd64.zip,my-repo-v1.2.3.tar.gz,my-repo-v1.2.3.zip"
+	if got := assetNames(report); got != want {
+		t.Errorf("got assets %s, want %s", got, want)
+	}
+	signerIDs := backend.signerIDs()
+	if signerIDs["app-1.2.3-linux-amd64.tar.gz"] != "bob@bitbucket" ||
+		signerIDs["my-repo-v1.2.3.zip"] != "alice@bitbucket" {
+		t.Errorf("got signer IDs %v, want the ones of the uploaders and of the tagger", signerIDs)
+	}
+
+	// the downloads of the neighbouring 1.2.3 version are not the ones of v1.2
+	cfg.ReleaseURL = bb.AddTag("my-workspace/my-repo", "v1.2", "alice")
+	report, err = notarize.NotarizeRelease(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("NotarizeRelease of v1.2: %v", err)
+	}
+	want = "app-1.2-linux-amd64.tar.gz,my-repo-v1.2.tar.gz,my-repo-v1.2.zip"
+	if got := assetNames(report); got != want {
+		t.Errorf("got assets %s of v1.2, want %s", got, want)
+	}
+
+	cfg.UploadChecksums = true
+	_, err = notarize.NotarizeRelease(context.Background(), cfg)
+	if !errors.Is(err, notarize.ErrInvalidConfig) {
+		t.Errorf("got error %v, want the uploads to be rejected with Bitbucket", err)
+	}
+}
+
+func TestInvalidConfigIsReportedAsAWhole(t *testing.T) {
+	cfg := &notarize.Config{
+		ReleaseURL:   "https://api.github.com/my-org/my-repo",
+		CNILGRPCPort: "443443",
+		LedgerID:     "my ledger",
+	}
+	_, err := notarize.NotarizeRelease(context.Background(), cfg)
+	var configErr *notarize.ConfigError
+	if !errors.As(err, &configErr) || !errors.Is(err, notarize.ErrInvalidConfig) {
+		t.Fatalf("got error %v, want a ConfigError", err)
+	}
+	if len(configErr.Problems) != 4 {
+		t.Errorf("got problems %q, want 4 of them", configErr.Problems)
+	}
+}
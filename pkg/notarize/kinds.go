@@ -0,0 +1,521 @@
+package notarize
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// assetKind is a kind of asset recorded as the kind of its artifact, instead
+// of the generic file kind of the vcn file extractor, along with the metadata
+// specific to that kind.
+type assetKind struct {
+	kind         string
+	extensions   []string
+	contentTypes []string
+	// metadata returns the metadata of the given local file, if it is of that
+	// kind (ok is false otherwise)
+	metadata func(filePath string) (metadata map[string]interface{}, ok bool, err error)
+}
+
+// assetKinds are the kinds of assets detected by their extension or content
+// type.
+var assetKinds = []*assetKind{
+	{
+		kind:         "wasm",
+		extensions:   []string{".wasm"},
+		contentTypes: []string{"application/wasm"},
+		metadata:     wasmMetadata,
+	},
+	// the APKs are JARs too, hence detected first
+	{
+		kind:         "apk",
+		extensions:   []string{".apk"},
+		contentTypes: []string{"application/vnd.android.package-archive"},
+		metadata:     apkMetadata,
+	},
+	{
+		kind:         "jar",
+		extensions:   []string{".jar", ".war", ".ear"},
+		contentTypes: []string{"application/java-archive"},
+		metadata:     jarMetadata,
+	},
+	{
+		kind:         "deb",
+		extensions:   []string{".deb"},
+		contentTypes: []string{"application/vnd.debian.binary-package", "application/x-deb"},
+		metadata:     debMetadata,
+	},
+	{
+		kind:         "rpm",
+		extensions:   []string{".rpm"},
+		contentTypes: []string{"application/x-rpm"},
+		metadata:     rpmMetadata,
+	},
+	{
+		// the packaged Helm charts, told apart from the other gzipped
+		// tarballs by their Chart.yaml, hence only when they are local files
+		kind:       "helm-chart",
+		extensions: []string{".tgz"},
+		metadata:   helmChartMetadata,
+	},
+	{
+		// the tarballs of docker save, told apart from the other tarballs by
+		// their manifest, hence only when they are local files
+		kind:       "docker-archive",
+		extensions: []string{".tar"},
+		metadata:   dockerArchiveMetadata,
+	},
+}
+
+// detectAssetKind returns the kind of the given asset, detected by its name
+// or content type, along with the metadata found in its local file, if any
+// (the assets of these kinds are downloaded, or spooled when streamed, see
+// packageNeedsLocalFile and needsLocalFile). It returns an empty kind if the
+// asset is a generic file.
+func detectAssetKind(
+	name string,
+	contentType string,
+	filePath string,
+) (string, map[string]interface{}, error) {
+
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, k := range assetKinds {
+		if !containsString(k.extensions, ext) && !containsString(k.contentTypes, contentType) {
+			continue
+		}
+		if len(filePath) == 0 {
+			if len(k.contentTypes) == 0 {
+				// only the local files can be told apart
+				continue
+			}
+			return k.kind, nil, nil
+		}
+		metadata, ok, err := k.metadata(filePath)
+		if err != nil {
+			return k.kind, nil, err
+		}
+		if !ok {
+			continue
+		}
+		return k.kind, metadata, nil
+	}
+	return "", nil, nil
+}
+
+// kindNeedsLocalFile tells whether the given content type is the one of an
+// asset kind, whose metadata is found in its local file only.
+func kindNeedsLocalFile(contentType string) bool {
+	for _, k := range assetKinds {
+		if containsString(k.contentTypes, contentType) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// wasmMetadata checks the WebAssembly magic bytes and gets the binary format
+// version.
+func wasmMetadata(filePath string) (map[string]interface{}, bool, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, false, fmt.Errorf("error opening WebAssembly module %s: %v", filePath, err)
+	}
+	defer f.Close()
+
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(f, header); err != nil || !bytes.Equal(header[:4], []byte("\x00asm")) {
+		return nil, false, nil
+	}
+	return map[string]interface{}{
+		"wasm_version": int(binary.LittleEndian.Uint32(header[4:])),
+	}, true, nil
+}
+
+// jarManifestAttributes maps the main attributes of the JAR manifests to
+// metadata keys.
+var jarManifestAttributes = map[string]string{
+	"Implementation-Title":   "title",
+	"Implementation-Version": "version",
+	"Implementation-Vendor":  "vendor",
+	"Main-Class":             "main_class",
+}
+
+// jarMetadata gets the implementation title, version and vendor and the main
+// class from the manifest of a JAR (or WAR or EAR) archive.
+func jarMetadata(filePath string) (map[string]interface{}, bool, error) {
+	r, err := zip.OpenReader(filePath)
+	if err != nil {
+		return nil, false, nil
+	}
+	defer r.Close()
+
+	metadata := make(map[string]interface{})
+	for _, f := range r.File {
+		if f.Name != "META-INF/MANIFEST.MF" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, true, fmt.Errorf("error opening the manifest of JAR %s: %v", filePath, err)
+		}
+		attributes, err := parseManifest(rc)
+		rc.Close()
+		if err != nil {
+			return nil, true, fmt.Errorf("error reading the manifest of JAR %s: %v", filePath, err)
+		}
+		for attribute, key := range jarManifestAttributes {
+			if value := attributes[attribute]; len(value) > 0 {
+				metadata[key] = value
+			}
+		}
+		break
+	}
+	return metadata, true, nil
+}
+
+// parseManifest returns the main attributes of a JAR manifest, i.e. the ones
+// of its first section, whose values can continue on the next lines starting
+// with a space.
+func parseManifest(r io.Reader) (map[string]string, error) {
+	attributes := make(map[string]string)
+	var last string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(line) == 0 {
+			break
+		}
+		if strings.HasPrefix(line, " ") && len(last) > 0 {
+			attributes[last] += line[1:]
+			continue
+		}
+		if i := strings.Index(line, ":"); i > 0 {
+			last = line[:i]
+			attributes[last] = strings.TrimSpace(line[i+1:])
+		}
+	}
+	return attributes, scanner.Err()
+}
+
+// apkMetadata counts the DEX files of an Android package and tells if it has
+// a v1 (i.e. JAR) signature.
+func apkMetadata(filePath string) (map[string]interface{}, bool, error) {
+	r, err := zip.OpenReader(filePath)
+	if err != nil {
+		return nil, false, nil
+	}
+	defer r.Close()
+
+	var dexFiles int
+	var v1Signed, hasManifest bool
+	for _, f := range r.File {
+		switch {
+		case f.Name == "AndroidManifest.xml":
+			hasManifest = true
+		case !strings.Contains(f.Name, "/") && strings.HasSuffix(f.Name, ".dex"):
+			dexFiles++
+		case strings.HasPrefix(f.Name, "META-INF/") && strings.HasSuffix(f.Name, ".SF"):
+			v1Signed = true
+		}
+	}
+	if !hasManifest {
+		return nil, false, nil
+	}
+	return map[string]interface{}{
+		"dex_files": dexFiles,
+		"v1_signed": v1Signed,
+	}, true, nil
+}
+
+// debControlFields maps the fields of the Debian control files to metadata
+// keys.
+var debControlFields = map[string]string{
+	"Package":      "package",
+	"Version":      "version",
+	"Architecture": "arch",
+}
+
+// debMetadata gets the package name, version and architecture from the
+// control file of a Debian package if its control archive is not compressed
+// or compressed with gzip, or else from the conventional
+// <name>_<version>_<arch>.deb file name.
+func debMetadata(filePath string) (map[string]interface{}, bool, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, false, fmt.Errorf("error opening Debian package %s: %v", filePath, err)
+	}
+	defer f.Close()
+
+	magic := make([]byte, 8)
+	if _, err := io.ReadFull(f, magic); err != nil || string(magic) != "!<arch>\n" {
+		return nil, false, nil
+	}
+	metadata := make(map[string]interface{})
+	// the members of the ar archive have a 60 bytes header and are padded to
+	// an even size
+	header := make([]byte, 60)
+	for {
+		if _, err := io.ReadFull(f, header); err != nil {
+			break
+		}
+		name := strings.TrimSuffix(strings.TrimSpace(string(header[:16])), "/")
+		var size int64
+		if _, err := fmt.Sscanf(strings.TrimSpace(string(header[48:58])), "%d", &size); err != nil {
+			return nil, true, fmt.Errorf("invalid member %s of Debian package %s", name, filePath)
+		}
+		if name == "control.tar.gz" || name == "control.tar" {
+			var control io.Reader = io.LimitReader(f, size)
+			if name == "control.tar.gz" {
+				gz, err := gzip.NewReader(control)
+				if err != nil {
+					return nil, true, fmt.Errorf("error reading the control archive of Debian package %s: %v",
+						filePath, err)
+				}
+				defer gz.Close()
+				control = gz
+			}
+			fields, err := debControlFile(control)
+			if err != nil {
+				return nil, true, fmt.Errorf("error reading the control file of Debian package %s: %v",
+					filePath, err)
+			}
+			for field, key := range debControlFields {
+				if value := fields[field]; len(value) > 0 {
+					metadata[key] = value
+				}
+			}
+			return withPackageCoordinates("deb", metadata), true, nil
+		}
+		if _, err := f.Seek(size+size%2, io.SeekCurrent); err != nil {
+			break
+		}
+	}
+
+	pieces := strings.Split(strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath)), "_")
+	if len(pieces) == 3 {
+		metadata["package"] = pieces[0]
+		metadata["version"] = pieces[1]
+		metadata["arch"] = pieces[2]
+	}
+	return withPackageCoordinates("deb", metadata), true, nil
+}
+
+// debControlFile returns the fields of the control file of the given control
+// archive.
+func debControlFile(r io.Reader) (map[string]string, error) {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil, errors.New("no control file")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if path.Clean(header.Name) != "control" {
+			continue
+		}
+		fields := make(map[string]string)
+		scanner := bufio.NewScanner(tr)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if i := strings.Index(line, ":"); i > 0 && !strings.HasPrefix(line, " ") {
+				fields[line[:i]] = strings.TrimSpace(line[i+1:])
+			}
+		}
+		return fields, scanner.Err()
+	}
+}
+
+// rpmEpochTag is the tag of the epoch in the RPM header.
+const rpmEpochTag = 1003
+
+// rpmHeaderTags maps the tags of the string values of the RPM header to
+// metadata keys.
+var rpmHeaderTags = map[uint32]string{
+	1000: "package",
+	1001: "version",
+	1002: "release",
+	1022: "arch",
+}
+
+// rpmMetadata gets the package name, version, release and architecture from
+// the header of an RPM package.
+func rpmMetadata(filePath string) (map[string]interface{}, bool, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, false, fmt.Errorf("error opening RPM package %s: %v", filePath, err)
+	}
+	defer f.Close()
+
+	lead := make([]byte, 96)
+	if _, err := io.ReadFull(f, lead); err != nil || !bytes.Equal(lead[:4], []byte{0xed, 0xab, 0xee, 0xdb}) {
+		return nil, false, nil
+	}
+	// the signature header (padded to a multiple of 8 bytes) comes first
+	r := bufio.NewReader(f)
+	if _, _, err := readRPMHeader(r); err != nil {
+		return nil, true, fmt.Errorf("error reading the signature of RPM package %s: %v", filePath, err)
+	}
+	indexes, store, err := readRPMHeader(r)
+	if err != nil {
+		return nil, true, fmt.Errorf("error reading the header of RPM package %s: %v", filePath, err)
+	}
+
+	metadata := make(map[string]interface{})
+	for i := 0; i+16 <= len(indexes); i += 16 {
+		tag := binary.BigEndian.Uint32(indexes[i:])
+		dataType := binary.BigEndian.Uint32(indexes[i+4:])
+		offset := binary.BigEndian.Uint32(indexes[i+8:])
+		if offset >= uint32(len(store)) {
+			continue
+		}
+		value := store[offset:]
+		switch key, ok := rpmHeaderTags[tag]; {
+		// the epoch is an INT32 (i.e. type 4) value
+		case tag == rpmEpochTag && dataType == 4 && len(value) >= 4:
+			metadata["epoch"] = int(binary.BigEndian.Uint32(value))
+		// the other values are STRING (i.e. type 6) ones
+		case ok && dataType == 6:
+			if end := bytes.IndexByte(value, 0); end >= 0 {
+				value = value[:end]
+			}
+			metadata[key] = string(value)
+		}
+	}
+	return withPackageCoordinates("rpm", metadata), true, nil
+}
+
+// withPackageCoordinates adds to the given metadata of a package of the given
+// format (i.e. deb or rpm) the package format and the package URL (see
+// https://github.com/package-url/purl-spec), e.g.
+// pkg:deb/curl@7.74.0-1.3?arch=amd64, for querying the notarizations by
+// package coordinates.
+func withPackageCoordinates(format string, metadata map[string]interface{}) map[string]interface{} {
+	metadata["package_format"] = format
+	name, _ := metadata["package"].(string)
+	version, _ := metadata["version"].(string)
+	if len(name) == 0 || len(version) == 0 {
+		return metadata
+	}
+	if release, ok := metadata["release"].(string); ok && len(release) > 0 {
+		version += "-" + release
+	}
+	qualifiers := url.Values{}
+	if arch, ok := metadata["arch"].(string); ok && len(arch) > 0 {
+		qualifiers.Set("arch", arch)
+	}
+	if epoch, ok := metadata["epoch"].(int); ok && epoch > 0 {
+		qualifiers.Set("epoch", strconv.Itoa(epoch))
+	}
+	purl := fmt.Sprintf("pkg:%s/%s@%s", format, url.PathEscape(name), url.PathEscape(version))
+	if len(qualifiers) > 0 {
+		purl += "?" + qualifiers.Encode()
+	}
+	metadata["purl"] = purl
+	return metadata
+}
+
+// readRPMHeader reads the next header structure of an RPM package, returning
+// its index entries and data store, and skips its padding, if any.
+func readRPMHeader(r *bufio.Reader) ([]byte, []byte, error) {
+	// a header is aligned on 8 bytes, which only the signature one might not be
+	// followed by
+	for {
+		b, err := r.Peek(1)
+		if err != nil {
+			return nil, nil, err
+		}
+		if b[0] != 0 {
+			break
+		}
+		if _, err := r.Discard(1); err != nil {
+			return nil, nil, err
+		}
+	}
+	intro := make([]byte, 16)
+	if _, err := io.ReadFull(r, intro); err != nil {
+		return nil, nil, err
+	}
+	if !bytes.Equal(intro[:3], []byte{0x8e, 0xad, 0xe8}) {
+		return nil, nil, errors.New("invalid header magic")
+	}
+	nIndexes := binary.BigEndian.Uint32(intro[8:])
+	storeSize := binary.BigEndian.Uint32(intro[12:])
+	if nIndexes > 1<<16 || storeSize > 1<<28 {
+		return nil, nil, errors.New("header too large")
+	}
+	indexes := make([]byte, nIndexes*16)
+	if _, err := io.ReadFull(r, indexes); err != nil {
+		return nil, nil, err
+	}
+	store := make([]byte, storeSize)
+	if _, err := io.ReadFull(r, store); err != nil {
+		return nil, nil, err
+	}
+	return indexes, store, nil
+}
+
+// dockerArchiveMetadata gets the repository tags, the image ID and the number
+// of layers from the manifest of a tarball created by docker save.
+func dockerArchiveMetadata(filePath string) (map[string]interface{}, bool, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, false, fmt.Errorf("error opening tarball %s: %v", filePath, err)
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			// not a tarball of docker save
+			return nil, false, nil
+		}
+		if path.Clean(header.Name) != "manifest.json" {
+			continue
+		}
+		var manifest []struct {
+			Config   string
+			RepoTags []string
+			Layers   []string
+		}
+		if err := json.NewDecoder(tr).Decode(&manifest); err != nil || len(manifest) == 0 {
+			return nil, false, nil
+		}
+		image := manifest[0]
+		metadata := map[string]interface{}{"layers": len(image.Layers)}
+		if len(image.RepoTags) > 0 {
+			metadata["repo_tags"] = image.RepoTags
+		}
+		// the config is named after its digest, i.e. <hex>.json or
+		// blobs/sha256/<hex> in the OCI layout
+		if digest := strings.TrimSuffix(path.Base(image.Config), ".json"); len(digest) == 64 {
+			metadata["image_id"] = "sha256:" + digest
+		}
+		return metadata, true, nil
+	}
+}
@@ -0,0 +1,326 @@
+package notarize
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	immuschema "github.com/codenotary/immudb/pkg/api/schema"
+	vcnAPI "github.com/vchain-us/vcn/pkg/api"
+)
+
+// ErrLedgerTampered is returned (wrapped) when a ledger is not consistent
+// with its state of a previous run (see Config.LedgerStateFile), i.e. its
+// history appears to have been rewritten.
+var ErrLedgerTampered = errors.New("the ledger is not consistent with its previous state")
+
+// LedgerState is the verified state of a ledger, i.e. the ID and hash of its
+// last transaction.
+type LedgerState struct {
+	TxID      uint64    `json:"tx_id"`
+	TxHash    string    `json:"tx_hash"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// StateKeeper is implemented by the notarizers whose ledger state can be kept
+// between runs, for checking at the next runs that the ledger history has not
+// been rewritten since then.
+type StateKeeper interface {
+	// Ledger identifies the ledger of the notarizer (e.g. its host and ID),
+	// the states are keyed by.
+	Ledger() string
+	// CheckState checks the ledger against the given state of a previous
+	// run, which becomes the trusted state the next verified reads and writes
+	// are checked against.
+	CheckState(ctx context.Context, previous *LedgerState) error
+	// State returns the last verified state of the ledger.
+	State(ctx context.Context) (*LedgerState, error)
+}
+
+// immutableState returns the immudb state of the given ledger state.
+func (s *LedgerState) immutableState(db string) (*immuschema.ImmutableState, error) {
+	txHash, err := hex.DecodeString(s.TxHash)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transaction hash %s: %v", s.TxHash, err)
+	}
+	return &immuschema.ImmutableState{Db: db, TxId: s.TxID, TxHash: txHash}, nil
+}
+
+// ledgerStateOf returns the ledger state of the given immudb state.
+func ledgerStateOf(state *immuschema.ImmutableState) *LedgerState {
+	return &LedgerState{
+		TxID:      state.TxId,
+		TxHash:    hex.EncodeToString(state.TxHash),
+		CheckedAt: time.Now().UTC(),
+	}
+}
+
+// readLedgerStates reads the ledger states of the given file, keyed by
+// ledger, if it exists.
+func readLedgerStates(filePath string) (map[string]*LedgerState, error) {
+	states := make(map[string]*LedgerState)
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return states, nil
+		}
+		return nil, fmt.Errorf("error reading ledger state file %s: %v", filePath, err)
+	}
+	if err := json.Unmarshal(content, &states); err != nil {
+		return nil, fmt.Errorf("error JSON-unmarshaling ledger state file %s: %v", filePath, err)
+	}
+	return states, nil
+}
+
+// writeLedgerStates writes the given ledger states into the given file
+// (creating its directory if needed), atomically replacing it.
+func writeLedgerStates(filePath string, states map[string]*LedgerState) error {
+	content, err := json.MarshalIndent(states, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error JSON-marshaling the ledger states: %v", err)
+	}
+	if err := writeFileAtomically(filePath, append(content, '\n')); err != nil {
+		return fmt.Errorf("error writing ledger state file %s: %v", filePath, err)
+	}
+	return nil
+}
+
+// ledgerStatesFile is a ledger state file read by the process, shared by its
+// runs (e.g. the releases processed in parallel), which save the states of
+// their ledgers into it in turn.
+type ledgerStatesFile struct {
+	mu       sync.Mutex
+	filePath string
+	states   map[string]*LedgerState
+}
+
+// ledgerStatesFiles are the ledger state files read by the process, by file
+// path.
+var ledgerStatesFiles = struct {
+	sync.Mutex
+	byPath map[string]*ledgerStatesFile
+}{byPath: make(map[string]*ledgerStatesFile)}
+
+// ledgerStatesOf returns the ledger states of the given file, reading it
+// unless already done.
+func ledgerStatesOf(filePath string) (*ledgerStatesFile, error) {
+	ledgerStatesFiles.Lock()
+	defer ledgerStatesFiles.Unlock()
+	if f, ok := ledgerStatesFiles.byPath[filePath]; ok {
+		return f, nil
+	}
+	states, err := readLedgerStates(filePath)
+	if err != nil {
+		return nil, err
+	}
+	f := &ledgerStatesFile{filePath: filePath, states: states}
+	ledgerStatesFiles.byPath[filePath] = f
+	return f, nil
+}
+
+// state returns the state of the given ledger, if any.
+func (f *ledgerStatesFile) state(ledger string) (*LedgerState, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	state, ok := f.states[ledger]
+	return state, ok
+}
+
+// save saves the given states of their ledgers, unless a newer one has been
+// saved since then (e.g. by another release), and writes the states back into
+// their file.
+func (f *ledgerStatesFile) save(states map[string]*LedgerState) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for ledger, state := range states {
+		if current, ok := f.states[ledger]; !ok || state.TxID >= current.TxID {
+			f.states[ledger] = state
+		}
+	}
+	return writeLedgerStates(f.filePath, f.states)
+}
+
+// checkLedgerStates checks the ledgers of the given notarizers against their
+// states of the given file, if any. It returns the function writing their
+// last verified states back into the file once done, for the next runs.
+func checkLedgerStates(
+	ctx context.Context,
+	filePath string,
+	notarizers []Notarizer,
+	log Logger,
+) (func() error, error) {
+
+	statesFile, err := ledgerStatesOf(filePath)
+	if err != nil {
+		return nil, err
+	}
+	keepersPerLedger := make(map[string][]StateKeeper)
+	seen := make(map[Notarizer]bool, len(notarizers))
+	for _, notarizer := range notarizers {
+		keeper, ok := notarizer.(StateKeeper)
+		if !ok {
+			return nil, errors.New("the backend cannot keep the ledger states")
+		}
+		if seen[notarizer] {
+			continue
+		}
+		seen[notarizer] = true
+		keepersPerLedger[keeper.Ledger()] = append(keepersPerLedger[keeper.Ledger()], keeper)
+	}
+	ledgers := make([]string, 0, len(keepersPerLedger))
+	for ledger := range keepersPerLedger {
+		ledgers = append(ledgers, ledger)
+	}
+	sort.Strings(ledgers)
+
+	states := make(map[string]*LedgerState, len(ledgers))
+	for _, ledger := range ledgers {
+		previous, ok := statesFile.state(ledger)
+		if !ok {
+			log.Infof("No previous state of ledger %s: trusting its current state\n", ledger)
+			continue
+		}
+		for _, keeper := range keepersPerLedger[ledger] {
+			if err := keeper.CheckState(ctx, previous); err != nil {
+				log.Errorf("LEDGER %s MIGHT HAVE BEEN TAMPERED WITH: it is not consistent with "+
+					"its state at transaction %d checked at %s: %v\n",
+					ledger, previous.TxID, previous.CheckedAt.Format(time.RFC3339), err)
+				return nil, fmt.Errorf("%w: ledger %s: %v", ErrLedgerTampered, ledger, err)
+			}
+		}
+		log.Infof("Ledger %s is consistent with its state at transaction %d\n",
+			ledger, previous.TxID)
+		states[ledger] = previous
+	}
+
+	return func() error {
+		// the states are read from the clients, not from the ledger, so that
+		// they can still be saved once the deadline of the run is hit
+		ctx := context.Background()
+		lastStates := make(map[string]*LedgerState, len(ledgers))
+		for _, ledger := range ledgers {
+			var last *LedgerState
+			for _, keeper := range keepersPerLedger[ledger] {
+				state, err := keeper.State(ctx)
+				if err != nil {
+					return fmt.Errorf("error reading the state of ledger %s: %v", ledger, err)
+				}
+				if last == nil || state.TxID > last.TxID {
+					last = state
+				}
+			}
+			if previous, ok := states[ledger]; ok && last.TxID < previous.TxID {
+				return fmt.Errorf("%w: ledger %s: transaction %d is older than transaction %d",
+					ErrLedgerTampered, ledger, last.TxID, previous.TxID)
+			}
+			lastStates[ledger] = last
+		}
+		if err := statesFile.save(lastStates); err != nil {
+			return err
+		}
+		log.Infof("Wrote the verified states of %d ledgers into %s\n", len(ledgers), filePath)
+		return nil
+	}, nil
+}
+
+// Ledger returns the CNIL host and ledger ID of the notarizer.
+func (n *cnilNotarizer) Ledger() string {
+	return n.ledger
+}
+
+// CheckState trusts the given state for the next verified reads and writes
+// of the vcn client, which are checked against it by CNIL consistency proofs.
+func (n *cnilNotarizer) CheckState(ctx context.Context, previous *LedgerState) error {
+	n.mu.Lock()
+	n.trustedState = previous
+	n.stateSet = make(map[*vcnAPI.LcUser]bool)
+	n.mu.Unlock()
+	vcnUser, _, err := n.backend.vcnUser(n.apiKey)
+	if err != nil {
+		return err
+	}
+	return n.setTrustedState(vcnUser)
+}
+
+// setTrustedState sets the trusted state, if any, on the given vcn client
+// (once per client, e.g. the one of a fallback CNIL host).
+func (n *cnilNotarizer) setTrustedState(vcnUser *vcnAPI.LcUser) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.trustedState == nil || n.stateSet[vcnUser] {
+		return nil
+	}
+	client := vcnUser.Client
+	state, err := n.trustedState.immutableState(client.ApiKey)
+	if err != nil {
+		return err
+	}
+	if err := client.StateService.SetState(client.ApiKey, state); err != nil {
+		return fmt.Errorf("error setting the state of the vcn client: %v", err)
+	}
+	n.stateSet[vcnUser] = true
+	return nil
+}
+
+func (n *cnilNotarizer) State(ctx context.Context) (*LedgerState, error) {
+	var ledgerState *LedgerState
+	err := n.withFailover(func(vcnUser *vcnAPI.LcUser) error {
+		client := vcnUser.Client
+		state, err := client.StateService.GetState(ctx, client.ApiKey)
+		if err != nil {
+			return err
+		}
+		ledgerState = ledgerStateOf(state)
+		return nil
+	})
+	return ledgerState, err
+}
+
+// Ledger returns the immudb server and database of the notarizer.
+func (n *immudbNotarizer) Ledger() string {
+	return fmt.Sprintf("%s/%s", n.client.address, n.client.database)
+}
+
+// CheckState checks the current state of the immudb database against the
+// given one by a consistency proof, trusting it for the next verified reads
+// and writes.
+func (n *immudbNotarizer) CheckState(ctx context.Context, previous *LedgerState) error {
+	state, err := previous.immutableState(n.client.database)
+	if err != nil {
+		return err
+	}
+	n.client.mu.Lock()
+	defer n.client.mu.Unlock()
+	if err := n.client.stateService.SetState(n.client.database, state); err != nil {
+		return fmt.Errorf("error setting the state of the immudb client: %v", err)
+	}
+	authorizedCtx := n.client.authorized(ctx)
+	current, err := n.client.client.CurrentState(authorizedCtx)
+	if err != nil {
+		return fmt.Errorf("error reading the immudb state: %v", err)
+	}
+	if current.TxId < previous.TxID {
+		return fmt.Errorf("current transaction %d is older than transaction %d",
+			current.TxId, previous.TxID)
+	}
+	if _, err := n.client.client.VerifiedTxByID(authorizedCtx, current.TxId); err != nil {
+		return fmt.Errorf("error verifying immudb transaction %d: %v", current.TxId, err)
+	}
+	return nil
+}
+
+func (n *immudbNotarizer) State(ctx context.Context) (*LedgerState, error) {
+	n.client.mu.Lock()
+	defer n.client.mu.Unlock()
+	state, err := n.client.stateService.GetState(n.client.authorized(ctx), n.client.database)
+	if err != nil {
+		return nil, err
+	}
+	return ledgerStateOf(state), nil
+}
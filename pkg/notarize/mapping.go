@@ -0,0 +1,123 @@
+package notarize
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/go-playground/validator"
+)
+
+// RepositoryMappingEntry holds the settings to be used for the repositories
+// whose full name (i.e. <owner>/<repo-name>) matches the Repository pattern.
+type RepositoryMappingEntry struct {
+	Repository  string                 `json:"repository" validate:"required"`
+	LedgerID    string                 `json:"ledger"`
+	SignerID    string                 `json:"signer_id"`
+	SignerTeams map[string]string      `json:"signer_teams"`
+	Metadata    map[string]interface{} `json:"metadata"`
+}
+
+type RepositoriesMapping []*RepositoryMappingEntry
+
+// Match returns the first entry whose pattern matches the given repository.
+func (m RepositoriesMapping) Match(repository string) *RepositoryMappingEntry {
+	for _, entry := range m {
+		if ok, _ := path.Match(entry.Repository, repository); ok {
+			return entry
+		}
+	}
+	return nil
+}
+
+// LoadRepositoriesMapping loads the repositories mapping either from an
+// http(s) URL or from a file of a GitHub repository specified like
+// <owner>/<repo-name>/<path>[@<ref>].
+func LoadRepositoriesMapping(
+	ctx context.Context,
+	httpClient *http.Client,
+	apiBaseURL string,
+	githubToken string,
+	location string,
+) (RepositoriesMapping, error) {
+
+	var req *http.Request
+	var err error
+	if strings.HasPrefix(location, "https://") || strings.HasPrefix(location, "http://") {
+		// the GitHub token is not sent to arbitrary URLs
+		req, err = http.NewRequestWithContext(ctx, "GET", location, nil)
+	} else {
+		pieces := strings.SplitN(location, "/", 3)
+		if len(pieces) != 3 {
+			return nil, fmt.Errorf(
+				"invalid repositories mapping location %s: must be an URL or of the form "+
+					"<owner>/<repo-name>/<path>[@<ref>]", location)
+		}
+		filePath, ref := pieces[2], ""
+		if i := strings.LastIndex(filePath, "@"); i >= 0 {
+			filePath, ref = filePath[:i], filePath[i+1:]
+		}
+		contentsURL := fmt.Sprintf("%s/repos/%s/%s/contents/%s",
+			apiBaseURL, pieces[0], pieces[1], filePath)
+		if len(ref) > 0 {
+			contentsURL += "?ref=" + url.QueryEscape(ref)
+		}
+		req, err = http.NewRequestWithContext(ctx, "GET", contentsURL, nil)
+		if err == nil {
+			req.Header.Set("Accept", "application/vnd.github.v3.raw")
+			if len(githubToken) > 0 {
+				req.Header.Set("Authorization", "token "+githubToken)
+			}
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf(
+			"error creating new HTTP GET request for loading the repositories mapping from %s: %v",
+			location, err)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error loading the repositories mapping from %s: %v", location, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"error loading the repositories mapping from %s: error reading response body: %v",
+			location, err)
+	}
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return nil, fmt.Errorf(
+			"error loading the repositories mapping from %s: expected a 2xx HTTP code, got %d with body %s",
+			location, resp.StatusCode, respBody)
+	}
+
+	var mapping RepositoriesMapping
+	if err := json.Unmarshal(respBody, &mapping); err != nil {
+		return nil, fmt.Errorf(
+			"error loading the repositories mapping from %s: error JSON-unmarshaling %s: %v",
+			location, respBody, err)
+	}
+
+	validate := validator.New()
+	for _, entry := range mapping {
+		if err := validate.Struct(entry); err != nil {
+			return nil, fmt.Errorf("validation of the repositories mapping failed: %v", err)
+		}
+		if _, err := path.Match(entry.Repository, ""); err != nil {
+			return nil, fmt.Errorf(
+				"invalid repository pattern %s in the repositories mapping: %v",
+				entry.Repository, err)
+		}
+	}
+
+	return mapping, nil
+}
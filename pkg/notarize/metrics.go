@@ -0,0 +1,213 @@
+package notarize
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// metricsJob is the Pushgateway job the metrics are pushed under.
+const metricsJob = "notarize_release_assets"
+
+// signDurationBuckets are the upper bounds (in seconds) of the buckets of the
+// sign (and verify) latency histogram.
+var signDurationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+type metricsKey struct{}
+
+// runMetrics are the Prometheus metrics of a run.
+type runMetrics struct {
+	downloadedBytes int64
+
+	mu            sync.Mutex
+	signDurations []time.Duration
+}
+
+func withMetrics(ctx context.Context) (context.Context, *runMetrics) {
+	m := &runMetrics{}
+	return context.WithValue(ctx, metricsKey{}, m), m
+}
+
+func metricsFrom(ctx context.Context) *runMetrics {
+	m, _ := ctx.Value(metricsKey{}).(*runMetrics)
+	return m
+}
+
+func (m *runMetrics) addDownloadedBytes(n int) {
+	if m != nil && n > 0 {
+		atomic.AddInt64(&m.downloadedBytes, int64(n))
+	}
+}
+
+func (m *runMetrics) observeSign(d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.signDurations = append(m.signDurations, d)
+}
+
+// format returns the metrics of the given run report in the Prometheus text
+// exposition format.
+func (m *runMetrics) format(report *Report, duration time.Duration, success bool) []byte {
+	labels := fmt.Sprintf(`operation=%q,repository=%q`, report.Operation, report.Repository)
+	var buf bytes.Buffer
+	metric := func(name, kind, help string) {
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+
+	var nbSucceeded, nbFailed int
+	for _, asset := range report.Assets {
+		if len(asset.Error) > 0 {
+			nbFailed++
+		} else {
+			nbSucceeded++
+		}
+	}
+	metric("notarize_assets_total", "counter",
+		"Release assets processed (i.e. notarized, verified or untrusted), by result.")
+	fmt.Fprintf(&buf, "notarize_assets_total{%s,result=\"success\"} %d\n", labels, nbSucceeded)
+	fmt.Fprintf(&buf, "notarize_assets_total{%s,result=\"failure\"} %d\n", labels, nbFailed)
+
+	metric("notarize_downloaded_bytes_total", "counter", "Bytes of release assets downloaded.")
+	fmt.Fprintf(&buf, "notarize_downloaded_bytes_total{%s} %d\n",
+		labels, atomic.LoadInt64(&m.downloadedBytes))
+
+	m.mu.Lock()
+	durations := append([]time.Duration(nil), m.signDurations...)
+	m.mu.Unlock()
+	metric("notarize_sign_duration_seconds", "histogram",
+		"Latency of the sign (or verify) calls of the release assets.")
+	var sum float64
+	counts := make([]int, len(signDurationBuckets))
+	for _, d := range durations {
+		sum += d.Seconds()
+		for i, le := range signDurationBuckets {
+			if d.Seconds() <= le {
+				counts[i]++
+			}
+		}
+	}
+	for i, le := range signDurationBuckets {
+		fmt.Fprintf(&buf, "notarize_sign_duration_seconds_bucket{%s,le=\"%g\"} %d\n",
+			labels, le, counts[i])
+	}
+	fmt.Fprintf(&buf, "notarize_sign_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n",
+		labels, len(durations))
+	fmt.Fprintf(&buf, "notarize_sign_duration_seconds_sum{%s} %g\n", labels, sum)
+	fmt.Fprintf(&buf, "notarize_sign_duration_seconds_count{%s} %d\n", labels, len(durations))
+
+	metric("notarize_run_duration_seconds", "gauge", "Duration of the last run.")
+	fmt.Fprintf(&buf, "notarize_run_duration_seconds{%s} %g\n", labels, duration.Seconds())
+	metric("notarize_run_success", "gauge", "Whether the last run succeeded (1) or failed (0).")
+	var successValue int
+	if success {
+		successValue = 1
+	}
+	fmt.Fprintf(&buf, "notarize_run_success{%s} %d\n", labels, successValue)
+	metric("notarize_run_timestamp_seconds", "gauge", "Time the last run finished at.")
+	fmt.Fprintf(&buf, "notarize_run_timestamp_seconds{%s} %d\n", labels, time.Now().Unix())
+
+	return buf.Bytes()
+}
+
+// writeMetricsFile writes the metrics into the given file (e.g. for the
+// textfile collector of the node exporter), atomically replacing it.
+func writeMetricsFile(filePath string, metrics []byte) error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(filePath), ".metrics-*")
+	if err != nil {
+		return fmt.Errorf("error creating metrics temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write(metrics); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("error writing metrics temp file %s: %v", tmpFile.Name(), err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("error closing metrics temp file %s: %v", tmpFile.Name(), err)
+	}
+	if err := os.Chmod(tmpFile.Name(), 0644); err != nil {
+		return fmt.Errorf("error setting the permissions of metrics file %s: %v", filePath, err)
+	}
+	if err := os.Rename(tmpFile.Name(), filePath); err != nil {
+		return fmt.Errorf("error writing metrics file %s: %v", filePath, err)
+	}
+	return nil
+}
+
+// pushMetrics pushes the metrics to the given Pushgateway URL, replacing the
+// ones of the previous run of the same operation on the same repository (i.e.
+// of the same grouping key). Basic auth credentials can be specified in the
+// URL.
+func pushMetrics(
+	ctx context.Context,
+	httpClient *http.Client,
+	pushgatewayURL string,
+	operation string,
+	repository string,
+	metrics []byte,
+) error {
+
+	u := fmt.Sprintf("%s/metrics/job/%s/operation/%s",
+		strings.TrimSuffix(pushgatewayURL, "/"), metricsJob, operation)
+	if len(repository) > 0 {
+		u += "/repository@base64/" + base64.RawURLEncoding.EncodeToString([]byte(repository))
+	}
+	req, err := http.NewRequestWithContext(ctx, "PUT", u, bytes.NewReader(metrics))
+	if err != nil {
+		return fmt.Errorf("error creating new HTTP PUT %s request: %v", pushgatewayURL, err)
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error pushing the metrics to %s: %v", pushgatewayURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("error pushing the metrics to %s: expected a 2xx HTTP code, got %d",
+			pushgatewayURL, resp.StatusCode)
+	}
+	return nil
+}
+
+// exportMetrics writes the metrics of the run into the metrics file and
+// pushes them to the Pushgateway, if specified, only warning on failure so
+// that the monitoring never fails the run.
+func exportMetrics(
+	httpClient *http.Client,
+	cfg *Config,
+	m *runMetrics,
+	report *Report,
+	success bool,
+	log Logger,
+) {
+
+	if len(cfg.MetricsFile) == 0 && len(cfg.MetricsPushgatewayURL) == 0 {
+		return
+	}
+	metrics := m.format(report, time.Since(report.StartedAt), success)
+	if len(cfg.MetricsFile) > 0 {
+		if err := writeMetricsFile(cfg.MetricsFile, metrics); err != nil {
+			log.Warningf("WARNING: %v\n", err)
+		}
+	}
+	if len(cfg.MetricsPushgatewayURL) > 0 {
+		// the run deadline might have been hit
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := pushMetrics(
+			ctx, httpClient, cfg.MetricsPushgatewayURL, report.Operation, report.Repository,
+			metrics); err != nil {
+			log.Warningf("WARNING: %v\n", err)
+		}
+	}
+}
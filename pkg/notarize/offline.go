@@ -0,0 +1,473 @@
+package notarize
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codenotary/notarize-release-assets-action/pkg/github"
+	vcnAPI "github.com/vchain-us/vcn/pkg/api"
+	vcnMeta "github.com/vchain-us/vcn/pkg/meta"
+)
+
+// signingBundleVersion is the version of the format of the signing bundles.
+const signingBundleVersion = 1
+
+// SigningBundle is the portable signing bundle written by an offline run (see
+// Config.OfflineBundle) instead of contacting the ledger, holding everything
+// needed for signing the artifacts later on (see SubmitBundle).
+type SigningBundle struct {
+	Version    int               `json:"version"`
+	CreatedAt  time.Time         `json:"created_at"`
+	Operation  string            `json:"operation"`
+	Repository string            `json:"repository,omitempty"`
+	ReleaseURL string            `json:"release_url,omitempty"`
+	ReleaseTag string            `json:"release_tag,omitempty"`
+	Artifacts  []*BundleArtifact `json:"artifacts"`
+}
+
+// BundleArtifact is an artifact of a signing bundle, to be signed by the
+// given signer ID with the given status (i.e. trusted, untrusted or
+// unsupported) into the given ledger (the default one if empty).
+type BundleArtifact struct {
+	Kind        string                 `json:"kind"`
+	Name        string                 `json:"name"`
+	Hash        string                 `json:"hash"`
+	Size        uint64                 `json:"size"`
+	ContentType string                 `json:"content_type"`
+	Metadata    map[string]interface{} `json:"metadata,omitempty"`
+	SignerID    string                 `json:"signer_id"`
+	LedgerID    string                 `json:"ledger_id,omitempty"`
+	Status      string                 `json:"status"`
+}
+
+// offlineBackend records the artifacts to sign instead of signing them, its
+// notarizers returning pending ledger entries.
+type offlineBackend struct {
+	mu         sync.Mutex
+	artifacts  []*BundleArtifact
+	notarizers map[notarizerKey]*offlineNotarizer
+}
+
+func newOfflineBackend() *offlineBackend {
+	return &offlineBackend{notarizers: make(map[notarizerKey]*offlineNotarizer)}
+}
+
+func (b *offlineBackend) Notarizers(
+	ctx context.Context,
+	ledgerID string,
+	signerIDs []string,
+) ([]Notarizer, error) {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	notarizers := make([]Notarizer, 0, len(signerIDs))
+	for _, signerID := range signerIDs {
+		key := notarizerKey{ledgerID: ledgerID, signerID: signerID}
+		notarizer, ok := b.notarizers[key]
+		if !ok {
+			notarizer = &offlineNotarizer{backend: b, ledgerID: ledgerID, signerID: signerID}
+			b.notarizers[key] = notarizer
+		}
+		notarizers = append(notarizers, notarizer)
+	}
+	return notarizers, nil
+}
+
+func (b *offlineBackend) Close() error {
+	return nil
+}
+
+// write writes the signing bundle of the recorded artifacts into the given
+// file (creating its directory if needed) and returns its SHA-256 hash.
+func (b *offlineBackend) write(filePath string, report *Report) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	content, err := json.MarshalIndent(&SigningBundle{
+		Version:    signingBundleVersion,
+		CreatedAt:  time.Now().UTC(),
+		Operation:  report.Operation,
+		Repository: report.Repository,
+		ReleaseURL: report.ReleaseURL,
+		ReleaseTag: report.ReleaseTag,
+		Artifacts:  b.artifacts,
+	}, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("error JSON-marshaling the signing bundle: %v", err)
+	}
+	if dir := filepath.Dir(filePath); len(dir) > 0 {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return "", fmt.Errorf("error creating signing bundle dir %s: %v", dir, err)
+		}
+	}
+	content = append(content, '\n')
+	if err := os.WriteFile(filePath, content, 0644); err != nil {
+		return "", fmt.Errorf("error writing signing bundle file %s: %v", filePath, err)
+	}
+	hash := sha256.Sum256(content)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// offlineNotarizer records the artifacts of a signer ID into a ledger.
+type offlineNotarizer struct {
+	backend  *offlineBackend
+	ledgerID string
+	signerID string
+}
+
+func (n *offlineNotarizer) Notarize(
+	ctx context.Context,
+	artifact *vcnAPI.Artifact,
+	status vcnMeta.Status,
+) (*LedgerEntry, uint64, error) {
+
+	n.backend.mu.Lock()
+	n.backend.artifacts = append(n.backend.artifacts, &BundleArtifact{
+		Kind:        artifact.Kind,
+		Name:        artifact.Name,
+		Hash:        artifact.Hash,
+		Size:        artifact.Size,
+		ContentType: artifact.ContentType,
+		Metadata:    artifact.Metadata,
+		SignerID:    n.signerID,
+		LedgerID:    n.ledgerID,
+		Status:      strings.ToLower(status.String()),
+	})
+	n.backend.mu.Unlock()
+	return &LedgerEntry{
+		Name:        artifact.Name,
+		Hash:        artifact.Hash,
+		Size:        artifact.Size,
+		ContentType: artifact.ContentType,
+		SignerID:    n.signerID,
+		Status:      status,
+		Pending:     true,
+	}, 0, nil
+}
+
+func (n *offlineNotarizer) Verify(ctx context.Context, artifact *vcnAPI.Artifact) (*LedgerEntry, error) {
+	return nil, errors.New("the ledger cannot be read offline")
+}
+
+// readSigningBundle reads the signing bundle of the given file, which must
+// have the given SHA-256 hash (i.e. the one of the offline run which wrote
+// it), so that a bundle modified since then is never signed.
+func readSigningBundle(filePath string, bundleSHA256 string) (*SigningBundle, error) {
+	if len(strings.TrimSpace(bundleSHA256)) == 0 {
+		return nil, fmt.Errorf("the SHA-256 hash of signing bundle file %s is required", filePath)
+	}
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading signing bundle file %s: %v", filePath, err)
+	}
+	hash := sha256.Sum256(content)
+	if actual := hex.EncodeToString(hash[:]); !strings.EqualFold(actual, strings.TrimSpace(bundleSHA256)) {
+		return nil, fmt.Errorf("refusing to sign signing bundle file %s: its SHA-256 hash %s does "+
+			"not match the expected %s, the bundle has been modified since the offline run",
+			filePath, actual, bundleSHA256)
+	}
+	var bundle SigningBundle
+	if err := json.Unmarshal(content, &bundle); err != nil {
+		return nil, fmt.Errorf("error JSON-unmarshaling signing bundle file %s: %v", filePath, err)
+	}
+	if bundle.Version != signingBundleVersion {
+		return nil, fmt.Errorf("unsupported version %d of signing bundle file %s",
+			bundle.Version, filePath)
+	}
+	return &bundle, nil
+}
+
+// allowedSignerIDs returns the signer IDs the given config allows the
+// artifacts of a signing bundle of the given repository to be signed with,
+// i.e. the one of the API key if specified, or else the ones of the API keys
+// per signer ID, of the teams, of the repositories mapping entry of the
+// repository, the fallback one and the one of the actor.
+func allowedSignerIDs(
+	ctx context.Context,
+	cfg *Config,
+	httpClient *http.Client,
+	repository string,
+	releaseURL string,
+) (map[string]bool, error) {
+
+	signerIDs := make(map[string]bool)
+	if len(cfg.APIKey) > 0 {
+		pieces := strings.Split(cfg.APIKey, ".")
+		if len(pieces) < 2 {
+			return nil, errors.New(
+				"the specified API key is not supported: must be of the form <identity>.<secret>")
+		}
+		signerIDs[strings.Join(pieces[:len(pieces)-1], ".")] = true
+		return signerIDs, nil
+	}
+
+	for signerID := range cfg.APIKeysPerSignerID {
+		signerIDs[signerID] = true
+	}
+	for _, signerID := range cfg.SignerIDsPerTeam {
+		signerIDs[signerID] = true
+	}
+	if len(cfg.FallbackSignerID) > 0 {
+		signerIDs[cfg.FallbackSignerID] = true
+	}
+	if len(cfg.RepositoriesMapping) > 0 {
+		apiBaseURL := strings.TrimSuffix(cfg.GitHubAPIURL, "/")
+		if len(apiBaseURL) == 0 {
+			apiBaseURL = github.APIBaseURL(releaseURL)
+		}
+		mapping, err := LoadRepositoriesMapping(
+			ctx, httpClient, apiBaseURL, cfg.GitHubToken, cfg.RepositoriesMapping)
+		if err != nil {
+			return nil, err
+		}
+		if entry := mapping.Match(repository); entry != nil {
+			if len(entry.SignerID) > 0 {
+				signerIDs[entry.SignerID] = true
+			}
+			for _, signerID := range entry.SignerTeams {
+				signerIDs[signerID] = true
+			}
+		}
+	}
+	if len(cfg.Actor) > 0 {
+		signerIDTemplateText := cfg.SignerIDTemplate
+		if bitbucketRelease(cfg) && len(strings.TrimSpace(signerIDTemplateText)) == 0 {
+			signerIDTemplateText = bitbucketSignerIDTemplate
+		}
+		signerIDTemplate, err := parseSignerIDTemplate(signerIDTemplateText)
+		if err != nil {
+			return nil, err
+		}
+		signerID, err := signerIDFromTemplate(signerIDTemplate, normalizeLogin(cfg.Actor), repository)
+		if err != nil {
+			return nil, err
+		}
+		signerIDs[signerID] = true
+	}
+	return signerIDs, nil
+}
+
+// SubmitBundle signs the artifacts of the given signing bundle file, written
+// by an offline run from a host without access to the ledger, into the ledger
+// configured by the given config, whose release and assets settings are
+// ignored. The bundle must have the given SHA-256 hash (see
+// Report.BundleSHA256), be of the configured repository (if any), and its
+// signer IDs must be allowed by the signer IDs settings of the config (see
+// allowedSignerIDs). It returns the report of the signed artifacts, also when
+// it fails.
+func SubmitBundle(
+	ctx context.Context,
+	cfg *Config,
+	bundlePath string,
+	bundleSHA256 string,
+) (report *Report, err error) {
+
+	report = &Report{
+		Operation:  string(opNotarize),
+		Repository: cfg.Repository,
+		StartedAt:  time.Now().UTC(),
+	}
+	defer func() {
+		report.FinishedAt = time.Now().UTC()
+		report.Success = err == nil
+		if err != nil {
+			report.Error = err.Error()
+		}
+	}()
+
+	bundle, err := readSigningBundle(bundlePath, bundleSHA256)
+	if err != nil {
+		return report, err
+	}
+	report.Operation = bundle.Operation
+	report.Repository = bundle.Repository
+	report.ReleaseURL = bundle.ReleaseURL
+	report.ReleaseTag = bundle.ReleaseTag
+	if cfg.Backend == nil && len(cfg.CNILHost) == 0 && len(cfg.ImmudbHost) == 0 {
+		return report, errors.New("either the CNIL host or the immudb host is required")
+	}
+	if len(cfg.Repository) > 0 && !strings.EqualFold(cfg.Repository, bundle.Repository) {
+		return report, fmt.Errorf("refusing to sign signing bundle file %s: it is of repository %s, "+
+			"not of the configured repository %s", bundlePath, bundle.Repository, cfg.Repository)
+	}
+	log := cfg.Logger
+	if log == nil {
+		log = nopLogger{}
+	}
+	httpClient, _, proxy, err := newHTTPClients(cfg)
+	if err != nil {
+		return report, err
+	}
+
+	// check the signer IDs before signing anything
+	signerIDs, err := allowedSignerIDs(ctx, cfg, httpClient, bundle.Repository, bundle.ReleaseURL)
+	if err != nil {
+		return report, err
+	}
+	if len(signerIDs) == 0 {
+		return report, errors.New("the signer IDs allowed to sign the signing bundle are required: " +
+			"specify the actor, the API key(s), the signer IDs per team, the fallback signer ID " +
+			"or a repositories mapping")
+	}
+	for _, artifact := range bundle.Artifacts {
+		if !signerIDs[artifact.SignerID] {
+			return report, fmt.Errorf("refusing to sign artifact %s of signing bundle file %s as %s: "+
+				"the signer ID is not allowed by the configured signer IDs", artifact.Name,
+				bundlePath, artifact.SignerID)
+		}
+	}
+
+	// check the statuses before signing anything
+	statusesOfArtifacts := make([]vcnMeta.Status, len(bundle.Artifacts))
+	assets := make([]*releaseAsset, len(bundle.Artifacts))
+	for i, artifact := range bundle.Artifacts {
+		status, err := ParseStatus(artifact.Status)
+		if err != nil {
+			return report, fmt.Errorf("invalid status of artifact %s: %v", artifact.Name, err)
+		}
+		statusesOfArtifacts[i] = statuses[status]
+		assets[i] = &releaseAsset{name: artifact.Name, signerID: artifact.SignerID}
+	}
+
+	backend := cfg.Backend
+	if backend == nil {
+		var runBackend Backend
+		runBackend, err = newDefaultBackend(cfg, httpClient, proxy, log)
+		if err != nil {
+			return report, err
+		}
+		defer func() {
+			if closeErr := runBackend.Close(); closeErr != nil {
+				log.Errorf("%v\n", closeErr)
+				if err == nil {
+					err = closeErr
+				}
+			}
+		}()
+		backend = runBackend
+	}
+
+	// get the notarizer of each artifact, per ledger
+	notarizers := make([]Notarizer, len(bundle.Artifacts))
+	var ledgerIDs []string
+	artifactsPerLedger := make(map[string][]int)
+	for i, artifact := range bundle.Artifacts {
+		ledgerID := artifact.LedgerID
+		if len(ledgerID) == 0 {
+			ledgerID = cfg.LedgerID
+		}
+		if _, ok := artifactsPerLedger[ledgerID]; !ok {
+			ledgerIDs = append(ledgerIDs, ledgerID)
+		}
+		artifactsPerLedger[ledgerID] = append(artifactsPerLedger[ledgerID], i)
+	}
+	for _, ledgerID := range ledgerIDs {
+		ledgerArtifacts := artifactsPerLedger[ledgerID]
+		signerIDs := make([]string, 0, len(ledgerArtifacts))
+		for _, i := range ledgerArtifacts {
+			signerIDs = append(signerIDs, bundle.Artifacts[i].SignerID)
+		}
+		ledgerNotarizers, err := backend.Notarizers(ctx, ledgerID, signerIDs)
+		if err != nil {
+			return report, err
+		}
+		for j, i := range ledgerArtifacts {
+			notarizers[i] = ledgerNotarizers[j]
+		}
+	}
+
+	if len(cfg.LedgerStateFile) > 0 {
+		var saveLedgerStates func() error
+		saveLedgerStates, err = checkLedgerStates(ctx, cfg.LedgerStateFile, notarizers, log)
+		if err != nil {
+			return report, err
+		}
+		defer func() {
+			if saveErr := saveLedgerStates(); saveErr != nil {
+				log.Errorf("%v\n", saveErr)
+				if err == nil {
+					err = saveErr
+				}
+			}
+		}()
+	}
+
+	log.Infof("\nSubmitting the %d artifacts of signing bundle %s ...\n\n",
+		len(bundle.Artifacts), bundlePath)
+	assetsReports := make([]*AssetReport, len(bundle.Artifacts))
+	errs := forEachParallel(len(bundle.Artifacts), cfg.MaxParallel, false, func(i int) error {
+		// do not start signing the artifact if the deadline has been hit
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		artifact := bundle.Artifacts[i]
+		assetReport := &AssetReport{
+			Name:        artifact.Name,
+			Kind:        artifact.Kind,
+			Hash:        artifact.Hash,
+			Size:        artifact.Size,
+			ContentType: artifact.ContentType,
+			SignerID:    artifact.SignerID,
+			LedgerID:    artifact.LedgerID,
+		}
+		assetsReports[i] = assetReport
+		entry, txID, err := notarizers[i].Notarize(ctx, &vcnAPI.Artifact{
+			Kind:        artifact.Kind,
+			Name:        artifact.Name,
+			Hash:        artifact.Hash,
+			Size:        artifact.Size,
+			ContentType: artifact.ContentType,
+			Metadata:    artifact.Metadata,
+		}, statusesOfArtifacts[i])
+		assetReport.TxID = txID
+		if err != nil {
+			assetReport.Error = err.Error()
+			log.Errorf("Error signing artifact %s: %v\n", artifact.Name, err)
+			return err
+		}
+		assetReport.SignerID = entry.SignerID
+		assetReport.Status = entry.Status.String()
+		assetReport.Timestamp = entry.Timestamp
+		assetReport.UID = entry.UID
+		if cfg.ExportProofs {
+			prover, ok := notarizers[i].(Prover)
+			if !ok {
+				err = fmt.Errorf("the backend cannot prove the entry of %s", artifact.Name)
+			} else {
+				assetReport.Proof, err = prover.Prove(ctx, &vcnAPI.Artifact{
+					Name: artifact.Name,
+					Hash: artifact.Hash,
+				})
+			}
+			if err != nil {
+				assetReport.Error = err.Error()
+				log.Errorf("Error proving artifact %s: %v\n", artifact.Name, err)
+				return err
+			}
+		}
+		log.Successf("Successfully notarized artifact %s (%s) as %s\n",
+			artifact.Name, entry.Status, entry.SignerID)
+		return nil
+	})
+	for _, assetReport := range assetsReports {
+		if assetReport != nil {
+			report.Assets = append(report.Assets, assetReport)
+		}
+	}
+
+	// abort with the results of the artifacts signed so far
+	if err := ctx.Err(); err != nil {
+		return report, fmt.Errorf("%w: only %d of the %d artifacts have been signed",
+			err, len(report.Assets), len(bundle.Artifacts))
+	}
+	return report, assetsError(assets, errs)
+}
@@ -0,0 +1,204 @@
+package notarize
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// packageMetadata detects the packaging format of the given asset file and
+// returns the identifying metadata (i.e. package format, app ID, version and
+// architecture) found in it, if any, to be attached to its notarization.
+func packageMetadata(filePath string) (map[string]interface{}, error) {
+	name := filepath.Base(filePath)
+	switch ext := strings.ToLower(filepath.Ext(name)); ext {
+	case ".flatpak":
+		return flatpakBundleMetadata(filePath)
+	case ".flatpakref":
+		return flatpakRefMetadata(filePath)
+	case ".appimage":
+		return appImageMetadata(filePath)
+	case ".snap":
+		return snapMetadataFromName(name), nil
+	}
+	return nil, nil
+}
+
+// packageNeedsLocalFile tells if the package metadata of the given asset, or
+// the metadata of its kind (see assetKinds), can be extracted only from a
+// local (i.e. downloaded) file.
+func packageNeedsLocalFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	switch ext {
+	case ".flatpak", ".flatpakref", ".appimage":
+		return true
+	}
+	for _, k := range assetKinds {
+		if containsString(k.extensions, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// flatpakBundleRef matches the NUL-terminated ref of a single-file Flatpak
+// bundle, i.e. app/<app ID>/<arch>/<branch> (or runtime/...), in the GVariant
+// metadata of its header.
+var flatpakBundleRef = regexp.MustCompile(`(?:app|runtime)/([A-Za-z0-9_.-]+)/([A-Za-z0-9_]+)/([A-Za-z0-9_.-]+)\x00`)
+
+// flatpakBundleMetadata extracts the app ID, architecture and version (i.e.
+// the branch) from the ref in the header of a single-file Flatpak bundle or,
+// if missing, the app ID and architecture (i.e. the one of its runtime) from
+// the application metadata key file which is embedded (uncompressed) in it.
+func flatpakBundleMetadata(filePath string) (map[string]interface{}, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening Flatpak bundle %s: %v", filePath, err)
+	}
+	defer f.Close()
+
+	header := make([]byte, 256*1024)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("error reading Flatpak bundle %s: %v", filePath, err)
+	}
+	header = header[:n]
+
+	metadata := map[string]interface{}{"package_format": "flatpak"}
+	if ref := flatpakBundleRef.FindSubmatch(header); ref != nil {
+		metadata["app_id"] = string(ref[1])
+		metadata["arch"] = string(ref[2])
+		metadata["version"] = string(ref[3])
+		return metadata, nil
+	}
+	start := bytes.Index(header, []byte("[Application]"))
+	if start < 0 {
+		return metadata, nil
+	}
+	keyFile := header[start:]
+	if end := bytes.IndexByte(keyFile, 0); end >= 0 {
+		keyFile = keyFile[:end]
+	}
+	values := parseKeyFileGroup(keyFile, "Application")
+	if appID := values["name"]; len(appID) > 0 {
+		metadata["app_id"] = appID
+	}
+	// the runtime is of the form <runtime ID>/<arch>/<branch>
+	if runtime := strings.Split(values["runtime"], "/"); len(runtime) == 3 {
+		metadata["arch"] = runtime[1]
+	}
+	return metadata, nil
+}
+
+// flatpakRefMetadata extracts the app ID and branch from a .flatpakref file.
+func flatpakRefMetadata(filePath string) (map[string]interface{}, error) {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading Flatpak ref %s: %v", filePath, err)
+	}
+	metadata := map[string]interface{}{"package_format": "flatpakref"}
+	values := parseKeyFileGroup(content, "Flatpak Ref")
+	if appID := values["Name"]; len(appID) > 0 {
+		metadata["app_id"] = appID
+	}
+	if branch := values["Branch"]; len(branch) > 0 {
+		metadata["version"] = branch
+	}
+	return metadata, nil
+}
+
+// parseKeyFileGroup returns the key-value pairs of the given group of a
+// freedesktop.org (i.e. INI-like) key file.
+func parseKeyFileGroup(keyFile []byte, group string) map[string]string {
+	values := make(map[string]string)
+	inGroup := false
+	scanner := bufio.NewScanner(bytes.NewReader(keyFile))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			inGroup = line == "["+group+"]"
+			continue
+		}
+		if !inGroup {
+			continue
+		}
+		if pieces := strings.SplitN(line, "=", 2); len(pieces) == 2 {
+			values[strings.TrimSpace(pieces[0])] = strings.TrimSpace(pieces[1])
+		}
+	}
+	return values
+}
+
+// elfArchitectures maps the ELF e_machine values to architecture names.
+var elfArchitectures = map[uint16]string{
+	0x03: "i386",
+	0x28: "armhf",
+	0x3e: "x86_64",
+	0xb7: "aarch64",
+}
+
+// appImageMetadata checks the AppImage magic bytes, gets the architecture
+// from the ELF header of the runtime and the app name and version from the
+// conventional <name>-<version>-<arch>.AppImage file name.
+func appImageMetadata(filePath string) (map[string]interface{}, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening AppImage %s: %v", filePath, err)
+	}
+	defer f.Close()
+
+	header := make([]byte, 20)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return nil, fmt.Errorf("error reading AppImage %s: %v", filePath, err)
+	}
+	if !bytes.Equal(header[:4], []byte("\x7fELF")) ||
+		header[8] != 'A' || header[9] != 'I' {
+		return nil, nil
+	}
+
+	metadata := map[string]interface{}{
+		"package_format": "appimage",
+		"appimage_type":  int(header[10]),
+	}
+	byteOrder := binary.ByteOrder(binary.LittleEndian)
+	if header[5] == 2 {
+		byteOrder = binary.BigEndian
+	}
+	if arch, ok := elfArchitectures[byteOrder.Uint16(header[18:20])]; ok {
+		metadata["arch"] = arch
+	}
+
+	name := strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath))
+	if arch, ok := metadata["arch"].(string); ok {
+		name = strings.TrimSuffix(name, "-"+arch)
+	}
+	if i := strings.LastIndex(name, "-"); i > 0 {
+		metadata["app_id"] = name[:i]
+		metadata["version"] = name[i+1:]
+	} else {
+		metadata["app_id"] = name
+	}
+	return metadata, nil
+}
+
+// snapMetadataFromName gets the snap name, version and architecture from the
+// <name>_<version>_<arch>.snap file name snapcraft produces only, i.e. not
+// from the meta/snap.yaml file of the squashfs image, so a renamed snap gets
+// the metadata of its new name: the metadata_source metadata tells so.
+func snapMetadataFromName(fileName string) map[string]interface{} {
+	metadata := map[string]interface{}{"package_format": "snap"}
+	pieces := strings.Split(strings.TrimSuffix(fileName, filepath.Ext(fileName)), "_")
+	if len(pieces) == 3 {
+		metadata["app_id"] = pieces[0]
+		metadata["version"] = pieces[1]
+		metadata["arch"] = pieces[2]
+		metadata["metadata_source"] = "file_name"
+	}
+	return metadata
+}
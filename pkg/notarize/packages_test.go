@@ -0,0 +1,80 @@
+package notarize
+
+import (
+	"reflect"
+	"testing"
+)
+
+// flatpakBundleHeader returns the start of a single-file Flatpak bundle, with
+// the given ref in its GVariant metadata, if any, and its application metadata.
+func flatpakBundleHeader(ref string) []byte {
+	header := []byte("xdg-app\x00\x01\x00\x00\x00\x00\x00\x00\x00")
+	if len(ref) > 0 {
+		header = append(header, "ref\x00"+ref+"\x00\x00s"...)
+	}
+	return append(header, "metadata\x00[Application]\n"+
+		"name=org.example.App\n"+
+		"runtime=org.freedesktop.Platform/aarch64/23.08\n"+
+		"sdk=org.freedesktop.Sdk/aarch64/23.08\n\x00\x00s"...)
+}
+
+func TestPackageMetadata(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		content []byte
+		want    map[string]interface{}
+	}{
+		{
+			name:    "app.flatpak",
+			content: flatpakBundleHeader("app/org.example.App/x86_64/stable"),
+			want: map[string]interface{}{
+				"package_format": "flatpak",
+				"app_id":         "org.example.App",
+				"arch":           "x86_64",
+				"version":        "stable",
+			},
+		},
+		{
+			name:    "app.flatpak",
+			content: flatpakBundleHeader(""),
+			want: map[string]interface{}{
+				"package_format": "flatpak",
+				"app_id":         "org.example.App",
+				"arch":           "aarch64",
+			},
+		},
+		{
+			name: "app.flatpakref",
+			content: []byte("[Flatpak Ref]\nName=org.example.App\nBranch=stable\n" +
+				"Url=https://dl.flathub.org/repo/\n"),
+			want: map[string]interface{}{
+				"package_format": "flatpakref",
+				"app_id":         "org.example.App",
+				"version":        "stable",
+			},
+		},
+		{
+			name:    "app_1.2.3_amd64.snap",
+			content: []byte("hsqs"),
+			want: map[string]interface{}{
+				"package_format":  "snap",
+				"app_id":          "app",
+				"version":         "1.2.3",
+				"arch":            "amd64",
+				"metadata_source": "file_name",
+			},
+		},
+		{
+			name:    "app.snap",
+			content: []byte("hsqs"),
+			want:    map[string]interface{}{"package_format": "snap"},
+		},
+	} {
+		metadata, err := packageMetadata(writeTestFile(t, tc.name, tc.content))
+		if err != nil {
+			t.Errorf("got error %v for %s, want none", err, tc.name)
+		} else if !reflect.DeepEqual(metadata, tc.want) {
+			t.Errorf("got metadata %v for %s, want %v", metadata, tc.name, tc.want)
+		}
+	}
+}
@@ -0,0 +1,413 @@
+package notarize
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/codenotary/notarize-release-assets-action/pkg/github"
+	vcnAPI "github.com/vchain-us/vcn/pkg/api"
+)
+
+// platformManifestSuffix is the suffix of the names of the platform
+// manifests, i.e. <group>.platforms.json.
+const platformManifestSuffix = ".platforms.json"
+
+// PlatformManifestOptions are the settings of the grouping of the per-OS /
+// arch variants of the same binary under a single notarized platform
+// manifest, listing all the variants along with their hashes.
+type PlatformManifestOptions struct {
+	// Groups are the glob patterns (see path.Match) of the names of the
+	// variants of each group, by group name. If empty, the variants are
+	// grouped by the name they have once stripped of their OS and arch, e.g.
+	// my-tool-linux-amd64.tar.gz and my-tool-darwin-arm64.zip are the
+	// variants of the my-tool group.
+	Groups map[string][]string
+
+	// Only specifies to notarize the manifests instead of (rather than in
+	// addition to) the variants themselves, which are then verified against
+	// the manifests of the release.
+	Only bool
+}
+
+// PlatformManifest is the content of the platform manifest of a group of
+// variants, which is notarized and uploaded to the release.
+type PlatformManifest struct {
+	Name       string            `json:"name"`
+	Repository string            `json:"repository,omitempty"`
+	ReleaseTag string            `json:"release_tag,omitempty"`
+	Variants   []PlatformVariant `json:"variants"`
+}
+
+// PlatformVariant is a variant of a binary, listed in its platform manifest.
+type PlatformVariant struct {
+	Name string `json:"name"`
+	OS   string `json:"os,omitempty"`
+	Arch string `json:"arch,omitempty"`
+	Hash string `json:"hash"`
+	Size uint64 `json:"size,omitempty"`
+}
+
+// ParsePlatformGroups parses the groups of the platform variants: either
+// "auto" (or empty), i.e. grouped by naming convention, or a JSON object of
+// the form {"<group>": "<asset name glob pattern>" | ["<pattern>", ...], ...}.
+func ParsePlatformGroups(groups string) (map[string][]string, error) {
+	groups = strings.TrimSpace(groups)
+	if len(groups) == 0 || strings.EqualFold(groups, "auto") {
+		return nil, nil
+	}
+
+	rawGroups := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(groups), &rawGroups); err != nil {
+		return nil, fmt.Errorf("error JSON-unmarshaling the platform groups: %v", err)
+	}
+	patternsPerGroup := make(map[string][]string, len(rawGroups))
+	for group, rawPatterns := range rawGroups {
+		if len(group) == 0 || strings.ContainsAny(group, "/\\") {
+			return nil, fmt.Errorf("invalid platform group name %q", group)
+		}
+		var patterns []string
+		switch v := rawPatterns.(type) {
+		case string:
+			patterns = []string{v}
+		case []interface{}:
+			for _, pattern := range v {
+				pattern, ok := pattern.(string)
+				if !ok {
+					return nil, fmt.Errorf(
+						"invalid patterns of platform group %s: must be strings", group)
+				}
+				patterns = append(patterns, pattern)
+			}
+		default:
+			return nil, fmt.Errorf("invalid patterns of platform group %s: "+
+				"must be either a string or an array of strings", group)
+		}
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid asset name pattern %s: %v", pattern, err)
+			}
+		}
+		patternsPerGroup[group] = patterns
+	}
+	return patternsPerGroup, nil
+}
+
+// platformOSes and platformArchs are the OS and arch names found in the names
+// of the platform variants, by lower case name.
+var (
+	platformOSes = map[string]string{
+		"linux": "linux", "darwin": "darwin", "macos": "darwin", "mac": "darwin", "osx": "darwin",
+		"windows": "windows", "win": "windows", "win32": "windows", "win64": "windows",
+		"freebsd": "freebsd", "openbsd": "openbsd", "netbsd": "netbsd", "dragonfly": "dragonfly",
+		"android": "android", "illumos": "illumos", "solaris": "solaris", "aix": "aix",
+	}
+	platformArchs = map[string]string{
+		"amd64": "amd64", "x64": "amd64", "386": "386", "i386": "386", "i686": "386",
+		"x86": "386", "arm64": "arm64", "aarch64": "arm64", "arm": "arm", "armv6": "arm",
+		"armv7": "arm", "armhf": "arm", "armel": "arm", "ppc64le": "ppc64le", "ppc64": "ppc64",
+		"s390x": "s390x", "riscv64": "riscv64", "mips": "mips", "mipsle": "mipsle",
+		"mips64": "mips64", "mips64le": "mips64le", "loong64": "loong64", "universal": "universal",
+	}
+	// platformExtensions are the extensions stripped from the names of the
+	// variants (the longest first)
+	platformExtensions = []string{
+		".tar.gz", ".tar.xz", ".tar.bz2", ".tar.zst", ".tgz", ".txz", ".tbz2",
+		".zip", ".7z", ".gz", ".xz", ".bz2", ".zst", ".exe", ".msi", ".dmg", ".pkg",
+		".deb", ".rpm", ".apk", ".appimage", ".snap",
+	}
+	x8664Pattern = regexp.MustCompile(`(?i)x86_64`)
+)
+
+// platformOf returns the group (by naming convention), OS and arch of the
+// given asset, if its name has an OS or an arch.
+func platformOf(name string) (group string, goos string, goarch string, ok bool) {
+	stem := name
+	for _, ext := range platformExtensions {
+		if strings.HasSuffix(strings.ToLower(name), ext) {
+			stem = name[:len(name)-len(ext)]
+			break
+		}
+	}
+	stem = x8664Pattern.ReplaceAllString(stem, "amd64")
+	tokens := strings.FieldsFunc(stem, func(r rune) bool { return r == '-' || r == '_' })
+	// the names with an unknown extension are not binaries (e.g. checksums)
+	if len(tokens) == 0 || (stem == name && strings.Contains(tokens[len(tokens)-1], ".")) {
+		return "", "", "", false
+	}
+	var rest []string
+	for _, token := range tokens {
+		if tokenOS, found := platformOSes[strings.ToLower(token)]; found && len(goos) == 0 {
+			goos = tokenOS
+		} else if tokenArch, found := platformArchs[strings.ToLower(token)]; found && len(goarch) == 0 {
+			goarch = tokenArch
+		} else {
+			rest = append(rest, token)
+		}
+	}
+	if (len(goos) == 0 && len(goarch) == 0) || len(rest) == 0 {
+		return "", "", "", false
+	}
+	return strings.Join(rest, "-"), goos, goarch, true
+}
+
+// platformManifests groups the platform variants of a run under their
+// platform manifests.
+type platformManifests struct {
+	options *PlatformManifestOptions
+	// groups are the names of the manifests (sorted) and the indexes of the
+	// assets of their variants
+	groups   []string
+	variants map[string][]int
+	// listed are the hashes of the variants listed in the manifests of the
+	// release, by manifest name and variant name, when verifying only the
+	// manifests
+	listed map[string]map[string]string
+}
+
+// newPlatformManifests groups the given file assets by platform, setting the
+// manifest and platform of the variants of the groups with at least two of
+// them (or of the explicit groups).
+func newPlatformManifests(options *PlatformManifestOptions, assets []*releaseAsset) *platformManifests {
+	m := &platformManifests{options: options, variants: make(map[string][]int)}
+	groupNames := make([]string, 0, len(options.Groups))
+	for name := range options.Groups {
+		groupNames = append(groupNames, name)
+	}
+	sort.Strings(groupNames)
+	for i, asset := range assets {
+		if asset.artifact != nil || strings.HasSuffix(asset.name, platformManifestSuffix) {
+			continue
+		}
+		group, goos, goarch, isVariant := platformOf(asset.name)
+		if len(groupNames) > 0 {
+			group = ""
+			for _, name := range groupNames {
+				if matchesAny(asset.name, options.Groups[name]) {
+					group = name
+					break
+				}
+			}
+		} else if !isVariant {
+			continue
+		}
+		if len(group) == 0 {
+			continue
+		}
+		manifestName := group + platformManifestSuffix
+		m.variants[manifestName] = append(m.variants[manifestName], i)
+		if len(goos) > 0 || len(goarch) > 0 {
+			asset.platform = goos + "/" + goarch
+		}
+	}
+	for manifestName, indexes := range m.variants {
+		// the naming convention might find a single variant of a binary
+		if len(options.Groups) == 0 && len(indexes) < 2 {
+			delete(m.variants, manifestName)
+			for _, i := range indexes {
+				assets[i].platform = ""
+			}
+			continue
+		}
+		m.groups = append(m.groups, manifestName)
+		for _, i := range indexes {
+			assets[i].platformManifest = manifestName
+		}
+	}
+	sort.Strings(m.groups)
+	return m
+}
+
+// only tells if the given asset is notarized (or verified) only through its
+// platform manifest.
+func (m *platformManifests) only(asset *releaseAsset) bool {
+	return m.options.Only && len(asset.platformManifest) > 0
+}
+
+// needsFile returns whether the given asset must be downloaded (even when
+// streaming the assets) to read the variants it lists, when verifying only the
+// manifests.
+func (m *platformManifests) needsFile(name string) bool {
+	return m.options.Only && strings.HasSuffix(name, platformManifestSuffix)
+}
+
+// loadListed reads the variants listed in the platform manifests of the
+// release, among the given assets and their files.
+func (m *platformManifests) loadListed(assets []*releaseAsset, assetsFiles []string) error {
+	m.listed = make(map[string]map[string]string)
+	for i, asset := range assets {
+		if !m.needsFile(asset.name) || len(assetsFiles[i]) == 0 {
+			continue
+		}
+		content, err := os.ReadFile(assetsFiles[i])
+		if err != nil {
+			return fmt.Errorf("error reading platform manifest %s: %v", asset.name, err)
+		}
+		var manifest PlatformManifest
+		if err := json.Unmarshal(content, &manifest); err != nil {
+			return fmt.Errorf("error JSON-unmarshaling platform manifest %s: %v", asset.name, err)
+		}
+		hashes := make(map[string]string, len(manifest.Variants))
+		for _, variant := range manifest.Variants {
+			hashes[variant.Name] = variant.Hash
+		}
+		m.listed[asset.name] = hashes
+	}
+	return nil
+}
+
+// processVariant fills in the report of the given variant, notarized (or
+// verified) only through its platform manifest: when verifying, its hash must
+// be the one listed in the manifest of the release (which is verified itself
+// against the ledger as any other asset).
+func (m *platformManifests) processVariant(
+	asset *releaseAsset,
+	artifact *vcnAPI.Artifact,
+	op operation,
+	assetReport *AssetReport,
+	log Logger,
+) error {
+
+	assetReport.Kind = artifact.Kind
+	assetReport.Hash = artifact.Hash
+	assetReport.Size = artifact.Size
+	assetReport.ContentType = artifact.ContentType
+	assetReport.PlatformManifest = asset.platformManifest
+	if op != opVerify {
+		log.Infof("Asset %s is notarized through platform manifest %s\n",
+			asset.name, asset.platformManifest)
+		return nil
+	}
+	hashes, ok := m.listed[asset.platformManifest]
+	if !ok {
+		return fmt.Errorf("platform manifest %s of asset %s not found in the release",
+			asset.platformManifest, asset.name)
+	}
+	if listedHash := hashes[asset.name]; listedHash != artifact.Hash {
+		assetReport.notNotarized = true
+		if len(listedHash) == 0 {
+			return fmt.Errorf("%s is not listed in platform manifest %s",
+				asset.name, asset.platformManifest)
+		}
+		return fmt.Errorf("%s does not match the hash %s listed in platform manifest %s",
+			asset.name, listedHash, asset.platformManifest)
+	}
+	log.Successf("Asset %s matches platform manifest %s\n", asset.name, asset.platformManifest)
+	return nil
+}
+
+// writeManifest writes the platform manifest of the given name, listing the
+// variants of the given reports, into the given dir and returns its path.
+func writeManifest(
+	dir string,
+	manifestName string,
+	assets []*releaseAsset,
+	indexes []int,
+	report *Report,
+) (string, *PlatformManifest, error) {
+
+	reportsPerName := make(map[string]*AssetReport, len(report.Assets))
+	for _, assetReport := range report.Assets {
+		if _, ok := reportsPerName[assetReport.Name]; !ok {
+			reportsPerName[assetReport.Name] = assetReport
+		}
+	}
+	manifest := &PlatformManifest{
+		Name:       strings.TrimSuffix(manifestName, platformManifestSuffix),
+		Repository: report.Repository,
+		ReleaseTag: report.ReleaseTag,
+	}
+	for _, i := range indexes {
+		assetReport := reportsPerName[assets[i].name]
+		if assetReport == nil {
+			return "", nil, fmt.Errorf("asset %s of platform manifest %s has not been processed",
+				assets[i].name, manifestName)
+		}
+		variant := PlatformVariant{Name: assets[i].name, Hash: assetReport.Hash, Size: assetReport.Size}
+		if platform := strings.SplitN(assets[i].platform, "/", 2); len(platform) == 2 {
+			variant.OS, variant.Arch = platform[0], platform[1]
+		}
+		manifest.Variants = append(manifest.Variants, variant)
+	}
+	sort.Slice(manifest.Variants, func(i, j int) bool {
+		return manifest.Variants[i].Name < manifest.Variants[j].Name
+	})
+
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return "", nil, fmt.Errorf("error JSON-marshaling platform manifest %s: %v", manifestName, err)
+	}
+	filePath := filepath.Join(dir, manifestName)
+	if err := os.WriteFile(filePath, append(content, '\n'), 0644); err != nil {
+		return "", nil, fmt.Errorf("error writing platform manifest %s: %v", filePath, err)
+	}
+	return filePath, manifest, nil
+}
+
+// notarizeAndUploadPlatformManifests notarizes the platform manifest of each
+// group, signed by the signer of its first variant into the ledgers of that
+// variant (with the given notarizers of its units), and uploads it to the
+// release, replacing the previous one (if any).
+func notarizeAndUploadPlatformManifests(
+	ctx context.Context,
+	httpClient *http.Client,
+	githubToken string,
+	dir string,
+	release *github.Release,
+	previousManifestURLs map[string]string,
+	m *platformManifests,
+	assets []*releaseAsset,
+	notarizersOf func(i int) ([]Notarizer, []string),
+	metadata map[string]interface{},
+	exportProofs bool,
+	report *Report,
+	log Logger,
+) error {
+
+	for _, manifestName := range m.groups {
+		indexes := m.variants[manifestName]
+		filePath, manifest, err := writeManifest(dir, manifestName, assets, indexes, report)
+		if err != nil {
+			return err
+		}
+		// the ledger entry of the manifest lists the variants as well, for
+		// discovering them all from a single lookup
+		manifestMetadata := make(map[string]interface{}, len(metadata)+1)
+		for key, value := range metadata {
+			manifestMetadata[key] = value
+		}
+		manifestMetadata["platform_variants"] = manifest.Variants
+
+		first := assets[indexes[0]]
+		asset := &releaseAsset{name: manifestName, signerID: first.signerID, status: first.status}
+		notarizers, ledgerIDs := notarizersOf(indexes[0])
+		for n, notarizer := range notarizers {
+			artifact, err := vcnArtifactFromAssetFile(filePath)
+			if err != nil {
+				return err
+			}
+			assetReport := &AssetReport{Name: manifestName, SignerID: first.signerID, LedgerID: ledgerIDs[n]}
+			report.Assets = append(report.Assets, assetReport)
+			if err := processAsset(
+				ctx, asset, filePath, artifact, notarizer, opNotarize, nil, manifestMetadata,
+				release.TagName, "", exportProofs, assetReport, log); err != nil {
+				assetReport.Error = err.Error()
+				return err
+			}
+		}
+
+		if err := github.ReplaceReleaseAsset(
+			ctx, httpClient, release.UploadURL, previousManifestURLs[manifestName], githubToken,
+			filePath, log); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,343 @@
+package notarize
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/codenotary/notarize-release-assets-action/pkg/github"
+	vcnAPI "github.com/vchain-us/vcn/pkg/api"
+	vcnFileExtractor "github.com/vchain-us/vcn/pkg/extractor/file"
+	"golang.org/x/crypto/openpgp"
+)
+
+// maxCheckFileSize is the maximum size of the checksums and signature files
+// read for the pre-check.
+const maxCheckFileSize = 1 << 20
+
+// releaseChecks are the checksums and detached GPG signatures published in
+// the release, which the assets are checked against before being notarized.
+type releaseChecks struct {
+	// checksums are the expected SHA-256 hashes by asset name, and
+	// checksumsFiles the names of the files they come from
+	checksums      map[string]string
+	checksumsFiles map[string]string
+	// signatures are the detached signatures by asset name, and
+	// signaturesFiles the names of the files they come from
+	signatures      map[string][]byte
+	signaturesFiles map[string]string
+	keyring         openpgp.EntityList
+
+	mu      sync.Mutex
+	results map[string]error
+}
+
+// isChecksumsFileName returns whether the given asset is a list of checksums,
+// e.g. checksums.txt or SHA256SUMS.
+func isChecksumsFileName(name string) bool {
+	name = strings.ToLower(name)
+	return name == "sha256sums" || name == "sha256sums.txt" ||
+		strings.HasSuffix(name, "checksums.txt") || name == checksumsAssetName
+}
+
+// loadReleaseChecks downloads and parses the checksums files (e.g.
+// checksums.txt or <asset>.sha256) and the detached signatures (i.e.
+// <asset>.asc or <asset>.sig) of the given release assets. The signatures are
+// verified only if an armored GPG public key (ring) is given.
+func loadReleaseChecks(
+	ctx context.Context,
+	httpClient *http.Client,
+	assets []*releaseAsset,
+	githubToken string,
+	gpgPublicKey string,
+	log Logger,
+) (*releaseChecks, error) {
+
+	checks := &releaseChecks{
+		checksums:       make(map[string]string),
+		checksumsFiles:  make(map[string]string),
+		signatures:      make(map[string][]byte),
+		signaturesFiles: make(map[string]string),
+		results:         make(map[string]error),
+	}
+	if len(strings.TrimSpace(gpgPublicKey)) > 0 {
+		keyring, err := openpgp.ReadArmoredKeyRing(strings.NewReader(gpgPublicKey))
+		if err != nil {
+			return nil, fmt.Errorf("error reading the GPG public key: %v", err)
+		}
+		checks.keyring = keyring
+	}
+
+	names := make(map[string]bool, len(assets))
+	for _, asset := range assets {
+		names[asset.name] = true
+	}
+	for _, asset := range assets {
+		if len(asset.url) == 0 || asset.sourceArchive {
+			continue
+		}
+		ext := strings.ToLower(path.Ext(asset.name))
+		target := strings.TrimSuffix(asset.name, path.Ext(asset.name))
+		switch {
+		case isChecksumsFileName(asset.name):
+		case ext == ".sha256" || ext == ".sha256sum":
+			if !names[target] {
+				continue
+			}
+		case ext == ".asc" || ext == ".sig":
+			// the .sig files along with .pem ones are cosign signatures
+			if !names[target] || names[target+".pem"] {
+				continue
+			}
+			if checks.keyring == nil {
+				log.Warningf("WARNING: not verifying signature %s since no GPG public key is "+
+					"specified\n", asset.name)
+				continue
+			}
+		default:
+			continue
+		}
+
+		content, err := readCheckFile(ctx, httpClient, asset, githubToken)
+		if err != nil {
+			return nil, err
+		}
+		if ext == ".asc" || ext == ".sig" {
+			checks.signatures[target] = content
+			checks.signaturesFiles[target] = asset.name
+			continue
+		}
+		checksums, err := parseChecksums(content, target)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing checksums file %s: %v", asset.name, err)
+		}
+		for name, hash := range checksums {
+			if !names[name] {
+				continue
+			}
+			if previous, ok := checks.checksums[name]; ok && previous != hash {
+				return nil, fmt.Errorf(
+					"the checksums of asset %s in %s and %s do not match",
+					name, checks.checksumsFiles[name], asset.name)
+			}
+			checks.checksums[name] = hash
+			checks.checksumsFiles[name] = asset.name
+		}
+	}
+	log.Infof("Checking %d release assets against their published checksums and %d against "+
+		"their signatures before notarizing them\n", len(checks.checksums), len(checks.signatures))
+
+	return checks, nil
+}
+
+func readCheckFile(
+	ctx context.Context,
+	httpClient *http.Client,
+	asset *releaseAsset,
+	githubToken string,
+) ([]byte, error) {
+
+	body, err := github.OpenAsset(ctx, httpClient, asset.url, githubToken, false)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	content, err := io.ReadAll(io.LimitReader(body, maxCheckFileSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("error downloading asset %s: %v", asset.name, err)
+	}
+	if len(content) > maxCheckFileSize {
+		return nil, fmt.Errorf("asset %s is too large for a checksums or signature file",
+			asset.name)
+	}
+	return content, nil
+}
+
+// parseChecksums parses the lines of a checksums file in the sha256sum format,
+// i.e. "<hash>  <name>" (or "<hash> *<name>" for binary mode). A line with a
+// hash only is the checksum of the given default name (e.g. for
+// <asset>.sha256 files). The lines which are not SHA-256 checksums (e.g.
+// comments) are skipped, but a 64 characters hash which is not in lowercase
+// hex (as sha256sum prints them) is an error.
+func parseChecksums(content []byte, defaultName string) (map[string]string, error) {
+	checksums := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || len(fields[0]) != 64 {
+			continue
+		}
+		if !isLowercaseHex(fields[0]) {
+			return nil, fmt.Errorf("invalid SHA-256 checksum %q on line %d", fields[0], line)
+		}
+		name := defaultName
+		if len(fields) > 1 {
+			name = path.Base(strings.TrimPrefix(fields[1], "*"))
+		}
+		checksums[name] = fields[0]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return checksums, nil
+}
+
+// isLowercaseHex returns whether the given string is made of lowercase hex
+// digits only.
+func isLowercaseHex(s string) bool {
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// readAssetHead downloads the first bytes of the given asset (at most
+// sniffedHeadSize of them), which its content type is sniffed from.
+func readAssetHead(
+	ctx context.Context,
+	httpClient *http.Client,
+	asset *releaseAsset,
+	githubToken string,
+) ([]byte, error) {
+
+	body, err := github.OpenAsset(ctx, httpClient, asset.url, githubToken, false)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	head := make([]byte, sniffedHeadSize)
+	n, err := io.ReadFull(body, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("error downloading asset %s: %v", asset.name, err)
+	}
+	return head[:n], nil
+}
+
+// needsFile returns whether the given asset must be downloaded (even when
+// streaming the assets) to be checked, i.e. whether it has a signature.
+func (c *releaseChecks) needsFile(name string) bool {
+	_, ok := c.signatures[name]
+	return ok
+}
+
+// check checks the asset with the given SHA-256 hash (and file, if
+// downloaded) against its published checksum and signature, if any.
+func (c *releaseChecks) check(asset *releaseAsset, hash string, filePath string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err, ok := c.results[asset.name]; ok {
+		return err
+	}
+	err := c.checkAsset(asset, hash, filePath)
+	c.results[asset.name] = err
+	return err
+}
+
+func (c *releaseChecks) checkAsset(asset *releaseAsset, hash string, filePath string) error {
+	if expected, ok := c.checksums[asset.name]; ok && !strings.EqualFold(expected, hash) {
+		return fmt.Errorf("refusing to notarize asset %s: its SHA-256 hash %s does not match "+
+			"the checksum %s published in %s", asset.name, hash, expected, c.checksumsFiles[asset.name])
+	}
+
+	signature, ok := c.signatures[asset.name]
+	if !ok {
+		return nil
+	}
+	if len(filePath) == 0 {
+		return fmt.Errorf("asset %s must be downloaded for verifying its signature", asset.name)
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("error opening asset file %s: %v", filePath, err)
+	}
+	defer file.Close()
+	if bytes.HasPrefix(bytes.TrimSpace(signature), []byte("-----BEGIN")) {
+		_, err = openpgp.CheckArmoredDetachedSignature(c.keyring, file, bytes.NewReader(signature))
+	} else {
+		_, err = openpgp.CheckDetachedSignature(c.keyring, file, bytes.NewReader(signature))
+	}
+	if err != nil {
+		return fmt.Errorf("refusing to notarize asset %s: its signature %s cannot be verified: %v",
+			asset.name, c.signaturesFiles[asset.name], err)
+	}
+	return nil
+}
+
+// artifactsFromChecksums creates the artifacts of the release assets listed in
+// the given trusted checksums file (which can be excluded from the processed
+// assets) from their hashes, so that they do not need to be downloaded. Only
+// their first bytes are, for sniffing their content type, so that their
+// artifacts are the ones the vcn file extractor creates. The assets whose
+// artifact (see needsLocalFile), package or kind metadata, signature (GPG or
+// Authenticode) or stapled ticket need a local file are downloaded anyway.
+func artifactsFromChecksums(
+	ctx context.Context,
+	httpClient *http.Client,
+	releaseAssets []*github.ReleaseAsset,
+	assets []*releaseAsset,
+	checksumsFileName string,
+	githubToken string,
+	checks *releaseChecks,
+	authenticode *authenticodeVerifier,
+	stapling *staplingVerifier,
+	log Logger,
+) error {
+
+	var checksumsFile *releaseAsset
+	for _, asset := range releaseAssets {
+		if asset.Name == checksumsFileName {
+			checksumsFile = &releaseAsset{name: asset.Name, url: asset.URL}
+		}
+	}
+	if checksumsFile == nil {
+		return fmt.Errorf("checksums file %s not found in the release assets", checksumsFileName)
+	}
+	content, err := readCheckFile(ctx, httpClient, checksumsFile, githubToken)
+	if err != nil {
+		return err
+	}
+	checksums, err := parseChecksums(content, "")
+	if err != nil {
+		return fmt.Errorf("error parsing checksums file %s: %v", checksumsFileName, err)
+	}
+
+	var nbHashOnly int
+	for _, asset := range assets {
+		hash, ok := checksums[asset.name]
+		if !ok || len(asset.url) == 0 || asset.sourceArchive || asset.artifact != nil ||
+			packageNeedsLocalFile(asset.name) || (checks != nil && checks.needsFile(asset.name)) ||
+			(authenticode != nil && authenticode.needsFile(asset.name)) ||
+			(stapling != nil && stapling.needsFile(asset.name)) {
+			continue
+		}
+		head, err := readAssetHead(ctx, httpClient, asset, githubToken)
+		if err != nil {
+			return err
+		}
+		if needsLocalFile(head) {
+			continue
+		}
+		asset.artifact = &vcnAPI.Artifact{
+			Kind:        vcnFileExtractor.Scheme,
+			Name:        asset.name,
+			Hash:        hash,
+			Size:        uint64(asset.size),
+			ContentType: sniffContentType(head),
+			Metadata:    inferMetadata(asset.name),
+		}
+		nbHashOnly++
+	}
+	log.Infof("Using the hashes of %d release assets listed in %s instead of downloading them\n",
+		nbHashOnly, checksumsFileName)
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package notarize
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// progressInterval is the minimum interval between two progress reports of
+// the same download, so that the logs are not spammed.
+const progressInterval = 10 * time.Second
+
+// downloadCounter numbers the downloads (or streams) of the assets of a run,
+// for reporting them as e.g. asset 7/23.
+type downloadCounter struct {
+	total   int
+	started int32
+}
+
+func newDownloadCounter(total int) *downloadCounter {
+	return &downloadCounter{total: total}
+}
+
+// next returns the position of the next download, e.g. 7/23.
+func (c *downloadCounter) next() string {
+	if c == nil {
+		return ""
+	}
+	return fmt.Sprintf("%d/%d", atomic.AddInt32(&c.started, 1), c.total)
+}
+
+// positionPrefix returns the given position among the downloads (if any) as
+// a prefix of the asset names.
+func positionPrefix(position string) string {
+	if len(position) == 0 {
+		return ""
+	}
+	return position + " "
+}
+
+// downloadProgress reports the progress of the download of an asset, i.e. the
+// bytes downloaded so far, the transfer rate and the estimated remaining time,
+// at most every progressInterval.
+type downloadProgress struct {
+	name     string
+	position string
+	log      Logger
+
+	started    time.Time
+	lastReport time.Time
+	finished   bool
+}
+
+func newDownloadProgress(name string, position string, log Logger) *downloadProgress {
+	now := time.Now()
+	return &downloadProgress{
+		name:       name,
+		position:   positionPrefix(position),
+		log:        log,
+		started:    now,
+		lastReport: now,
+	}
+}
+
+// update reports the given number of bytes downloaded so far of the given
+// size (-1 if unknown), unless the last report is too recent.
+func (p *downloadProgress) update(downloaded int64, size int64) {
+	now := time.Now()
+	if now.Sub(p.lastReport) < progressInterval {
+		return
+	}
+	p.lastReport = now
+	elapsed := now.Sub(p.started)
+	rate := float64(downloaded) / elapsed.Seconds()
+	if size <= 0 {
+		p.log.Infof("Asset %s%s: %s downloaded (%s/s)\n",
+			p.position, p.name, humanize.Bytes(uint64(downloaded)), humanize.Bytes(uint64(rate)))
+		return
+	}
+	eta := "unknown"
+	if rate > 0 {
+		eta = time.Duration(float64(size-downloaded) / rate * float64(time.Second)).Round(time.Second).String()
+	}
+	p.log.Infof("Asset %s%s: %s / %s downloaded (%d%%, %s/s, ETA %s)\n",
+		p.position, p.name, humanize.Bytes(uint64(downloaded)), humanize.Bytes(uint64(size)),
+		downloaded*100/size, humanize.Bytes(uint64(rate)), eta)
+}
+
+// done reports the completion of a download whose progress was reported.
+func (p *downloadProgress) done(downloaded int64) {
+	if p.finished || p.lastReport == p.started {
+		return
+	}
+	p.finished = true
+	elapsed := time.Since(p.started)
+	p.log.Infof("Asset %s%s: %s downloaded in %s (%s/s)\n",
+		p.position, p.name, humanize.Bytes(uint64(downloaded)),
+		elapsed.Round(time.Second), humanize.Bytes(uint64(float64(downloaded)/elapsed.Seconds())))
+}
@@ -0,0 +1,150 @@
+package notarize
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	immustore "github.com/codenotary/immudb/embedded/store"
+	immuschema "github.com/codenotary/immudb/pkg/api/schema"
+	immudatabase "github.com/codenotary/immudb/pkg/database"
+	"github.com/golang/protobuf/ptypes/empty"
+	vcnAPI "github.com/vchain-us/vcn/pkg/api"
+	vcnMeta "github.com/vchain-us/vcn/pkg/meta"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Proof is the cryptographic proof of a ledger entry, for auditors to
+// independently re-verify it later on. The verifiable entry holds the entry
+// along with its inclusion proof into its transaction and the dual proof of
+// the consistency of that transaction with the given ledger state, both in
+// the protobuf JSON format of immudb (i.e. schema.VerifiableEntry and
+// schema.ImmutableState).
+type Proof struct {
+	Key             []byte          `json:"key"`
+	VerifiableEntry json.RawMessage `json:"verifiable_entry"`
+	State           json.RawMessage `json:"state"`
+}
+
+// Prover is implemented by the notarizers able to prove their ledger
+// entries.
+type Prover interface {
+	// Prove returns the proof of the ledger entry of the given artifact
+	// against the current ledger state.
+	Prove(ctx context.Context, artifact *vcnAPI.Artifact) (*Proof, error)
+}
+
+// newProof checks the given verifiable entry of the given key against the
+// given ledger state, returning their proof.
+func newProof(
+	key []byte,
+	state *immuschema.ImmutableState,
+	vEntry *immuschema.VerifiableEntry,
+) (*Proof, error) {
+
+	if vEntry.GetEntry() == nil || vEntry.GetVerifiableTx().GetDualProof() == nil ||
+		vEntry.GetInclusionProof() == nil {
+		return nil, errors.New("incomplete verifiable entry")
+	}
+	if vEntry.Entry.ReferencedBy != nil {
+		return nil, errors.New("unexpected reference entry")
+	}
+	inclusionProof := immuschema.InclusionProofFrom(vEntry.InclusionProof)
+	dualProof := immuschema.DualProofFrom(vEntry.VerifiableTx.DualProof)
+
+	// the state is read first, so the entry is normally in an older transaction
+	vTx := vEntry.Entry.Tx
+	var eh [sha256.Size]byte
+	var sourceID, targetID uint64
+	var sourceAlh, targetAlh [sha256.Size]byte
+	if state.TxId <= vTx {
+		eh = immuschema.DigestFrom(vEntry.VerifiableTx.DualProof.TargetTxMetadata.EH)
+		sourceID = state.TxId
+		sourceAlh = immuschema.DigestFrom(state.TxHash)
+		targetID = vTx
+		targetAlh = dualProof.TargetTxMetadata.Alh()
+	} else {
+		eh = immuschema.DigestFrom(vEntry.VerifiableTx.DualProof.SourceTxMetadata.EH)
+		sourceID = vTx
+		sourceAlh = dualProof.SourceTxMetadata.Alh()
+		targetID = state.TxId
+		targetAlh = immuschema.DigestFrom(state.TxHash)
+	}
+	kv := immudatabase.EncodeKV(key, vEntry.Entry.Value)
+	if !immustore.VerifyInclusion(inclusionProof, kv, eh) {
+		return nil, fmt.Errorf("ledger might be compromised: %v", immustore.ErrCorruptedData)
+	}
+	if state.TxId > 0 &&
+		!immustore.VerifyDualProof(dualProof, sourceID, targetID, sourceAlh, targetAlh) {
+		return nil, fmt.Errorf("ledger might be compromised: %v", immustore.ErrCorruptedData)
+	}
+
+	vEntryJSON, err := protojson.Marshal(vEntry)
+	if err != nil {
+		return nil, fmt.Errorf("error JSON-marshaling the verifiable entry: %v", err)
+	}
+	stateJSON, err := protojson.Marshal(state)
+	if err != nil {
+		return nil, fmt.Errorf("error JSON-marshaling the ledger state: %v", err)
+	}
+	return &Proof{Key: key, VerifiableEntry: vEntryJSON, State: stateJSON}, nil
+}
+
+// Prove reads the CNIL state and the verifiable entry of the given artifact
+// straight from the ledger, bypassing the state of the vcn client.
+func (n *cnilNotarizer) Prove(ctx context.Context, artifact *vcnAPI.Artifact) (*Proof, error) {
+	md := metadata.Pairs(vcnMeta.VcnLCPluginTypeHeaderName, vcnMeta.VcnLCPluginTypeHeaderValue)
+	ctx = metadata.NewOutgoingContext(ctx, md)
+	var proof *Proof
+	err := n.withFailover(func(vcnUser *vcnAPI.LcUser) error {
+		client := vcnUser.Client
+		state, err := client.ServiceClient.CurrentState(ctx, &empty.Empty{})
+		if err != nil {
+			return fmt.Errorf("error reading the CNIL state: %w", err)
+		}
+		signerID := vcnAPI.GetSignerIDByApiKey(client.ApiKey)
+		key := vcnAPI.AppendSignerId(artifact.Hash, vcnAPI.AppendPrefix(vcnMeta.VcnPrefix, []byte(signerID)))
+		item, err := client.ServiceClient.VerifiableGetExt(ctx, &immuschema.VerifiableGetRequest{
+			KeyRequest:   &immuschema.KeyRequest{Key: key},
+			ProveSinceTx: state.TxId,
+		})
+		if err != nil {
+			return fmt.Errorf("error reading the proof of artifact %s: %w", artifact.Name, err)
+		}
+		proof, err = newProof(key, state, item.Item)
+		if err != nil {
+			return fmt.Errorf("error proving artifact %s: %v", artifact.Name, err)
+		}
+		return nil
+	})
+	return proof, err
+}
+
+// Prove reads the immudb state and the verifiable entry of the given artifact
+// straight from the database, bypassing the state of the client.
+func (n *immudbNotarizer) Prove(ctx context.Context, artifact *vcnAPI.Artifact) (*Proof, error) {
+	n.client.mu.Lock()
+	defer n.client.mu.Unlock()
+	authorizedCtx := n.client.authorized(ctx)
+	state, err := n.client.client.CurrentState(authorizedCtx)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the immudb state: %v", err)
+	}
+	key := n.key(artifact.Hash)
+	vEntry, err := n.client.client.GetServiceClient().VerifiableGet(authorizedCtx,
+		&immuschema.VerifiableGetRequest{
+			KeyRequest:   &immuschema.KeyRequest{Key: key},
+			ProveSinceTx: state.TxId,
+		})
+	if err != nil {
+		return nil, fmt.Errorf("error reading the proof of artifact %s: %v", artifact.Name, err)
+	}
+	proof, err := newProof(key, state, vEntry)
+	if err != nil {
+		return nil, fmt.Errorf("error proving artifact %s: %v", artifact.Name, err)
+	}
+	return proof, nil
+}
@@ -0,0 +1,100 @@
+package notarize
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// proofFileSuffix is the suffix of the names of the proof files of the
+// assets, i.e. <asset>.vcn.json.
+const proofFileSuffix = ".vcn.json"
+
+// ProofFile is the content of the proof file of a notarized asset, uploaded
+// next to it in the release for its consumers to verify it offline against
+// the ledger later on.
+type ProofFile struct {
+	Asset      string `json:"asset"`
+	Hash       string `json:"hash"`
+	Size       uint64 `json:"size,omitempty"`
+	Repository string `json:"repository,omitempty"`
+	ReleaseTag string `json:"release_tag,omitempty"`
+	// Entries are the ledger entries of the asset, i.e. one per ledger it is
+	// notarized into
+	Entries []ProofEntry `json:"entries"`
+}
+
+// ProofEntry is a ledger entry of an asset, along with its inclusion proof if
+// the proofs are exported (see Config.ExportProofs).
+type ProofEntry struct {
+	SignerID  string    `json:"signer_id"`
+	LedgerID  string    `json:"ledger_id,omitempty"`
+	Status    string    `json:"status"`
+	Timestamp time.Time `json:"timestamp"`
+	TxID      uint64    `json:"tx_id,omitempty"`
+	UID       string    `json:"uid,omitempty"`
+	Proof     *Proof    `json:"proof,omitempty"`
+}
+
+// writeProofFiles writes the proof files of the notarized file assets into
+// the given dir and returns their paths.
+func writeProofFiles(dir string, report *Report) ([]string, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("error creating proof files dir %s: %v", dir, err)
+	}
+
+	// the assets notarized into multiple ledgers get a single proof file
+	var proofFiles []*ProofFile
+	proofFilesByName := make(map[string]*ProofFile)
+	for _, assetReport := range report.Assets {
+		// the variants notarized only through their platform manifest have no
+		// ledger entry
+		if !isFileKind(assetReport.Kind) || len(assetReport.Error) > 0 || len(assetReport.Hash) == 0 ||
+			len(assetReport.Status) == 0 {
+			continue
+		}
+		proofFile := proofFilesByName[assetReport.Name]
+		if proofFile == nil {
+			proofFile = &ProofFile{
+				Asset:      assetReport.Name,
+				Hash:       assetReport.Hash,
+				Size:       assetReport.Size,
+				Repository: report.Repository,
+				ReleaseTag: report.ReleaseTag,
+			}
+			proofFilesByName[assetReport.Name] = proofFile
+			proofFiles = append(proofFiles, proofFile)
+		}
+		ledgerID := assetReport.LedgerID
+		if len(ledgerID) == 0 {
+			ledgerID = report.LedgerID
+		}
+		proofFile.Entries = append(proofFile.Entries, ProofEntry{
+			SignerID:  assetReport.SignerID,
+			LedgerID:  ledgerID,
+			Status:    assetReport.Status,
+			Timestamp: assetReport.Timestamp,
+			TxID:      assetReport.TxID,
+			UID:       assetReport.UID,
+			Proof:     assetReport.Proof,
+		})
+	}
+
+	var filePaths []string
+	for _, proofFile := range proofFiles {
+		content, err := json.MarshalIndent(proofFile, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("error JSON-marshaling the proof file of asset %s: %v",
+				proofFile.Asset, err)
+		}
+		filePath := filepath.Join(dir, proofFile.Asset+proofFileSuffix)
+		if err := os.WriteFile(filePath, append(content, '\n'), 0644); err != nil {
+			return nil, fmt.Errorf("error writing proof file %s: %v", filePath, err)
+		}
+		filePaths = append(filePaths, filePath)
+	}
+
+	return filePaths, nil
+}
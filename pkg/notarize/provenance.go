@@ -0,0 +1,173 @@
+package notarize
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ProvenanceOptions are the settings of the in-toto / SLSA v1 provenance
+// statements generated for the notarized assets.
+type ProvenanceOptions struct {
+	// Dir is the directory the statements are written to, one
+	// <asset name>.intoto.json file per asset. If empty, the statements are
+	// not written (e.g. when only used for the GitHub attestations).
+	Dir string
+
+	// Upload specifies to upload the statements to the release.
+	Upload bool
+
+	// ServerURL is the URL of the GitHub instance (e.g. https://github.com).
+	ServerURL string
+
+	// WorkflowRef is the ref of the workflow which runs the notarization, i.e.
+	// <owner>/<repo-name>/<workflow path>@<ref> (see GITHUB_WORKFLOW_REF).
+	WorkflowRef string
+
+	// InvocationID identifies the workflow run (attempt), e.g. its URL.
+	InvocationID string
+}
+
+// InTotoStatement is an in-toto v1 attestation statement.
+type InTotoStatement struct {
+	Type          string             `json:"_type"`
+	Subject       []InTotoSubject    `json:"subject"`
+	PredicateType string             `json:"predicateType"`
+	Predicate     SLSAProvenancePred `json:"predicate"`
+}
+
+type InTotoSubject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+// SLSAProvenancePred is a SLSA v1 provenance predicate.
+type SLSAProvenancePred struct {
+	BuildDefinition SLSABuildDefinition `json:"buildDefinition"`
+	RunDetails      SLSARunDetails      `json:"runDetails"`
+}
+
+type SLSABuildDefinition struct {
+	BuildType            string                 `json:"buildType"`
+	ExternalParameters   map[string]interface{} `json:"externalParameters"`
+	InternalParameters   map[string]interface{} `json:"internalParameters,omitempty"`
+	ResolvedDependencies []SLSAResourceDesc     `json:"resolvedDependencies"`
+}
+
+type SLSAResourceDesc struct {
+	URI    string            `json:"uri"`
+	Digest map[string]string `json:"digest"`
+}
+
+type SLSARunDetails struct {
+	Builder  SLSABuilder  `json:"builder"`
+	Metadata SLSAMetadata `json:"metadata"`
+}
+
+type SLSABuilder struct {
+	ID string `json:"id"`
+}
+
+type SLSAMetadata struct {
+	InvocationID string    `json:"invocationId,omitempty"`
+	StartedOn    time.Time `json:"startedOn"`
+	FinishedOn   time.Time `json:"finishedOn"`
+}
+
+// provenanceStatement returns the provenance statement of the given
+// notarized asset: the builder is the GitHub workflow and the source is the
+// commit the release tag points to.
+func provenanceStatement(
+	options *ProvenanceOptions,
+	report *Report,
+	assetReport *AssetReport,
+	tagCommitSHA string,
+) *InTotoStatement {
+
+	serverURL := strings.TrimSuffix(options.ServerURL, "/")
+	ledgerID := assetReport.LedgerID
+	if len(ledgerID) == 0 {
+		ledgerID = report.LedgerID
+	}
+	workflow := map[string]interface{}{}
+	if pieces := strings.SplitN(options.WorkflowRef, "@", 2); len(pieces) == 2 {
+		workflow["ref"] = pieces[1]
+		if i := strings.Index(pieces[0], "/.github/"); i >= 0 {
+			workflow["repository"] = serverURL + "/" + pieces[0][:i]
+			workflow["path"] = pieces[0][i+1:]
+		}
+	}
+
+	return &InTotoStatement{
+		Type: "https://in-toto.io/Statement/v1",
+		Subject: []InTotoSubject{{
+			Name:   assetReport.Name,
+			Digest: assetReport.digestSet(),
+		}},
+		PredicateType: "https://slsa.dev/provenance/v1",
+		Predicate: SLSAProvenancePred{
+			BuildDefinition: SLSABuildDefinition{
+				BuildType:          "https://actions.github.io/buildtypes/workflow/v1",
+				ExternalParameters: map[string]interface{}{"workflow": workflow},
+				InternalParameters: map[string]interface{}{
+					"ledger":    ledgerID,
+					"signer_id": assetReport.SignerID,
+					"status":    assetReport.Status,
+				},
+				ResolvedDependencies: []SLSAResourceDesc{{
+					URI: fmt.Sprintf("git+%s/%s@refs/tags/%s",
+						serverURL, report.Repository, report.ReleaseTag),
+					Digest: map[string]string{"gitCommit": tagCommitSHA},
+				}},
+			},
+			RunDetails: SLSARunDetails{
+				Builder: SLSABuilder{ID: serverURL + "/" + options.WorkflowRef},
+				Metadata: SLSAMetadata{
+					InvocationID: options.InvocationID,
+					StartedOn:    report.StartedAt,
+					FinishedOn:   assetReport.Timestamp,
+				},
+			},
+		},
+	}
+}
+
+// writeProvenance writes the provenance statements of the notarized file
+// assets into the provenance dir and returns their paths.
+func writeProvenance(
+	options *ProvenanceOptions,
+	report *Report,
+	tagCommitSHA string,
+) ([]string, error) {
+
+	if err := os.MkdirAll(options.Dir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("error creating provenance dir %s: %v", options.Dir, err)
+	}
+
+	var filePaths []string
+	written := make(map[string]bool)
+	for _, assetReport := range report.Assets {
+		// the assets notarized into multiple ledgers get a single statement
+		if !isFileKind(assetReport.Kind) || len(assetReport.Error) > 0 ||
+			written[assetReport.Name] {
+			continue
+		}
+		written[assetReport.Name] = true
+		statement := provenanceStatement(options, report, assetReport, tagCommitSHA)
+		content, err := json.MarshalIndent(statement, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("error JSON-marshaling the provenance of asset %s: %v",
+				assetReport.Name, err)
+		}
+		filePath := filepath.Join(options.Dir, assetReport.Name+".intoto.json")
+		if err := os.WriteFile(filePath, content, 0644); err != nil {
+			return nil, fmt.Errorf("error writing provenance file %s: %v", filePath, err)
+		}
+		filePaths = append(filePaths, filePath)
+	}
+
+	return filePaths, nil
+}
@@ -0,0 +1,134 @@
+package notarize
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// proxyFunc returns the proxy to use for each URL: the given proxy URL,
+// except for the hosts excluded by the NO_PROXY environment variable.
+type proxyFunc func(*url.URL) (*url.URL, error)
+
+func newProxyFunc(proxyURL string) (proxyFunc, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil || len(u.Host) == 0 || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid proxy URL %s: must be an http:// or https:// URL", proxyURL)
+	}
+	noProxy := os.Getenv("NO_PROXY")
+	if len(noProxy) == 0 {
+		noProxy = os.Getenv("no_proxy")
+	}
+	config := &httpproxy.Config{HTTPProxy: proxyURL, HTTPSProxy: proxyURL, NoProxy: noProxy}
+	return config.ProxyFunc(), nil
+}
+
+// withProxy makes the given HTTP client use the given proxy, instead of the
+// one of the HTTP_PROXY and HTTPS_PROXY environment variables.
+func withProxy(httpClient *http.Client, proxy proxyFunc) error {
+	transport := httpClient.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	httpTransport, ok := transport.(*http.Transport)
+	if !ok {
+		return errors.New("the proxy URL requires an HTTP transport")
+	}
+	httpTransport = httpTransport.Clone()
+	httpTransport.Proxy = func(req *http.Request) (*url.URL, error) {
+		return proxy(req.URL)
+	}
+	httpClient.Transport = httpTransport
+	return nil
+}
+
+// proxyDialer returns the gRPC dialer tunneling the connections through the
+// given proxy with HTTP CONNECT requests.
+func proxyDialer(proxy proxyFunc) func(context.Context, string) (net.Conn, error) {
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		var dialer net.Dialer
+		proxyURL, err := proxy(&url.URL{Scheme: "https", Host: addr})
+		if err != nil {
+			return nil, err
+		}
+		if proxyURL == nil {
+			return dialer.DialContext(ctx, "tcp", addr)
+		}
+
+		proxyAddr := proxyURL.Host
+		if len(proxyURL.Port()) == 0 {
+			if proxyURL.Scheme == "https" {
+				proxyAddr = net.JoinHostPort(proxyURL.Hostname(), "443")
+			} else {
+				proxyAddr = net.JoinHostPort(proxyURL.Hostname(), "80")
+			}
+		}
+		conn, err := dialer.DialContext(ctx, "tcp", proxyAddr)
+		if err != nil {
+			return nil, fmt.Errorf("error connecting to proxy %s: %v", proxyURL.Redacted(), err)
+		}
+		if deadline, ok := ctx.Deadline(); ok {
+			conn.SetDeadline(deadline)
+			defer conn.SetDeadline(time.Time{})
+		}
+		if proxyURL.Scheme == "https" {
+			tlsConn := tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname()})
+			if err := tlsConn.Handshake(); err != nil {
+				conn.Close()
+				return nil, fmt.Errorf("error connecting to proxy %s: %v", proxyURL.Redacted(), err)
+			}
+			conn = tlsConn
+		}
+
+		req := &http.Request{
+			Method: "CONNECT",
+			URL:    &url.URL{Opaque: addr},
+			Host:   addr,
+			Header: make(http.Header),
+		}
+		if user := proxyURL.User; user != nil {
+			password, _ := user.Password()
+			credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+			req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+		}
+		if err := req.Write(conn); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("error sending CONNECT %s request to proxy %s: %v",
+				addr, proxyURL.Redacted(), err)
+		}
+		reader := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(reader, req)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("error reading CONNECT %s response of proxy %s: %v",
+				addr, proxyURL.Redacted(), err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			conn.Close()
+			return nil, fmt.Errorf("proxy %s refused CONNECT %s: %s",
+				proxyURL.Redacted(), addr, resp.Status)
+		}
+		// the bytes the proxy might have sent after its response are kept
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+}
+
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
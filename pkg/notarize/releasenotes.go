@@ -0,0 +1,81 @@
+package notarize
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The markers delimiting the verification instructions section of the
+// release notes, which is replaced at each run.
+const (
+	releaseNotesBeginMarker = "<!-- notarize-release-assets: verification begin -->"
+	releaseNotesEndMarker   = "<!-- notarize-release-assets: verification end -->"
+)
+
+// releaseNotesSection returns the Markdown section of the release notes
+// listing the hashes, signer IDs and statuses of the notarized assets, along
+// with the vcn commands authenticating them against the given CNIL instance,
+// if any.
+func releaseNotesSection(report *Report, cnilHost string, cnilPort string) string {
+	var md strings.Builder
+	md.WriteString(releaseNotesBeginMarker + "\n")
+	md.WriteString("### Verify the downloads\n\n")
+	md.WriteString("The assets of this release are notarized with [CodeNotary](https://codenotary.com):\n\n")
+	md.WriteString("| Asset | Hash | Signer ID | Status |\n")
+	md.WriteString("| --- | --- | --- | --- |\n")
+
+	type signer struct{ signerID, ledgerID string }
+	var signers []signer
+	seen := make(map[signer]bool)
+	for _, asset := range report.Assets {
+		if len(asset.Error) > 0 {
+			continue
+		}
+		fmt.Fprintf(&md, "| %s | `%s` | %s | %s |\n",
+			markdownCell(asset.Name), asset.Hash, markdownCell(asset.SignerID),
+			markdownCell(asset.Status))
+		s := signer{signerID: asset.SignerID, ledgerID: asset.LedgerID}
+		if !seen[s] {
+			seen[s] = true
+			signers = append(signers, s)
+		}
+	}
+
+	if len(cnilHost) == 0 {
+		md.WriteString(releaseNotesEndMarker)
+		return md.String()
+	}
+	md.WriteString("\nTo authenticate a downloaded asset with [vcn](https://github.com/vchain-us/vcn), " +
+		"using an API key of the ledger in the `VCN_LC_API_KEY` environment variable:\n\n")
+	md.WriteString("```sh\n")
+	for _, s := range signers {
+		fmt.Fprintf(&md, "vcn authenticate --lc-host %s --lc-port %s", cnilHost, cnilPort)
+		if len(s.ledgerID) > 0 {
+			fmt.Fprintf(&md, " --lc-ledger %s", s.ledgerID)
+		}
+		fmt.Fprintf(&md, " --signerID %s <downloaded asset>\n", s.signerID)
+	}
+	md.WriteString("```\n")
+	md.WriteString(releaseNotesEndMarker)
+	return md.String()
+}
+
+// withReleaseNotesSection returns the given release notes with the given
+// section replacing the one of a previous run, if any, or appended.
+func withReleaseNotesSection(body string, section string) string {
+	begin := strings.Index(body, releaseNotesBeginMarker)
+	end := strings.Index(body, releaseNotesEndMarker)
+	if begin >= 0 && end > begin {
+		return body[:begin] + section + body[end+len(releaseNotesEndMarker):]
+	}
+	body = strings.TrimRight(body, "\r\n \t")
+	if len(body) == 0 {
+		return section + "\n"
+	}
+	return body + "\n\n" + section + "\n"
+}
+
+// markdownCell escapes the value of a Markdown table cell.
+func markdownCell(value string) string {
+	return strings.NewReplacer("|", "\\|", "\n", " ").Replace(value)
+}
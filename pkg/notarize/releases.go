@@ -0,0 +1,318 @@
+package notarize
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/codenotary/notarize-release-assets-action/pkg/github"
+)
+
+// allReleasesSince prefixes the tag of the oldest release of a releases list
+// selecting all the releases of a repository created since that one.
+const allReleasesSince = "all-releases-since:"
+
+// ResolveReleaseURLs returns the GitHub API URLs of the given releases of the
+// given repository (i.e. <owner>/<repo-name>), which are either a list of
+// release API URLs and/or tags separated by commas or new lines, or
+// "all-releases-since: <tag>" for all the (non-draft) releases created since
+// the one of the given tag (included), the oldest first.
+func ResolveReleaseURLs(
+	ctx context.Context,
+	cfg *Config,
+	repository string,
+	releases string,
+) ([]string, error) {
+
+	if trimmed := strings.TrimSpace(releases); strings.HasPrefix(trimmed, allReleasesSince) {
+		sinceTag := strings.TrimSpace(strings.TrimPrefix(trimmed, allReleasesSince))
+		return releaseURLsSince(ctx, cfg, repository, sinceTag)
+	}
+
+	var releaseURLs []string
+	for _, release := range strings.FieldsFunc(releases, func(r rune) bool {
+		return r == ',' || r == '\n'
+	}) {
+		release = strings.TrimSpace(release)
+		switch {
+		case len(release) == 0:
+			continue
+		case strings.HasPrefix(release, "https://") || strings.HasPrefix(release, "http://"):
+			releaseURLs = append(releaseURLs, release)
+		case len(repository) == 0:
+			return nil, classify(ErrInvalidConfig, fmt.Errorf(
+				"the repository is required for specifying the release %s by its tag", release))
+		default:
+			releaseURLs = append(releaseURLs,
+				github.ReleaseURLFromTag(cfg.GitHubAPIURL, repository, release))
+		}
+	}
+	if len(releaseURLs) == 0 {
+		return nil, classify(ErrInvalidConfig, errors.New("no release specified"))
+	}
+	return releaseURLs, nil
+}
+
+// ParseRepositories parses the repositories (i.e. <owner>/<repo-name>)
+// separated by commas or new lines.
+func ParseRepositories(repositories string) ([]string, error) {
+	var parsed []string
+	unique := make(map[string]bool)
+	for _, repository := range strings.FieldsFunc(repositories, func(r rune) bool {
+		return r == ',' || r == '\n'
+	}) {
+		repository = strings.TrimSpace(repository)
+		if len(repository) == 0 {
+			continue
+		}
+		parts := strings.Split(repository, "/")
+		if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+			return nil, fmt.Errorf("invalid repository %s: must be of the form <owner>/<repo-name>",
+				repository)
+		}
+		if key := strings.ToLower(repository); !unique[key] {
+			unique[key] = true
+			parsed = append(parsed, repository)
+		}
+	}
+	return parsed, nil
+}
+
+// ResolveRepositoriesReleaseURLs returns the GitHub API URLs of the releases of
+// each of the given repositories in turn, e.g. for notarizing the releases of
+// many product repositories from a central one: its latest release if latest
+// is set, its release of the given tag, if any, or else its given releases
+// (see ResolveReleaseURLs).
+func ResolveRepositoriesReleaseURLs(
+	ctx context.Context,
+	cfg *Config,
+	repositories []string,
+	latest bool,
+	tag string,
+	releases string,
+) ([]string, error) {
+
+	var releaseURLs []string
+	for _, repository := range repositories {
+		switch {
+		case latest:
+			releaseURLs = append(releaseURLs, github.LatestReleaseURL(cfg.GitHubAPIURL, repository))
+		case len(tag) > 0:
+			releaseURLs = append(releaseURLs, github.ReleaseURLFromTag(cfg.GitHubAPIURL, repository, tag))
+		case len(releases) > 0:
+			repositoryReleaseURLs, err := ResolveReleaseURLs(ctx, cfg, repository, releases)
+			if err != nil {
+				return nil, fmt.Errorf("error resolving the releases of repository %s: %w", repository, err)
+			}
+			releaseURLs = append(releaseURLs, repositoryReleaseURLs...)
+		default:
+			return nil, classify(ErrInvalidConfig, errors.New(
+				"either the latest release, the release tag or the releases are required"))
+		}
+	}
+	return releaseURLs, nil
+}
+
+// releaseURLsSince returns the API URLs of the non-draft releases of the
+// given repository created since the one of the given tag (included), the
+// oldest first.
+func releaseURLsSince(
+	ctx context.Context,
+	cfg *Config,
+	repository string,
+	sinceTag string,
+) ([]string, error) {
+
+	if len(sinceTag) == 0 {
+		return nil, classify(ErrInvalidConfig, fmt.Errorf("a tag is required after %s", allReleasesSince))
+	}
+	if len(repository) == 0 {
+		return nil, classify(ErrInvalidConfig, fmt.Errorf(
+			"the repository is required for listing all its releases since %s", sinceTag))
+	}
+	httpClient, _, _, err := newHTTPClients(cfg)
+	if err != nil {
+		return nil, err
+	}
+	releases, err := github.ListReleases(
+		ctx, httpClient, cfg.GitHubAPIURL, repository, cfg.GitHubToken)
+	if err != nil {
+		return nil, classify(ErrGitHubAPI, err)
+	}
+
+	var since *github.ReleaseSummary
+	for _, release := range releases {
+		if release.TagName == strings.TrimPrefix(sinceTag, "refs/tags/") && !release.Draft {
+			since = release
+			break
+		}
+	}
+	if since == nil {
+		return nil, classify(ErrInvalidConfig,
+			fmt.Errorf("no release of repository %s with tag %s", repository, sinceTag))
+	}
+	var releaseURLs []string
+	// the releases are listed the most recently created first
+	for i := len(releases) - 1; i >= 0; i-- {
+		if !releases[i].Draft && !releases[i].CreatedAt.Before(since.CreatedAt) {
+			releaseURLs = append(releaseURLs, releases[i].URL)
+		}
+	}
+	return releaseURLs, nil
+}
+
+// ProcessReleases runs the given operation (i.e. NotarizeRelease,
+// VerifyRelease, ReverifyRelease or UntrustRelease) on each of the given releases in turn (or
+// on several ones at the same time, see Config.MaxParallelReleases), e.g.
+// for backfilling the historical releases of a repository, and returns the
+// combined report of all of them. The failure of a release does not prevent
+// processing the next ones, unless the deadline has been hit, and a summary
+// per release is logged once done.
+func ProcessReleases(
+	ctx context.Context,
+	cfg *Config,
+	releaseURLs []string,
+	process func(context.Context, *Config) (*Report, error),
+) (report *Report, err error) {
+
+	report = &Report{
+		Repository: cfg.Repository,
+		StartedAt:  time.Now().UTC(),
+	}
+	// the assets of the releases of several repositories are reported along
+	// with their repository
+	multiRepository := false
+	if len(releaseURLs) > 0 {
+		if repository := github.RepositoryFromReleaseURL(releaseURLs[0]); len(repository) > 0 {
+			report.Repository = repository
+		}
+		for _, releaseURL := range releaseURLs[1:] {
+			if !strings.EqualFold(github.RepositoryFromReleaseURL(releaseURL), report.Repository) {
+				multiRepository = true
+				report.Repository = ""
+				break
+			}
+		}
+	}
+	defer func() {
+		report.FinishedAt = time.Now().UTC()
+		report.Success = err == nil
+		if err != nil {
+			report.Error = err.Error()
+		}
+	}()
+
+	if len(cfg.Paths) > 0 || len(cfg.Images) > 0 {
+		return report, errors.New(
+			"the local paths and the images cannot be processed along with multiple releases")
+	}
+	log := cfg.Logger
+	if log == nil {
+		log = nopLogger{}
+	}
+
+	releasesReports := make([]*Report, len(releaseURLs))
+	releasesErrs := forEachParallel(len(releaseURLs), cfg.MaxParallelReleases, false, func(i int) error {
+		// do not start processing the release if the deadline has been hit
+		if err := ctx.Err(); err != nil {
+			return nil
+		}
+		log.Infof("\nRelease %d of %d: %s\n", i+1, len(releaseURLs), releaseURLs[i])
+		releaseCfg := *cfg
+		releaseCfg.ReleaseURL = releaseURLs[i]
+		if repository := github.RepositoryFromReleaseURL(releaseURLs[i]); len(repository) > 0 {
+			releaseCfg.Repository = repository
+		}
+		var err error
+		releasesReports[i], err = process(ctx, &releaseCfg)
+		return err
+	})
+
+	var failures []string
+	var firstErr error
+	var nbVerificationFailures, nbDrifts int
+	for i, releaseReport := range releasesReports {
+		if releaseReport == nil {
+			continue
+		}
+		if len(report.Operation) == 0 {
+			report.Operation = releaseReport.Operation
+		}
+		report.Releases = append(report.Releases, releaseReport)
+		for _, assetReport := range releaseReport.Assets {
+			combined := *assetReport
+			combined.ReleaseTag = releaseReport.ReleaseTag
+			if multiRepository {
+				combined.Repository = releaseReport.Repository
+			}
+			report.Assets = append(report.Assets, &combined)
+		}
+		if err := releasesErrs[i]; err != nil {
+			log.Errorf("Release %s failed: %v\n", releaseName(releaseReport, multiRepository), err)
+			failures = append(failures, fmt.Sprintf("%s: %v", releaseName(releaseReport, multiRepository), err))
+			if firstErr == nil {
+				firstErr = err
+			}
+			if errors.Is(err, ErrVerificationFailed) {
+				nbVerificationFailures++
+			}
+			if errors.Is(err, ErrDriftDetected) {
+				nbDrifts++
+			}
+		}
+	}
+	logReleasesSummary(report.Releases, multiRepository, log)
+
+	// abort with the results of the releases processed so far
+	if err := ctx.Err(); err != nil {
+		return report, fmt.Errorf("%w: only %d of the %d releases have been processed",
+			err, len(report.Releases), len(releaseURLs))
+	}
+	if len(failures) == 0 {
+		return report, nil
+	}
+	msg := fmt.Sprintf("%d of the %d releases failed: %s",
+		len(failures), len(releaseURLs), strings.Join(failures, "; "))
+	if nbDrifts > 0 {
+		return report, fmt.Errorf("%w: %s", ErrDriftDetected, msg)
+	}
+	if nbVerificationFailures == len(failures) {
+		return report, fmt.Errorf("%w: %s", ErrVerificationFailed, msg)
+	}
+	return report, classify(classOf(firstErr), errors.New(msg))
+}
+
+// releaseName returns the tag of the release of the given report (prefixed
+// with its repository if requested), or its URL if unknown (e.g. when the
+// release could not be fetched).
+func releaseName(report *Report, withRepository bool) string {
+	if len(report.ReleaseTag) == 0 {
+		return report.ReleaseURL
+	}
+	if withRepository && len(report.Repository) > 0 {
+		return report.Repository + "@" + report.ReleaseTag
+	}
+	return report.ReleaseTag
+}
+
+// logReleasesSummary logs the number of assets processed and the outcome of
+// each release.
+func logReleasesSummary(reports []*Report, multiRepository bool, log Logger) {
+	if len(reports) == 0 {
+		return
+	}
+	log.Infof("\nReleases summary:\n")
+	for _, report := range reports {
+		line := fmt.Sprintf("  %-24s %4d assets  %s", releaseName(report, multiRepository),
+			len(report.Assets), report.FinishedAt.Sub(report.StartedAt).Round(time.Second))
+		if report.Skipped {
+			log.Infof("%s  SKIPPED (notarized already)\n", line)
+		} else if report.Success {
+			log.Successf("%s  OK\n", line)
+		} else {
+			log.Errorf("%s  FAILED: %s\n", line, report.Error)
+		}
+	}
+}
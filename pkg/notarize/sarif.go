@@ -0,0 +1,254 @@
+package notarize
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+	sarifVersion = "2.1.0"
+	sarifToolURL = "https://github.com/codenotary/notarize-release-assets-action"
+)
+
+// sarifRule is a kind of verification finding, i.e. a SARIF reporting
+// descriptor.
+type sarifRule struct {
+	ID               string                 `json:"id"`
+	Name             string                 `json:"name"`
+	ShortDescription sarifMessage           `json:"shortDescription"`
+	FullDescription  sarifMessage           `json:"fullDescription"`
+	Help             sarifMessage           `json:"help"`
+	DefaultConfig    sarifRuleConfig        `json:"defaultConfiguration"`
+	Properties       map[string]interface{} `json:"properties"`
+}
+
+type sarifRuleConfig struct {
+	Level string `json:"level"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+// sarifRules are the kinds of findings of the verifications, from the most
+// to the least severe.
+var sarifRules = []sarifRule{
+	newSARIFRule("NRA001", "AssetDrifted", "error", "9.0",
+		"Release asset drifted from its notarized bytes",
+		"The bytes of the release asset no longer match the ones that were notarized, or the ones "+
+			"of its last re-verification: the asset has been replaced (or regenerated) after the release."),
+	newSARIFRule("NRA002", "AssetNotNotarized", "error", "8.0",
+		"Release asset not notarized",
+		"The hash of the release asset is not notarized in the ledger: the asset might have been "+
+			"tampered with, or it has been added to the release after its notarization."),
+	newSARIFRule("NRA003", "AssetRevoked", "error", "8.0",
+		"Release asset signed with a revoked API key",
+		"The release asset has been notarized with an API key that has been revoked since, e.g. "+
+			"because it was compromised."),
+	newSARIFRule("NRA004", "AssetUntrusted", "error", "7.0",
+		"Release asset untrusted",
+		"The release asset has been explicitly untrusted in the ledger, e.g. because it is "+
+			"vulnerable or it has been published by mistake."),
+	newSARIFRule("NRA005", "AssetUnknown", "warning", "5.0",
+		"Release asset with an unknown status",
+		"The release asset is notarized in the ledger with the unknown status."),
+	newSARIFRule("NRA006", "AssetUnsupported", "warning", "4.0",
+		"Release asset unsupported",
+		"The release asset is notarized in the ledger with the unsupported status."),
+	newSARIFRule("NRA007", "AssetVerificationFailed", "warning", "5.0",
+		"Release asset verification failed",
+		"The release asset could not be verified against the ledger, or its ledger entry does not "+
+			"satisfy the status policy of the verification."),
+}
+
+func newSARIFRule(id, name, level, severity, short, full string) sarifRule {
+	return sarifRule{
+		ID:               id,
+		Name:             name,
+		ShortDescription: sarifMessage{Text: short},
+		FullDescription:  sarifMessage{Text: full},
+		Help: sarifMessage{Text: full + " Check the release asset and re-notarize (or untrust) it " +
+			"once its bytes have been confirmed."},
+		DefaultConfig: sarifRuleConfig{Level: level},
+		Properties: map[string]interface{}{
+			"security-severity": severity,
+			"tags":              []string{"security", "supply-chain"},
+		},
+	}
+}
+
+// sarifRuleIndex returns the index of the rule the given asset of a
+// verification report is a finding of, or -1 if the asset is verified.
+func sarifRuleIndex(asset *AssetReport) int {
+	switch {
+	case asset.Drifted:
+		return 0
+	case asset.notNotarized:
+		return 1
+	}
+	switch asset.Status {
+	case "REVOKED":
+		return 2
+	case "UNTRUSTED":
+		return 3
+	case "UNKNOWN":
+		return 4
+	case "UNSUPPORTED":
+		return 5
+	}
+	if len(asset.Error) > 0 {
+		return 6
+	}
+	return -1
+}
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifResult struct {
+	RuleID              string                 `json:"ruleId"`
+	RuleIndex           int                    `json:"ruleIndex"`
+	Level               string                 `json:"level"`
+	Message             sarifMessage           `json:"message"`
+	Locations           []sarifLocation        `json:"locations"`
+	PartialFingerprints map[string]string      `json:"partialFingerprints"`
+	Properties          map[string]interface{} `json:"properties,omitempty"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           sarifRegion           `json:"region"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine int `json:"startLine"`
+}
+
+// sarifResults returns the findings of the given verification report, i.e.
+// one per asset not notarized, not trusted or drifted.
+func sarifResults(report *Report) []sarifResult {
+	results := []sarifResult{}
+	for _, asset := range report.Assets {
+		ruleIndex := sarifRuleIndex(asset)
+		if ruleIndex < 0 {
+			continue
+		}
+		rule := sarifRules[ruleIndex]
+
+		releaseTag := asset.ReleaseTag
+		if len(releaseTag) == 0 {
+			releaseTag = report.ReleaseTag
+		}
+		release := report.Repository
+		if len(releaseTag) > 0 {
+			release += "@" + releaseTag
+		}
+		message := fmt.Sprintf("%s: asset %s of release %s", rule.ShortDescription.Text, asset.Name, release)
+		if len(asset.Status) > 0 {
+			message += fmt.Sprintf(" has status %s (signer %s)", asset.Status, asset.SignerID)
+		}
+		if len(asset.Error) > 0 {
+			message += ": " + asset.Error
+		}
+
+		// the findings are tracked across runs by rule, release and asset, the
+		// assets not being files of the repository
+		fingerprint := sha256.Sum256([]byte(rule.ID + "\n" + release + "\n" + asset.Name))
+		properties := map[string]interface{}{"release": release}
+		if len(asset.Hash) > 0 {
+			properties["hash"] = asset.Hash
+		}
+		if len(asset.BaselineHash) > 0 {
+			properties["baseline_hash"] = asset.BaselineHash
+		}
+		if len(asset.Status) > 0 {
+			properties["status"] = asset.Status
+			properties["signer_id"] = asset.SignerID
+		}
+		results = append(results, sarifResult{
+			RuleID:    rule.ID,
+			RuleIndex: ruleIndex,
+			Level:     rule.DefaultConfig.Level,
+			Message:   sarifMessage{Text: message},
+			Locations: []sarifLocation{{PhysicalLocation: sarifPhysicalLocation{
+				ArtifactLocation: sarifArtifactLocation{URI: sarifURI(asset.Name)},
+				Region:           sarifRegion{StartLine: 1},
+			}}},
+			PartialFingerprints: map[string]string{
+				"releaseAssetHash/v1": hex.EncodeToString(fingerprint[:]),
+			},
+			Properties: properties,
+		})
+	}
+	return results
+}
+
+// sarifURI returns the relative URI of the location of the given asset, i.e.
+// its name (escaped where needed).
+func sarifURI(name string) string {
+	return strings.NewReplacer("%", "%25", " ", "%20", "#", "%23", "?", "%3F").Replace(name)
+}
+
+// WriteSARIF writes the findings of the given verification report (i.e. the
+// assets not notarized, not trusted or drifted) into the given file as a
+// SARIF log (creating its directory if needed), e.g. for uploading them to
+// the code scanning alerts of the repository.
+func WriteSARIF(filePath string, report *Report) error {
+	log := sarifLog{
+		Schema:  sarifSchema,
+		Version: sarifVersion,
+		Runs: []sarifRun{{
+			Tool: sarifTool{Driver: sarifDriver{
+				Name:           "notarize-release-assets",
+				InformationURI: sarifToolURL,
+				Rules:          sarifRules,
+			}},
+			Results: sarifResults(report),
+		}},
+	}
+	content, err := json.MarshalIndent(&log, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error JSON-marshaling the SARIF log: %v", err)
+	}
+	if dir := filepath.Dir(filePath); len(dir) > 0 {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return fmt.Errorf("error creating SARIF file dir %s: %v", dir, err)
+		}
+	}
+	if err := os.WriteFile(filePath, append(content, '\n'), 0644); err != nil {
+		return fmt.Errorf("error writing SARIF file %s: %v", filePath, err)
+	}
+	return nil
+}
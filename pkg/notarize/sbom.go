@@ -0,0 +1,109 @@
+package notarize
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	git "gopkg.in/src-d/go-git.v4"
+)
+
+// sbomFormats maps the supported SBOM formats to the syft output formats and
+// to the suffixes of the SBOM file names.
+var sbomFormats = map[string]struct {
+	syftOutput string
+	suffix     string
+}{
+	"cyclonedx": {syftOutput: "cyclonedx-json", suffix: ".cdx.json"},
+	"spdx":      {syftOutput: "spdx-json", suffix: ".spdx.json"},
+}
+
+// generateSBOM generates with syft (which must be in the PATH) the SBOM of
+// the local git repository in the given dir, which must have the release tag
+// checked out, and writes it into the given file.
+func generateSBOM(
+	ctx context.Context,
+	gitDir string,
+	format string,
+	tagCommitSHA string,
+	filePath string,
+	log Logger,
+) error {
+
+	sbomFormat, ok := sbomFormats[format]
+	if !ok {
+		return fmt.Errorf("unsupported SBOM format %s: must be either cyclonedx or spdx", format)
+	}
+
+	repo, err := git.PlainOpen(gitDir)
+	if err != nil {
+		return fmt.Errorf("error opening git repository %s: %v", gitDir, err)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return fmt.Errorf("error getting the HEAD of git repository %s: %v", gitDir, err)
+	}
+	if head.Hash().String() != tagCommitSHA {
+		return fmt.Errorf(
+			"the HEAD of git repository %s is not commit %s the release tag points to: "+
+				"the release tag must be checked out", gitDir, tagCommitSHA)
+	}
+
+	log.Infof("Generating the %s SBOM of git repository %s ...\n", format, gitDir)
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "syft", "dir:"+gitDir, "-o", sbomFormat.syftOutput, "-q")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("error generating the SBOM with syft: %v: %s",
+			err, strings.TrimSpace(stderr.String()))
+	}
+
+	if err := os.WriteFile(filePath, stdout.Bytes(), 0644); err != nil {
+		return fmt.Errorf("error writing SBOM file %s: %v", filePath, err)
+	}
+	return nil
+}
+
+// sbomFileName returns the name of the SBOM file of the given format for the
+// given <repo-name>-<tag>.
+func sbomFileName(repoAndTag string, format string) string {
+	return repoAndTag + sbomFormats[format].suffix
+}
+
+// sbomAsset returns the asset of the SBOM, either the specified pre-built one
+// or one generated into the given dir.
+func sbomAsset(
+	ctx context.Context,
+	cfg *Config,
+	dir string,
+	repoAndTag string,
+	tagCommitSHA string,
+	signerID string,
+	log Logger,
+) (*releaseAsset, error) {
+
+	filePath := cfg.SBOMPath
+	if len(filePath) == 0 {
+		gitDir := cfg.GitDir
+		if len(gitDir) == 0 {
+			gitDir = "."
+		}
+		filePath = filepath.Join(dir, sbomFileName(repoAndTag, cfg.SBOMFormat))
+		if err := generateSBOM(ctx, gitDir, cfg.SBOMFormat, tagCommitSHA, filePath, log); err != nil {
+			return nil, err
+		}
+	} else if _, err := os.Stat(filePath); err != nil {
+		return nil, fmt.Errorf("error reading SBOM file %s: %v", filePath, err)
+	}
+
+	return &releaseAsset{
+		name:     filepath.Base(filePath),
+		signerID: signerID,
+		filePath: filePath,
+	}, nil
+}
@@ -0,0 +1,212 @@
+package notarize
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	immuschema "github.com/codenotary/immudb/pkg/api/schema"
+	vcnAPI "github.com/vchain-us/vcn/pkg/api"
+	vcnMeta "github.com/vchain-us/vcn/pkg/meta"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	defaultMaxStreams = 4
+	// maxBatchSize is the maximum number of artifacts signed in a single
+	// ledger transaction
+	maxBatchSize = 64
+	// batchWindow is how long the first sign request of a batch waits for
+	// other sign requests of the same signer to join it
+	batchWindow = 20 * time.Millisecond
+)
+
+type signRequest struct {
+	artifact *vcnAPI.Artifact
+	status   vcnMeta.Status
+	done     chan signResult
+}
+
+type signResult struct {
+	txID uint64
+	err  error
+}
+
+// signScheduler signs the artifacts over the (single) gRPC connection of each
+// vcn client: the sign requests of the same signer which are pending at the
+// same time are batched into a single ledger transaction, the batches are
+// pipelined (i.e. the next one is collected while the previous ones are in
+// flight) and the number of concurrent gRPC streams over all the connections
+// (for signing and verifying) is bounded.
+type signScheduler struct {
+	window  time.Duration
+	streams chan struct{}
+	mu      sync.Mutex
+	queues  map[*vcnAPI.LcUser]chan *signRequest
+	wg      sync.WaitGroup
+}
+
+// newSignScheduler returns a scheduler starting the batching of the sign
+// requests of each vcn client at its first one. The batch window is used only
+// if the sign requests can be concurrent, i.e. if maxParallel is greater than
+// 1.
+func newSignScheduler(maxParallel int, maxStreams int) *signScheduler {
+
+	if maxStreams < 1 {
+		maxStreams = defaultMaxStreams
+	}
+	s := &signScheduler{
+		streams: make(chan struct{}, maxStreams),
+		queues:  make(map[*vcnAPI.LcUser]chan *signRequest),
+	}
+	if maxParallel > 1 {
+		s.window = batchWindow
+	}
+	return s
+}
+
+// queue returns the queue of the sign requests of the given vcn client,
+// starting its batching if needed.
+func (s *signScheduler) queue(vcnUser *vcnAPI.LcUser) chan *signRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	queue, ok := s.queues[vcnUser]
+	if !ok {
+		queue = make(chan *signRequest, maxBatchSize)
+		s.queues[vcnUser] = queue
+		s.wg.Add(1)
+		go s.schedule(vcnUser, queue)
+	}
+	return queue
+}
+
+// close stops the scheduler once all the sign requests sent so far are done.
+func (s *signScheduler) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, queue := range s.queues {
+		close(queue)
+	}
+	s.wg.Wait()
+}
+
+// acquireStream waits for a free gRPC stream slot and returns the function
+// releasing it.
+func (s *signScheduler) acquireStream() func() {
+	s.streams <- struct{}{}
+	return func() { <-s.streams }
+}
+
+// sign signs the given artifact with the given vcn client, returning the ID
+// of the ledger transaction (shared with the other artifacts of its batch).
+func (s *signScheduler) sign(
+	vcnUser *vcnAPI.LcUser,
+	artifact *vcnAPI.Artifact,
+	status vcnMeta.Status,
+) (uint64, error) {
+
+	req := &signRequest{artifact: artifact, status: status, done: make(chan signResult, 1)}
+	s.queue(vcnUser) <- req
+	res := <-req.done
+	return res.txID, res.err
+}
+
+// schedule collects the sign requests of the given vcn client into batches
+// and sends each batch as soon as a gRPC stream is free.
+func (s *signScheduler) schedule(vcnUser *vcnAPI.LcUser, queue chan *signRequest) {
+	defer s.wg.Done()
+	var inFlight sync.WaitGroup
+	defer inFlight.Wait()
+
+	var pending []*signRequest
+	for {
+		if len(pending) == 0 {
+			req, ok := <-queue
+			if !ok {
+				return
+			}
+			pending = append(pending, req)
+		}
+		pending = s.collect(queue, pending)
+
+		// a transaction cannot set the same key twice, hence the requests
+		// for the same hash wait for the next batch
+		var batch, next []*signRequest
+		hashes := make(map[string]bool, len(pending))
+		for _, req := range pending {
+			if hashes[req.artifact.Hash] || len(batch) == maxBatchSize {
+				next = append(next, req)
+				continue
+			}
+			hashes[req.artifact.Hash] = true
+			batch = append(batch, req)
+		}
+		pending = next
+
+		release := s.acquireStream()
+		inFlight.Add(1)
+		go func() {
+			defer inFlight.Done()
+			defer release()
+			txID, err := signBatch(vcnUser, batch)
+			for _, req := range batch {
+				req.done <- signResult{txID: txID, err: err}
+			}
+		}()
+	}
+}
+
+// collect adds to the pending requests the ones received within the batch
+// window, up to the maximum batch size.
+func (s *signScheduler) collect(queue chan *signRequest, pending []*signRequest) []*signRequest {
+	timer := time.NewTimer(s.window)
+	defer timer.Stop()
+	for len(pending) < maxBatchSize {
+		select {
+		case req, ok := <-queue:
+			if !ok {
+				return pending
+			}
+			pending = append(pending, req)
+		case <-timer.C:
+			return pending
+		}
+	}
+	return pending
+}
+
+// signBatch notarizes the artifacts of the given sign requests in a single
+// ledger transaction, writing the same entries vcn does when signing them
+// one by one.
+func signBatch(vcnUser *vcnAPI.LcUser, batch []*signRequest) (uint64, error) {
+	signerID := vcnAPI.GetSignerIDByApiKey(vcnUser.Client.ApiKey)
+	setReq := &immuschema.SetRequest{}
+	for _, req := range batch {
+		lcArtifact := &vcnAPI.LcArtifact{
+			Kind:        req.artifact.Kind,
+			Name:        req.artifact.Name,
+			Hash:        req.artifact.Hash,
+			Size:        req.artifact.Size,
+			ContentType: req.artifact.ContentType,
+			Metadata:    req.artifact.Metadata,
+			Signer:      signerID,
+			Status:      req.status,
+		}
+		value, err := json.Marshal(lcArtifact)
+		if err != nil {
+			return 0, fmt.Errorf("error JSON-marshaling artifact %s: %v", req.artifact.Name, err)
+		}
+		key := vcnAPI.AppendPrefix(vcnMeta.VcnPrefix, []byte(signerID))
+		key = vcnAPI.AppendSignerId(req.artifact.Hash, key)
+		setReq.KVs = append(setReq.KVs, &immuschema.KeyValue{Key: key, Value: value})
+	}
+
+	md := metadata.Pairs(vcnMeta.VcnLCPluginTypeHeaderName, vcnMeta.VcnLCPluginTypeHeaderValue)
+	txMeta, err := vcnUser.Client.SetAll(metadata.NewOutgoingContext(context.Background(), md), setReq)
+	if err != nil {
+		return 0, err
+	}
+	return txMeta.Id, nil
+}
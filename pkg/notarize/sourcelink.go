@@ -0,0 +1,207 @@
+package notarize
+
+import (
+	"context"
+	"fmt"
+
+	vcnAPI "github.com/vchain-us/vcn/pkg/api"
+	vcnGitExtractor "github.com/vchain-us/vcn/pkg/extractor/git"
+	vcnMeta "github.com/vchain-us/vcn/pkg/meta"
+)
+
+// sourceArchiveLink is the parent / child relationship of the assets built
+// from the source code of the release (the children) with its notarized
+// source archive (the parent), i.e. its .tar.gz one or, if excluded, its .zip
+// one.
+type sourceArchiveLink struct {
+	name string
+	hash string
+	// status is the one the source archive is notarized with
+	status vcnMeta.Status
+}
+
+// newSourceArchiveLink returns the link to the source archive among the given
+// assets, whose artifacts are created (its hash being unknown if its download
+// failed), or nil if it is not processed (e.g. excluded).
+func newSourceArchiveLink(
+	repoAndTag string,
+	assets []*releaseAsset,
+	units []ledgerAsset,
+	artifacts []*vcnAPI.Artifact,
+) *sourceArchiveLink {
+
+	for _, name := range []string{repoAndTag + ".tar.gz", repoAndTag + ".zip"} {
+		for u, artifact := range artifacts {
+			i := units[u].index
+			if !assets[i].sourceArchive || assets[i].name != name {
+				continue
+			}
+			link := &sourceArchiveLink{name: name, status: assets[i].status}
+			if artifact != nil {
+				link.hash = artifact.Hash
+			}
+			return link
+		}
+	}
+	return nil
+}
+
+// links tells whether the given asset is a child of the source archive, i.e.
+// any asset but the source archives and the git objects.
+func (l *sourceArchiveLink) links(asset *releaseAsset, artifact *vcnAPI.Artifact) bool {
+	return !asset.sourceArchive && artifact.Kind != vcnGitExtractor.Scheme
+}
+
+// link records the source archive into the metadata of the given artifact of
+// a child asset and into its report.
+func (l *sourceArchiveLink) link(artifact *vcnAPI.Artifact, assetReport *AssetReport) {
+	artifact.Metadata.Set("source_archive", map[string]interface{}{
+		"name": l.name,
+		"hash": l.hash,
+	})
+	assetReport.SourceArchive = l.name
+}
+
+// checkChildren walks the verified children back to the source archive they
+// were built from, as recorded in their own ledger entries rather than the
+// one downloaded now (whose bytes GitHub might have regenerated since): the
+// children fail if that source archive is not notarized in their ledger with
+// the status expected of the source archive, or if their entries do not
+// record any.
+func (l *sourceArchiveLink) checkChildren(
+	ctx context.Context,
+	notarizers []Notarizer,
+	policy *statusPolicy,
+	assetsReports []*AssetReport,
+	errs []error,
+	log Logger,
+) {
+	for u, assetReport := range assetsReports {
+		if assetReport == nil || assetReport.SourceArchive != l.name || errs[u] != nil {
+			continue
+		}
+		parent := assetReport.ledgerSourceArchive
+		var err error
+		if parent == nil {
+			err = fmt.Errorf("asset %s is not linked to any source archive in its ledger entry", assetReport.Name)
+		} else {
+			var entry *LedgerEntry
+			entry, err = notarizers[u].Verify(ctx, &vcnAPI.Artifact{Name: parent.name, Hash: parent.hash})
+			switch {
+			case err != nil:
+				err = fmt.Errorf("error verifying source archive %s (hash %s) of asset %s: %v",
+					parent.name, parent.hash, assetReport.Name, err)
+			case entry == nil:
+				err = fmt.Errorf("asset %s is built from source archive %s (hash %s), which is not notarized",
+					assetReport.Name, parent.name, parent.hash)
+			default:
+				if err = policy.check(parent.name, entry.Status, l.status); err != nil {
+					err = fmt.Errorf("asset %s is built from source archive %s (hash %s), whose verification "+
+						"failed: %v", assetReport.Name, parent.name, parent.hash, err)
+				}
+			}
+		}
+		if err != nil {
+			errs[u] = err
+			assetReport.Error = err.Error()
+			log.Errorf("%v\n", err)
+		}
+	}
+}
+
+// linkedSourceArchive returns the source archive recorded in the given
+// metadata of the ledger entry of a child asset, if any.
+func linkedSourceArchive(metadata map[string]interface{}) *sourceArchiveLink {
+	sourceArchive, ok := metadata["source_archive"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	name, _ := sourceArchive["name"].(string)
+	hash, _ := sourceArchive["hash"].(string)
+	if len(name) == 0 || len(hash) == 0 {
+		return nil
+	}
+	return &sourceArchiveLink{name: name, hash: hash}
+}
+
+// explainSourceArchives tells why the source archives found not notarized
+// when verifying are not, from the commit the release tag pointed to when
+// notarized, i.e. the commit_sha metadata of the notarized source archive
+// recorded by their children in the same ledger (see
+// Config.LinkSourceArchive), if any, or else the one of the other source
+// archive, if it still verifies: GitHub regenerated the archives if the tag
+// still points to it, or else the tag has moved. Without such notarization,
+// the release might not have been notarized at all.
+func explainSourceArchives(
+	ctx context.Context,
+	assets []*releaseAsset,
+	units []ledgerAsset,
+	notarizers []Notarizer,
+	assetsReports []*AssetReport,
+	tag string,
+	tagCommitSHA string,
+	log Logger,
+) {
+	for u, archiveReport := range assetsReports {
+		asset := assets[units[u].index]
+		if archiveReport == nil || !archiveReport.notNotarized || !asset.sourceArchive {
+			continue
+		}
+		// the archive itself is preferred, but any of the release will do
+		var notarized *sourceArchiveLink
+		var sibling *AssetReport
+		for v, assetReport := range assetsReports {
+			if assetReport == nil || units[v].ledgerID != units[u].ledgerID {
+				continue
+			}
+			if assetReport.ledgerSourceArchive != nil &&
+				(notarized == nil || assetReport.ledgerSourceArchive.name == asset.name) {
+				notarized = assetReport.ledgerSourceArchive
+			}
+			if len(assetReport.ledgerCommitSHA) > 0 && v != u {
+				sibling = assetReport
+			}
+		}
+
+		var notarizedName, notarizedCommitSHA string
+		switch {
+		case notarized != nil:
+			entry, err := notarizers[u].Verify(ctx, &vcnAPI.Artifact{Name: notarized.name, Hash: notarized.hash})
+			if err != nil {
+				log.Warningf("WARNING: error looking up the notarization of source archive %s (hash %s): %v\n",
+					notarized.name, notarized.hash, err)
+				continue
+			}
+			if entry == nil {
+				log.Warningf("WARNING: source archive %s is not notarized, nor is source archive %s (hash %s) "+
+					"the other assets are linked to: the release might not have been notarized\n",
+					asset.name, notarized.name, notarized.hash)
+				continue
+			}
+			notarizedName = notarized.name
+			notarizedCommitSHA, _ = entry.Metadata["commit_sha"].(string)
+		case sibling != nil:
+			notarizedName = sibling.Name
+			notarizedCommitSHA = sibling.ledgerCommitSHA
+		default:
+			log.Warningf("WARNING: source archive %s of release tag %s (commit %s) is not notarized, "+
+				"no other source archive verifies and no other asset records the notarization of the "+
+				"source archives: the release might not have been notarized, or its tag might have moved\n",
+				asset.name, tag, tagCommitSHA)
+			continue
+		}
+
+		switch {
+		case len(notarizedCommitSHA) == 0:
+			log.Warningf("WARNING: source archive %s is not notarized and the commit of the notarized "+
+				"source archive %s is not recorded\n", asset.name, notarizedName)
+		case notarizedCommitSHA == tagCommitSHA:
+			log.Warningf("WARNING: source archive %s is not notarized while the release tag %s still "+
+				"points to the commit %s of the notarized source archive %s: GitHub regenerated the "+
+				"archive with different bytes\n", asset.name, tag, tagCommitSHA, notarizedName)
+		default:
+			log.Warningf("WARNING: source archive %s is not notarized: the release tag %s has moved "+
+				"from the notarized commit %s to commit %s\n", asset.name, tag, notarizedCommitSHA, tagCommitSHA)
+		}
+	}
+}
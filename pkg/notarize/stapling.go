@@ -0,0 +1,226 @@
+package notarize
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// appleTicketMagic is the magic of the notarization tickets Apple
+	// issues, which stapler staples to the notarized artifacts.
+	appleTicketMagic = "s8ch"
+	// maxAppleTicketSize is the maximum size of the stapled tickets read.
+	maxAppleTicketSize = 1 << 20
+
+	// udifTrailerSize is the size of the koly trailer ending the disk images.
+	udifTrailerSize = 512
+	// codeSignatureSuperBlobMagic is the magic of the embedded code
+	// signatures, e.g. the ones of the disk images.
+	codeSignatureSuperBlobMagic = 0xfade0cc0
+	// codeSignatureTicketSlot is the slot of the stapled ticket in the
+	// embedded code signatures.
+	codeSignatureTicketSlot = 0x10002
+
+	// xarTicketTrailerMagic is the magic of the trailer following the ticket
+	// stapled to the flat installer packages (i.e. xar archives).
+	xarTicketTrailerMagic = "t8lr"
+	xarTicketTrailerSize  = 12
+)
+
+// staplingVerifier verifies that the macOS disk images and installer packages
+// (i.e. .dmg and .pkg assets) have an Apple notarization ticket stapled to
+// them before they are notarized.
+type staplingVerifier struct {
+	mu      sync.Mutex
+	results map[string]staplingResult
+}
+
+type staplingResult struct {
+	ticketID string
+	err      error
+}
+
+func newStaplingVerifier() *staplingVerifier {
+	return &staplingVerifier{results: make(map[string]staplingResult)}
+}
+
+// isStapledFileName tells if the given asset is a macOS disk image or
+// installer package, to which Apple notarization tickets are stapled.
+func isStapledFileName(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".dmg", ".pkg":
+		return true
+	}
+	return false
+}
+
+// needsFile returns whether the given asset must be downloaded (even when
+// streaming the assets) to read its stapled ticket.
+func (v *staplingVerifier) needsFile(name string) bool {
+	return isStapledFileName(name)
+}
+
+// check checks that a notarization ticket is stapled to the given asset file,
+// if it is a macOS disk image or installer package, and returns the ID of the
+// ticket, i.e. the SHA-256 hash of its data.
+func (v *staplingVerifier) check(asset *releaseAsset, filePath string) (string, error) {
+	if !isStapledFileName(asset.name) {
+		return "", nil
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if result, ok := v.results[asset.name]; ok {
+		return result.ticketID, result.err
+	}
+	var result staplingResult
+	if len(filePath) == 0 {
+		result.err = fmt.Errorf("asset %s must be downloaded for checking its stapled "+
+			"notarization ticket", asset.name)
+	} else if ticket, err := stapledTicket(filePath); err != nil {
+		result.err = fmt.Errorf("refusing to notarize asset %s: %v", asset.name, err)
+	} else {
+		hash := sha256.Sum256(ticket)
+		result.ticketID = hex.EncodeToString(hash[:])
+	}
+	v.results[asset.name] = result
+	return result.ticketID, result.err
+}
+
+// stapledTicket returns the Apple notarization ticket stapled to the given
+// disk image or installer package. The ticket itself (i.e. the signature of
+// Apple over the code directory hashes it lists) is not verified.
+func stapledTicket(filePath string) ([]byte, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s: %v", filePath, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s: %v", filePath, err)
+	}
+
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return nil, errors.New("it is neither a disk image nor an installer package")
+	}
+	var ticket []byte
+	if string(magic) == "xar!" {
+		ticket, err = xarStapledTicket(f, info.Size())
+	} else {
+		ticket, err = dmgStapledTicket(f, info.Size())
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ticket == nil {
+		return nil, errors.New("it has no stapled Apple notarization ticket")
+	}
+	if !bytes.HasPrefix(ticket, []byte(appleTicketMagic)) {
+		return nil, errors.New("its stapled Apple notarization ticket is invalid")
+	}
+	return ticket, nil
+}
+
+// dmgStapledTicket returns the ticket stored in the embedded code signature of
+// the given disk image, located by its koly trailer, if any.
+func dmgStapledTicket(f io.ReaderAt, size int64) ([]byte, error) {
+	if size < udifTrailerSize {
+		return nil, errors.New("it is neither a disk image nor an installer package")
+	}
+	trailer := make([]byte, udifTrailerSize)
+	if _, err := f.ReadAt(trailer, size-udifTrailerSize); err != nil {
+		return nil, fmt.Errorf("error reading the disk image trailer: %v", err)
+	}
+	if string(trailer[:4]) != "koly" {
+		return nil, errors.New("it is neither a disk image nor an installer package")
+	}
+	signatureOffset := int64(binary.BigEndian.Uint64(trailer[296:]))
+	signatureSize := int64(binary.BigEndian.Uint64(trailer[304:]))
+	if signatureSize == 0 {
+		return nil, errors.New("it has no code signature")
+	}
+	if signatureOffset < 0 || signatureSize < 12 || signatureSize > maxAppleTicketSize ||
+		signatureOffset+signatureSize > size {
+		return nil, errors.New("its code signature is invalid")
+	}
+	superBlob := make([]byte, signatureSize)
+	if _, err := f.ReadAt(superBlob, signatureOffset); err != nil {
+		return nil, fmt.Errorf("error reading the code signature: %v", err)
+	}
+	return superBlobSlot(superBlob, codeSignatureTicketSlot)
+}
+
+// superBlobSlot returns the content of the given slot of a code signature
+// super blob, if any. The content of a slot spans up to the next one.
+func superBlobSlot(superBlob []byte, slot uint32) ([]byte, error) {
+	be := binary.BigEndian
+	if be.Uint32(superBlob) != codeSignatureSuperBlobMagic {
+		return nil, errors.New("its code signature is invalid")
+	}
+	length := be.Uint32(superBlob[4:])
+	count := be.Uint32(superBlob[8:])
+	if int64(length) > int64(len(superBlob)) || 12+int64(count)*8 > int64(length) {
+		return nil, errors.New("its code signature is invalid")
+	}
+	offsets := []uint32{length}
+	var start uint32
+	found := false
+	for i := uint32(0); i < count; i++ {
+		entry := superBlob[12+8*i:]
+		offset := be.Uint32(entry[4:])
+		if offset > length {
+			return nil, errors.New("its code signature is invalid")
+		}
+		offsets = append(offsets, offset)
+		if be.Uint32(entry) == slot {
+			start, found = offset, true
+		}
+	}
+	if !found {
+		return nil, nil
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	end := length
+	for _, offset := range offsets {
+		if offset > start {
+			end = offset
+			break
+		}
+	}
+	return superBlob[start:end], nil
+}
+
+// xarStapledTicket returns the ticket appended (along with a t8lr trailer) to
+// the given installer package, if any.
+func xarStapledTicket(f io.ReaderAt, size int64) ([]byte, error) {
+	if size < xarTicketTrailerSize {
+		return nil, nil
+	}
+	trailer := make([]byte, xarTicketTrailerSize)
+	if _, err := f.ReadAt(trailer, size-xarTicketTrailerSize); err != nil {
+		return nil, fmt.Errorf("error reading the installer package trailer: %v", err)
+	}
+	if string(trailer[:4]) != xarTicketTrailerMagic {
+		return nil, nil
+	}
+	length := int64(binary.LittleEndian.Uint32(trailer[8:]))
+	if length == 0 || length > maxAppleTicketSize || length > size-xarTicketTrailerSize {
+		return nil, errors.New("its stapled Apple notarization ticket is invalid")
+	}
+	ticket := make([]byte, length)
+	if _, err := f.ReadAt(ticket, size-xarTicketTrailerSize-length); err != nil {
+		return nil, fmt.Errorf("error reading the stapled Apple notarization ticket: %v", err)
+	}
+	return ticket, nil
+}
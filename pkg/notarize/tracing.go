@@ -0,0 +1,136 @@
+package notarize
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	tracerName         = "github.com/codenotary/notarize-release-assets-action/pkg/notarize"
+	defaultServiceName = "notarize-release-assets-action"
+)
+
+// StartTracing sets up the export of the OpenTelemetry spans of the release
+// fetch, of the downloads, of the API key management calls and of the sign and
+// verify calls to the OTLP (gRPC) endpoint configured via the standard
+// OTEL_EXPORTER_OTLP_* environment variables (e.g.
+// OTEL_EXPORTER_OTLP_ENDPOINT), if any. The returned function flushes and
+// stops the export.
+func StartTracing(ctx context.Context) (func(context.Context) error, error) {
+	if len(os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")) == 0 &&
+		len(os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")) == 0 {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracegrpc.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error creating the OTLP trace exporter: %v", err)
+	}
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the defaults
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceNameKey.String(defaultServiceName)),
+		resource.WithTelemetrySDK(),
+		resource.WithFromEnv())
+	if err != nil {
+		return nil, fmt.Errorf("error creating the OpenTelemetry resource: %v", err)
+	}
+	tracerProvider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res))
+	otel.SetTracerProvider(tracerProvider)
+
+	return tracerProvider.Shutdown, nil
+}
+
+type timingsKey struct{}
+
+// timing is the total and maximum durations of the spans of the same name.
+type timing struct {
+	name  string
+	count int
+	total time.Duration
+	max   time.Duration
+}
+
+// timings aggregates the durations of the spans of a run, for its final
+// timing summary.
+type timings struct {
+	mu      sync.Mutex
+	byName  map[string]*timing
+	ordered []*timing
+}
+
+func withTimings(ctx context.Context) (context.Context, *timings) {
+	t := &timings{byName: make(map[string]*timing)}
+	return context.WithValue(ctx, timingsKey{}, t), t
+}
+
+func (t *timings) add(name string, d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tm, ok := t.byName[name]
+	if !ok {
+		tm = &timing{name: name}
+		t.byName[name] = tm
+		t.ordered = append(t.ordered, tm)
+	}
+	tm.count++
+	tm.total += d
+	if d > tm.max {
+		tm.max = d
+	}
+}
+
+// logSummary logs the count, total and maximum durations of each kind of
+// span, the slowest first.
+func (t *timings) logSummary(log Logger) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.ordered) == 0 {
+		return
+	}
+	ordered := append([]*timing(nil), t.ordered...)
+	sort.SliceStable(ordered, func(i, j int) bool { return ordered[i].total > ordered[j].total })
+	log.Infof("Timing summary:\n")
+	for _, tm := range ordered {
+		log.Infof("  %-24s %4d x  total %-12s max %s\n", tm.name, tm.count,
+			tm.total.Round(time.Millisecond), tm.max.Round(time.Millisecond))
+	}
+}
+
+// startSpan starts the span of the given name, which is also accounted in the
+// timings of the run (if any). The returned function ends the span, recording
+// the given error, if any.
+func startSpan(
+	ctx context.Context,
+	name string,
+	attrs ...attribute.KeyValue,
+) (context.Context, func(error)) {
+
+	started := time.Now()
+	ctx, span := otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+	t, _ := ctx.Value(timingsKey{}).(*timings)
+	return ctx, func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+		if t != nil {
+			t.add(name, time.Since(started))
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package notarize
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codenotary/notarize-release-assets-action/pkg/bitbucket"
+	"github.com/codenotary/notarize-release-assets-action/pkg/github"
+)
+
+// ConfigError is an invalid configuration, with all its problems rather than
+// only the first one found. It is of the ErrInvalidConfig failure class.
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	if len(e.Problems) == 1 {
+		return e.Problems[0]
+	}
+	return fmt.Sprintf("%d configuration problems:\n  - %s",
+		len(e.Problems), strings.Join(e.Problems, "\n  - "))
+}
+
+// Is tells that the configuration error is of the ErrInvalidConfig class.
+func (e *ConfigError) Is(target error) bool {
+	return target == ErrInvalidConfig
+}
+
+// Add records the given error, if any, as a problem of the configuration; the
+// problems of another ConfigError are recorded one by one.
+func (e *ConfigError) Add(err error) {
+	if err == nil {
+		return
+	}
+	if configErr, ok := err.(*ConfigError); ok {
+		e.Problems = append(e.Problems, configErr.Problems...)
+		return
+	}
+	e.Problems = append(e.Problems, err.Error())
+}
+
+// Addf records the formatted problem of the configuration.
+func (e *ConfigError) Addf(format string, a ...interface{}) {
+	e.Problems = append(e.Problems, fmt.Sprintf(format, a...))
+}
+
+// Err returns the configuration error if any problem was recorded, nil
+// otherwise.
+func (e *ConfigError) Err() error {
+	if len(e.Problems) == 0 {
+		return nil
+	}
+	return e
+}
+
+// ledgerIDPattern is the format of the CNIL ledger IDs (and of the immudb
+// database names).
+var ledgerIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Validate checks the configuration as a whole, independently of the
+// operation, and returns a *ConfigError with all its problems, if any: the
+// URLs, the ports, the ledger IDs, the limits and the options which cannot be
+// combined.
+func (cfg *Config) Validate() error {
+	var problems ConfigError
+
+	offlineRun := len(cfg.OfflineBundle) > 0
+	if cfg.Backend == nil && !offlineRun && len(cfg.CNILHost) == 0 && len(cfg.ImmudbHost) == 0 {
+		problems.Addf("either the CNIL host or the immudb host is required")
+	}
+	hasRelease := len(cfg.ReleaseURL) > 0
+	if !hasRelease && len(cfg.Paths) == 0 && len(cfg.Images) == 0 {
+		problems.Addf("either the release URL, the local paths or the images are required")
+	}
+	provider, err := ParseProvider(cfg.Provider)
+	problems.Add(err)
+	if hasRelease && provider == ProviderBitbucket {
+		problems.Add(validateTagURL(cfg.ReleaseURL))
+	} else if hasRelease {
+		problems.Add(validateReleaseURL(cfg.ReleaseURL))
+	}
+
+	problems.Add(validateURL("CNIL REST API URL", cfg.CNILRESTURL))
+	for _, restURL := range cfg.CNILFallbackRESTURLs {
+		problems.Add(validateURL("CNIL fallback REST API URL", restURL))
+	}
+	problems.Add(validateURL("GitHub API URL", cfg.GitHubAPIURL))
+	problems.Add(validateURL("proxy URL", cfg.ProxyURL))
+	problems.Add(validateURL("Prometheus Pushgateway URL", cfg.MetricsPushgatewayURL))
+	problems.Add(validatePort("CNIL gRPC port", cfg.CNILGRPCPort))
+	problems.Add(validatePort("immudb port", cfg.ImmudbPort))
+
+	problems.Add(validateLedgerID(cfg.LedgerID))
+	for pattern, ledgerIDs := range cfg.LedgersPerAsset {
+		for _, ledgerID := range ledgerIDs {
+			if err := validateLedgerID(ledgerID); err != nil {
+				problems.Addf("invalid ledger IDs of asset name pattern %s: %v", pattern, err)
+			}
+		}
+	}
+	for _, ledger := range cfg.VerifyLedgers {
+		problems.Add(validateLedgerID(ledger.LedgerID))
+	}
+
+	if len(cfg.Status) > 0 {
+		_, err := ParseStatus(cfg.Status)
+		problems.Add(err)
+	}
+	for pattern, status := range cfg.StatusPerAsset {
+		if _, err := ParseStatus(status); err != nil {
+			problems.Addf("invalid status of asset name pattern %s: %v", pattern, err)
+		}
+	}
+	if cfg.AssetNames != nil {
+		problems.Add(cfg.AssetNames.validate())
+	}
+	if _, err := newStatusPolicy(cfg.RequireStatus, cfg.DenyStatuses); err != nil {
+		problems.Add(err)
+	}
+
+	for _, limit := range []struct {
+		name  string
+		value int64
+	}{
+		{"maximum asset size", cfg.MaxAssetSize},
+		{"maximum number of parallel assets", int64(cfg.MaxParallel)},
+		{"maximum number of parallel releases", int64(cfg.MaxParallelReleases)},
+		{"maximum number of concurrent gRPC calls", int64(cfg.MaxStreams)},
+		{"maximum number of gRPC attempts", int64(cfg.GRPCMaxAttempts)},
+	} {
+		if limit.value < 0 {
+			problems.Addf("invalid %s %d: must not be negative", limit.name, limit.value)
+		}
+	}
+	for _, timeout := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"API timeout", cfg.APITimeout},
+		{"download timeout", cfg.DownloadTimeout},
+		{"gRPC dial timeout", cfg.GRPCDialTimeout},
+		{"gRPC keepalive interval", cfg.GRPCKeepalive},
+		{"gRPC keepalive timeout", cfg.GRPCKeepaliveTimeout},
+	} {
+		if timeout.value < 0 {
+			problems.Addf("invalid %s %s: must not be negative", timeout.name, timeout.value)
+		}
+	}
+
+	if !hasRelease && (cfg.NotarizeGit || len(cfg.SBOMFormat) > 0 || cfg.UploadSBOM ||
+		cfg.UploadChecksums || cfg.UpdateReleaseNotes || cfg.CosignSign || cfg.Provenance != nil ||
+		cfg.GitHubAttestations || cfg.UploadProofFiles || cfg.PlatformManifests != nil || cfg.Dependencies != nil) {
+		problems.Addf("the git objects, the generated SBOM, the dependency manifest, the uploads, " +
+			"the cosign signatures, the provenance and the attestations require a release")
+	}
+	assetSelection, err := ParseAssetSelection(cfg.AssetSelection)
+	problems.Add(err)
+	skipSourceArchives := cfg.SkipSourceArchives || assetSelection == AssetsUploadedOnly
+	if assetSelection == AssetsSourceOnly && cfg.SkipSourceArchives {
+		problems.Addf("the source code archives cannot be both the only assets processed and skipped")
+	}
+	if cfg.LinkSourceArchive && (!hasRelease || skipSourceArchives) {
+		problems.Addf("linking the assets to the source archive requires a release whose source archives are processed")
+	}
+	if cfg.PrimaryDigest != "" && cfg.PrimaryDigest != DigestSHA256 && len(cfg.HashesFromChecksums) > 0 {
+		problems.Addf("the hashes from the checksums file are SHA-256 ones, which must be the primary digest")
+	}
+	if cfg.GitHubAttestations && cfg.Provenance == nil {
+		problems.Addf("the GitHub attestations require the provenance options")
+	}
+	if provider == ProviderBitbucket && (cfg.ValidateAccounts || len(cfg.SignerIDsPerTeam) > 0 ||
+		cfg.SignerIDFromEmail || cfg.UploadChecksums || cfg.UploadSBOM || cfg.UpdateReleaseNotes ||
+		cfg.CosignSign || (cfg.Provenance != nil && cfg.Provenance.Upload) || cfg.GitHubAttestations ||
+		cfg.UploadProofFiles || cfg.PlatformManifests != nil || cfg.Dependencies != nil ||
+		len(cfg.ReleaseEventFile) > 0) {
+		problems.Addf("the uploads, the release notes, the attestations, the platform manifests, " +
+			"the dependency manifest, the event payload and the GitHub accounts checks and resolutions " +
+			"are not supported with Bitbucket")
+	}
+
+	return problems.Err()
+}
+
+// validateURL checks that the given URL, if any, is an absolute HTTP(S) one.
+func validateURL(name string, rawURL string) error {
+	if len(rawURL) == 0 {
+		return nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid %s %s: %v", name, rawURL, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return fmt.Errorf("invalid %s %s: must be an absolute http(s) URL", name, rawURL)
+	}
+	return nil
+}
+
+// validateReleaseURL checks that the given URL is the GitHub API URL of a
+// release, e.g. https://api.github.com/repos/<owner>/<repo-name>/releases/<ID>.
+func validateReleaseURL(releaseURL string) error {
+	if err := validateURL("release URL", releaseURL); err != nil {
+		return err
+	}
+	if len(github.RepositoryFromReleaseURL(releaseURL)) == 0 || !strings.Contains(releaseURL, "/releases") {
+		return fmt.Errorf("invalid release URL %s: must be the GitHub API URL of a release, "+
+			"e.g. https://api.github.com/repos/<owner>/<repo-name>/releases/<ID>", releaseURL)
+	}
+	return nil
+}
+
+// validateTagURL checks that the given URL is the Bitbucket API URL of a tag.
+func validateTagURL(tagURL string) error {
+	if err := validateURL("release URL", tagURL); err != nil {
+		return err
+	}
+	if len(bitbucket.RepositoryFromTagURL(tagURL)) == 0 {
+		return fmt.Errorf("invalid release URL %s: must be the Bitbucket API URL of a tag, "+
+			"e.g. %s/repositories/<workspace>/<repo-slug>/refs/tags/<tag>", tagURL, bitbucket.DefaultAPIURL)
+	}
+	return nil
+}
+
+// validatePort checks that the given port, if any, is in the 1-65535 range.
+func validatePort(name string, port string) error {
+	if len(port) == 0 {
+		return nil
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("invalid %s %s: must be a number between 1 and 65535", name, port)
+	}
+	return nil
+}
+
+// validateLedgerID checks the format of the given ledger ID, if any.
+func validateLedgerID(ledgerID string) error {
+	if len(ledgerID) == 0 || ledgerIDPattern.MatchString(ledgerID) {
+		return nil
+	}
+	return fmt.Errorf("invalid ledger ID %s: must only contain letters, digits, dots, dashes and underscores",
+		ledgerID)
+}
+
+// ValidateReleases is Validate for the configuration shared by several
+// releases processed in turn (see ProcessReleases and BulkNotarize), whose
+// release URLs are only known once resolved.
+func (cfg *Config) ValidateReleases() error {
+	withRelease := *cfg
+	withRelease.ReleaseURL = github.LatestReleaseURL("", "owner/repository")
+	return withRelease.Validate()
+}
@@ -0,0 +1,337 @@
+package notarize
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/blang/semver"
+	"github.com/h2non/filetype"
+	sdk "github.com/vchain-us/ledger-compliance-go/grpcclient"
+	vcnAPI "github.com/vchain-us/vcn/pkg/api"
+	vcnFileExtractor "github.com/vchain-us/vcn/pkg/extractor/file"
+	vcnMeta "github.com/vchain-us/vcn/pkg/meta"
+	vcnStore "github.com/vchain-us/vcn/pkg/store"
+	vcnURI "github.com/vchain-us/vcn/pkg/uri"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+)
+
+const (
+	defaultGRPCKeepalive        = 20 * time.Second
+	defaultGRPCKeepaliveTimeout = 10 * time.Second
+	defaultGRPCRetryBackoff     = time.Second
+	// maxGRPCRetryBackoff caps the exponential backoff of the gRPC retries
+	maxGRPCRetryBackoff = 30 * time.Second
+)
+
+// grpcSettings are the settings of the gRPC connections of the vcn clients
+// (see Config.GRPCDialTimeout and the next ones), defaults applied.
+type grpcSettings struct {
+	dialTimeout      time.Duration
+	keepalive        time.Duration
+	keepaliveTimeout time.Duration
+	maxAttempts      int
+	retryBackoff     time.Duration
+}
+
+func grpcSettingsOf(cfg *Config) grpcSettings {
+	settings := grpcSettings{
+		dialTimeout:      cfg.GRPCDialTimeout,
+		keepalive:        cfg.GRPCKeepalive,
+		keepaliveTimeout: cfg.GRPCKeepaliveTimeout,
+		maxAttempts:      cfg.GRPCMaxAttempts,
+		retryBackoff:     cfg.GRPCRetryBackoff,
+	}
+	if settings.keepalive <= 0 {
+		settings.keepalive = defaultGRPCKeepalive
+	}
+	if settings.keepaliveTimeout <= 0 {
+		settings.keepaliveTimeout = defaultGRPCKeepaliveTimeout
+	}
+	if settings.retryBackoff <= 0 {
+		settings.retryBackoff = defaultGRPCRetryBackoff
+	}
+	return settings
+}
+
+// isDefault tells whether the settings are the ones vcn uses.
+func (s grpcSettings) isDefault() bool {
+	return s.dialTimeout <= 0 && s.keepalive == defaultGRPCKeepalive &&
+		s.keepaliveTimeout == defaultGRPCKeepaliveTimeout && s.maxAttempts <= 1
+}
+
+// dialOptions returns the gRPC dial options of the keepalive pings, of the
+// dial timeout and of the retries of the calls failing with an Unavailable
+// error (e.g. a connection reset), if any.
+func (s grpcSettings) dialOptions() []grpc.DialOption {
+	dialOptions := []grpc.DialOption{
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                s.keepalive,
+			Timeout:             s.keepaliveTimeout,
+			PermitWithoutStream: true,
+		}),
+	}
+	if s.dialTimeout > 0 {
+		// the connection is established (or fails) right away
+		dialOptions = append(dialOptions, grpc.WithBlock(), grpc.WithTimeout(s.dialTimeout))
+	}
+	if s.maxAttempts > 1 {
+		maxBackoff := s.retryBackoff * 8
+		if maxBackoff > maxGRPCRetryBackoff {
+			maxBackoff = maxGRPCRetryBackoff
+		}
+		dialOptions = append(dialOptions, grpc.WithDefaultServiceConfig(fmt.Sprintf(`{
+  "methodConfig": [{
+    "name": [{}],
+    "retryPolicy": {
+      "maxAttempts": %d,
+      "initialBackoff": "%.3fs",
+      "maxBackoff": "%.3fs",
+      "backoffMultiplier": 2,
+      "retryableStatusCodes": ["UNAVAILABLE"]
+    }
+  }]
+}`, s.maxAttempts, s.retryBackoff.Seconds(), maxBackoff.Seconds())))
+	}
+	return dialOptions
+}
+
+// newVCNUser creates the vcn client of the given API key. The given TLS
+// config, if any (i.e. for a private CA or mutual TLS), is used instead of the
+// default one of vcn and the connection goes through the given proxy, if any,
+// with the gRPC dial options of the given settings (the ones vcn uses by
+// default).
+func newVCNUser(
+	apiKey string,
+	host string,
+	port string,
+	noTLS bool,
+	tlsConfig *tls.Config,
+	proxy proxyFunc,
+	settings grpcSettings,
+) (*vcnAPI.LcUser, error) {
+
+	if (tlsConfig == nil || noTLS) && proxy == nil && settings.isDefault() {
+		return vcnAPI.NewLcUser(apiKey, "", host, port, "", false, noTLS)
+	}
+
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CNIL gRPC port %s", port)
+	}
+	dialOptions := settings.dialOptions()
+	switch {
+	case noTLS:
+		dialOptions = append(dialOptions, grpc.WithInsecure())
+	case tlsConfig != nil:
+		dialOptions = append(dialOptions, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	default:
+		dialOptions = append(dialOptions, grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{})))
+	}
+	if proxy != nil {
+		dialOptions = append(dialOptions, grpc.WithContextDialer(proxyDialer(proxy)))
+	}
+	client := sdk.NewLcClient(
+		sdk.ApiKey(apiKey),
+		sdk.MetadataPairs([]string{
+			vcnMeta.VcnLCLedgerHeaderName, "",
+			vcnMeta.VcnLCVersionHeaderName, vcnMeta.Version(),
+		}),
+		sdk.Host(host),
+		sdk.Port(p),
+		sdk.Dir(vcnStore.CurrentConfigFilePath()),
+		sdk.DialOptions(dialOptions),
+	)
+	vcnStore.Config().NewLcUser(host, port, "", false, noTLS)
+
+	return &vcnAPI.LcUser{Client: client}, nil
+}
+
+func vcnArtifactFromAssetFile(filePath string) (*vcnAPI.Artifact, error) {
+	fileURI, err := vcnURI.Parse("file://" + filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing URI from asset file path %s: %v", filePath, err)
+	}
+
+	artifacts, err := vcnFileExtractor.Artifact(fileURI)
+	if err != nil {
+		return nil, fmt.Errorf("error creating vcn artifact from asset file %s: %v", fileURI, err)
+	}
+
+	return artifacts[0], nil
+}
+
+// sniffedHeadSize is the number of the first bytes of the files the vcn file
+// extractor sniffs their content type from.
+const sniffedHeadSize = 512
+
+// sniffContentType returns the content type the vcn file extractor sniffs
+// from the given first bytes of a file (at most sniffedHeadSize of them),
+// i.e. from a zero-padded buffer of sniffedHeadSize bytes, or an empty string
+// for an empty file. The executables (see isExecutable) get another one from
+// the extractor.
+func sniffContentType(head []byte) string {
+	if len(head) == 0 {
+		return ""
+	}
+	buf := make([]byte, sniffedHeadSize)
+	copy(buf, head)
+	if kind, err := filetype.Match(buf); err == nil && kind != filetype.Unknown {
+		return kind.MIME.Value
+	}
+	return http.DetectContentType(buf)
+}
+
+// vcnVersionRegexp matches the versions the vcn file extractor infers from the
+// file names (sic).
+var vcnVersionRegexp = regexp.MustCompile(`v[0-9]+\.[0-9]\.+[0-9]+`)
+
+// inferVersion returns the version the vcn file extractor infers from the
+// given file name (and records in the version metadata), if any.
+func inferVersion(name string) string {
+	if match := vcnVersionRegexp.FindString(name); len(match) > 0 {
+		if version, err := semver.ParseTolerant(match); err == nil {
+			return version.String()
+		}
+	}
+	return ""
+}
+
+// inferMetadata returns the metadata the vcn file extractor infers from the
+// given file name, i.e. its version (if any).
+func inferMetadata(name string) vcnAPI.Metadata {
+	metadata := vcnAPI.Metadata{}
+	if version := inferVersion(name); len(version) > 0 {
+		metadata["version"] = version
+	}
+	return metadata
+}
+
+// isExecutable tells whether the given first bytes of a file are the ones of
+// an ELF, PE or Mach-O executable, whose content type and metadata (e.g. its
+// architecture) the vcn file extractor sniffs from the whole file.
+func isExecutable(head []byte) bool {
+	for _, magic := range [][]byte{
+		[]byte("\x7fELF"),
+		[]byte("MZ"),
+		{0xfe, 0xed, 0xfa, 0xce}, {0xfe, 0xed, 0xfa, 0xcf},
+		{0xce, 0xfa, 0xed, 0xfe}, {0xcf, 0xfa, 0xed, 0xfe},
+		{0xca, 0xfe, 0xba, 0xbe},
+	} {
+		if bytes.HasPrefix(head, magic) {
+			return true
+		}
+	}
+	return false
+}
+
+// needsLocalFile tells whether the file of the given first bytes needs to be
+// a local file for its artifact to be the one of the downloaded assets, i.e.
+// whether it is an executable (see isExecutable) or of an asset kind detected
+// by its content type (see kindNeedsLocalFile).
+func needsLocalFile(head []byte) bool {
+	return isExecutable(head) || kindNeedsLocalFile(sniffContentType(head))
+}
+
+// vcnArtifactFromReader creates the vcn artifact of the given name from its
+// content, with the same hash, size, content type and version metadata as the
+// vcn file extractor, except for the files which need to be local ones (see
+// needsLocalFile), which must be spooled to a file instead (see streamAsset).
+func vcnArtifactFromReader(name string, r io.Reader) (*vcnAPI.Artifact, error) {
+	h := sha256.New()
+
+	head := make([]byte, sniffedHeadSize)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	head = head[:n]
+	h.Write(head)
+
+	size, err := io.Copy(h, r)
+	if err != nil {
+		return nil, err
+	}
+
+	return &vcnAPI.Artifact{
+		Kind:        "file",
+		Name:        name,
+		Hash:        hex.EncodeToString(h.Sum(nil)),
+		Size:        uint64(n) + uint64(size),
+		ContentType: sniffContentType(head),
+		Metadata:    inferMetadata(name),
+	}, nil
+}
+
+func notarizeAndVerify(
+	vcnUser *vcnAPI.LcUser,
+	artifact *vcnAPI.Artifact,
+	state vcnMeta.Status,
+	scheduler *signScheduler,
+) (*vcnAPI.LcArtifact, uint64, error) {
+
+	var txID uint64
+	var err error
+	if scheduler != nil {
+		txID, err = scheduler.sign(vcnUser, artifact, state)
+	} else {
+		_, txID, err = vcnUser.Sign(*artifact, vcnAPI.LcSignWithStatus(state))
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("error signing artifact: %w", err)
+	}
+
+	notarizedArtifact, err := verify(vcnUser, artifact, scheduler)
+	if err != nil {
+		return nil, txID, fmt.Errorf(
+			"%s was notarized without errors, but there was an error when verifying it: %v",
+			artifact.Name, err)
+	}
+	if notarizedArtifact == nil {
+		return nil, txID, fmt.Errorf(
+			"%s was notarized without error, but there was an error when verifying it: artifact not found",
+			artifact.Name)
+	}
+
+	return notarizedArtifact, txID, nil
+}
+
+func verify(
+	vcnCNILUser *vcnAPI.LcUser,
+	vcnArtifact *vcnAPI.Artifact,
+	scheduler *signScheduler,
+) (*vcnAPI.LcArtifact, error) {
+
+	// the scheduler, if any, bounds the gRPC streams
+	if scheduler != nil {
+		defer scheduler.acquireStream()()
+	}
+	cnilArtifact, verified, err := vcnCNILUser.LoadArtifact(vcnArtifact.Hash, "", "", 0)
+	if err == vcnAPI.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ledger might be compromised: %w", err)
+	}
+
+	if !verified {
+		return nil, errors.New(
+			`ledger might be compromised: CNIL verification status is "false"`)
+	}
+
+	if cnilArtifact.Revoked != nil && !cnilArtifact.Revoked.IsZero() {
+		cnilArtifact.Status = vcnMeta.StatusApikeyRevoked
+	}
+
+	return cnilArtifact, nil
+}
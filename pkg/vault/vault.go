@@ -0,0 +1,301 @@
+// Package vault implements the HashiCorp Vault API calls for reading the CNIL
+// credentials from a KV v2 secret and writing back the API keys issued by the
+// runs.
+package vault
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/codenotary/notarize-release-assets-action/pkg/cnil"
+)
+
+const (
+	// PersonalTokenField is the field of the secret holding the CNIL personal
+	// token.
+	PersonalTokenField = "personal_token"
+	// APIKeysField is the field of the secret holding the pre-provisioned
+	// API keys, as an object mapping the signer IDs to their API keys.
+	APIKeysField = "api_keys"
+	// IssuedAPIKeysField is the field of the secret the API keys issued by
+	// the runs are written back into, keyed by <ledger ID>/<signer ID>.
+	IssuedAPIKeysField = "issued_api_keys"
+)
+
+// Options are the settings of the Vault API calls.
+type Options struct {
+	// Address is the address of the Vault server, e.g.
+	// https://vault.example.com:8200.
+	Address string
+	// Token is the Vault token. If empty, the client logs in with the
+	// AppRole credentials.
+	Token string
+	// RoleID and SecretID are the AppRole credentials.
+	RoleID   string
+	SecretID string
+	// Namespace is the Vault Enterprise namespace, if any.
+	Namespace string
+	// Mount is the mount path of the KV v2 secrets engine (defaults to
+	// secret).
+	Mount string
+	// Path is the path of the secret within the secrets engine, e.g.
+	// ci/cnil.
+	Path string
+}
+
+// Client reads and writes the KV v2 secret of its options.
+type Client struct {
+	httpClient *http.Client
+	options    *Options
+	token      string
+}
+
+// NewClient returns a client of the given options, logging in with the
+// AppRole credentials if no token is specified.
+func NewClient(ctx context.Context, httpClient *http.Client, options *Options) (*Client, error) {
+	if len(options.Address) == 0 || len(options.Path) == 0 {
+		return nil, errors.New("the Vault address and secret path are required")
+	}
+	client := &Client{httpClient: httpClient, options: options, token: options.Token}
+	if len(client.token) > 0 {
+		return client, nil
+	}
+	if len(options.RoleID) == 0 || len(options.SecretID) == 0 {
+		return nil, errors.New("either the Vault token or the AppRole role ID and secret ID are required")
+	}
+	payload := map[string]string{"role_id": options.RoleID, "secret_id": options.SecretID}
+	var responsePayload struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	if err := client.sendHTTPRequest(
+		ctx, http.MethodPost, "auth/approle/login", payload, &responsePayload); err != nil {
+		return nil, fmt.Errorf("error logging into Vault with AppRole: %v", err)
+	}
+	if len(responsePayload.Auth.ClientToken) == 0 {
+		return nil, errors.New("error logging into Vault with AppRole: no client token")
+	}
+	client.token = responsePayload.Auth.ClientToken
+	return client, nil
+}
+
+// Token returns the Vault token of the client, e.g. for masking it.
+func (c *Client) Token() string {
+	return c.token
+}
+
+func (c *Client) secretPath() string {
+	mount := c.options.Mount
+	if len(mount) == 0 {
+		mount = "secret"
+	}
+	return fmt.Sprintf("%s/data/%s", strings.Trim(mount, "/"), strings.Trim(c.options.Path, "/"))
+}
+
+// Secret is the data of a version of a KV v2 secret.
+type Secret struct {
+	Data    map[string]interface{}
+	Version int
+}
+
+// ReadSecret reads the current version of the secret, which is empty if it
+// does not exist yet.
+func (c *Client) ReadSecret(ctx context.Context) (*Secret, error) {
+	var responsePayload struct {
+		Data struct {
+			Data     map[string]interface{} `json:"data"`
+			Metadata struct {
+				Version int `json:"version"`
+			} `json:"metadata"`
+		} `json:"data"`
+	}
+	err := c.sendHTTPRequest(ctx, http.MethodGet, c.secretPath(), nil, &responsePayload)
+	if errors.Is(err, errNotFound) {
+		return &Secret{Data: make(map[string]interface{})}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading Vault secret %s: %v", c.secretPath(), err)
+	}
+	secret := &Secret{Data: responsePayload.Data.Data, Version: responsePayload.Data.Metadata.Version}
+	if secret.Data == nil {
+		secret.Data = make(map[string]interface{})
+	}
+	return secret, nil
+}
+
+// WriteSecret writes a new version of the secret, failing if its current
+// version is not the given one (check-and-set), and returns it.
+func (c *Client) WriteSecret(ctx context.Context, secret *Secret) (*Secret, error) {
+	payload := map[string]interface{}{
+		"options": map[string]int{"cas": secret.Version},
+		"data":    secret.Data,
+	}
+	var responsePayload struct {
+		Data struct {
+			Version int `json:"version"`
+		} `json:"data"`
+	}
+	if err := c.sendHTTPRequest(
+		ctx, http.MethodPost, c.secretPath(), payload, &responsePayload); err != nil {
+		return nil, fmt.Errorf("error writing Vault secret %s: %v", c.secretPath(), err)
+	}
+	return &Secret{Data: secret.Data, Version: responsePayload.Data.Version}, nil
+}
+
+// PersonalToken returns the CNIL personal token of the given secret, if any.
+func PersonalToken(secret *Secret) string {
+	token, _ := secret.Data[PersonalTokenField].(string)
+	return token
+}
+
+// APIKeysPerSignerID returns the pre-provisioned API keys of the given
+// secret, if any.
+func APIKeysPerSignerID(secret *Secret) (map[string]string, error) {
+	value, ok := secret.Data[APIKeysField]
+	if !ok {
+		return nil, nil
+	}
+	// the API keys can be either an object or its JSON string
+	if s, ok := value.(string); ok {
+		var apiKeys map[string]string
+		if err := json.Unmarshal([]byte(s), &apiKeys); err != nil {
+			return nil, fmt.Errorf("invalid %s field of the Vault secret: %v", APIKeysField, err)
+		}
+		return apiKeys, nil
+	}
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid %s field of the Vault secret: not an object", APIKeysField)
+	}
+	apiKeys := make(map[string]string, len(object))
+	for signerID, apiKey := range object {
+		s, ok := apiKey.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid API key of signer ID %s in the Vault secret", signerID)
+		}
+		apiKeys[signerID] = s
+	}
+	return apiKeys, nil
+}
+
+// APIKeyCache is a cnil.APIKeyCache kept in the issued API keys field of the
+// Vault secret, which is written back at each Put.
+type APIKeyCache struct {
+	client *Client
+
+	mu     sync.Mutex
+	secret *Secret
+}
+
+// NewAPIKeyCache returns the API key cache of the given secret (see
+// ReadSecret) of the given client.
+func NewAPIKeyCache(client *Client, secret *Secret) *APIKeyCache {
+	return &APIKeyCache{client: client, secret: secret}
+}
+
+func (c *APIKeyCache) issuedAPIKeys() map[string]interface{} {
+	issued, ok := c.secret.Data[IssuedAPIKeysField].(map[string]interface{})
+	if !ok {
+		issued = make(map[string]interface{})
+		c.secret.Data[IssuedAPIKeysField] = issued
+	}
+	return issued
+}
+
+func (c *APIKeyCache) Get(ledgerID, signerID string) (*cnil.APIKeyResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	value, ok := c.issuedAPIKeys()[ledgerID+"/"+signerID]
+	if !ok {
+		return nil, nil
+	}
+	// the values are decoded as generic JSON
+	valueJSON, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var apiKey cnil.APIKeyResponse
+	if err := json.Unmarshal(valueJSON, &apiKey); err != nil {
+		return nil, fmt.Errorf("invalid issued API key of signer ID %s in the Vault secret: %v",
+			signerID, err)
+	}
+	return &apiKey, nil
+}
+
+func (c *APIKeyCache) Put(ledgerID, signerID string, apiKey *cnil.APIKeyResponse) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.issuedAPIKeys()[ledgerID+"/"+signerID] = apiKey
+	secret, err := c.client.WriteSecret(context.Background(), c.secret)
+	if err != nil {
+		return err
+	}
+	c.secret = secret
+	return nil
+}
+
+// errNotFound is returned (wrapped) by the requests answered with a 404.
+var errNotFound = errors.New("not found")
+
+func (c *Client) sendHTTPRequest(
+	ctx context.Context,
+	method string,
+	path string,
+	payload interface{},
+	responsePayload interface{},
+) error {
+	url := fmt.Sprintf("%s/v1/%s", strings.TrimSuffix(c.options.Address, "/"), path)
+	var body io.Reader
+	if payload != nil {
+		payloadJSON, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("error JSON-marshaling %s %s request payload: %v", method, url, err)
+		}
+		body = bytes.NewBuffer(payloadJSON)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return fmt.Errorf("error creating HTTP request %s %s: %v", method, url, err)
+	}
+	req.Header.Add("Content-Type", "application/json")
+	if len(c.token) > 0 {
+		req.Header.Add("X-Vault-Token", c.token)
+	}
+	if len(c.options.Namespace) > 0 {
+		req.Header.Add("X-Vault-Namespace", c.options.Namespace)
+	}
+
+	response, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request %s %s: %v", method, url, err)
+	}
+	defer response.Body.Close()
+
+	responseBody, err := io.ReadAll(response.Body)
+	if err != nil {
+		return fmt.Errorf("%s %s: error reading response body: %v", method, url, err)
+	}
+	if response.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s %s", errNotFound, method, url)
+	}
+	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("%s %s error: got %s with body %s",
+			method, url, response.Status, responseBody)
+	}
+
+	if responsePayload == nil || len(responseBody) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(responseBody, responsePayload); err != nil {
+		return fmt.Errorf("error JSON-unmarshaling %s %s response body: %v", method, url, err)
+	}
+	return nil
+}